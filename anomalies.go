@@ -0,0 +1,447 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//anomalyLockID is the advisory lock that keeps one instance detecting at a time
+const anomalyLockID = 720046
+
+//Kinds of store anomaly
+const (
+	anomalyOrdersDrop   = "orders_drop"
+	anomalyRevenueSpike = "revenue_spike"
+)
+
+const (
+	//anomalyWindow is the recent stretch of sales that is checked
+	anomalyWindow = 6 * time.Hour
+	//anomalyBaselineDays is how many previous days the same hours are compared with
+	anomalyBaselineDays = 14
+	//anomalyMinActiveDays keeps new and seasonal stores from alerting on a thin baseline
+	anomalyMinActiveDays = 7
+	//anomalyDropZ and anomalySpikeZ are how many deviations from the baseline
+	//mean count as a drop or a spike
+	anomalyDropZ  = -3.0
+	anomalySpikeZ = 4.0
+	//anomalySpikeFactor also requires a spike to be a multiple of the mean
+	anomalySpikeFactor = 2.0
+	//anomalyNotifyBatch bounds the notifications sent per run
+	anomalyNotifyBatch     = 100
+	defaultAnomalyPageSize = 50
+	maxAnomalyPageSize     = 200
+)
+
+//storeAnomaliesDetected counts anomalies recorded by the detector by kind
+var storeAnomaliesDetected = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "store_anomalies_detected_total",
+		Help: "Total number of store sales anomalies detected",
+	},
+	[]string{"kind"},
+)
+
+//salesWindow is the orders and revenue of a store in one window
+type salesWindow struct {
+	Orders       int
+	RevenueCents int64
+}
+
+//anomalyFinding is an unusual recent window and what was expected instead
+type anomalyFinding struct {
+	Kind            string
+	Observed        salesWindow
+	ExpectedOrders  float64
+	ExpectedRevenue float64
+	ZScore          float64
+}
+
+//detectAnomalies compares a recent window with the same window on previous
+//days. Orders dropping to zero and revenue jumping far above normal are
+//anomalies; a baseline with fewer than anomalyMinActiveDays days of sales is
+//too thin to judge.
+func detectAnomalies(recent salesWindow, baseline []salesWindow) []anomalyFinding {
+	orders := make([]float64, 0, len(baseline))
+	revenue := make([]float64, 0, len(baseline))
+	active := 0
+	for _, w := range baseline {
+		if w.Orders > 0 {
+			active++
+		}
+		orders = append(orders, float64(w.Orders))
+		revenue = append(revenue, float64(w.RevenueCents))
+	}
+	if active < anomalyMinActiveDays {
+		return nil
+	}
+
+	var findings []anomalyFinding
+	ordersMean, ordersSD := meanStdDev(orders)
+	revenueMean, revenueSD := meanStdDev(revenue)
+
+	//Orders are counts, so never assume less spread than a Poisson process has
+	if recent.Orders == 0 {
+		z := -ordersMean / math.Max(ordersSD, math.Sqrt(ordersMean))
+		if z <= anomalyDropZ {
+			findings = append(findings, anomalyFinding{Kind: anomalyOrdersDrop, Observed: recent,
+				ExpectedOrders: ordersMean, ExpectedRevenue: revenueMean, ZScore: z})
+		}
+	}
+
+	//A store with very steady revenue would otherwise flag every good day
+	observed := float64(recent.RevenueCents)
+	z := (observed - revenueMean) / math.Max(revenueSD, 0.1*revenueMean)
+	if z >= anomalySpikeZ && observed >= anomalySpikeFactor*revenueMean {
+		findings = append(findings, anomalyFinding{Kind: anomalyRevenueSpike, Observed: recent,
+			ExpectedOrders: ordersMean, ExpectedRevenue: revenueMean, ZScore: z})
+	}
+	return findings
+}
+
+func meanStdDev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	m := mean(values)
+	var sum float64
+	for _, v := range values {
+		sum += (v - m) * (v - m)
+	}
+	return m, math.Sqrt(sum / float64(len(values)))
+}
+
+//detectStoreAnomalies checks the last anomalyWindow of complete hours before
+//now for every active store, records new anomalies and notifies owners.
+//It returns how many anomalies were recorded.
+func (h *Handler) detectStoreAnomalies(ctx context.Context, now time.Time) (int, error) {
+	conn, err := h.database.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", anomalyLockID).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("failed to acquire anomaly lock: %w", err)
+	}
+	if !locked {
+		//Another instance is detecting the same windows
+		return 0, nil
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", anomalyLockID)
+
+	end := now.UTC().Truncate(time.Hour)
+	start := end.Add(-anomalyWindow)
+
+	//Day 0 is the recent window, days 1 to anomalyBaselineDays the same hours before
+	rows, err := conn.QueryContext(ctx,
+		`SELECT s.id, d.day, COALESCE(SUM(r.orders), 0), COALESCE(SUM(r.revenue_cents), 0)
+		FROM stores s
+		CROSS JOIN generate_series(0, $3) AS d(day)
+		LEFT JOIN store_revenue_snapshots r ON r.store_id = s.id
+			AND r.hour >= $1::timestamptz - d.day * INTERVAL '24 hours'
+			AND r.hour < $2::timestamptz - d.day * INTERVAL '24 hours'
+		WHERE s.deleted_at IS NULL AND s.status = $4
+		GROUP BY s.id, d.day
+		ORDER BY s.id, d.day`,
+		start, end, anomalyBaselineDays, storeStatusActive)
+	if err != nil {
+		h.logger.Error("database error loading sales windows", "error", err.Error())
+		return 0, err
+	}
+
+	type storeWindows struct {
+		id       int
+		recent   salesWindow
+		baseline []salesWindow
+	}
+	var stores []*storeWindows
+	for rows.Next() {
+		var id, day int
+		var w salesWindow
+		if err := rows.Scan(&id, &day, &w.Orders, &w.RevenueCents); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if len(stores) == 0 || stores[len(stores)-1].id != id {
+			stores = append(stores, &storeWindows{id: id})
+		}
+		if day == 0 {
+			stores[len(stores)-1].recent = w
+		} else {
+			stores[len(stores)-1].baseline = append(stores[len(stores)-1].baseline, w)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	recorded := 0
+	for _, s := range stores {
+		for _, f := range detectAnomalies(s.recent, s.baseline) {
+			//An anomaly that is still going on is not recorded again as the window slides
+			result, err := conn.ExecContext(ctx,
+				`INSERT INTO store_anomalies (store_id, kind, window_start, window_end, observed_orders,
+					observed_revenue_cents, expected_orders, expected_revenue_cents, z_score)
+				SELECT $1::int, $2::text, $3::timestamptz, $4::timestamptz, $5::int, $6::bigint, $7::float8, $8::float8, $9::float8
+				WHERE NOT EXISTS (
+					SELECT 1 FROM store_anomalies WHERE store_id = $1::int AND kind = $2::text AND window_end > $3::timestamptz
+				)
+				ON CONFLICT (store_id, kind, window_start) DO NOTHING`,
+				s.id, f.Kind, start, end, f.Observed.Orders, f.Observed.RevenueCents,
+				f.ExpectedOrders, f.ExpectedRevenue, f.ZScore)
+			if err != nil {
+				h.logger.Error("database error recording anomaly", "store_id", s.id, "kind", f.Kind, "error", err.Error())
+				return recorded, err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				continue
+			}
+			recorded++
+			storeAnomaliesDetected.WithLabelValues(f.Kind).Inc()
+			h.logger.Warn("store anomaly detected",
+				"store_id", s.id,
+				"kind", f.Kind,
+				"observed_orders", f.Observed.Orders,
+				"observed_revenue_cents", f.Observed.RevenueCents,
+				"expected_orders", f.ExpectedOrders,
+				"expected_revenue_cents", f.ExpectedRevenue,
+				"z_score", f.ZScore,
+			)
+		}
+	}
+
+	if err := h.notifyAnomalies(ctx, conn); err != nil {
+		return recorded, err
+	}
+	return recorded, nil
+}
+
+//notifyAnomalies tells owners about anomalies not notified yet. A failed
+//notification stays pending and is retried on the next run.
+func (h *Handler) notifyAnomalies(ctx context.Context, conn *sql.Conn) error {
+	if h.notifier == nil {
+		return nil
+	}
+
+	rows, err := conn.QueryContext(ctx,
+		`SELECT a.id, a.store_id, s.name, s.currency, u.id, u.email, a.kind, a.window_start, a.window_end,
+			a.observed_orders, a.observed_revenue_cents, a.expected_orders, a.expected_revenue_cents
+		FROM store_anomalies a
+		JOIN stores s ON s.id = a.store_id
+		JOIN users u ON u.id = s.user_id
+		WHERE a.notified_at IS NULL
+		ORDER BY a.id
+		LIMIT $1`, anomalyNotifyBatch)
+	if err != nil {
+		h.logger.Error("database error loading pending anomalies", "error", err.Error())
+		return err
+	}
+
+	type pending struct {
+		id           int64
+		notification Notification
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		var storeID, observedOrders int
+		var storeName, currency, kind string
+		var windowStart, windowEnd time.Time
+		var observedRevenue int64
+		var expectedOrders, expectedRevenue float64
+		if err := rows.Scan(&p.id, &storeID, &storeName, &currency, &p.notification.UserID, &p.notification.Email,
+			&kind, &windowStart, &windowEnd, &observedOrders, &observedRevenue, &expectedOrders, &expectedRevenue); err != nil {
+			rows.Close()
+			return err
+		}
+		windowStart, windowEnd = windowStart.UTC(), windowEnd.UTC()
+		observed := Money{Amount: observedRevenue, Currency: currency}
+		expected := Money{Amount: int64(math.Round(expectedRevenue)), Currency: currency}
+		n := &p.notification
+		switch kind {
+		case anomalyOrdersDrop:
+			n.Subject = fmt.Sprintf("%s has had no orders since %s", storeName, windowStart.Format(time.RFC3339))
+			n.Body = fmt.Sprintf("%s usually gets %.1f orders between %s and %s but got none. Check that the store is working.",
+				storeName, expectedOrders, windowStart.Format("15:04"), windowEnd.Format("15:04 MST"))
+		case anomalyRevenueSpike:
+			n.Subject = fmt.Sprintf("Unusual revenue at %s", storeName)
+			n.Body = fmt.Sprintf("%s made %s %s between %s and %s, against %s %s normally. Check these orders are genuine.",
+				storeName, observed.Decimal(), currency, windowStart.Format("15:04"), windowEnd.Format("15:04 MST"),
+				expected.Decimal(), currency)
+		}
+		n.Data = map[string]interface{}{
+			"anomaly_id":   p.id,
+			"store_id":     storeID,
+			"kind":         kind,
+			"window_start": windowStart.Format(time.RFC3339),
+			"window_end":   windowEnd.Format(time.RFC3339),
+		}
+		batch = append(batch, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, p := range batch {
+		if err := h.notifier.Notify(ctx, p.notification); err != nil {
+			h.logger.Warn("failed to notify store owner of anomaly",
+				"anomaly_id", p.id,
+				"user_id", p.notification.UserID,
+				"error", err.Error(),
+			)
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, "UPDATE store_anomalies SET notified_at = NOW() WHERE id = $1", p.id); err != nil {
+			h.logger.Error("database error marking anomaly notified", "anomaly_id", p.id, "error", err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+//runAnomalyDetector checks for anomalies every interval until ctx is done
+func (h *Handler) runAnomalyDetector(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := h.detectStoreAnomalies(ctx, time.Now()); err != nil {
+			h.logger.Error("anomaly detection failed", "error", err.Error())
+		}
+	}
+}
+
+//anomaliesResolver lists a store's anomalies, newest first - REQUIRES AUTH + OWNERSHIP OR ADMIN
+func (h *Handler) anomaliesResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized anomalies attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid storeId")
+	}
+	limit := defaultAnomalyPageSize
+	if l, ok := p.Args["limit"].(int); ok {
+		if l < 1 || l > maxAnomalyPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxAnomalyPageSize)
+		}
+		limit = l
+	}
+
+	ctx := p.Context
+	ownerID, err := storeOwnerID(ctx, h.database, storeID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		h.logger.Error("database error loading store owner", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if ownerID != userID {
+		admin, err := isAdmin(ctx, h.database, userID)
+		if err != nil {
+			h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+			return nil, err
+		}
+		if !admin {
+			return nil, fmt.Errorf("you can only view the anomalies of your own stores")
+		}
+	}
+
+	rows, err := h.database.QueryContext(ctx,
+		`SELECT a.id, a.kind, a.window_start, a.window_end, a.observed_orders, a.observed_revenue_cents,
+			a.expected_orders, a.expected_revenue_cents, a.z_score, a.detected_at, a.notified_at, s.currency
+		FROM store_anomalies a JOIN stores s ON s.id = a.store_id
+		WHERE a.store_id = $1
+		ORDER BY a.id DESC
+		LIMIT $2`, storeID, limit)
+	if err != nil {
+		h.logger.Error("database error loading anomalies", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	anomalies := []map[string]interface{}{}
+	for rows.Next() {
+		var id int64
+		var kind, currency string
+		var windowStart, windowEnd, detectedAt time.Time
+		var notifiedAt sql.NullTime
+		var observedOrders int
+		var observedRevenue int64
+		var expectedOrders, expectedRevenue, zScore float64
+		if err := rows.Scan(&id, &kind, &windowStart, &windowEnd, &observedOrders, &observedRevenue,
+			&expectedOrders, &expectedRevenue, &zScore, &detectedAt, &notifiedAt, &currency); err != nil {
+			return nil, err
+		}
+		anomaly := map[string]interface{}{
+			"id":               int(id),
+			"store_id":         storeID,
+			"kind":             kind,
+			"window_start":     windowStart,
+			"window_end":       windowEnd,
+			"observed_orders":  observedOrders,
+			"observed_revenue": Money{Amount: observedRevenue, Currency: currency},
+			"expected_orders":  expectedOrders,
+			"expected_revenue": Money{Amount: int64(math.Round(expectedRevenue)), Currency: currency},
+			"z_score":          zScore,
+			"detected_at":      detectedAt,
+			"notified_at":      nil,
+		}
+		if notifiedAt.Valid {
+			anomaly["notified_at"] = notifiedAt.Time
+		}
+		anomalies = append(anomalies, anomaly)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return anomalies, nil
+}
+
+//anomalyKindEnum is the kind of a store anomaly in GraphQL
+var anomalyKindEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "AnomalyKind",
+	Values: graphql.EnumValueConfigMap{
+		"ORDERS_DROP":   &graphql.EnumValueConfig{Value: anomalyOrdersDrop, Description: "No orders where the store normally has some"},
+		"REVENUE_SPIKE": &graphql.EnumValueConfig{Value: anomalyRevenueSpike, Description: "Revenue far above normal"},
+	},
+})
+
+//storeAnomalyType is a detected anomaly in GraphQL
+var storeAnomalyType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "StoreAnomaly",
+	Fields: graphql.Fields{
+		"id":               &graphql.Field{Type: graphql.Int},
+		"store_id":         &graphql.Field{Type: graphql.Int},
+		"kind":             &graphql.Field{Type: anomalyKindEnum},
+		"window_start":     &graphql.Field{Type: dateTimeScalar},
+		"window_end":       &graphql.Field{Type: dateTimeScalar, Description: "Exclusive"},
+		"observed_orders":  &graphql.Field{Type: graphql.Int},
+		"observed_revenue": &graphql.Field{Type: moneyType},
+		"expected_orders":  &graphql.Field{Type: graphql.Float, Description: "Mean of the same hours on the previous 14 days"},
+		"expected_revenue": &graphql.Field{Type: moneyType},
+		"z_score":          &graphql.Field{Type: graphql.Float},
+		"detected_at":      &graphql.Field{Type: dateTimeScalar},
+		"notified_at":      &graphql.Field{Type: dateTimeScalar, Description: "Null until the owner has been notified"},
+	},
+})
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+//recordingNotifier keeps what it was asked to send and can be made to fail
+type recordingNotifier struct {
+	sent []Notification
+	err  error
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, n Notification) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+//steadyBaseline is two weeks of a store doing about ten orders for 100.00 a window
+func steadyBaseline() []salesWindow {
+	baseline := make([]salesWindow, 14)
+	for i := range baseline {
+		baseline[i] = salesWindow{Orders: 9 + i%3, RevenueCents: 9500 + int64(i%3)*500}
+	}
+	return baseline
+}
+
+func TestDetectAnomalies_OrdersDrop(t *testing.T) {
+	//ACT: A window without any orders
+	findings := detectAnomalies(salesWindow{}, steadyBaseline())
+
+	//ASSERT: Reported as a drop against the baseline
+	if len(findings) != 1 || findings[0].Kind != anomalyOrdersDrop {
+		t.Fatalf("Expected one orders drop, got %v", findings)
+	}
+	if findings[0].ExpectedOrders < 9 || findings[0].ZScore > anomalyDropZ {
+		t.Errorf("Unexpected finding: %+v", findings[0])
+	}
+}
+
+func TestDetectAnomalies_RevenueSpike(t *testing.T) {
+	//ACT: Ten times the usual revenue
+	findings := detectAnomalies(salesWindow{Orders: 12, RevenueCents: 100000}, steadyBaseline())
+
+	//ASSERT: Reported as a spike
+	if len(findings) != 1 || findings[0].Kind != anomalyRevenueSpike {
+		t.Fatalf("Expected one revenue spike, got %v", findings)
+	}
+}
+
+func TestDetectAnomalies_Normal(t *testing.T) {
+	cases := map[string]salesWindow{
+		"usual window":   {Orders: 10, RevenueCents: 10000},
+		"good day":       {Orders: 14, RevenueCents: 15000},
+		"slow, not zero": {Orders: 2, RevenueCents: 2000},
+	}
+	for name, recent := range cases {
+		if findings := detectAnomalies(recent, steadyBaseline()); len(findings) != 0 {
+			t.Errorf("%s: expected no anomaly, got %v", name, findings)
+		}
+	}
+}
+
+func TestDetectAnomalies_ThinBaseline(t *testing.T) {
+	//ARRANGE: A store that started selling five days ago
+	baseline := make([]salesWindow, 14)
+	for i := 0; i < 5; i++ {
+		baseline[i] = salesWindow{Orders: 20, RevenueCents: 20000}
+	}
+
+	//ACT: Neither a silent window nor a big one is judged
+	drop := detectAnomalies(salesWindow{}, baseline)
+	spike := detectAnomalies(salesWindow{Orders: 100, RevenueCents: 1000000}, baseline)
+
+	//ASSERT: Not enough history to call anything unusual
+	if len(drop) != 0 || len(spike) != 0 {
+		t.Errorf("Expected no anomalies on a thin baseline, got %v and %v", drop, spike)
+	}
+}
+
+func TestDetectStoreAnomalies(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 5 went silent, store 6 is trading as usual
+	now := time.Date(2026, 3, 22, 14, 20, 0, 0, time.UTC)
+	start := time.Date(2026, 3, 22, 8, 0, 0, 0, time.UTC)
+	end := time.Date(2026, 3, 22, 14, 0, 0, 0, time.UTC)
+	windows := sqlmock.NewRows([]string{"id", "day", "orders", "revenue_cents"}).AddRow(5, 0, 0, int64(0))
+	for i, w := range steadyBaseline() {
+		windows.AddRow(5, i+1, w.Orders, w.RevenueCents)
+	}
+	windows.AddRow(6, 0, 10, int64(10000))
+	for i, w := range steadyBaseline() {
+		windows.AddRow(6, i+1, w.Orders, w.RevenueCents)
+	}
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(anomalyLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectQuery("CROSS JOIN generate_series\\(0, \\$3\\)").
+		WithArgs(start, end, anomalyBaselineDays, storeStatusActive).
+		WillReturnRows(windows)
+	mock.ExpectExec("INSERT INTO store_anomalies (.+) WHERE NOT EXISTS").
+		WithArgs(5, anomalyOrdersDrop, start, end, 0, int64(0), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("FROM store_anomalies a (.+) WHERE a.notified_at IS NULL").
+		WithArgs(anomalyNotifyBatch).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "name", "currency", "user_id", "email", "kind",
+			"window_start", "window_end", "observed_orders", "observed_revenue_cents", "expected_orders", "expected_revenue_cents"}).
+			AddRow(1, 5, "Sticker Shop", "USD", 1, "owner@example.com", anomalyOrdersDrop, start, end, 0, int64(0), 9.93, 9964.29))
+	mock.ExpectExec("UPDATE store_anomalies SET notified_at = NOW\\(\\) WHERE id = \\$1").
+		WithArgs(int64(1)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("SELECT pg_advisory_unlock").
+		WithArgs(anomalyLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	notifier := &recordingNotifier{}
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		notifier: notifier,
+	}
+
+	//ACT: Run the detector
+	recorded, err := handler.detectStoreAnomalies(context.Background(), now)
+
+	//ASSERT: One anomaly recorded and its owner told
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if recorded != 1 {
+		t.Errorf("Expected 1 anomaly, got %d", recorded)
+	}
+	if len(notifier.sent) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", len(notifier.sent))
+	}
+	sent := notifier.sent[0]
+	if sent.Email != "owner@example.com" || !strings.Contains(sent.Subject, "Sticker Shop has had no orders") {
+		t.Errorf("Unexpected notification: %+v", sent)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestNotifyAnomalies_FailureStaysPending(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	start := time.Date(2026, 3, 22, 8, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("WHERE a.notified_at IS NULL").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "name", "currency", "user_id", "email", "kind",
+			"window_start", "window_end", "observed_orders", "observed_revenue_cents", "expected_orders", "expected_revenue_cents"}).
+			AddRow(2, 5, "Sticker Shop", "USD", 1, "owner@example.com", anomalyRevenueSpike, start, start.Add(anomalyWindow), 40, int64(250000), 10.0, 10000.0))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		notifier: &recordingNotifier{err: errors.New("relay down")},
+	}
+	conn, err := fakeDB.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	//ACT: Deliver while the notifier is failing
+	err = handler.notifyAnomalies(context.Background(), conn)
+
+	//ASSERT: No error and the anomaly is not marked notified
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAnomaliesResolver(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	start := time.Date(2026, 3, 22, 8, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery("FROM store_anomalies a JOIN stores s ON s.id = a.store_id WHERE a.store_id = \\$1 ORDER BY a.id DESC LIMIT \\$2").
+		WithArgs(5, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "window_start", "window_end", "observed_orders", "observed_revenue_cents",
+			"expected_orders", "expected_revenue_cents", "z_score", "detected_at", "notified_at", "currency"}).
+			AddRow(1, anomalyOrdersDrop, start, start.Add(anomalyWindow), 0, int64(0), 9.93, 9964.29, -4.8, start.Add(7*time.Hour), nil, "USD"))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"storeId": 5, "limit": 50},
+	}
+
+	//ACT: Call the resolver as the owner
+	result, err := handler.anomaliesResolver(params)
+
+	//ASSERT: The anomaly with its expected revenue in whole cents
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	anomalies := result.([]map[string]interface{})
+	if len(anomalies) != 1 {
+		t.Fatalf("Expected 1 anomaly, got %d", len(anomalies))
+	}
+	if anomalies[0]["kind"] != anomalyOrdersDrop || anomalies[0]["expected_revenue"] != (Money{Amount: 9964, Currency: "USD"}) || anomalies[0]["notified_at"] != nil {
+		t.Errorf("Unexpected anomaly: %v", anomalies[0])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
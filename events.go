@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//Names of the domain events the app publishes
+const (
+	eventOrderStatusChanged = "orderStatusChanged"
+	eventStoreStatusChanged = "storeStatusChanged"
+)
+
+//eventsPublished counts domain events by name
+var eventsPublished = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "events_published_total",
+		Help: "Total number of domain events published",
+	},
+	[]string{"event"},
+)
+
+//publishEvent emits a domain event. It is always logged and, when Redis is
+//available, published on the "events:<name>" channel for other services.
+//Publishing is best effort: failures are logged and never fail the request.
+func (h *Handler) publishEvent(ctx context.Context, name string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		h.logger.Error("failed to encode event", "event", name, "error", err.Error())
+		return
+	}
+
+	eventsPublished.WithLabelValues(name).Inc()
+	h.logger.Info("event published", "event", name, "payload", string(body))
+
+	if h.redis == nil {
+		return
+	}
+	if err := h.redis.Publish(ctx, "events:"+name, body).Err(); err != nil {
+		h.logger.Warn("failed to publish event to redis",
+			"event", name,
+			"error", err.Error(),
+		)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+//orderTransitions lists the statuses an order may move to from each status.
+//Delivered and cancelled are final.
+var orderTransitions = map[string][]string{
+	orderStatusPlaced:    {orderStatusProofSent, orderStatusCancelled},
+	orderStatusProofSent: {orderStatusApproved, orderStatusPlaced, orderStatusCancelled},
+	orderStatusApproved:  {orderStatusPrinting, orderStatusCancelled},
+	orderStatusPrinting:  {orderStatusShipped},
+	orderStatusShipped:   {orderStatusDelivered},
+}
+
+//canTransition reports whether an order may move from one status to another
+func canTransition(from, to string) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+//orderStatusChange is one step in an order's history, published as the orderStatusChanged event
+type orderStatusChange struct {
+	OrderID   int       `json:"order_id"`
+	StoreID   int       `json:"store_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	ChangedBy int       `json:"changed_by"`
+	Note      string    `json:"note,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+//transitionOrder validates and applies a status change and records it in the
+//history. Must run inside the caller's transaction with the order row locked.
+func transitionOrder(ctx context.Context, tx *sql.Tx, order *Order, to string, actorID int, note string) (*orderStatusChange, error) {
+	if !canTransition(order.Status, to) {
+		return nil, fmt.Errorf("order %d cannot move from %s to %s", order.ID, order.Status, to)
+	}
+	if to == orderStatusPrinting {
+		approved, err := allProofsApproved(ctx, tx, order.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !approved {
+			return nil, fmt.Errorf("order %d cannot be printed before every proof is approved", order.ID)
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, "UPDATE orders SET status = $1, updated_at = NOW() WHERE id = $2", to, order.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update order status: %w", err)
+	}
+
+	historyQuery := "INSERT INTO order_status_history (order_id, from_status, to_status, changed_by, note) VALUES ($1, $2, $3, $4, $5)"
+	_, err = tx.ExecContext(ctx, historyQuery, order.ID, order.Status, to, actorID, note)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record order status history: %w", err)
+	}
+
+	change := &orderStatusChange{
+		OrderID:   order.ID,
+		StoreID:   order.StoreID,
+		From:      order.Status,
+		To:        to,
+		ChangedBy: actorID,
+		Note:      note,
+		At:        time.Now().UTC(),
+	}
+	order.Status = to
+	return change, nil
+}
+
+//recordInitialStatus writes the first history entry of a newly placed order
+func recordInitialStatus(ctx context.Context, tx *sql.Tx, orderID int, actorID int) error {
+	historyQuery := "INSERT INTO order_status_history (order_id, from_status, to_status, changed_by) VALUES ($1, NULL, $2, $3)"
+	if _, err := tx.ExecContext(ctx, historyQuery, orderID, orderStatusPlaced, actorID); err != nil {
+		return fmt.Errorf("failed to record order status history: %w", err)
+	}
+	return nil
+}
+
+//lockOrderForOwner loads and locks an order, checking the caller owns its store
+func lockOrderForOwner(ctx context.Context, tx *sql.Tx, orderID int, userID int) (*Order, error) {
+	order, err := loadOrder(ctx, tx, orderID, true)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order with id %d not found", orderID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ownerID, err := storeOwnerID(ctx, tx, order.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	if ownerID != userID {
+		return nil, fmt.Errorf("you can only manage orders of your own stores")
+	}
+	return order, nil
+}
+
+//updateOrderStatusResolver moves an order along the fulfillment flow - REQUIRES AUTH + STORE OWNERSHIP
+func (h *Handler) updateOrderStatusResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized order status update attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+	status, _ := p.Args["status"].(string)
+	status = strings.ToLower(strings.TrimSpace(status))
+	note, _ := p.Args["note"].(string)
+
+	//These steps carry data of their own and have dedicated mutations
+	switch status {
+	case orderStatusProofSent:
+		return nil, fmt.Errorf("use uploadProof to send a proof")
+	case orderStatusApproved:
+		return nil, fmt.Errorf("proofs are approved by the buyer with approveProof")
+	case orderStatusShipped:
+		return nil, fmt.Errorf("use shipOrder to mark an order as shipped")
+	case orderStatusCancelled:
+		return nil, fmt.Errorf("use cancelOrder to cancel an order")
+	}
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := lockOrderForOwner(ctx, tx, id, userID)
+	if err != nil {
+		h.logger.Warn("order status update rejected", "order_id", id, "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+
+	change, err := transitionOrder(ctx, tx, order, status, userID, note)
+	if err != nil {
+		return nil, err
+	}
+
+	if status == orderStatusDelivered {
+		_, err = tx.ExecContext(ctx, "UPDATE shipments SET delivered_at = NOW() WHERE order_id = $1 AND delivered_at IS NULL", id)
+		if err != nil {
+			h.logger.Error("database error marking shipments delivered", "order_id", id, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit order status update", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("order status changed",
+		"order_id", id,
+		"from", change.From,
+		"to", change.To,
+		"user_id", userID,
+	)
+	h.publishEvent(ctx, eventOrderStatusChanged, change)
+
+	return orderToMap(order), nil
+}
+
+//shipOrderResolver records a shipment and marks the order as shipped - REQUIRES AUTH + STORE OWNERSHIP
+func (h *Handler) shipOrderResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized ship order attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+	carrier, _ := p.Args["carrier"].(string)
+	trackingNumber, _ := p.Args["trackingNumber"].(string)
+	carrier = strings.TrimSpace(carrier)
+	trackingNumber = strings.TrimSpace(trackingNumber)
+	if carrier == "" || trackingNumber == "" {
+		return nil, fmt.Errorf("carrier and tracking number are required")
+	}
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	order, err := lockOrderForOwner(ctx, tx, id, userID)
+	if err != nil {
+		h.logger.Warn("ship order rejected", "order_id", id, "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+
+	change, err := transitionOrder(ctx, tx, order, orderStatusShipped, userID, carrier+" "+trackingNumber)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO shipments (order_id, carrier, tracking_number) VALUES ($1, $2, $3)",
+		id, carrier, trackingNumber)
+	if err != nil {
+		h.logger.Error("database error recording shipment", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit shipment", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("order shipped",
+		"order_id", id,
+		"carrier", carrier,
+		"tracking_number", trackingNumber,
+		"user_id", userID,
+	)
+	h.publishEvent(ctx, eventOrderStatusChanged, change)
+
+	return orderToMap(order), nil
+}
+
+//orderHistoryResolver loads the status history of an order
+func (h *Handler) orderHistoryResolver(p graphql.ResolveParams) (interface{}, error) {
+	order, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		`SELECT from_status, to_status, changed_by, note, created_at
+		FROM order_status_history WHERE order_id = $1 ORDER BY id`, order["id"])
+	if err != nil {
+		h.logger.Error("database error loading order history", "order_id", order["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []map[string]interface{}
+	for rows.Next() {
+		var from, note sql.NullString
+		var to string
+		var changedBy sql.NullInt64
+		var createdAt time.Time
+		if err := rows.Scan(&from, &to, &changedBy, &note, &createdAt); err != nil {
+			return nil, err
+		}
+
+		entry := map[string]interface{}{
+			"from_status": nil,
+			"to_status":   to,
+			"changed_by":  nil,
+			"note":        note.String,
+			"created_at":  createdAt.Format(time.RFC3339),
+		}
+		if from.Valid {
+			entry["from_status"] = from.String
+		}
+		if changedBy.Valid {
+			entry["changed_by"] = int(changedBy.Int64)
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+//orderShipmentsResolver loads the shipments of an order
+func (h *Handler) orderShipmentsResolver(p graphql.ResolveParams) (interface{}, error) {
+	order, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		`SELECT id, carrier, tracking_number, shipped_at, delivered_at
+		FROM shipments WHERE order_id = $1 ORDER BY id`, order["id"])
+	if err != nil {
+		h.logger.Error("database error loading shipments", "order_id", order["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var shipments []map[string]interface{}
+	for rows.Next() {
+		var id int
+		var carrier, trackingNumber string
+		var shippedAt time.Time
+		var deliveredAt sql.NullTime
+		if err := rows.Scan(&id, &carrier, &trackingNumber, &shippedAt, &deliveredAt); err != nil {
+			return nil, err
+		}
+
+		shipment := map[string]interface{}{
+			"id":              id,
+			"carrier":         carrier,
+			"tracking_number": trackingNumber,
+			"shipped_at":      shippedAt.Format(time.RFC3339),
+			"delivered_at":    nil,
+		}
+		if deliveredAt.Valid {
+			shipment["delivered_at"] = deliveredAt.Time.Format(time.RFC3339)
+		}
+		shipments = append(shipments, shipment)
+	}
+	return shipments, rows.Err()
+}
+
+//orderStatusChangeType is one entry of an order's status history in GraphQL
+var orderStatusChangeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "OrderStatusChange",
+	Fields: graphql.Fields{
+		"from_status": &graphql.Field{Type: graphql.String},
+		"to_status":   &graphql.Field{Type: graphql.String},
+		"changed_by":  &graphql.Field{Type: graphql.Int},
+		"note":        &graphql.Field{Type: graphql.String},
+		"created_at":  &graphql.Field{Type: graphql.String},
+	},
+})
+
+//shipmentType is a parcel sent for an order in GraphQL
+var shipmentType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Shipment",
+	Fields: graphql.Fields{
+		"id":              &graphql.Field{Type: graphql.Int},
+		"carrier":         &graphql.Field{Type: graphql.String},
+		"tracking_number": &graphql.Field{Type: graphql.String},
+		"shipped_at":      &graphql.Field{Type: graphql.String},
+		"delivered_at":    &graphql.Field{Type: graphql.String},
+	},
+})
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{orderStatusPlaced, orderStatusProofSent, true},
+		{orderStatusProofSent, orderStatusApproved, true},
+		{orderStatusProofSent, orderStatusPlaced, true},
+		{orderStatusApproved, orderStatusPrinting, true},
+		{orderStatusPrinting, orderStatusShipped, true},
+		{orderStatusShipped, orderStatusDelivered, true},
+		{orderStatusPlaced, orderStatusCancelled, true},
+		{orderStatusPlaced, orderStatusPrinting, false},
+		{orderStatusPrinting, orderStatusCancelled, false},
+		{orderStatusDelivered, orderStatusPlaced, false},
+		{orderStatusCancelled, orderStatusPlaced, false},
+	}
+
+	for _, c := range cases {
+		if got := canTransition(c.from, c.to); got != c.want {
+			t.Errorf("canTransition(%s, %s) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestUpdateOrderStatusResolver_Success(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store owner (user 1) starts printing an approved order
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 0, "approved"))
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM order_items").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("UPDATE orders SET status").
+		WithArgs("printing", 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(10, "approved", "printing", 1, "press 3").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 10, "status": "printing", "note": "press 3"},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.updateOrderStatusResolver(params)
+
+	//ASSERT: Order moved to printing
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.(map[string]interface{})["status"] != "printing" {
+		t.Errorf("Expected status=printing, got %v", result.(map[string]interface{})["status"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateOrderStatusResolver_InvalidTransition(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: A placed order cannot skip straight to printing
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 0, "placed"))
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 10, "status": "printing"},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.updateOrderStatusResolver(params)
+
+	//ASSERT: Transition is rejected and nothing is written
+	if err == nil {
+		t.Error("Expected error for invalid transition, got nil")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestShipOrderResolver_RecordsShipment(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: A printed order is handed to the carrier
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 0, "printing"))
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectExec("UPDATE orders SET status").
+		WithArgs("shipped", 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(10, "printing", "shipped", 1, "UPS 1Z999").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO shipments").
+		WithArgs(10, "UPS", "1Z999").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 10, "carrier": "UPS", "trackingNumber": "1Z999"},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.shipOrderResolver(params)
+
+	//ASSERT: Order is shipped
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.(map[string]interface{})["status"] != "shipped" {
+		t.Errorf("Expected status=shipped, got %v", result.(map[string]interface{})["status"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//invoiceLine is one priced line of an invoice
+type invoiceLine struct {
+	Description string
+	Quantity    int
+	UnitPrice   Money
+	Amount      Money
+}
+
+//invoiceTaxLine is one itemized tax on an invoice
+type invoiceTaxLine struct {
+	Label  string
+	Amount Money
+}
+
+//Invoice holds everything printed on an order's invoice
+type Invoice struct {
+	Number      string
+	IssuedAt    time.Time
+	OrderID     int
+	OrderStatus string
+	StoreName   string
+	BuyerEmail  string
+	Lines       []invoiceLine
+	Subtotal    Money
+	Discount    Money
+	PromoCode   string
+	TaxLines    []invoiceTaxLine
+	Total       Money
+	Refunded    Money
+}
+
+//formatInvoiceNumber renders a store's sequential invoice number, e.g. INV-5-000042
+func formatInvoiceNumber(storeID, number int) string {
+	return fmt.Sprintf("INV-%d-%06d", storeID, number)
+}
+
+//ensureInvoiceNumber returns the invoice number of an order, issuing the
+//store's next number the first time the invoice is requested
+func (h *Handler) ensureInvoiceNumber(ctx context.Context, order *Order) (int, time.Time, error) {
+	var number int
+	var issuedAt time.Time
+	query := "SELECT number, issued_at FROM invoices WHERE order_id = $1"
+	err := h.database.QueryRowContext(ctx, query, order.ID).Scan(&number, &issuedAt)
+	if err != sql.ErrNoRows {
+		return number, issuedAt, err
+	}
+
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	//Lock the order so concurrent first requests issue a single number
+	if _, err := loadOrder(ctx, tx, order.ID, true); err != nil {
+		return 0, time.Time{}, err
+	}
+	err = tx.QueryRowContext(ctx, query, order.ID).Scan(&number, &issuedAt)
+	if err == nil {
+		return number, issuedAt, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return 0, time.Time{}, err
+	}
+
+	nextNumber := `INSERT INTO invoice_sequences (store_id, last_number) VALUES ($1, 1)
+		ON CONFLICT (store_id) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		RETURNING last_number`
+	if err := tx.QueryRowContext(ctx, nextNumber, order.StoreID).Scan(&number); err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to issue invoice number: %w", err)
+	}
+
+	insertInvoice := "INSERT INTO invoices (order_id, store_id, number) VALUES ($1, $2, $3) RETURNING issued_at"
+	if err := tx.QueryRowContext(ctx, insertInvoice, order.ID, order.StoreID, number).Scan(&issuedAt); err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to record invoice: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	h.logger.Info("invoice issued",
+		"order_id", order.ID,
+		"store_id", order.StoreID,
+		"invoice_number", formatInvoiceNumber(order.StoreID, number),
+	)
+	return number, issuedAt, nil
+}
+
+//loadInvoice gathers the data printed on an order's invoice
+func (h *Handler) loadInvoice(ctx context.Context, order *Order) (*Invoice, error) {
+	number, issuedAt, err := h.ensureInvoiceNumber(ctx, order)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := &Invoice{
+		Number:      formatInvoiceNumber(order.StoreID, number),
+		IssuedAt:    issuedAt,
+		OrderID:     order.ID,
+		OrderStatus: order.Status,
+		Subtotal:    Money{Currency: order.Total.Currency},
+		Discount:    Money{Currency: order.Total.Currency},
+		Total:       order.Total,
+		Refunded:    order.RefundedAmount,
+	}
+
+	//Subtotal is the lines as priced, so the discount is printed to get from it to the total
+	err = h.database.QueryRowContext(ctx,
+		`SELECT s.name, o.discount_cents, COALESCE(p.code, '') FROM orders o
+		JOIN stores s ON s.id = o.store_id LEFT JOIN promotions p ON p.id = o.promotion_id
+		WHERE o.id = $1`, order.ID).Scan(&inv.StoreName, &inv.Discount.Amount, &inv.PromoCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load store: %w", err)
+	}
+	err = h.database.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1", order.UserID).Scan(&inv.BuyerEmail)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to load buyer: %w", err)
+	}
+
+	rows, err := h.database.QueryContext(ctx,
+		"SELECT description, quantity, unit_price_cents FROM order_items WHERE order_id = $1 ORDER BY id", order.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load order items: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		line := invoiceLine{UnitPrice: Money{Currency: order.Total.Currency}}
+		if err := rows.Scan(&line.Description, &line.Quantity, &line.UnitPrice.Amount); err != nil {
+			return nil, err
+		}
+		line.Amount = line.UnitPrice.Mul(int64(line.Quantity))
+		inv.Subtotal.Amount += line.Amount.Amount
+		inv.Lines = append(inv.Lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	taxes, err := loadOrderTaxLines(ctx, h.database, order.ID, order.Total.Currency)
+	if err != nil {
+		return nil, err
+	}
+	for _, tax := range taxes {
+		inv.TaxLines = append(inv.TaxLines, invoiceTaxLine{Label: tax.label(), Amount: tax.Amount})
+	}
+	return inv, nil
+}
+
+//renderInvoicePDF lays out an invoice on A4 pages
+func renderInvoicePDF(inv *Invoice) []byte {
+	const (
+		left     = 50.0
+		right    = pdfPageWidth - 50
+		qtyCol   = 360.0
+		priceCol = 450.0
+		bottom   = 90.0
+	)
+
+	doc := &pdfDocument{}
+	doc.AddPage()
+	y := pdfPageHeight - 60
+
+	doc.Text(left, y, 22, true, "INVOICE")
+	doc.TextRight(right, y, 12, true, inv.StoreName)
+	y -= 30
+	doc.Text(left, y, 10, false, "Invoice number: "+inv.Number)
+	doc.TextRight(right, y, 10, false, "Issued: "+inv.IssuedAt.UTC().Format("2006-01-02"))
+	y -= 15
+	doc.Text(left, y, 10, false, fmt.Sprintf("Order #%d", inv.OrderID))
+	if inv.BuyerEmail != "" {
+		doc.TextRight(right, y, 10, false, "Billed to: "+inv.BuyerEmail)
+	}
+	if inv.OrderStatus == orderStatusCancelled {
+		y -= 15
+		doc.Text(left, y, 10, true, "This order was cancelled.")
+	}
+
+	tableHeader := func() {
+		y -= 35
+		doc.Text(left, y, 10, true, "Description")
+		doc.TextRight(qtyCol, y, 10, true, "Qty")
+		doc.TextRight(priceCol, y, 10, true, "Unit price")
+		doc.TextRight(right, y, 10, true, "Amount")
+		y -= 6
+		doc.Line(left, y, right, y)
+	}
+	tableHeader()
+
+	for _, line := range inv.Lines {
+		y -= 16
+		if y < bottom {
+			doc.AddPage()
+			y = pdfPageHeight - 40
+			tableHeader()
+			y -= 16
+		}
+		doc.Text(left, y, 10, false, line.Description)
+		doc.TextRight(qtyCol, y, 10, false, strconv.Itoa(line.Quantity))
+		doc.TextRight(priceCol, y, 10, false, line.UnitPrice.Decimal())
+		doc.TextRight(right, y, 10, false, line.Amount.Decimal())
+	}
+
+	if y-40-16*float64(len(inv.TaxLines)+4) < bottom {
+		doc.AddPage()
+		y = pdfPageHeight - 40
+	}
+	y -= 10
+	doc.Line(qtyCol-60, y, right, y)
+
+	summary := func(label string, amount Money, bold bool) {
+		y -= 16
+		doc.Text(qtyCol-60, y, 10, bold, label)
+		doc.TextRight(right, y, 10, bold, amount.Decimal())
+	}
+	summary("Subtotal", inv.Subtotal, false)
+	if inv.Discount.Amount != 0 {
+		label := "Discount"
+		if inv.PromoCode != "" {
+			label += " (" + inv.PromoCode + ")"
+		}
+		summary(label, inv.Discount.Neg(), false)
+	}
+	if len(inv.TaxLines) == 0 {
+		summary("Tax", Money{Currency: inv.Total.Currency}, false)
+	}
+	for _, tax := range inv.TaxLines {
+		summary(tax.Label, tax.Amount, false)
+	}
+	summary("Total ("+inv.Total.Currency+")", inv.Total, true)
+	if inv.Refunded.Amount != 0 {
+		summary("Refunded", inv.Refunded.Neg(), false)
+		net, _ := inv.Total.Sub(inv.Refunded)
+		summary("Net paid", net, true)
+	}
+
+	return doc.Bytes()
+}
+
+//invoicePDF returns the rendered invoice, reusing the copy cached in the blob store.
+//The cache key is the hash of the invoice data, so any change to the order renders a new PDF.
+//Invoices hold the buyer's details, so they are cached under the private prefix
+//that /assets refuses and only leave through invoiceHandler.
+func (h *Handler) invoicePDF(ctx context.Context, inv *Invoice) ([]byte, error) {
+	if h.blobs == nil {
+		return renderInvoicePDF(inv), nil
+	}
+
+	fingerprint, err := json.Marshal(inv)
+	if err != nil {
+		return nil, err
+	}
+	key := "private/invoices/" + contentKey(fingerprint) + ".pdf"
+
+	cached, err := h.blobs.Get(ctx, key)
+	if err == nil {
+		defer cached.Close()
+		return io.ReadAll(cached)
+	}
+	if err != errBlobNotFound {
+		h.logger.Warn("failed to read cached invoice", "key", key, "error", err.Error())
+	}
+
+	pdf := renderInvoicePDF(inv)
+	if err := h.blobs.Put(ctx, key, pdf, "application/pdf"); err != nil {
+		h.logger.Warn("failed to cache invoice", "key", key, "error", err.Error())
+	}
+	return pdf, nil
+}
+
+//invoiceHandler serves GET /orders/{id}/invoice.pdf - REQUIRES AUTH (buyer or store member)
+func (h *Handler) invoiceHandler(w http.ResponseWriter, r *http.Request) {
+	idPart, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/orders/"), "/invoice.pdf")
+	orderID, err := strconv.Atoi(idPart)
+	if !ok || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		h.logger.Warn("unauthorized invoice request", "order_id", orderID, "error", err.Error())
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "authentication required"}`))
+		return
+	}
+
+	ctx := r.Context()
+	order, err := loadOrder(ctx, h.database, orderID, false)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.logger.Error("database error loading order", "order_id", orderID, "error", err.Error())
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+
+	//Store members are its owner; anyone else gets a 404 so order ids are not probeable
+	ownerID, err := storeOwnerID(ctx, h.database, order.StoreID)
+	if err != nil && err != sql.ErrNoRows {
+		h.logger.Error("database error checking store owner", "store_id", order.StoreID, "error", err.Error())
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return
+	}
+	if userID != order.UserID && userID != ownerID {
+		h.logger.Warn("unauthorized invoice request", "order_id", orderID, "requesting_user", userID)
+		http.NotFound(w, r)
+		return
+	}
+
+	inv, err := h.loadInvoice(ctx, order)
+	if err != nil {
+		h.logger.Error("failed to load invoice", "order_id", orderID, "error", err.Error())
+		http.Error(w, "failed to generate invoice", http.StatusInternalServerError)
+		return
+	}
+
+	pdf, err := h.invoicePDF(ctx, inv)
+	if err != nil {
+		h.logger.Error("failed to render invoice", "order_id", orderID, "error", err.Error())
+		http.Error(w, "failed to generate invoice", http.StatusInternalServerError)
+		return
+	}
+
+	h.logger.Info("invoice served", "order_id", orderID, "invoice_number", inv.Number, "user_id", userID)
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.pdf"`, inv.Number))
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Write(pdf)
+}
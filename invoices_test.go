@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestInvoiceHandler_IssuesNumberAndRendersPDF(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The buyer (user 2) requests the first invoice of order 10
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1$").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 2931, 0, "placed"))
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery("SELECT number, issued_at FROM invoices").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "issued_at"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 2931, 0, "placed"))
+	mock.ExpectQuery("SELECT number, issued_at FROM invoices").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "issued_at"}))
+	mock.ExpectQuery("INSERT INTO invoice_sequences").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"last_number"}).AddRow(42))
+	mock.ExpectQuery("INSERT INTO invoices").
+		WithArgs(10, 5, 42).
+		WillReturnRows(sqlmock.NewRows([]string{"issued_at"}).AddRow(time.Now()))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT s.name, o.discount_cents, COALESCE\\(p.code, ''\\) FROM orders o").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "discount_cents", "code"}).AddRow("Sticker Shop", 0, ""))
+	mock.ExpectQuery("SELECT email FROM users").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("buyer@example.com"))
+	mock.ExpectQuery("SELECT description, quantity, unit_price_cents FROM order_items").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"description", "quantity", "unit_price_cents"}).
+			AddRow("Laptop sticker", 10, 250).
+			AddRow("Shipping", 1, 250))
+	mock.ExpectQuery("SELECT (.+) FROM order_tax_lines").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"tax_rule_id", "jurisdiction", "name", "rate", "inclusive", "taxable_cents", "amount_cents"}).
+			AddRow(1, "US-CA", "CA sales tax", "0.072500", false, 2500, 181))
+
+	dir := t.TempDir()
+	blobs, _ := NewLocalBlobStore(dir)
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		blobs:    blobs,
+	}
+
+	req := httptest.NewRequest("GET", "/orders/10/invoice.pdf", nil)
+	authorize(t, fakeDB, req, 2)
+	w := httptest.NewRecorder()
+
+	//ACT: Request the invoice
+	handler.invoiceHandler(w, req)
+
+	//ASSERT: A PDF numbered INV-5-000042 is returned and cached
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/pdf" {
+		t.Errorf("Expected application/pdf, got %s", w.Header().Get("Content-Type"))
+	}
+	body := w.Body.Bytes()
+	if !bytes.HasPrefix(body, []byte("%PDF-")) {
+		t.Error("Expected a PDF document")
+	}
+	for _, text := range []string{"INV-5-000042", "Laptop sticker", "25.00", "27.50", "CA sales tax 7.25%", "1.81", "29.31"} {
+		if !bytes.Contains(body, []byte(text)) {
+			t.Errorf("Expected invoice to contain %q", text)
+		}
+	}
+
+	//ASSERT: The cached copy is private and cannot be fetched from /assets
+	cached, _ := filepath.Glob(filepath.Join(dir, "private", "invoices", "*.pdf"))
+	if len(cached) != 1 {
+		t.Fatalf("Expected one cached invoice under private/invoices, got %v", cached)
+	}
+	asset := httptest.NewRecorder()
+	handler.assetHandler(asset, httptest.NewRequest("GET", "/assets/private/invoices/"+filepath.Base(cached[0]), nil))
+	if asset.Code != http.StatusNotFound {
+		t.Errorf("Expected cached invoice to be hidden from /assets, got %d", asset.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestInvoiceHandler_HiddenFromOtherUsers(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: User 3 is neither the buyer nor the store owner
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1$").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 2750, 0, "placed"))
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest("GET", "/orders/10/invoice.pdf", nil)
+	authorize(t, fakeDB, req, 3)
+	w := httptest.NewRecorder()
+
+	//ACT: Request the invoice
+	handler.invoiceHandler(w, req)
+
+	//ASSERT: The order is not revealed and no number is issued
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLoadInvoice_DiscountedOrder(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: 27.50 of stickers, 10% off with SUMMER10, then California tax on what is paid
+	mock.ExpectQuery("SELECT number, issued_at FROM invoices").
+		WithArgs(11).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "issued_at"}).AddRow(43, time.Now()))
+	mock.ExpectQuery("SELECT s.name, o.discount_cents, COALESCE\\(p.code, ''\\) FROM orders o").
+		WithArgs(11).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "discount_cents", "code"}).AddRow("Sticker Shop", 275, "SUMMER10"))
+	mock.ExpectQuery("SELECT email FROM users").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"email"}).AddRow("buyer@example.com"))
+	mock.ExpectQuery("SELECT description, quantity, unit_price_cents FROM order_items").
+		WithArgs(11).
+		WillReturnRows(sqlmock.NewRows([]string{"description", "quantity", "unit_price_cents"}).
+			AddRow("Laptop sticker", 10, 250).
+			AddRow("Shipping", 1, 250))
+	mock.ExpectQuery("SELECT (.+) FROM order_tax_lines").
+		WithArgs(11).
+		WillReturnRows(sqlmock.NewRows([]string{"tax_rule_id", "jurisdiction", "name", "rate", "inclusive", "taxable_cents", "amount_cents"}).
+			AddRow(1, "US-CA", "CA sales tax", "0.072500", false, 2250, 163))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	order := &Order{ID: 11, StoreID: 5, UserID: 2, Total: usd(2638), RefundedAmount: usd(0), Status: "placed"}
+
+	//ACT: Load and render the invoice
+	inv, err := handler.loadInvoice(context.Background(), order)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	body := renderInvoicePDF(inv)
+
+	//ASSERT: Subtotal less discount plus tax adds up to the total
+	if inv.Discount != usd(275) || inv.Subtotal.Amount-inv.Discount.Amount+163 != inv.Total.Amount {
+		t.Errorf("Expected 27.50 - 2.75 + 1.63 = 26.38, got %+v", inv)
+	}
+	for _, text := range []string{"27.50", "Discount \\(SUMMER10\\)", "-2.75", "1.63", "26.38"} {
+		if !bytes.Contains(body, []byte(text)) {
+			t.Errorf("Expected invoice to contain %q", text)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
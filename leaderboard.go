@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+//Leaderboard metrics
+const (
+	leaderboardRevenue = "revenue"
+	leaderboardOrders  = "orders"
+	leaderboardRating  = "rating"
+)
+
+//Leaderboard periods. Day and week windows are calendar periods in UTC, weeks start on Monday.
+const (
+	periodDay  = "day"
+	periodWeek = "week"
+	periodAll  = "all"
+)
+
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+	leaderboardBuiltKey     = "leaderboard:built"
+)
+
+//leaderboardMetrics lists the periods each metric is kept for. Ratings are only ranked all-time.
+var leaderboardMetrics = map[string][]string{
+	leaderboardRevenue: {periodDay, periodWeek, periodAll},
+	leaderboardOrders:  {periodDay, periodWeek, periodAll},
+	leaderboardRating:  {periodAll},
+}
+
+//windowStart returns the beginning of the period containing now
+func windowStart(period string, now time.Time) time.Time {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case periodDay:
+		return day
+	case periodWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	default:
+		return time.Time{}
+	}
+}
+
+//leaderboardKey names the sorted set of a metric for the period containing now,
+//e.g. leaderboard:revenue:day:2026-05-04 or leaderboard:orders:all
+func leaderboardKey(metric, period string, now time.Time) string {
+	if period == periodAll {
+		return "leaderboard:" + metric + ":all"
+	}
+	return "leaderboard:" + metric + ":" + period + ":" + windowStart(period, now).Format("2006-01-02")
+}
+
+//leaderboardTTL keeps a window's set a little longer than the window itself
+func leaderboardTTL(period string) time.Duration {
+	switch period {
+	case periodDay:
+		return 48 * time.Hour
+	case periodWeek:
+		return 8 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+//revenueScore is a store's revenue in minor units of the reporting currency, so stores
+//selling in different currencies rank on one scale
+func (h *Handler) revenueScore(ctx context.Context, amount Money, rates map[string]*big.Rat) (float64, error) {
+	target := reportingCurrency()
+	rate, ok := rates[amount.Currency]
+	if !ok {
+		var err error
+		rate, err = exchangeRate(ctx, h.database, amount.Currency, target)
+		if err != nil {
+			return 0, err
+		}
+		rates[amount.Currency] = rate
+	}
+	return float64(convertMoney(amount, target, rate).Amount), nil
+}
+
+//markLeaderboardStale flags the sets for a rebuild from Postgres on the next leaderboard query
+func (h *Handler) markLeaderboardStale(err error) {
+	h.logger.Warn("leaderboard update failed, sets will be rebuilt", "error", err.Error())
+	h.leaderboardStale.Store(true)
+	//Other instances only notice through the marker; if Redis is down this fails too and a restart rebuilds anyway
+	h.redis.Del(context.Background(), leaderboardBuiltKey)
+}
+
+//recordLeaderboardActivity adds an order, cancellation or refund of a store to the day and week windows
+//and refreshes its all-time scores. Failures never fail the mutation; they mark the sets for rebuild.
+func (h *Handler) recordLeaderboardActivity(ctx context.Context, storeID int, revenue Money, orders int) {
+	if h.redis == nil {
+		return
+	}
+
+	score, err := h.revenueScore(ctx, revenue, map[string]*big.Rat{})
+	if err != nil {
+		h.markLeaderboardStale(err)
+		return
+	}
+
+	now := time.Now()
+	member := strconv.Itoa(storeID)
+	pipe := h.redis.Pipeline()
+	for _, period := range []string{periodDay, periodWeek} {
+		revenueKey := leaderboardKey(leaderboardRevenue, period, now)
+		pipe.ZIncrBy(ctx, revenueKey, score, member)
+		pipe.Expire(ctx, revenueKey, leaderboardTTL(period))
+		if orders != 0 {
+			ordersKey := leaderboardKey(leaderboardOrders, period, now)
+			pipe.ZIncrBy(ctx, ordersKey, float64(orders), member)
+			pipe.Expire(ctx, ordersKey, leaderboardTTL(period))
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.markLeaderboardStale(err)
+		return
+	}
+
+	h.refreshStoreLeaderboard(ctx, storeID)
+}
+
+//refreshStoreLeaderboard copies a store's current all-time revenue, order count and rating into the sets.
+//Leaderboards are public, so deleted stores and drafts are taken out instead.
+func (h *Handler) refreshStoreLeaderboard(ctx context.Context, storeID int) {
+	if h.redis == nil {
+		return
+	}
+
+	var revenue Money
+	var orders, reviewCount, ratingSum int
+	err := h.database.QueryRowContext(ctx,
+		"SELECT revenue_cents, currency, total_orders, review_count, rating_sum FROM stores WHERE id = $1 AND deleted_at IS NULL AND status <> 'draft'", storeID).
+		Scan(&revenue.Amount, &revenue.Currency, &orders, &reviewCount, &ratingSum)
+	if err == sql.ErrNoRows {
+		h.removeStoreFromLeaderboard(ctx, storeID)
+		return
+	}
+	if err != nil {
+		h.markLeaderboardStale(err)
+		return
+	}
+	score, err := h.revenueScore(ctx, revenue, map[string]*big.Rat{})
+	if err != nil {
+		h.markLeaderboardStale(err)
+		return
+	}
+
+	member := strconv.Itoa(storeID)
+	pipe := h.redis.Pipeline()
+	pipe.ZAdd(ctx, leaderboardKey(leaderboardRevenue, periodAll, time.Time{}), redis.Z{Score: score, Member: member})
+	pipe.ZAdd(ctx, leaderboardKey(leaderboardOrders, periodAll, time.Time{}), redis.Z{Score: float64(orders), Member: member})
+	if reviewCount > 0 {
+		pipe.ZAdd(ctx, leaderboardKey(leaderboardRating, periodAll, time.Time{}),
+			redis.Z{Score: float64(ratingSum) / float64(reviewCount), Member: member})
+	} else {
+		pipe.ZRem(ctx, leaderboardKey(leaderboardRating, periodAll, time.Time{}), member)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.markLeaderboardStale(err)
+	}
+}
+
+//removeStoreFromLeaderboard drops a deleted or draft store from every current set
+func (h *Handler) removeStoreFromLeaderboard(ctx context.Context, storeID int) {
+	if h.redis == nil {
+		return
+	}
+
+	now := time.Now()
+	member := strconv.Itoa(storeID)
+	pipe := h.redis.Pipeline()
+	for metric, periods := range leaderboardMetrics {
+		for _, period := range periods {
+			pipe.ZRem(ctx, leaderboardKey(metric, period, now), member)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		h.markLeaderboardStale(err)
+	}
+}
+
+//leaderboardScoresFromDB computes every store's score for a metric and period from Postgres:
+//all-time figures from the store rows, windows from the revenue ledger
+func (h *Handler) leaderboardScoresFromDB(ctx context.Context, metric, period string, now time.Time) (map[int]float64, error) {
+	var rows *sql.Rows
+	var err error
+	switch {
+	case metric == leaderboardRating:
+		rows, err = h.database.QueryContext(ctx,
+			"SELECT id, rating_sum::float8 / review_count FROM stores WHERE review_count > 0 AND deleted_at IS NULL AND status <> 'draft'")
+	case period == periodAll && metric == leaderboardOrders:
+		rows, err = h.database.QueryContext(ctx, "SELECT id, total_orders FROM stores WHERE deleted_at IS NULL AND status <> 'draft'")
+	case period == periodAll:
+		rows, err = h.database.QueryContext(ctx, "SELECT id, revenue_cents, currency FROM stores WHERE deleted_at IS NULL AND status <> 'draft'")
+	case metric == leaderboardOrders:
+		rows, err = h.database.QueryContext(ctx,
+			`SELECT a.store_id, SUM(a.orders_delta) FROM revenue_adjustments a JOIN stores s ON s.id = a.store_id
+			WHERE a.created_at >= $1 AND a.kind <> $2 AND s.deleted_at IS NULL AND s.status <> 'draft' GROUP BY a.store_id`, windowStart(period, now), adjustmentOpeningBalance)
+	default:
+		rows, err = h.database.QueryContext(ctx,
+			`SELECT a.store_id, SUM(a.amount_cents), s.currency FROM revenue_adjustments a JOIN stores s ON s.id = a.store_id
+			WHERE a.created_at >= $1 AND a.kind <> $2 AND s.deleted_at IS NULL AND s.status <> 'draft' GROUP BY a.store_id, s.currency`, windowStart(period, now), adjustmentOpeningBalance)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load leaderboard scores: %w", err)
+	}
+	defer rows.Close()
+
+	rates := map[string]*big.Rat{}
+	scores := map[int]float64{}
+	for rows.Next() {
+		var storeID int
+		if metric != leaderboardRevenue {
+			var score float64
+			if err := rows.Scan(&storeID, &score); err != nil {
+				return nil, err
+			}
+			scores[storeID] = score
+			continue
+		}
+
+		var revenue Money
+		if err := rows.Scan(&storeID, &revenue.Amount, &revenue.Currency); err != nil {
+			return nil, err
+		}
+		score, err := h.revenueScore(ctx, revenue, rates)
+		if err != nil {
+			h.logger.Warn("store left out of revenue leaderboard", "store_id", storeID, "error", err.Error())
+			continue
+		}
+		scores[storeID] = score
+	}
+	return scores, rows.Err()
+}
+
+//rebuildLeaderboards recomputes every set from Postgres and swaps each one in atomically
+func (h *Handler) rebuildLeaderboards(ctx context.Context) error {
+	now := time.Now()
+	for metric, periods := range leaderboardMetrics {
+		for _, period := range periods {
+			scores, err := h.leaderboardScoresFromDB(ctx, metric, period, now)
+			if err != nil {
+				return err
+			}
+
+			key := leaderboardKey(metric, period, now)
+			tmpKey := key + ":rebuild"
+			members := make([]redis.Z, 0, len(scores))
+			for storeID, score := range scores {
+				members = append(members, redis.Z{Score: score, Member: strconv.Itoa(storeID)})
+			}
+
+			pipe := h.redis.TxPipeline()
+			pipe.Del(ctx, tmpKey)
+			if len(members) > 0 {
+				pipe.ZAdd(ctx, tmpKey, members...)
+				pipe.Rename(ctx, tmpKey, key)
+				if ttl := leaderboardTTL(period); ttl > 0 {
+					pipe.Expire(ctx, key, ttl)
+				}
+			} else {
+				pipe.Del(ctx, key)
+			}
+			if _, err := pipe.Exec(ctx); err != nil {
+				return fmt.Errorf("failed to write leaderboard %s: %w", key, err)
+			}
+		}
+	}
+
+	if err := h.redis.Set(ctx, leaderboardBuiltKey, now.UTC().Format(time.RFC3339), 0).Err(); err != nil {
+		return err
+	}
+	h.leaderboardStale.Store(false)
+	h.logger.Info("leaderboards rebuilt from postgres")
+	return nil
+}
+
+//ensureLeaderboards rebuilds the sets when Redis lost them (restart, eviction) or missed an update
+func (h *Handler) ensureLeaderboards(ctx context.Context) error {
+	if !h.leaderboardStale.Load() {
+		built, err := h.redis.Exists(ctx, leaderboardBuiltKey).Result()
+		if err != nil {
+			return err
+		}
+		if built == 1 {
+			return nil
+		}
+	}
+	return h.rebuildLeaderboards(ctx)
+}
+
+//leaderboardEntry is one ranked store
+type leaderboardEntry struct {
+	StoreID int
+	Score   float64
+}
+
+//topFromRedis reads the highest scores of a set
+func (h *Handler) topFromRedis(ctx context.Context, metric, period string, limit int) ([]leaderboardEntry, error) {
+	if err := h.ensureLeaderboards(ctx); err != nil {
+		return nil, err
+	}
+	members, err := h.redis.ZRevRangeWithScores(ctx, leaderboardKey(metric, period, time.Now()), 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]leaderboardEntry, 0, len(members))
+	for _, m := range members {
+		storeID, err := strconv.Atoi(fmt.Sprint(m.Member))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, leaderboardEntry{StoreID: storeID, Score: m.Score})
+	}
+	return entries, nil
+}
+
+//topFromDB ranks stores straight from Postgres, used when Redis is not available
+func (h *Handler) topFromDB(ctx context.Context, metric, period string, limit int) ([]leaderboardEntry, error) {
+	scores, err := h.leaderboardScoresFromDB(ctx, metric, period, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]leaderboardEntry, 0, len(scores))
+	for storeID, score := range scores {
+		entries = append(entries, leaderboardEntry{StoreID: storeID, Score: score})
+	}
+	//Ties rank the higher store id first, like ZREVRANGE
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Score != entries[j].Score {
+			return entries[i].Score > entries[j].Score
+		}
+		return entries[i].StoreID > entries[j].StoreID
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+//leaderboardResolver returns the top stores by revenue, orders or rating
+func (h *Handler) leaderboardResolver(p graphql.ResolveParams) (interface{}, error) {
+	metric, _ := p.Args["metric"].(string)
+	period := periodAll
+	if v, ok := p.Args["period"].(string); ok && v != "" {
+		period = v
+	}
+	periods, ok := leaderboardMetrics[metric]
+	if !ok {
+		return nil, fmt.Errorf("invalid metric %q: use revenue, orders or rating", metric)
+	}
+	validPeriod := false
+	for _, allowed := range periods {
+		validPeriod = validPeriod || allowed == period
+	}
+	if !validPeriod {
+		return nil, fmt.Errorf("invalid period %q for %s leaderboard", period, metric)
+	}
+	limit := defaultLeaderboardLimit
+	if v, ok := p.Args["limit"].(int); ok {
+		if v <= 0 || v > maxLeaderboardLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxLeaderboardLimit)
+		}
+		limit = v
+	}
+
+	ctx := p.Context
+	var entries []leaderboardEntry
+	var err error
+	if h.redis != nil {
+		entries, err = h.topFromRedis(ctx, metric, period, limit)
+		if err != nil {
+			h.logger.Warn("leaderboard unavailable in redis, reading postgres", "metric", metric, "period", period, "error", err.Error())
+			h.leaderboardStale.Store(true)
+		}
+	}
+	if h.redis == nil || err != nil {
+		entries, err = h.topFromDB(ctx, metric, period, limit)
+		if err != nil {
+			h.logger.Error("database error computing leaderboard", "metric", metric, "period", period, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	return h.leaderboardRows(ctx, metric, entries)
+}
+
+//leaderboardRows loads the ranked stores and formats their scores
+func (h *Handler) leaderboardRows(ctx context.Context, metric string, entries []leaderboardEntry) ([]map[string]interface{}, error) {
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = int64(e.StoreID)
+	}
+
+	stores := map[int]map[string]interface{}{}
+	if len(ids) > 0 {
+		rows, err := h.database.QueryContext(ctx,
+			"SELECT id, name, revenue_cents, currency, total_orders, status, user_id, slug, logo_key FROM stores WHERE id = ANY($1) AND deleted_at IS NULL AND status <> 'draft'", pq.Array(ids))
+		if err != nil {
+			h.logger.Error("database error loading leaderboard stores", "error", err.Error())
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id, totalOrders, userID int
+			var name, status, slug string
+			var revenue Money
+			var logoKey sql.NullString
+			if err := rows.Scan(&id, &name, &revenue.Amount, &revenue.Currency, &totalOrders, &status, &userID, &slug, &logoKey); err != nil {
+				return nil, err
+			}
+			stores[id] = map[string]interface{}{
+				"id":           id,
+				"name":         name,
+				"revenue":      revenue,
+				"currency":     revenue.Currency,
+				"total_orders": totalOrders,
+				"status":       status,
+				"user_id":      userID,
+				"slug":         slug,
+				"logo_key":     nil,
+			}
+			if logoKey.Valid {
+				stores[id]["logo_key"] = logoKey.String
+			}
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	target := reportingCurrency()
+	result := []map[string]interface{}{}
+	for _, e := range entries {
+		store, ok := stores[e.StoreID]
+		if !ok {
+			continue //Deleted since the set was written
+		}
+		entry := map[string]interface{}{
+			"rank":  len(result) + 1,
+			"store": store,
+			"score": e.Score,
+		}
+		switch metric {
+		case leaderboardRevenue:
+			revenue := Money{Amount: int64(math.Round(e.Score)), Currency: target}
+			entry["score"], _ = strconv.ParseFloat(revenue.Decimal(), 64)
+			entry["revenue"] = revenue
+		case leaderboardRating:
+			entry["score"] = math.Round(e.Score*100) / 100
+		}
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+//newLeaderboardEntryType builds the LeaderboardEntry GraphQL type around the Store type
+func newLeaderboardEntryType(storeType *graphql.Object) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "LeaderboardEntry",
+		Fields: graphql.Fields{
+			"rank":    &graphql.Field{Type: graphql.Int},
+			"score":   &graphql.Field{Type: graphql.Float},
+			"revenue": &graphql.Field{Type: moneyType, Description: "Revenue in the reporting currency, for the revenue metric"},
+			"store":   &graphql.Field{Type: storeType},
+		},
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+func TestLeaderboardKey(t *testing.T) {
+	//Sunday 2026-05-10 belongs to the week starting Monday 2026-05-04
+	sunday := time.Date(2026, 5, 10, 23, 30, 0, 0, time.UTC)
+
+	cases := []struct {
+		metric, period string
+		want           string
+	}{
+		{leaderboardRevenue, periodDay, "leaderboard:revenue:day:2026-05-10"},
+		{leaderboardRevenue, periodWeek, "leaderboard:revenue:week:2026-05-04"},
+		{leaderboardOrders, periodAll, "leaderboard:orders:all"},
+	}
+	for _, c := range cases {
+		if got := leaderboardKey(c.metric, c.period, sunday); got != c.want {
+			t.Errorf("leaderboardKey(%s, %s) = %s, want %s", c.metric, c.period, got, c.want)
+		}
+	}
+
+	monday := time.Date(2026, 5, 4, 0, 0, 0, 0, time.UTC)
+	if got := windowStart(periodWeek, monday); !got.Equal(monday) {
+		t.Errorf("Expected a Monday to start its own week, got %v", got)
+	}
+}
+
+func TestLeaderboardResolver_PostgresFallbackConvertsRevenue(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+	t.Setenv("REPORTING_CURRENCY", "USD")
+
+	//ARRANGE: Without Redis, all-time revenue is ranked from the store rows in the reporting currency
+	mock.ExpectQuery("SELECT id, revenue_cents, currency FROM stores").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "revenue_cents", "currency"}).
+			AddRow(1, int64(10000), "USD").
+			AddRow(2, int64(10000), "EUR").
+			AddRow(3, int64(500), "USD"))
+	mock.ExpectQuery("SELECT rate FROM exchange_rates").
+		WithArgs("EUR", "USD").
+		WillReturnRows(sqlmock.NewRows([]string{"rate"}).AddRow("1.10"))
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = ANY\\(\\$1\\) AND deleted_at IS NULL AND status <> 'draft'").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "revenue_cents", "currency", "total_orders", "status", "user_id", "slug", "logo_key"}).
+			AddRow(1, "Dollar Shop", int64(10000), "USD", 4, "active", 7, "dollar-shop", nil).
+			AddRow(2, "Euro Shop", int64(10000), "EUR", 3, "active", 8, "euro-shop", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: context.Background(),
+		Args:    map[string]interface{}{"metric": "revenue", "limit": 2},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.leaderboardResolver(params)
+
+	//ASSERT: 100 EUR (110 USD) outranks 100 USD and the list is cut at the limit
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	entries := result.([]map[string]interface{})
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(entries))
+	}
+	first := entries[0]
+	if first["rank"] != 1 || first["store"].(map[string]interface{})["name"] != "Euro Shop" {
+		t.Errorf("Expected Euro Shop first, got %v", first)
+	}
+	if first["revenue"] != (Money{Amount: 11000, Currency: "USD"}) || first["score"] != 110.0 {
+		t.Errorf("Expected 110.00 USD, got %v / %v", first["revenue"], first["score"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLeaderboardResolver_WeeklyOrdersFromLedger(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: This week's orders come from the ledger, opening balances excluded
+	mock.ExpectQuery("SELECT a.store_id, SUM\\(a.orders_delta\\) FROM revenue_adjustments a JOIN stores s (.+) AND s.deleted_at IS NULL AND s.status <> 'draft'").
+		WithArgs(windowStart(periodWeek, time.Now()), adjustmentOpeningBalance).
+		WillReturnRows(sqlmock.NewRows([]string{"store_id", "sum"}).AddRow(1, 2).AddRow(2, 5))
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = ANY\\(\\$1\\) AND deleted_at IS NULL AND status <> 'draft'").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "revenue_cents", "currency", "total_orders", "status", "user_id", "slug", "logo_key"}).
+			AddRow(1, "Small", int64(100), "USD", 9, "active", 7, "small", nil).
+			AddRow(2, "Busy", int64(100), "USD", 9, "active", 8, "busy", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: context.Background(),
+		Args:    map[string]interface{}{"metric": "orders", "period": "week"},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.leaderboardResolver(params)
+
+	//ASSERT: Ranked by this week's orders
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	entries := result.([]map[string]interface{})
+	if len(entries) != 2 || entries[0]["store"].(map[string]interface{})["id"] != 2 || entries[0]["score"] != 5.0 {
+		t.Errorf("Expected Busy first with 5 orders, got %v", entries)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestLeaderboardResolver_InvalidArguments(t *testing.T) {
+	handler := &Handler{logger: slog.New(slog.NewJSONHandler(io.Discard, nil))}
+
+	for _, args := range []map[string]interface{}{
+		{"metric": "profit"},
+		{"metric": "rating", "period": "day"},
+		{"metric": "orders", "period": "month"},
+		{"metric": "orders", "limit": 500},
+	} {
+		if _, err := handler.leaderboardResolver(graphql.ResolveParams{Context: context.Background(), Args: args}); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
@@ -12,6 +12,9 @@ import (
 	"time"
 	"encoding/json"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	
 
 	"github.com/joho/godotenv"
@@ -97,6 +100,9 @@ type Handler struct {
 	database *sql.DB
 	logger *slog.Logger
 	redis    *redis.Client
+	blobs    BlobStore //nil when uploads are not configured
+	notifier Notifier  //nil disables anomaly notifications
+	leaderboardStale atomic.Bool //a leaderboard update missed Redis, see ensureLeaderboards
 }
 
 
@@ -185,8 +191,10 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-//prometheusMiddleware wraps handlers to automatically record metrics
-func prometheusMiddleware(next http.Handler) http.Handler {
+//prometheusMiddleware wraps handlers to automatically record metrics. Requests are
+//labelled with the route they were registered under, not their path, so ids and
+//slugs in URLs do not create a new series each.
+func prometheusMiddleware(route string, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		//Start timer
 		start := time.Now()
@@ -206,14 +214,14 @@ func prometheusMiddleware(next http.Handler) http.Handler {
 		//Record counter metric
 		httpRequestsTotal.WithLabelValues(
 			r.Method,
-			r.URL.Path,
+			route,
 			fmt.Sprintf("%d", wrappedWriter.statusCode),
 		).Inc()
 
 		//Record histogram metric
 		httpRequestDuration.WithLabelValues(
 			r.Method,
-			r.URL.Path,
+			route,
 		).Observe(duration)
 	})
 }
@@ -224,8 +232,10 @@ func corsMiddleware(next http.Handler) http.Handler {
 		//Allow requests from any origin (for development)
 		//In production, you'd restrict this to your frontend domain
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match")
+		//Let browser clients read the version to send back in If-Match
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		//Handle preflight OPTIONS request
 		if r.Method == "OPTIONS" {
@@ -331,6 +341,18 @@ func(h *Handler) getStoreInfo(w http.ResponseWriter, r *http.Request) {
 			)
 			cacheHits.WithLabelValues("store").Inc()
 			w.Header().Set("X-Cache", "HIT")
+
+			//Conditional GETs are answered from the cached version without a body
+			etag := ""
+			if version := cachedStoreVersion(cachedData); version > 0 {
+				etag = storeETag(version)
+			}
+			if writeNotModified(w, r, etag) {
+				return
+			}
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.Write([]byte(cachedData))
 			return
@@ -353,18 +375,20 @@ func(h *Handler) getStoreInfo(w http.ResponseWriter, r *http.Request) {
 	_, dbSpan := tracer.Start(ctx, "database.query.stores")
 	dbSpan.SetAttributes(
 		attribute.String("db.system", "postgresql"),
-		attribute.String("db.statement", "SELECT name, revenue, total_orders, active FROM stores WHERE id = $1"),
+		attribute.String("db.statement", "SELECT name, revenue_cents, currency, total_orders, status, version FROM stores s WHERE id = $1 AND deleted_at IS NULL AND "+fmt.Sprintf(draftVisibility, 2)),
 		attribute.String("store.id", storeID),
 	)
 
 	//Query Postgres
 	var name string
-	var revenue float64
+	var revenue Money
 	var totalOrders int
-	var active bool
+	var status string
+	var version int
 
-	query := "SELECT name, revenue, total_orders, active FROM stores WHERE id = $1"
-	err := h.database.QueryRow(query, storeID).Scan(&name, &revenue, &totalOrders, &active)
+	//Drafts are only shown to their owner
+	query := "SELECT name, revenue_cents, currency, total_orders, status, version FROM stores s WHERE id = $1 AND deleted_at IS NULL AND " + fmt.Sprintf(draftVisibility, 2)
+	err := h.database.QueryRow(query, storeID, h.viewerID(r)).Scan(&name, &revenue.Amount, &revenue.Currency, &totalOrders, &status, &version)
 
 	dbSpan.End()
 
@@ -386,12 +410,12 @@ func(h *Handler) getStoreInfo(w http.ResponseWriter, r *http.Request) {
 	}
 
 	response := fmt.Sprintf(
-		`{"store_id": "%s", "name": "%s", "revenue": %.2f, "total_orders": %d, "active": %t}`,
-		storeID, name, revenue, totalOrders, active,
+		`{"store_id": "%s", "name": "%s", "revenue": %s, "currency": "%s", "total_orders": %d, "status": "%s", "version": %d}`,
+		storeID, name, revenue.Decimal(), revenue.Currency, totalOrders, status, version,
 	)
 
-	//Store in cache for next time 
-	if h.redis != nil {
+	//Store in cache for next time; a draft's owner is the only one who may see it
+	if h.redis != nil && status != storeStatusDraft {
 		_, cacheSetSpan := tracer.Start(ctx, "cache.set")
 		cacheSetSpan.SetAttributes(
 			attribute.String("cache.key", "store:"+storeID),
@@ -418,10 +442,14 @@ func(h *Handler) getStoreInfo(w http.ResponseWriter, r *http.Request) {
 	h.logger.Info("store query successful",
 		"store_id", storeID,
 		"name", name,
-		"revenue", revenue,
+		"revenue", revenue.String(),
 	)
 
 	w.Header().Set("X-Cache", "MISS")
+	if writeNotModified(w, r, storeETag(version)) {
+		return
+	}
+	w.Header().Set("ETag", storeETag(version))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(response))
 }
@@ -430,8 +458,15 @@ func(h *Handler) getStoreInfo(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) storesResolver(p graphql.ResolveParams) (interface{}, error) {
 	h.logger.Info("graphql stores query - fetching all stores")
 
-	query := "SELECT id, name, revenue, total_orders, active, user_id FROM stores ORDER BY id"
-	rows, err := h.database.Query(query)
+	filter, err := storeFilterArg(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	where, args := filter.where()
+	args = append(args, h.resolverViewerID(p))
+	where += " AND " + fmt.Sprintf(draftVisibility, len(args))
+	query := "SELECT id, name, revenue_cents, currency, total_orders, status, version, user_id, slug, logo_key FROM stores s " + where + " ORDER BY id"
+	rows, err := h.database.QueryContext(p.Context, query, args...)
 	if err != nil {
 		h.logger.Error("database error during stores query",
 			"error", err.Error(),
@@ -445,12 +480,15 @@ func (h *Handler) storesResolver(p graphql.ResolveParams) (interface{}, error) {
 	for rows.Next() {
 		var id int
 		var name string
-		var revenue float64
+		var revenue Money
 		var totalOrders int
-		var active bool
+		var status string
+		var version int
 		var userID sql.NullInt64 // Use sql.NullInt64 for nullable columns
+		var slug string
+		var logoKey sql.NullString
 
-		err := rows.Scan(&id, &name, &revenue, &totalOrders, &active, &userID)
+		err := rows.Scan(&id, &name, &revenue.Amount, &revenue.Currency, &totalOrders, &status, &version, &userID, &slug, &logoKey)
 		if err != nil {
 			h.logger.Error("error scanning store row",
 				"error", err.Error(),
@@ -462,8 +500,15 @@ func (h *Handler) storesResolver(p graphql.ResolveParams) (interface{}, error) {
 			"id":           id,
 			"name":         name,
 			"revenue":      revenue,
+			"currency":     revenue.Currency,
 			"total_orders": totalOrders,
-			"active":       active,
+			"status":       status,
+			"version":      version,
+			"slug":         slug,
+			"logo_key":     nil,
+		}
+		if logoKey.Valid {
+			store["logo_key"] = logoKey.String
 		}
 
 		// Add user_id if it's not null
@@ -485,8 +530,22 @@ func (h *Handler) storesResolver(p graphql.ResolveParams) (interface{}, error) {
 
 //Method that returns a GraphQL resolver function (READ)
 func (h *Handler) storeResolver(p graphql.ResolveParams) (interface{}, error){
-	//Extract ID from query
+	//Extract ID from query, or look it up by slug
 	id, ok := p.Args["id"].(int)
+	if slug, bySlug := p.Args["slug"].(string); bySlug {
+		if ok {
+			return nil, fmt.Errorf("pass either id or slug, not both")
+		}
+		storeID, _, err := storeIDBySlug(p.Context, h.database, strings.ToLower(slug))
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("store not found")
+		}
+		if err != nil {
+			h.logger.Error("database error looking up store slug", "slug", slug, "error", err.Error())
+			return nil, err
+		}
+		id, ok = storeID, true
+	}
 	if !ok {
 		h.logger.Error("invalid store id argument")
 		return nil, fmt.Errorf("invalid id")
@@ -496,16 +555,29 @@ func (h *Handler) storeResolver(p graphql.ResolveParams) (interface{}, error){
 		"store_id", id,
 	)
 
+	//A point-in-time read comes from the revision history instead of the live row
+	viewerID := h.resolverViewerID(p)
+	if asOf, ok := p.Args["asOf"].(time.Time); ok {
+		store, err := h.storeAsOf(p.Context, id, asOf)
+		if err == nil && storeHiddenFrom(store, viewerID) {
+			return nil, fmt.Errorf("store not found")
+		}
+		return store, err
+	}
 
 	//Query database - using h.database instead of global db
 	var storeID int
 	var name string
-	var revenue float64
+	var revenue Money
 	var totalOrders int
-	var active bool
+	var status string
+	var version int
+	var slug string
+	var logoKey sql.NullString
 
-	query := "SELECT id, name, revenue, total_orders, active FROM stores WHERE id = $1"
-	err := h.database.QueryRow(query, id).Scan(&storeID, &name, &revenue, &totalOrders, &active)
+	//Drafts are only shown to their owner
+	query := "SELECT id, name, revenue_cents, currency, total_orders, status, version, slug, logo_key FROM stores s WHERE id = $1 AND deleted_at IS NULL AND " + fmt.Sprintf(draftVisibility, 2)
+	err := h.database.QueryRow(query, id, viewerID).Scan(&storeID, &name, &revenue.Amount, &revenue.Currency, &totalOrders, &status, &version, &slug, &logoKey)
 
 	if err == sql.ErrNoRows {
 		h.logger.Warn("store not found",
@@ -529,13 +601,21 @@ func (h *Handler) storeResolver(p graphql.ResolveParams) (interface{}, error){
 	
 
 	//Return as a map
-	return map[string]interface{}{
+	store := map[string]interface{}{
 		"id":           storeID,
 		"name":         name,
 		"revenue":      revenue,
+		"currency":     revenue.Currency,
 		"total_orders": totalOrders,
-		"active":       active,
-	}, nil
+		"status":       status,
+		"version":      version,
+		"slug":         slug,
+		"logo_key":     nil,
+	}
+	if logoKey.Valid {
+		store["logo_key"] = logoKey.String
+	}
+	return store, nil
 
 
 
@@ -559,8 +639,17 @@ func (h *Handler) createStoreResolver(p graphql.ResolveParams) (interface{}, err
 
 	// 2. Extract arguments
 	name, nameOk := p.Args["name"].(string)
-	revenue, revenueOk := p.Args["revenue"].(float64)
-	active, activeOk := p.Args["active"].(bool)
+	currencyArg, _ := p.Args["currency"].(string)
+	status, statusOk := p.Args["status"].(string)
+
+	currency, err := normalizeCurrency(currencyArg)
+	if err != nil {
+		return nil, err
+	}
+	revenue, revenueOk, err := moneyArg(p.Args, "revenue", currency)
+	if err != nil {
+		return nil, err
+	}
 
 	//Validate required fields
 	if !nameOk || !revenueOk {
@@ -568,22 +657,43 @@ func (h *Handler) createStoreResolver(p graphql.ResolveParams) (interface{}, err
 		return nil, fmt.Errorf("invalid arguments: name and revenue are required")
 	}
 
-	//Default active to true if not provided
-	if !activeOk {
-		active = true
+	//New stores open right away unless they start as a draft
+	if !statusOk {
+		status = storeStatusActive
+	}
+	if status != storeStatusActive && status != storeStatusDraft {
+		return nil, fmt.Errorf("new stores start as draft or active")
 	}
 
 	h.logger.Info("creating new store",
 		"name", name,
-		"revenue", revenue,
-		"active", active,
+		"revenue", revenue.String(),
+		"status", status,
 		"user_id", userID,
 	)
 
-	// 3. Insert into database WITH user_id
+	// 3. Insert into database WITH user_id, together with the opening ledger entry
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setRevisionActor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	slugs, err := allocateStoreSlugs(ctx, tx, []string{name}, 0)
+	if err != nil {
+		h.logger.Error("failed to allocate store slug", "name", name, "error", err.Error())
+		return nil, err
+	}
+
 	var newID int
-	query := "INSERT INTO stores (name, revenue, total_orders, active, user_id) VALUES ($1, $2, $3, $4, $5) RETURNING id"
-	err = h.database.QueryRow(query, name, revenue, 0, active, userID).Scan(&newID)
+	query := "INSERT INTO stores (name, revenue_cents, currency, total_orders, status, user_id, slug) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+	err = tx.QueryRowContext(ctx, query, name, revenue.Amount, revenue.Currency, 0, status, userID, slugs[0]).Scan(&newID)
 
 	if err != nil {
 		h.logger.Error("database error during insert",
@@ -593,6 +703,36 @@ func (h *Handler) createStoreResolver(p graphql.ResolveParams) (interface{}, err
 		return nil, err
 	}
 
+	err = recordRevenueAdjustment(ctx, tx, revenueAdjustment{
+		StoreID:   newID,
+		Kind:      adjustmentOpeningBalance,
+		Amount:    revenue.Amount,
+		CreatedBy: sql.NullInt64{Int64: int64(userID), Valid: true},
+	})
+	if err != nil {
+		h.logger.Error("failed to record opening balance",
+			"store_id", newID,
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+
+	if err := recordInitialStoreStatus(ctx, tx, newID, status, userID); err != nil {
+		h.logger.Error("failed to record store status",
+			"store_id", newID,
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit store creation",
+			"store_id", newID,
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+
 	h.logger.Info("store created successfully",
 		"store_id", newID,
 		"name", name,
@@ -600,25 +740,22 @@ func (h *Handler) createStoreResolver(p graphql.ResolveParams) (interface{}, err
 	)
 
 	// 4. Invalidate cache
-	if h.redis != nil {
-		cacheKey := fmt.Sprintf("store:%d", newID)
-		err := h.redis.Del(context.Background(), cacheKey).Err()
-		if err != nil {
-			h.logger.Warn("failed to invalidate cache after create",
-				"store_id", newID,
-				"error", err.Error(),
-			)
-		}
-	}
+	h.invalidateStoreCache(newID)
+
+	h.refreshStoreLeaderboard(p.Context, newID)
 
 	// 5. Return the created store
 	return map[string]interface{}{
 		"id":           newID,
 		"name":         name,
 		"revenue":      revenue,
+		"currency":     revenue.Currency,
 		"total_orders": 0,
-		"active":       active,
+		"status":       status,
+		"version":      1,
 		"user_id":      userID,
+		"slug":         slugs[0],
+		"logo_key":     nil,
 	}, nil
 }
 
@@ -644,13 +781,24 @@ func (h *Handler) updateStoreResolver(p graphql.ResolveParams) (interface{}, err
 		return nil, fmt.Errorf("invalid id")
 	}
 
-	// 3. Check ownership before allowing update
+	// 3. Collect the fields the caller actually sent
+	expectedVersion, _ := p.Args["expectedVersion"].(int)
+	return h.updateStore(p.Context, userID, id, graphqlStorePatch(p), expectedVersion)
+}
+
+//updateStore applies a partial update to a store owned by userID. Only the
+//fields present in the patch are written; the status only changes through
+//setStoreStatus. Shared by the updateStore mutation and PATCH /stores/{id}.
+//A non-zero expectedVersion makes the write fail with a versionConflictError
+//when the store has changed since the caller read it.
+func (h *Handler) updateStore(ctx context.Context, userID int, id int, patch storePatch, expectedVersion int) (map[string]interface{}, error) {
+	// 1. Check ownership before allowing update
 	var storeUserID int
-	ownershipQuery := "SELECT user_id FROM stores WHERE id = $1"
-	err = h.database.QueryRow(ownershipQuery, id).Scan(&storeUserID)
+	ownershipQuery := "SELECT user_id FROM stores WHERE id = $1 AND deleted_at IS NULL"
+	err := h.database.QueryRowContext(ctx, ownershipQuery, id).Scan(&storeUserID)
 	if err == sql.ErrNoRows {
 		h.logger.Warn("store not found for update", "store_id", id)
-		return nil, fmt.Errorf("store with id %d not found", id)
+		return nil, &requestError{Status: http.StatusNotFound, Message: fmt.Sprintf("store with id %d not found", id)}
 	}
 	if err != nil {
 		h.logger.Error("database error checking ownership", "store_id", id, "error", err.Error())
@@ -663,23 +811,62 @@ func (h *Handler) updateStoreResolver(p graphql.ResolveParams) (interface{}, err
 			"requesting_user", userID,
 			"store_owner", storeUserID,
 		)
-		return nil, fmt.Errorf("you can only update your own stores")
+		return nil, &requestError{Status: http.StatusForbidden, Message: "you can only update your own stores"}
 	}
 
-	// 4. Extract optional fields
-	name, _ := p.Args["name"].(string)
-	revenue, _ := p.Args["revenue"].(float64)
-	totalOrders, _ := p.Args["total_orders"].(int)
-	active, _ := p.Args["active"].(bool)
+	// 2. Turn the patch into a SET clause
+	setClause, args, err := patch.setClause()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		//Nothing to change, but a stale version is still a conflict
+		store, err := loadStore(ctx, h.database, id)
+		if err == nil && expectedVersion != 0 && store["version"] != expectedVersion {
+			return nil, &versionConflictError{StoreID: id, Expected: expectedVersion, Current: store["version"].(int)}
+		}
+		return store, err
+	}
 
 	h.logger.Info("updating store",
 		"store_id", id,
 		"user_id", userID,
+		"fields", patch.fields(),
 	)
 
-	// 5. Update the database
-	query := "UPDATE stores SET name = $1, revenue = $2, total_orders = $3, active = $4 WHERE id = $5"
-	result, err := h.database.Exec(query, name, revenue, totalOrders, active, id)
+	// 3. Update the database, only if the store is still at the expected version
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setRevisionActor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	//A new name brings a new slug; the old one is kept to redirect from
+	var slug, previousSlug string
+	if name, ok := patch["name"].(string); ok {
+		slug, previousSlug, err = renamedStoreSlug(ctx, tx, id, name)
+		if err != nil {
+			h.logger.Error("failed to allocate store slug", "store_id", id, "error", err.Error())
+			return nil, err
+		}
+		if slug != previousSlug {
+			args = append(args, slug)
+			setClause += fmt.Sprintf(", slug = $%d", len(args))
+		}
+	}
+
+	args = append(args, id)
+	query := "UPDATE stores SET " + setClause + fmt.Sprintf(" WHERE id = $%d", len(args))
+	if expectedVersion != 0 {
+		args = append(args, expectedVersion)
+		query += fmt.Sprintf(" AND version = $%d", len(args))
+	}
+	result, err := tx.ExecContext(ctx, query, args...)
 
 	if err != nil {
 		h.logger.Error("database error during update",
@@ -694,10 +881,27 @@ func (h *Handler) updateStoreResolver(p graphql.ResolveParams) (interface{}, err
 		return nil, err
 	}
 	if rowsAffected == 0 {
+		if expectedVersion != 0 {
+			err := storeVersionConflict(ctx, tx, id, expectedVersion)
+			h.logger.Warn("store update rejected", "store_id", id, "error", err.Error())
+			return nil, err
+		}
 		h.logger.Warn("store not found for update",
 			"store_id", id,
 		)
-		return nil, fmt.Errorf("store with id %d not found", id)
+		return nil, &requestError{Status: http.StatusNotFound, Message: fmt.Sprintf("store with id %d not found", id)}
+	}
+
+	if slug != previousSlug {
+		if err := retireStoreSlug(ctx, tx, id, previousSlug, slug); err != nil {
+			h.logger.Error("failed to record previous store slug", "store_id", id, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit store update", "store_id", id, "error", err.Error())
+		return nil, err
 	}
 
 	h.logger.Info("store updated successfully",
@@ -705,43 +909,53 @@ func (h *Handler) updateStoreResolver(p graphql.ResolveParams) (interface{}, err
 		"user_id", userID,
 	)
 
-	// 6. Invalidate cache
-	if h.redis != nil {
-		cacheKey := fmt.Sprintf("store:%d", id)
-		err := h.redis.Del(context.Background(), cacheKey).Err()
-		if err != nil {
-			h.logger.Warn("failed to invalidate cache after update",
-				"store_id", id,
-				"error", err.Error(),
-			)
-		}
-	}
-
-	// 7. Fetch and return updated store
-	var storeID int
-	var storeName string
-	var storeRevenue float64
-	var storeTotalOrders int
-	var storeActive bool
+	// 4. Invalidate cache
+	h.invalidateStoreCache(id)
+	h.refreshStoreLeaderboard(ctx, id)
 
-	selectQuery := "SELECT id, name, revenue, total_orders, active, user_id FROM stores WHERE id = $1"
-	err = h.database.QueryRow(selectQuery, id).Scan(&storeID, &storeName, &storeRevenue, &storeTotalOrders, &storeActive, &storeUserID)
+	// 5. Fetch and return updated store
+	return loadStore(ctx, h.database, id)
+}
 
+//loadStore reads a live (not deleted) store row into the map the Store GraphQL type resolves from
+func loadStore(ctx context.Context, q rowQuerier, id int) (map[string]interface{}, error) {
+	var storeID int
+	var name, status, slug string
+	var revenue Money
+	var totalOrders, version int
+	var userID sql.NullInt64
+	var logoKey sql.NullString
+
+	query := "SELECT id, name, revenue_cents, currency, total_orders, status, version, user_id, slug, logo_key FROM stores WHERE id = $1 AND deleted_at IS NULL"
+	err := q.QueryRowContext(ctx, query, id).Scan(&storeID, &name, &revenue.Amount, &revenue.Currency, &totalOrders, &status, &version, &userID, &slug, &logoKey)
 	if err != nil {
 		return nil, err
 	}
 
-	return map[string]interface{}{
+	store := map[string]interface{}{
 		"id":           storeID,
-		"name":         storeName,
-		"revenue":      storeRevenue,
-		"total_orders": storeTotalOrders,
-		"active":       storeActive,
-		"user_id":      storeUserID,
-	}, nil
+		"name":         name,
+		"revenue":      revenue,
+		"currency":     revenue.Currency,
+		"total_orders": totalOrders,
+		"status":       status,
+		"version":      version,
+		"user_id":      nil,
+		"slug":         slug,
+		"logo_key":     nil,
+	}
+	if userID.Valid {
+		store["user_id"] = int(userID.Int64)
+	}
+	if logoKey.Valid {
+		store["logo_key"] = logoKey.String
+	}
+	return store, nil
 }
 
-//deleteStoreResolver handles deleting a store (DELETE) - REQUIRES AUTH + OWNERSHIP
+//deleteStoreResolver handles deleting a store (DELETE) - REQUIRES AUTH + OWNERSHIP.
+//The store is only marked deleted; restoreStore can bring it back until the
+//retention purge removes it for good.
 func (h *Handler) deleteStoreResolver(p graphql.ResolveParams) (interface{}, error) {
 	// 1. Get user ID from request context (requires authentication)
 	r, ok := p.Context.Value(httpRequestKey).(*http.Request)
@@ -765,7 +979,7 @@ func (h *Handler) deleteStoreResolver(p graphql.ResolveParams) (interface{}, err
 
 	// 3. Check ownership before allowing delete
 	var storeUserID int
-	ownershipQuery := "SELECT user_id FROM stores WHERE id = $1"
+	ownershipQuery := "SELECT user_id FROM stores WHERE id = $1 AND deleted_at IS NULL"
 	err = h.database.QueryRow(ownershipQuery, id).Scan(&storeUserID)
 	if err == sql.ErrNoRows {
 		h.logger.Warn("store not found for delete", "store_id", id)
@@ -790,10 +1004,38 @@ func (h *Handler) deleteStoreResolver(p graphql.ResolveParams) (interface{}, err
 		"user_id", userID,
 	)
 
-	// 4. Delete from database
-	query := "DELETE FROM stores WHERE id = $1"
-	result, err := h.database.Exec(query, id)
+	// 4. Mark the store deleted, only if it is still at the expected version
+	tx, err := h.database.BeginTx(p.Context, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setRevisionActor(p.Context, tx, userID); err != nil {
+		return nil, err
+	}
+
+	query := "UPDATE stores SET deleted_at = NOW() WHERE id = $1 AND deleted_at IS NULL"
+	args := []interface{}{id}
+	expectedVersion, _ := p.Args["expectedVersion"].(int)
+	if expectedVersion != 0 {
+		query += " AND version = $2"
+		args = append(args, expectedVersion)
+	}
+	var deletedAt time.Time
+	err = tx.QueryRowContext(p.Context, query+" RETURNING deleted_at", args...).Scan(&deletedAt)
 
+	// 5. No row means the store changed or went away since the ownership check
+	if err == sql.ErrNoRows && expectedVersion != 0 {
+		return nil, storeVersionConflict(p.Context, h.database, id, expectedVersion)
+	}
+	if err == sql.ErrNoRows {
+		h.logger.Warn("store not found for delete",
+			"store_id", id,
+		)
+		return nil, fmt.Errorf("store with id %d not found", id)
+	}
 	if err != nil {
 		h.logger.Error("database error during delete",
 			"store_id", id,
@@ -802,39 +1044,36 @@ func (h *Handler) deleteStoreResolver(p graphql.ResolveParams) (interface{}, err
 		return nil, err
 	}
 
-	// 5. Check if any rows were affected
-	rowsAffected, err := result.RowsAffected()
+	//Planned status changes would otherwise fire on a store nobody can see
+	_, err = tx.ExecContext(p.Context,
+		"UPDATE store_status_schedules SET state = $1, processed_at = NOW() WHERE store_id = $2 AND state = $3",
+		scheduleStateCancelled, id, scheduleStatePending)
 	if err != nil {
+		h.logger.Error("database error cancelling store schedules", "store_id", id, "error", err.Error())
 		return nil, err
 	}
-	if rowsAffected == 0 {
-		h.logger.Warn("store not found for delete",
-			"store_id", id,
-		)
-		return nil, fmt.Errorf("store with id %d not found", id)
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit store delete", "store_id", id, "error", err.Error())
+		return nil, err
 	}
 
+	restorableUntil := deletedAt.Add(storeRetention())
 	h.logger.Info("store deleted successfully",
 		"store_id", id,
 		"user_id", userID,
+		"restorable_until", restorableUntil,
 	)
 
 	// 6. Invalidate cache for the deleted store
-	if h.redis != nil {
-		cacheKey := fmt.Sprintf("store:%d", id)
-		err := h.redis.Del(context.Background(), cacheKey).Err()
-		if err != nil {
-			h.logger.Warn("failed to invalidate cache after delete",
-				"store_id", id,
-				"error", err.Error(),
-			)
-		}
-	}
+	h.invalidateStoreCache(id)
+	h.removeStoreFromLeaderboard(p.Context, id)
 
 	// 7. Return success response
 	return map[string]interface{}{
-		"success": true,
-		"id":      id,
+		"success":          true,
+		"id":               id,
+		"restorable_until": restorableUntil.UTC().Format(time.RFC3339),
 	}, nil
 }
 
@@ -954,6 +1193,66 @@ func (h *Handler) getUserIDFromContext(r *http.Request) (int, error) {
 	return userID, nil
 }
 
+//requireUserID authenticates the caller of a GraphQL resolver
+func (h *Handler) requireUserID(p graphql.ResolveParams) (int, error) {
+	r, ok := p.Context.Value(httpRequestKey).(*http.Request)
+	if !ok {
+		h.logger.Error("failed to get http request from context")
+		return 0, fmt.Errorf("authentication required")
+	}
+
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		return 0, fmt.Errorf("authentication required: %v", err)
+	}
+	return userID, nil
+}
+
+//requireAdmin authenticates the caller and checks they are an admin
+func (h *Handler) requireAdmin(p graphql.ResolveParams) (int, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		return 0, err
+	}
+
+	admin, err := isAdmin(p.Context, h.database, userID)
+	if err != nil {
+		h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+		return 0, err
+	}
+	if !admin {
+		return 0, fmt.Errorf("admin access required")
+	}
+	return userID, nil
+}
+
+//isAdmin reports whether a user has the admin flag; unknown users are not admins
+func isAdmin(ctx context.Context, q rowQuerier, userID int) (bool, error) {
+	var admin bool
+	err := q.QueryRowContext(ctx, "SELECT is_admin FROM users WHERE id = $1", userID).Scan(&admin)
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	return admin, nil
+}
+
+//invalidateStoreCache drops the cached REST responses for a store, by id and
+//by slug, and the cached fleet statistics it is part of
+func (h *Handler) invalidateStoreCache(storeID int) {
+	if h.redis == nil {
+		return
+	}
+	cacheKey := fmt.Sprintf("store:%d", storeID)
+	if err := h.redis.Del(context.Background(), cacheKey).Err(); err != nil {
+		h.logger.Warn("failed to invalidate cache",
+			"store_id", storeID,
+			"error", err.Error(),
+		)
+	}
+	h.invalidateStoreSlugCache(context.Background(), storeID)
+	h.invalidateStoreStats(context.Background())
+}
+
 
 
 
@@ -1086,18 +1385,89 @@ func min(a, b int) int {
 
 
 //Define the store type in GraphQL
-var storeType = graphql.NewObject(graphql.ObjectConfig{
-	Name: "Store",
-	Fields: graphql.Fields{
-		"id": &graphql.Field{Type: graphql.Int,},
-		"name": &graphql.Field{Type: graphql.String,},
-		"revenue": &graphql.Field{Type: graphql.Float,},
-		"total_orders": &graphql.Field{Type: graphql.Int,},
-		"active": &graphql.Field{Type: graphql.Boolean,},
-		"user_id":      &graphql.Field{Type: graphql.Int},
+func newStoreType(h *Handler) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Store",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{Type: graphql.Int,},
+			"name": &graphql.Field{Type: graphql.String,},
+			"slug": &graphql.Field{
+				Type:        graphql.String,
+				Description: "URL-friendly identifier made from the name; changes when the store is renamed",
+			},
+			"revenue": &graphql.Field{Type: moneyType,},
+			"currency": &graphql.Field{Type: graphql.String,},
+			"reporting_revenue": &graphql.Field{
+				Type:        moneyType,
+				Description: "Revenue converted to the reporting currency (REPORTING_CURRENCY, default USD)",
+				Resolve:     h.reportingRevenueResolver,
+			},
+			"total_orders": &graphql.Field{Type: graphql.Int,},
+			"status": &graphql.Field{Type: storeStatusEnum},
+			"version": &graphql.Field{
+				Type:        graphql.Int,
+				Description: "Incremented on every change; pass it as expectedVersion to detect concurrent edits",
+			},
+			"active": &graphql.Field{
+				Type:              graphql.Boolean,
+				DeprecationReason: "Use status",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					store, _ := p.Source.(map[string]interface{})
+					return store["status"] == storeStatusActive, nil
+				},
+			},
+			"status_history": &graphql.Field{
+				Type:    graphql.NewList(storeStatusChangeType),
+				Resolve: h.storeStatusHistoryResolver,
+			},
+			"status_schedule": &graphql.Field{
+				Type:        graphql.NewList(storeStatusScheduleType),
+				Description: "Pending scheduled status changes, soonest first",
+				Resolve:     h.storeScheduleResolver,
+			},
+			"user_id":      &graphql.Field{Type: graphql.Int},
+			"tags": &graphql.Field{
+				Type:    graphql.NewList(graphql.String),
+				Resolve: h.storeTagsResolver,
+			},
+			"category": &graphql.Field{
+				Type:    categoryType,
+				Resolve: h.storeCategoryResolver,
+			},
+			"logoUrl": &graphql.Field{
+				Type:    graphql.String,
+				Resolve: h.storeLogoURLResolver(false),
+			},
+			"logoThumbnailUrl": &graphql.Field{
+				Type:    graphql.String,
+				Resolve: h.storeLogoURLResolver(true),
+			},
+			"averageRating": &graphql.Field{
+				Type:    graphql.Float,
+				Resolve: h.storeRatingResolver(true),
+			},
+			"reviewCount": &graphql.Field{
+				Type:    graphql.Int,
+				Resolve: h.storeRatingResolver(false),
+			},
+			"reviews": &graphql.Field{
+				Type: reviewPageType,
+				Args: graphql.FieldConfigArgument{
+					"first": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Page size, default 20, at most 100",
+					},
+					"after": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "next_cursor of the previous page",
+					},
+				},
+				Resolve: h.storeReviewsResolver,
+			},
 
-	},
-})
+		},
+	})
+}
 
 //Define the delete result type in GraphQL
 var deleteResultType = graphql.NewObject(graphql.ObjectConfig{
@@ -1105,6 +1475,10 @@ var deleteResultType = graphql.NewObject(graphql.ObjectConfig{
 	Fields: graphql.Fields{
 		"success": &graphql.Field{Type: graphql.Boolean},
 		"id":      &graphql.Field{Type: graphql.Int},
+		"restorable_until": &graphql.Field{
+			Type:        graphql.String,
+			Description: "RFC 3339 time until which a deleted store can be restored",
+		},
 	},
 })
 
@@ -1132,6 +1506,10 @@ var authResponseType = graphql.NewObject(graphql.ObjectConfig{
 
 //Function that creates the GraphQL schema with a Handler
 func createSchema(h *Handler) (graphql.Schema, error) {
+	storeType := newStoreType(h)
+	orderType := newOrderType(h)
+	promotionType := newPromotionType(h)
+
 	queryType := graphql.NewObject(graphql.ObjectConfig{
 		Name: "Query",
 		Fields: graphql.Fields{
@@ -1141,67 +1519,305 @@ func createSchema(h *Handler) (graphql.Schema, error) {
 					"id": &graphql.ArgumentConfig{
 						Type: graphql.Int,
 					},
+					"slug": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "Look the store up by its slug instead of id; slugs it had before a rename still work",
+					},
+					"asOf": &graphql.ArgumentConfig{
+						Type:        dateTimeScalar,
+						Description: "Read the store as it was at this time",
+					},
 				},
 				Resolve: h.storeResolver, //Use the Handler's method!
 			},
-			"stores": &graphql.Field{
-				Type:    graphql.NewList(storeType),
-				Resolve: h.storesResolver,
+			"storeStats": &graphql.Field{
+				Type:        storeStatsType,
+				Description: "Fleet-wide store statistics, cached briefly - admin only",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{
+						Type: storeFilterInput,
+					},
+					"topOwners": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: defaultTopOwners,
+					},
+				},
+				Resolve: h.storeStatsResolver,
 			},
-		},
-	})
-
-	//Define mutations
-	mutationType := graphql.NewObject(graphql.ObjectConfig{
-		Name: "Mutation",
-		Fields: graphql.Fields{
-			"createStore": &graphql.Field{
-				Type: storeType,
+			"revenueForecast": &graphql.Field{
+				Type: revenueForecastType,
 				Args: graphql.FieldConfigArgument{
-					"name": &graphql.ArgumentConfig{
-						Type: graphql.NewNonNull(graphql.String),
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
 					},
-					"revenue": &graphql.ArgumentConfig{
-						Type: graphql.NewNonNull(graphql.Float),
+					"horizonDays": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: defaultForecastDays,
 					},
-					"active": &graphql.ArgumentConfig{
-						Type: graphql.Boolean,
+					"timezone": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "UTC",
+						Description:  "IANA time zone the days are cut in",
 					},
 				},
-				Resolve: h.createStoreResolver,
+				Resolve: h.revenueForecastResolver,
 			},
-			"updateStore": &graphql.Field{
-				Type: storeType,
+			"anomalies": &graphql.Field{
+				Type: graphql.NewList(storeAnomalyType),
 				Args: graphql.FieldConfigArgument{
-					"id": &graphql.ArgumentConfig{
+					"storeId": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.Int),
 					},
-					"name": &graphql.ArgumentConfig{
-						Type: graphql.String,
+					"limit": &graphql.ArgumentConfig{
+						Type:         graphql.Int,
+						DefaultValue: defaultAnomalyPageSize,
 					},
-					"revenue": &graphql.ArgumentConfig{
-						Type: graphql.Float,
+				},
+				Resolve: h.anomaliesResolver,
+			},
+			"revenueSeries": &graphql.Field{
+				Type: graphql.NewList(revenueBucketType),
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
 					},
-					"total_orders": &graphql.ArgumentConfig{
-						Type: graphql.Int,
+					"from": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(dateTimeScalar),
 					},
-					"active": &graphql.ArgumentConfig{
-						Type: graphql.Boolean,
+					"to": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(dateTimeScalar),
+						Description: "Exclusive; the range is widened to whole buckets",
+					},
+					"granularity": &graphql.ArgumentConfig{
+						Type:         revenueGranularityEnum,
+						DefaultValue: granularityDay,
+					},
+					"timezone": &graphql.ArgumentConfig{
+						Type:         graphql.String,
+						DefaultValue: "UTC",
+						Description:  "IANA time zone the buckets are cut in, e.g. America/New_York",
 					},
 				},
-				Resolve: h.updateStoreResolver,  
-			}, 
-			"deleteStore": &graphql.Field{
-				Type: deleteResultType,
+				Resolve: h.revenueSeriesResolver,
+			},
+			"storeHistory": &graphql.Field{
+				Type: graphql.NewList(storeRevisionType),
 				Args: graphql.FieldConfigArgument{
 					"id": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.Int),
 					},
+					"limit": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Page size, default 50, at most 200",
+					},
+					"before": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Only revisions older than this revision id, for the next page",
+					},
 				},
-				Resolve: h.deleteStoreResolver,
-			}, 
-			"register": &graphql.Field{
-				Type: authResponseType,
+				Resolve: h.storeHistoryResolver,
+			},
+			"stores": &graphql.Field{
+				Type: graphql.NewList(storeType),
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{
+						Type: storeFilterInput,
+					},
+				},
+				Resolve: h.storesResolver,
+			},
+			"storeFacets": &graphql.Field{
+				Type:        storeFacetsType,
+				Description: "Store counts per tag and category for faceted navigation",
+				Args: graphql.FieldConfigArgument{
+					"filter": &graphql.ArgumentConfig{
+						Type: storeFilterInput,
+					},
+					"tagLimit": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "How many tags to count, default 20, at most 100",
+					},
+				},
+				Resolve: h.storeFacetsResolver,
+			},
+			"categories": &graphql.Field{
+				Type:        graphql.NewList(categoryType),
+				Description: "The category tree, top-level categories first",
+				Resolve:     h.categoriesResolver,
+			},
+			"order": &graphql.Field{
+				Type: orderType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: h.orderResolver,
+			},
+			"quoteOrder": &graphql.Field{
+				Type: quoteType,
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"items": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(orderItemInputType))),
+					},
+					"jurisdiction": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"promoCode": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: h.quoteOrderResolver,
+			},
+			"taxRules": &graphql.Field{
+				Type: graphql.NewList(taxRuleType),
+				Args: graphql.FieldConfigArgument{
+					"jurisdiction": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: h.taxRulesResolver,
+			},
+			"leaderboard": &graphql.Field{
+				Type: graphql.NewList(newLeaderboardEntryType(storeType)),
+				Args: graphql.FieldConfigArgument{
+					"metric": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.String),
+						Description: "revenue, orders or rating",
+					},
+					"period": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "day, week or all (default); rating is all-time only",
+					},
+					"limit": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Default 10, at most 100",
+					},
+				},
+				Resolve: h.leaderboardResolver,
+			},
+			"promotions": &graphql.Field{
+				Type: graphql.NewList(promotionType),
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: h.promotionsResolver,
+			},
+		},
+	})
+
+	//Define mutations
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createStore": &graphql.Field{
+				Type: storeType,
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"revenue": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(decimalScalar),
+					},
+					"currency": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"status": &graphql.ArgumentConfig{
+						Type:        storeStatusEnum,
+						Description: "DRAFT or ACTIVE (default)",
+					},
+				},
+				Resolve: h.createStoreResolver,
+			},
+			"updateStore": &graphql.Field{
+				Type: storeType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"expectedVersion": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Fail with a conflict if the store is no longer at this version",
+					},
+				},
+				Resolve: h.updateStoreResolver,  
+			}, 
+			"setStoreStatus": &graphql.Field{
+				Type: storeType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"status": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(storeStatusEnum),
+					},
+					"reason": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "Why the status changed; required to suspend",
+					},
+				},
+				Resolve: h.setStoreStatusResolver,
+			},
+			"scheduleStoreStatus": &graphql.Field{
+				Type: storeStatusScheduleType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"status": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(storeStatusEnum),
+					},
+					"at": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.String),
+						Description: "RFC 3339 timestamp in the future",
+					},
+					"reason": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: h.scheduleStoreStatusResolver,
+			},
+			"cancelStoreStatusSchedule": &graphql.Field{
+				Type: storeStatusScheduleType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: h.cancelStoreStatusScheduleResolver,
+			},
+			"deleteStore": &graphql.Field{
+				Type: deleteResultType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"expectedVersion": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Fail with a conflict if the store is no longer at this version",
+					},
+				},
+				Resolve: h.deleteStoreResolver,
+			}, 
+			"restoreStore": &graphql.Field{
+				Type: storeType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: h.restoreStoreResolver,
+			},
+			"register": &graphql.Field{
+				Type: authResponseType,
 				Args: graphql.FieldConfigArgument{
 					"email": &graphql.ArgumentConfig{
 						Type: graphql.NewNonNull(graphql.String),
@@ -1224,6 +1840,355 @@ func createSchema(h *Handler) (graphql.Schema, error) {
 				},
 				Resolve: h.loginResolver,
 			},
+			"placeOrder": &graphql.Field{
+				Type: orderType,
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"items": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(orderItemInputType))),
+					},
+					"jurisdiction": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "Tax jurisdiction of the delivery address, e.g. US-CA. Omit for no tax.",
+					},
+					"promoCode": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: h.placeOrderResolver,
+			},
+			"cancelOrder": &graphql.Field{
+				Type: orderType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"reason": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: h.cancelOrderResolver,
+			},
+			"refundOrder": &graphql.Field{
+				Type: orderType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"amount": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(decimalScalar),
+					},
+					"reason": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: h.refundOrderResolver,
+			},
+			"updateOrderStatus": &graphql.Field{
+				Type: orderType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"status": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"note": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+				},
+				Resolve: h.updateOrderStatusResolver,
+			},
+			"shipOrder": &graphql.Field{
+				Type: orderType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"carrier": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"trackingNumber": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: h.shipOrderResolver,
+			},
+			"uploadProof": &graphql.Field{
+				Type: proofType,
+				Args: graphql.FieldConfigArgument{
+					"orderItemId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"fileUrl": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: h.uploadProofResolver,
+			},
+			"approveProof": &graphql.Field{
+				Type: proofType,
+				Args: graphql.FieldConfigArgument{
+					"proofId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: h.approveProofResolver,
+			},
+			"requestProofChanges": &graphql.Field{
+				Type: proofType,
+				Args: graphql.FieldConfigArgument{
+					"proofId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"comment": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: h.requestProofChangesResolver,
+			},
+			"setStoreLogo": &graphql.Field{
+				Type: storeLogoType,
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"assetKey": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: h.setStoreLogoResolver,
+			},
+			"setTaxRule": &graphql.Field{
+				Type: taxRuleType,
+				Args: graphql.FieldConfigArgument{
+					"jurisdiction": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"rate": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(decimalScalar),
+						Description: "Fraction, e.g. 0.0725 for 7.25%",
+					},
+					"inclusive": &graphql.ArgumentConfig{
+						Type: graphql.Boolean,
+					},
+					"exemptCategories": &graphql.ArgumentConfig{
+						Type: graphql.NewList(graphql.String),
+					},
+					"active": &graphql.ArgumentConfig{
+						Type: graphql.Boolean,
+					},
+				},
+				Resolve: h.setTaxRuleResolver,
+			},
+			"setStoreTags": &graphql.Field{
+				Type: storeType,
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"tags": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(graphql.String))),
+						Description: "The store's tags, replacing the ones it had; at most 10",
+					},
+				},
+				Resolve: h.setStoreTagsResolver,
+			},
+			"setStoreCategory": &graphql.Field{
+				Type: storeType,
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"categoryId": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Leave out or pass null to take the store out of its category",
+					},
+				},
+				Resolve: h.setStoreCategoryResolver,
+			},
+			"createCategory": &graphql.Field{
+				Type: categoryType,
+				Args: graphql.FieldConfigArgument{
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"parentId": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Create it as a subcategory of this one",
+					},
+				},
+				Resolve: h.createCategoryResolver,
+			},
+			"updateCategory": &graphql.Field{
+				Type: categoryType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"name": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"parentId": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Move it under this category",
+					},
+					"moveToTop": &graphql.ArgumentConfig{
+						Type:        graphql.Boolean,
+						Description: "Move it to the top level",
+					},
+				},
+				Resolve: h.updateCategoryResolver,
+			},
+			"deleteCategory": &graphql.Field{
+				Type: deleteResultType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: h.deleteCategoryResolver,
+			},
+			"createReview": &graphql.Field{
+				Type: reviewType,
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"rating": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.Int),
+						Description: "1 to 5",
+					},
+					"body": &graphql.ArgumentConfig{
+						Type: graphql.String,
+					},
+					"orderId": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "One of your orders at the store; marks the review as a verified purchase",
+					},
+				},
+				Resolve: h.createReviewResolver,
+			},
+			"deleteReview": &graphql.Field{
+				Type: deleteResultType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+				},
+				Resolve: h.deleteReviewResolver,
+			},
+			"replyToReview": &graphql.Field{
+				Type: reviewType,
+				Args: graphql.FieldConfigArgument{
+					"reviewId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"reply": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+				},
+				Resolve: h.replyToReviewResolver,
+			},
+			"createPromotion": &graphql.Field{
+				Type: promotionType,
+				Args: graphql.FieldConfigArgument{
+					"storeId": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"code": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"kind": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(graphql.String),
+						Description: "percentage or fixed",
+					},
+					"value": &graphql.ArgumentConfig{
+						Type:        graphql.NewNonNull(decimalScalar),
+						Description: "Percent off (e.g. 15) or amount off in the store currency",
+					},
+					"minOrder": &graphql.ArgumentConfig{
+						Type: decimalScalar,
+					},
+					"maxRedemptions": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Total uses allowed, 0 for unlimited",
+					},
+					"maxRedemptionsPerUser": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Uses allowed per buyer, 0 for unlimited",
+					},
+					"startsAt": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "RFC 3339 timestamp",
+					},
+					"endsAt": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "RFC 3339 timestamp",
+					},
+				},
+				Resolve: h.createPromotionResolver,
+			},
+			"updatePromotion": &graphql.Field{
+				Type: promotionType,
+				Args: graphql.FieldConfigArgument{
+					"id": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.Int),
+					},
+					"minOrder": &graphql.ArgumentConfig{
+						Type: decimalScalar,
+					},
+					"maxRedemptions": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Total uses allowed, 0 for unlimited",
+					},
+					"maxRedemptionsPerUser": &graphql.ArgumentConfig{
+						Type:        graphql.Int,
+						Description: "Uses allowed per buyer, 0 for unlimited",
+					},
+					"startsAt": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "RFC 3339 timestamp",
+					},
+					"endsAt": &graphql.ArgumentConfig{
+						Type:        graphql.String,
+						Description: "RFC 3339 timestamp",
+					},
+					"active": &graphql.ArgumentConfig{
+						Type: graphql.Boolean,
+					},
+				},
+				Resolve: h.updatePromotionResolver,
+			},
+			"reconcileRevenue": &graphql.Field{
+				Type: reconciliationReportType,
+				Args: graphql.FieldConfigArgument{
+					"autoCorrect": &graphql.ArgumentConfig{
+						Type: graphql.Boolean,
+					},
+				},
+				Resolve: h.reconcileRevenueResolver,
+			},
+			"setExchangeRate": &graphql.Field{
+				Type: exchangeRateType,
+				Args: graphql.FieldConfigArgument{
+					"base": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"quote": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(graphql.String),
+					},
+					"rate": &graphql.ArgumentConfig{
+						Type: graphql.NewNonNull(decimalScalar),
+					},
+				},
+				Resolve: h.setExchangeRateResolver,
+			},
 		},
 	})
 
@@ -1240,7 +2205,9 @@ func main() {
 	var err error
 
 	//Register Prometheus metrics
-	prometheus.MustRegister(httpRequestsTotal, httpRequestDuration, cacheHits, cacheMisses)
+	prometheus.MustRegister(httpRequestsTotal, httpRequestDuration, cacheHits, cacheMisses,
+		reconciliationDiscrepancies, reconciliationDrift, reconciliationLastRun, eventsPublished, storesPurged,
+		storeAnomaliesDetected)
 	fmt.Println("Prometheus metrics registered")
 
 	//Initialize OpenTelemetry tracing
@@ -1309,30 +2276,120 @@ func main() {
 	//Initialize Redis
 	redisClient := initRedis()
 
+	//Initialize blob storage for uploads (local disk unless BLOB_STORE=s3)
+	blobStore, err := newBlobStoreFromEnv()
+	if err != nil {
+		logger.Warn("blob store unavailable, uploads disabled", "error", err.Error())
+	}
+
+	//Initialize owner notifications (log unless NOTIFIER=webhook)
+	notifier, err := newNotifierFromEnv(logger)
+	if err != nil {
+		logger.Warn("notifier unavailable, anomaly notifications disabled", "error", err.Error())
+	}
+
 	storeHandler := &Handler{
 		database: db,
 		logger:   logger,
 		redis:    redisClient,
+		blobs:    blobStore,
+		notifier: notifier,
+	}
+
+	//CLI subcommand: reconcile revenue once and exit
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		runReconcileCommand(storeHandler, os.Args[2:])
+		return
+	}
+
+	//Nightly revenue reconciliation (hour in UTC, auto-correct opt-in)
+	reconcileHour := 3
+	if v := os.Getenv("RECONCILE_HOUR_UTC"); v != "" {
+		if hour, err := strconv.Atoi(v); err == nil && hour >= 0 && hour < 24 {
+			reconcileHour = hour
+		}
+	}
+	autoCorrect := os.Getenv("RECONCILE_AUTO_CORRECT") == "true"
+	go storeHandler.runNightlyReconciliation(context.Background(), reconcileHour, autoCorrect)
+
+	//Scheduled store status changes are polled on every instance; each fires once
+	scheduleInterval := 30 * time.Second
+	if v := os.Getenv("STORE_SCHEDULER_INTERVAL"); v != "" {
+		if interval, err := time.ParseDuration(v); err == nil && interval > 0 {
+			scheduleInterval = interval
+		}
+	}
+	go storeHandler.runStoreStatusScheduler(context.Background(), scheduleInterval)
+
+	//Deleted stores are hard-deleted once STORE_RETENTION has passed; one instance purges at a time
+	purgeInterval := time.Hour
+	if v := os.Getenv("STORE_PURGE_INTERVAL"); v != "" {
+		if interval, err := time.ParseDuration(v); err == nil && interval > 0 {
+			purgeInterval = interval
+		}
+	}
+	go storeHandler.runStoreRetentionPurge(context.Background(), purgeInterval)
+
+	//Recent sales are checked against the same hours of previous days; one instance detects at a time
+	anomalyInterval := 15 * time.Minute
+	if v := os.Getenv("ANOMALY_DETECTOR_INTERVAL"); v != "" {
+		if interval, err := time.ParseDuration(v); err == nil && interval > 0 {
+			anomalyInterval = interval
+		}
+	}
+	go storeHandler.runAnomalyDetector(context.Background(), anomalyInterval)
+
+	//Leaderboards live in Redis; rebuild them from Postgres if Redis came up empty
+	if storeHandler.redis != nil {
+		go func() {
+			if err := storeHandler.ensureLeaderboards(context.Background()); err != nil {
+				logger.Warn("failed to rebuild leaderboards", "error", err.Error())
+			}
+		}()
 	}
 
 	http.Handle("/health", 
 		otelhttp.NewHandler(
-			prometheusMiddleware(http.HandlerFunc(storeHandler.healthCheck)),
+			prometheusMiddleware("/health", http.HandlerFunc(storeHandler.healthCheck)),
 			"GET /health",
 		),
 	)
 	http.Handle("/store", 
 		otelhttp.NewHandler(
-			prometheusMiddleware(http.HandlerFunc(storeHandler.getStoreInfo)),
+			corsMiddleware(prometheusMiddleware("/store", http.HandlerFunc(storeHandler.getStoreInfo))),
 			"GET /store",
 		),
 	)
+	http.Handle("/stores/",
+		otelhttp.NewHandler(
+			corsMiddleware(prometheusMiddleware("/stores/", http.HandlerFunc(storeHandler.storesHandler))),
+			"/stores/{id}",
+		),
+	)
 	http.Handle("/demo/stress-test",
 		otelhttp.NewHandler(
-			prometheusMiddleware(http.HandlerFunc(storeHandler.stressTest)),
+			prometheusMiddleware("/demo/stress-test", http.HandlerFunc(storeHandler.stressTest)),
 			"POST /demo/stress-test",
 		),
 	)
+	http.Handle("/uploads",
+		otelhttp.NewHandler(
+			prometheusMiddleware("/uploads", http.HandlerFunc(storeHandler.uploadHandler)),
+			"POST /uploads",
+		),
+	)
+	http.Handle("/assets/",
+		otelhttp.NewHandler(
+			prometheusMiddleware("/assets/", http.HandlerFunc(storeHandler.assetHandler)),
+			"GET /assets",
+		),
+	)
+	http.Handle("/orders/",
+		otelhttp.NewHandler(
+			prometheusMiddleware("/orders/", http.HandlerFunc(storeHandler.invoiceHandler)),
+			"GET /orders/invoice.pdf",
+		),
+	)
 
 
 	http.Handle("/metrics", promhttp.Handler())
@@ -1372,7 +2429,7 @@ func main() {
 			http.NotFound(w, r)
 			return
 		}
-		w.Write([]byte("StickerMule Portfolio API | Endpoints: /health, /store, /graphql, /metrics | CI/CD: Active"))
+		w.Write([]byte("StickerMule Portfolio API | Endpoints: /health, /store, /graphql, /uploads, /metrics | CI/CD: Active"))
 	})
 
 
@@ -16,9 +16,13 @@ import (
 	"log/slog"
 	"database/sql"
 	"context"
+	"strings"
+	"time"
 
 	"github.com/graphql-go/graphql"
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 )
 
 func TestHealthCheck(t *testing.T) {
@@ -62,11 +66,11 @@ func TestGetStoreInfo_Success(t *testing.T) {
 	defer fakeDB.Close()
 
 	//ARRANGE: Tell the mock what to expect and what to return
-	rows := sqlmock.NewRows([]string{"name", "revenue", "total_orders", "active"}).
-		AddRow("Test Store", 99999.99, 500, true)
+	rows := sqlmock.NewRows([]string{"name", "revenue_cents", "currency", "total_orders", "status", "version"}).
+		AddRow("Test Store", 9999999, "USD", 500, "active", 3)
 
-	mock.ExpectQuery("SELECT name, revenue, total_orders, active FROM stores WHERE id = \\$1").
-		WithArgs("1").
+	mock.ExpectQuery("SELECT name, revenue_cents, currency, total_orders, status, version FROM stores s WHERE id = \\$1").
+		WithArgs("1", 0).
 		WillReturnRows(rows)
 
 	//ARRANGE: Create Handler with mock database
@@ -88,7 +92,7 @@ func TestGetStoreInfo_Success(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 
-	expectedBody := `{"store_id": "1", "name": "Test Store", "revenue": 99999.99, "total_orders": 500, "active": true}`
+	expectedBody := `{"store_id": "1", "name": "Test Store", "revenue": 99999.99, "currency": "USD", "total_orders": 500, "status": "active", "version": 3}`
 	if w.Body.String() != expectedBody {
 		t.Errorf("Expected body:\n%s\n\nGot:\n%s", expectedBody, w.Body.String())
 	}
@@ -109,8 +113,8 @@ func TestGetStoreInfo_NotFound(t *testing.T) {
 	defer fakeDB.Close()
 
 	//ARRANGE: Mock will return "no rows" error
-	mock.ExpectQuery("SELECT name, revenue, total_orders, active FROM stores WHERE id = \\$1").
-		WithArgs("999").
+	mock.ExpectQuery("SELECT name, revenue_cents, currency, total_orders, status, version FROM stores s WHERE id = \\$1").
+		WithArgs("999", 0).
 		WillReturnError(sql.ErrNoRows)  // Simulate store not found
 
 	//ARRANGE: Create Handler and request
@@ -156,8 +160,8 @@ func TestGetStoreInfo_DatabaseError(t *testing.T) {
 	defer fakeDB.Close()
 
 	//ARRANGE: Mock will return a generic database error
-	mock.ExpectQuery("SELECT name, revenue, total_orders, active FROM stores WHERE id = \\$1").
-		WithArgs("1").
+	mock.ExpectQuery("SELECT name, revenue_cents, currency, total_orders, status, version FROM stores s WHERE id = \\$1").
+		WithArgs("1", 0).
 		WillReturnError(fmt.Errorf("connection timeout"))  // Simulate DB failure
 
 	//ARRANGE: Create Handler and request
@@ -201,11 +205,11 @@ func TestStoreResolver_Success(t *testing.T) {
 	defer fakeDB.Close()
 
 	//ARRANGE: Set up mock expectation
-	rows := sqlmock.NewRows([]string{"id", "name", "revenue", "total_orders", "active"}).
-		AddRow(1, "GraphQL Store", 75000.50, 300, true)
+	rows := sqlmock.NewRows([]string{"id", "name", "revenue_cents", "currency", "total_orders", "status", "version", "slug", "logo_key"}).
+		AddRow(1, "GraphQL Store", 7500050, "USD", 300, "active", 3, "graphql-store", nil)
 
-	mock.ExpectQuery("SELECT id, name, revenue, total_orders, active FROM stores WHERE id = \\$1").
-		WithArgs(1).
+	mock.ExpectQuery("SELECT id, name, revenue_cents, currency, total_orders, status, version, slug, logo_key FROM stores s WHERE id = \\$1").
+		WithArgs(1, 0).
 		WillReturnRows(rows)
 
 	//ARRANGE: Create Handler and GraphQL params
@@ -244,8 +248,8 @@ func TestStoreResolver_Success(t *testing.T) {
 	if storeMap["name"] != "GraphQL Store" {
 		t.Errorf("Expected name='GraphQL Store', got %v", storeMap["name"])
 	}
-	if storeMap["revenue"] != 75000.50 {
-		t.Errorf("Expected revenue=75000.50, got %v", storeMap["revenue"])
+	if storeMap["revenue"] != (Money{Amount: 7500050, Currency: "USD"}) {
+		t.Errorf("Expected revenue=75000.50 USD, got %v", storeMap["revenue"])
 	}
 
 	//ASSERT: Verify mock expectations
@@ -263,8 +267,8 @@ func TestStoreResolver_NotFound(t *testing.T) {
 	defer fakeDB.Close()
 
 	//ARRANGE: Mock returns "no rows"
-	mock.ExpectQuery("SELECT id, name, revenue, total_orders, active FROM stores WHERE id = \\$1").
-		WithArgs(999).
+	mock.ExpectQuery("SELECT id, name, revenue_cents, currency, total_orders, status, version, slug, logo_key FROM stores s WHERE id = \\$1").
+		WithArgs(999, 0).
 		WillReturnError(sql.ErrNoRows)
 
 	//ARRANGE: Create Handler and params
@@ -338,11 +342,11 @@ func TestGetStoreInfo_DefaultID(t *testing.T) {
 	defer fakeDB.Close()
 
 	//ARRANGE: Set up mock for default ID "1"
-	rows := sqlmock.NewRows([]string{"name", "revenue", "total_orders", "active"}).
-		AddRow("Default Store", 12345.67, 100, true)
+	rows := sqlmock.NewRows([]string{"name", "revenue_cents", "currency", "total_orders", "status", "version"}).
+		AddRow("Default Store", 1234567, "USD", 100, "active", 1)
 
-	mock.ExpectQuery("SELECT name, revenue, total_orders, active FROM stores WHERE id = \\$1").
-		WithArgs("1").  //Should default to 1 when no ID provided
+	mock.ExpectQuery("SELECT name, revenue_cents, currency, total_orders, status, version FROM stores s WHERE id = \\$1").
+		WithArgs("1", 0).  //Should default to 1 when no ID provided
 		WillReturnRows(rows)
 
 	//ARRANGE: Create Handler and request WITH NO ID PARAMETER
@@ -366,7 +370,7 @@ func TestGetStoreInfo_DefaultID(t *testing.T) {
 		t.Errorf("Expected status 200, got %d", w.Code)
 	}
 
-	expectedBody := `{"store_id": "1", "name": "Default Store", "revenue": 12345.67, "total_orders": 100, "active": true}`
+	expectedBody := `{"store_id": "1", "name": "Default Store", "revenue": 12345.67, "currency": "USD", "total_orders": 100, "status": "active", "version": 1}`
 	if w.Body.String() != expectedBody {
 		t.Errorf("Expected body:\n%s\n\nGot:\n%s", expectedBody, w.Body.String())
 	}
@@ -430,10 +434,33 @@ func TestCreateStoreResolver_Success(t *testing.T) {
 	defer fakeDB.Close()
 
 	//ARRANGE: Expect INSERT query and return new ID
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	//ARRANGE: Another store already has the plain slug
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(storeSlugLockID, pq.Array([]string{"brand-new-store"})).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT slug FROM stores WHERE id <> \\$2").
+		WithArgs(pq.Array([]string{"brand-new-store"}), 0).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("brand-new-store"))
 	mock.ExpectQuery("INSERT INTO stores").
-		WithArgs("Brand New Store", 25000.00, 0, true, 1). // user_id = 1
+		WithArgs("Brand New Store", int64(2500000), "USD", 0, "active", 1, "brand-new-store-2"). // user_id = 1
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(99))
 
+	//ARRANGE: Expect the opening balance to be written to the ledger
+	mock.ExpectExec("INSERT INTO revenue_adjustments").
+		WithArgs(99, sqlmock.AnyArg(), "opening_balance", int64(2500000), 0, "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE stores SET").
+		WithArgs(99).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO store_status_history").
+		WithArgs(99, "active", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
 	//ARRANGE: Create handler
 	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
 	handler := &Handler{
@@ -458,8 +485,8 @@ func TestCreateStoreResolver_Success(t *testing.T) {
 		Context: ctx,
 		Args: map[string]interface{}{
 			"name":    "Brand New Store",
-			"revenue": 25000.00,
-			"active":  true,
+			"revenue": "25000.00",
+			"status":  "active",
 		},
 	}
 
@@ -507,20 +534,29 @@ func TestUpdateStoreResolver_Success(t *testing.T) {
 	defer fakeDB.Close()
 
 	//ARRANGE: Mock ownership check
-	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
 		WithArgs(1).
 		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
 
-	//ARRANGE: Expected UPDATE query
-	mock.ExpectExec("UPDATE stores SET (.+) WHERE id = \\$").
-		WithArgs("Updated Store", 75000.00, 500, false, 1).
+	//ARRANGE: Expected UPDATE query, made on behalf of user 1
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	//ARRANGE: The store already has a slug made from the new name, so it keeps it
+	mock.ExpectQuery("SELECT slug FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("updated-store-2"))
+	mock.ExpectExec("UPDATE stores SET name = \\$1 WHERE id = \\$2").
+		WithArgs("Updated Store", 1).
 		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
 
 	//ARRANGE: Expect SELECT query to return updated data
-	rows := sqlmock.NewRows([]string{"id", "name", "revenue", "total_orders", "active", "user_id"}).
-		AddRow(1, "Updated Store", 75000.00, 500, false, 1)
+	rows := sqlmock.NewRows([]string{"id", "name", "revenue_cents", "currency", "total_orders", "status", "version", "user_id", "slug", "logo_key"}).
+		AddRow(1, "Updated Store", 7500000, "USD", 500, "paused", 4, 1, "updated-store-2", nil)
 
-	mock.ExpectQuery("SELECT id, name, revenue, total_orders, active, user_id FROM stores WHERE id = \\$1").
+	mock.ExpectQuery("SELECT id, name, revenue_cents, currency, total_orders, status, version, user_id, slug, logo_key FROM stores WHERE id = \\$1").
 		WithArgs(1).
 		WillReturnRows(rows)
 
@@ -546,11 +582,8 @@ func TestUpdateStoreResolver_Success(t *testing.T) {
 	params := graphql.ResolveParams{
 		Context: ctx,
 		Args: map[string]interface{}{
-			"id":           1,
-			"name":         "Updated Store",
-			"revenue":      75000.00,
-			"total_orders": 500,
-			"active":       false,
+			"id":   1,
+			"name": "Updated Store",
 		},
 	}
 
@@ -574,6 +607,9 @@ func TestUpdateStoreResolver_Success(t *testing.T) {
 	if storeMap["name"] != "Updated Store" {
 		t.Errorf("Expected name='Updated Store', got %v", storeMap["name"])
 	}
+	if storeMap["status"] != "paused" {
+		t.Errorf("Expected status to be left alone, got %v", storeMap["status"])
+	}
 
 	//ASSERT: Check mock expectations
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -598,10 +634,18 @@ func TestDeleteStoreResolver_Success(t *testing.T) {
 		WithArgs(99).
 		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
 
-	//ARRANGE: Expect DELETE query
-	mock.ExpectExec("DELETE FROM stores WHERE id = \\$1").
+	//ARRANGE: Expect the store to be marked deleted and its schedules cancelled
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("UPDATE stores SET deleted_at = NOW\\(\\) WHERE id = \\$1 AND deleted_at IS NULL RETURNING deleted_at").
 		WithArgs(99).
-		WillReturnResult(sqlmock.NewResult(0, 1)) // 1 row affected
+		WillReturnRows(sqlmock.NewRows([]string{"deleted_at"}).AddRow(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+	mock.ExpectExec("UPDATE store_status_schedules SET state = \\$1").
+		WithArgs("cancelled", 99, "pending").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
 
 	//ARRANGE: Create Handler
 	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
@@ -649,6 +693,9 @@ func TestDeleteStoreResolver_Success(t *testing.T) {
 	if resultMap["id"] != 99 {
 		t.Errorf("Expected id=99, got %v", resultMap["id"])
 	}
+	if resultMap["restorable_until"] != "2026-03-31T12:00:00Z" {
+		t.Errorf("Expected restorable_until 30 days later, got %v", resultMap["restorable_until"])
+	}
 
 	//ASSERT: Verify mock expectations
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -713,4 +760,45 @@ func TestDeleteStoreResolver_NotFound(t *testing.T) {
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("Unfulfilled expectations: %v", err)
 	}
-}
\ No newline at end of file
+}
+
+func TestPrometheusMiddleware_LabelsRoute(t *testing.T) {
+	handler := prometheusMiddleware("/stores/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	before := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", "/stores/", "404"))
+
+	//ACT: Two different store URLs
+	for _, path := range []string{"/stores/41", "/stores/by-slug/sticker-shop"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	//ASSERT: Both land in the route's series
+	if got := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", "/stores/", "404")) - before; got != 2 {
+		t.Errorf("Expected 2 requests counted for /stores/, got %v", got)
+	}
+}
+
+func TestCorsMiddleware_AllowsConditionalPatch(t *testing.T) {
+	handler := corsMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the preflight to be answered by the middleware")
+	}))
+
+	req := httptest.NewRequest(http.MethodOptions, "/stores/1", nil)
+	req.Header.Set("Access-Control-Request-Method", "PATCH")
+	w := httptest.NewRecorder()
+
+	//ACT: Browser preflight for a PATCH with If-Match
+	handler.ServeHTTP(w, req)
+
+	//ASSERT: PATCH and the conditional headers are allowed and ETag is readable
+	if !strings.Contains(w.Header().Get("Access-Control-Allow-Methods"), "PATCH") {
+		t.Errorf("Expected PATCH to be allowed, got %q", w.Header().Get("Access-Control-Allow-Methods"))
+	}
+	if !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "If-Match") {
+		t.Errorf("Expected If-Match to be allowed, got %q", w.Header().Get("Access-Control-Allow-Headers"))
+	}
+	if w.Header().Get("Access-Control-Expose-Headers") != "ETag" {
+		t.Errorf("Expected ETag to be exposed, got %q", w.Header().Get("Access-Control-Expose-Headers"))
+	}
+}
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS trg_revenue_adjustments_immutable ON revenue_adjustments;
+DROP FUNCTION IF EXISTS revenue_adjustments_immutable();
+DROP TABLE IF EXISTS revenue_adjustments;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
@@ -0,0 +1,64 @@
+-- Orders placed against a store
+CREATE TABLE orders (
+    id SERIAL PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    total NUMERIC(12, 2) NOT NULL CHECK (total >= 0),
+    refunded_amount NUMERIC(12, 2) NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'placed',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (refunded_amount <= total)
+);
+
+CREATE INDEX idx_orders_store_id ON orders(store_id);
+CREATE INDEX idx_orders_user_id ON orders(user_id);
+
+-- Line items of an order
+CREATE TABLE order_items (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    description VARCHAR(255) NOT NULL,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    unit_price NUMERIC(12, 2) NOT NULL CHECK (unit_price >= 0)
+);
+
+CREATE INDEX idx_order_items_order_id ON order_items(order_id);
+
+-- Append-only ledger of every change to a store's revenue and order count.
+-- stores.revenue and stores.total_orders are the sums of this table.
+CREATE TABLE revenue_adjustments (
+    id BIGSERIAL PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    order_id INTEGER, -- no FK: ledger history outlives deleted orders
+    kind VARCHAR(30) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    orders_delta INTEGER NOT NULL DEFAULT 0,
+    reason TEXT,
+    created_by INTEGER, -- user who caused the adjustment, NULL for system entries
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_revenue_adjustments_store_id ON revenue_adjustments(store_id);
+CREATE INDEX idx_revenue_adjustments_order_id ON revenue_adjustments(order_id);
+
+-- Ledger rows can never be edited or removed. The only exception is the
+-- cleanup cascaded from deleting the parent store.
+CREATE FUNCTION revenue_adjustments_immutable() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' AND NOT EXISTS (SELECT 1 FROM stores WHERE id = OLD.store_id) THEN
+        RETURN OLD;
+    END IF;
+    RAISE EXCEPTION 'revenue_adjustments is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER trg_revenue_adjustments_immutable
+    BEFORE UPDATE OR DELETE ON revenue_adjustments
+    FOR EACH ROW EXECUTE FUNCTION revenue_adjustments_immutable();
+
+-- Seed the ledger with the current totals so recomputing from it
+-- does not wipe revenue recorded before the ledger existed
+INSERT INTO revenue_adjustments (store_id, kind, amount, orders_delta, reason)
+SELECT id, 'opening_balance', revenue, total_orders, 'balance carried over when the ledger was introduced'
+FROM stores;
@@ -0,0 +1,2 @@
+-- Flag for operators allowed to run admin-only mutations
+ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE;
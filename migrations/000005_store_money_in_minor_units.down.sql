@@ -0,0 +1,25 @@
+DROP TABLE IF EXISTS exchange_rates;
+
+ALTER TABLE revenue_adjustments DISABLE TRIGGER trg_revenue_adjustments_immutable;
+ALTER TABLE revenue_adjustments ADD COLUMN amount NUMERIC(12, 2) NOT NULL DEFAULT 0;
+UPDATE revenue_adjustments SET amount = amount_cents / 100.0;
+ALTER TABLE revenue_adjustments DROP COLUMN amount_cents;
+ALTER TABLE revenue_adjustments ALTER COLUMN amount DROP DEFAULT;
+ALTER TABLE revenue_adjustments ENABLE TRIGGER trg_revenue_adjustments_immutable;
+
+ALTER TABLE order_items ADD COLUMN unit_price NUMERIC(12, 2) NOT NULL DEFAULT 0 CHECK (unit_price >= 0);
+UPDATE order_items SET unit_price = unit_price_cents / 100.0;
+ALTER TABLE order_items DROP COLUMN unit_price_cents;
+
+ALTER TABLE orders DROP CONSTRAINT IF EXISTS orders_amounts_check;
+ALTER TABLE orders ADD COLUMN total NUMERIC(12, 2) NOT NULL DEFAULT 0 CHECK (total >= 0);
+ALTER TABLE orders ADD COLUMN refunded_amount NUMERIC(12, 2) NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0);
+UPDATE orders SET total = total_cents / 100.0, refunded_amount = refunded_cents / 100.0;
+ALTER TABLE orders DROP COLUMN total_cents;
+ALTER TABLE orders DROP COLUMN refunded_cents;
+ALTER TABLE orders DROP COLUMN currency;
+
+ALTER TABLE stores ADD COLUMN revenue NUMERIC(12, 2) NOT NULL DEFAULT 0;
+UPDATE stores SET revenue = revenue_cents / 100.0;
+ALTER TABLE stores DROP COLUMN revenue_cents;
+ALTER TABLE stores DROP COLUMN currency;
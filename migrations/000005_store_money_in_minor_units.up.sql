@@ -0,0 +1,47 @@
+-- Money is stored as integer minor units (cents) plus an ISO 4217 currency
+-- instead of floating point / fixed decimals
+
+-- Stores
+ALTER TABLE stores ADD COLUMN revenue_cents BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE stores ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+UPDATE stores SET revenue_cents = ROUND(revenue * 100)::BIGINT;
+ALTER TABLE stores DROP COLUMN revenue;
+
+-- Orders (in the currency of their store)
+ALTER TABLE orders ADD COLUMN total_cents BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN refunded_cents BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN currency CHAR(3) NOT NULL DEFAULT 'USD';
+UPDATE orders o SET
+    total_cents = ROUND(o.total * 100)::BIGINT,
+    refunded_cents = ROUND(o.refunded_amount * 100)::BIGINT,
+    currency = s.currency
+FROM stores s WHERE s.id = o.store_id;
+ALTER TABLE orders DROP COLUMN total;
+ALTER TABLE orders DROP COLUMN refunded_amount;
+ALTER TABLE orders ALTER COLUMN total_cents DROP DEFAULT;
+ALTER TABLE orders ADD CONSTRAINT orders_amounts_check
+    CHECK (total_cents >= 0 AND refunded_cents >= 0 AND refunded_cents <= total_cents);
+
+ALTER TABLE order_items ADD COLUMN unit_price_cents BIGINT NOT NULL DEFAULT 0 CHECK (unit_price_cents >= 0);
+UPDATE order_items SET unit_price_cents = ROUND(unit_price * 100)::BIGINT;
+ALTER TABLE order_items DROP COLUMN unit_price;
+ALTER TABLE order_items ALTER COLUMN unit_price_cents DROP DEFAULT;
+
+-- Ledger (in the currency of its store). The append-only trigger is
+-- suspended only for this one-off conversion.
+ALTER TABLE revenue_adjustments DISABLE TRIGGER trg_revenue_adjustments_immutable;
+ALTER TABLE revenue_adjustments ADD COLUMN amount_cents BIGINT NOT NULL DEFAULT 0;
+UPDATE revenue_adjustments SET amount_cents = ROUND(amount * 100)::BIGINT;
+ALTER TABLE revenue_adjustments DROP COLUMN amount;
+ALTER TABLE revenue_adjustments ALTER COLUMN amount_cents DROP DEFAULT;
+ALTER TABLE revenue_adjustments ENABLE TRIGGER trg_revenue_adjustments_immutable;
+
+-- Rates used to convert amounts to the reporting currency.
+-- One unit of base_currency is worth `rate` units of quote_currency.
+CREATE TABLE exchange_rates (
+    base_currency CHAR(3) NOT NULL,
+    quote_currency CHAR(3) NOT NULL,
+    rate NUMERIC(20, 10) NOT NULL CHECK (rate > 0),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (base_currency, quote_currency)
+);
@@ -0,0 +1,36 @@
+-- Orders move through an explicit fulfillment state machine
+ALTER TABLE orders ADD CONSTRAINT orders_status_check
+    CHECK (status IN ('placed', 'proof_sent', 'approved', 'printing', 'shipped', 'delivered', 'cancelled'));
+
+-- Every status change of an order
+CREATE TABLE order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    from_status VARCHAR(20), -- NULL for the initial 'placed' entry
+    to_status VARCHAR(20) NOT NULL,
+    changed_by INTEGER, -- user who made the change, NULL for system changes
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_order_status_history_order_id ON order_status_history(order_id);
+
+-- Backfill the initial entry for orders placed before history existed
+INSERT INTO order_status_history (order_id, from_status, to_status, created_at)
+SELECT id, NULL, 'placed', created_at FROM orders;
+
+INSERT INTO order_status_history (order_id, from_status, to_status, created_at)
+SELECT id, 'placed', status, updated_at FROM orders WHERE status <> 'placed';
+
+-- Parcels sent for an order
+CREATE TABLE shipments (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    carrier VARCHAR(50) NOT NULL,
+    tracking_number VARCHAR(100) NOT NULL,
+    shipped_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (carrier, tracking_number)
+);
+
+CREATE INDEX idx_shipments_order_id ON shipments(order_id);
@@ -0,0 +1,16 @@
+-- Artwork proofs attached to an order line. Each upload is a new version;
+-- the buyer approves or requests changes on the latest one.
+CREATE TABLE order_proofs (
+    id SERIAL PRIMARY KEY,
+    order_item_id INTEGER NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL,
+    file_url TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'approved', 'changes_requested', 'superseded')),
+    comment TEXT, -- buyer's change request
+    uploaded_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    reviewed_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (order_item_id, version)
+);
@@ -0,0 +1,2 @@
+-- Content address (SHA-256) of the store's logo in the blob store
+ALTER TABLE stores ADD COLUMN logo_key VARCHAR(100);
@@ -0,0 +1,15 @@
+-- Last invoice number issued by each store; numbers are gapless per store
+CREATE TABLE invoice_sequences (
+    store_id INTEGER PRIMARY KEY REFERENCES stores(id) ON DELETE CASCADE,
+    last_number INTEGER NOT NULL
+);
+
+-- One invoice per order, numbered when it is first requested
+CREATE TABLE invoices (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL UNIQUE REFERENCES orders(id) ON DELETE CASCADE,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    number INTEGER NOT NULL,
+    issued_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (store_id, number)
+);
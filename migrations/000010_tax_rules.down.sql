@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS order_tax_lines;
+ALTER TABLE order_items DROP COLUMN IF EXISTS category;
+ALTER TABLE orders DROP COLUMN IF EXISTS subtotal_cents;
+ALTER TABLE orders DROP COLUMN IF EXISTS jurisdiction;
+DROP TABLE IF EXISTS tax_rules;
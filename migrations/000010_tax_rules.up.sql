@@ -0,0 +1,36 @@
+-- Tax rules per jurisdiction. A rule for "US" also applies to "US-CA";
+-- several rules can apply to the same order (e.g. state and county tax).
+CREATE TABLE tax_rules (
+    id SERIAL PRIMARY KEY,
+    jurisdiction VARCHAR(20) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    rate NUMERIC(7, 6) NOT NULL CHECK (rate >= 0 AND rate < 1),
+    inclusive BOOLEAN NOT NULL DEFAULT FALSE, -- prices already include this tax (e.g. EU VAT)
+    exempt_categories TEXT[] NOT NULL DEFAULT '{}',
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (jurisdiction, name)
+);
+
+ALTER TABLE orders ADD COLUMN jurisdiction VARCHAR(20);
+ALTER TABLE orders ADD COLUMN subtotal_cents BIGINT;
+UPDATE orders SET subtotal_cents = total_cents;
+ALTER TABLE orders ALTER COLUMN subtotal_cents SET NOT NULL;
+
+ALTER TABLE order_items ADD COLUMN category VARCHAR(50) NOT NULL DEFAULT 'general';
+
+-- Taxes charged on an order, snapshotted from the rules at checkout
+CREATE TABLE order_tax_lines (
+    id SERIAL PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    tax_rule_id INTEGER, -- no FK: rules can change after the order was placed
+    jurisdiction VARCHAR(20) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    rate NUMERIC(7, 6) NOT NULL,
+    inclusive BOOLEAN NOT NULL,
+    taxable_cents BIGINT NOT NULL,
+    amount_cents BIGINT NOT NULL
+);
+
+CREATE INDEX idx_order_tax_lines_order_id ON order_tax_lines(order_id);
@@ -0,0 +1,36 @@
+-- Discount codes of a store. Codes are stored uppercase and are unique per store.
+CREATE TABLE promotions (
+    id SERIAL PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    code VARCHAR(32) NOT NULL,
+    kind VARCHAR(20) NOT NULL CHECK (kind IN ('percentage', 'fixed')),
+    percent_off NUMERIC(5, 2) CHECK (percent_off > 0 AND percent_off <= 100), -- percentage codes
+    amount_off_cents BIGINT CHECK (amount_off_cents > 0), -- fixed codes, in currency
+    currency CHAR(3) NOT NULL,
+    min_order_cents BIGINT NOT NULL DEFAULT 0,
+    max_redemptions INTEGER CHECK (max_redemptions > 0), -- NULL means unlimited
+    max_redemptions_per_user INTEGER CHECK (max_redemptions_per_user > 0),
+    redemption_count INTEGER NOT NULL DEFAULT 0,
+    starts_at TIMESTAMP WITH TIME ZONE,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (store_id, code),
+    CHECK ((kind = 'percentage' AND percent_off IS NOT NULL) OR (kind = 'fixed' AND amount_off_cents IS NOT NULL))
+);
+
+-- One row per order that used a code
+CREATE TABLE promotion_redemptions (
+    id SERIAL PRIMARY KEY,
+    promotion_id INTEGER NOT NULL REFERENCES promotions(id) ON DELETE CASCADE,
+    order_id INTEGER NOT NULL UNIQUE REFERENCES orders(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    discount_cents BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_promotion_redemptions_promotion_user ON promotion_redemptions(promotion_id, user_id);
+
+ALTER TABLE orders ADD COLUMN discount_cents BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN promotion_id INTEGER REFERENCES promotions(id) ON DELETE SET NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE stores DROP COLUMN IF EXISTS rating_sum;
+ALTER TABLE stores DROP COLUMN IF EXISTS review_count;
+DROP TABLE IF EXISTS reviews;
@@ -0,0 +1,21 @@
+-- Buyer reviews of stores, one per buyer and store
+CREATE TABLE reviews (
+    id SERIAL PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+    rating SMALLINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    body TEXT NOT NULL DEFAULT '',
+    verified_purchase BOOLEAN NOT NULL DEFAULT FALSE, -- linked to a non-cancelled order of the reviewer
+    reply TEXT, -- the store owner's answer
+    replied_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (store_id, user_id)
+);
+
+CREATE INDEX idx_reviews_store_id ON reviews(store_id, id DESC);
+CREATE INDEX idx_reviews_user_created ON reviews(user_id, created_at);
+
+-- Maintained incrementally as reviews are added and removed
+ALTER TABLE stores ADD COLUMN review_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE stores ADD COLUMN rating_sum INTEGER NOT NULL DEFAULT 0;
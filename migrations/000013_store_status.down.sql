@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS store_status_history;
+ALTER TABLE stores ADD COLUMN active BOOLEAN DEFAULT TRUE;
+UPDATE stores SET active = (status = 'active');
+ALTER TABLE stores DROP COLUMN IF EXISTS status;
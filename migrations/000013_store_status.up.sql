@@ -0,0 +1,25 @@
+-- Stores move through an explicit lifecycle instead of a bare active flag
+ALTER TABLE stores ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('draft', 'active', 'paused', 'suspended', 'closed'));
+
+-- Inactive stores were switched off by their owners
+UPDATE stores SET status = CASE WHEN active THEN 'active' ELSE 'paused' END;
+
+ALTER TABLE stores DROP COLUMN active;
+
+-- Every status change of a store
+CREATE TABLE store_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    from_status VARCHAR(20), -- NULL for the initial entry
+    to_status VARCHAR(20) NOT NULL,
+    reason TEXT,
+    changed_by INTEGER, -- user who made the change, NULL for system changes
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_store_status_history_store_id ON store_status_history(store_id);
+
+-- Backfill the initial entry for existing stores
+INSERT INTO store_status_history (store_id, from_status, to_status, reason)
+SELECT id, NULL, status, 'migrated from active flag' FROM stores;
@@ -0,0 +1,17 @@
+-- Store status changes planned for a later time, applied by the background scheduler
+CREATE TABLE store_status_schedules (
+    id SERIAL PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('draft', 'active', 'paused', 'suspended', 'closed')),
+    run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    state VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'applied', 'failed', 'cancelled')),
+    error TEXT, -- why a due change could not be applied
+    created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- The scheduler only ever looks for pending changes that are due
+CREATE INDEX idx_store_status_schedules_due ON store_status_schedules(run_at) WHERE state = 'pending';
+CREATE INDEX idx_store_status_schedules_store_id ON store_status_schedules(store_id);
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS stores_bump_version ON stores;
+DROP FUNCTION IF EXISTS bump_store_version();
+ALTER TABLE stores DROP COLUMN IF EXISTS version;
@@ -0,0 +1,14 @@
+-- Optimistic concurrency: every write to a store bumps its version, whichever
+-- code path makes it (edits, ledger recomputes, review counters, status changes)
+ALTER TABLE stores ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE FUNCTION bump_store_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version := OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stores_bump_version
+    BEFORE UPDATE ON stores
+    FOR EACH ROW EXECUTE FUNCTION bump_store_version();
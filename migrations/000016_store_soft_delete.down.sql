@@ -0,0 +1,3 @@
+-- Stores still waiting for the purge come back as live stores rather than being lost
+DROP INDEX IF EXISTS idx_stores_deleted_at;
+ALTER TABLE stores DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,6 @@
+-- Deleting a store only stamps deleted_at. The row can be restored until the
+-- retention purge hard-deletes it, which is when its orders and ledger go too
+ALTER TABLE stores ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+-- The purge only ever looks at deleted stores
+CREATE INDEX idx_stores_deleted_at ON stores(deleted_at) WHERE deleted_at IS NOT NULL;
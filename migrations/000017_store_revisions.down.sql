@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS stores_record_revision ON stores;
+DROP FUNCTION IF EXISTS record_store_revision();
+DROP TABLE IF EXISTS store_revisions;
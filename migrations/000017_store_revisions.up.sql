@@ -0,0 +1,54 @@
+-- Every change to a store row, whichever code path makes it, as before/after
+-- snapshots. The acting user comes from the transaction-local app.user_id
+-- setting (see setRevisionActor); system writes such as ledger recomputes
+-- leave it unset. Revisions go with the store when the retention purge removes it.
+CREATE TABLE store_revisions (
+    id BIGSERIAL PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    version INTEGER NOT NULL, -- store version after the change
+    operation VARCHAR(10) NOT NULL CHECK (operation IN ('create', 'update', 'delete', 'restore')),
+    changed_by INTEGER, -- user who made the change, NULL for system changes
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    before JSONB, -- NULL for create
+    after JSONB NOT NULL
+);
+
+-- Point-in-time reads look for the last revision at or before a time
+CREATE INDEX idx_store_revisions_store_id_changed_at ON store_revisions(store_id, changed_at);
+
+CREATE FUNCTION record_store_revision() RETURNS trigger AS $$
+DECLARE
+    op VARCHAR(10);
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        op := 'create';
+    ELSIF OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        op := 'delete';
+    ELSIF OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        op := 'restore';
+    ELSIF to_jsonb(OLD) - 'version' = to_jsonb(NEW) - 'version' THEN
+        RETURN NULL; -- nothing but the version moved
+    ELSE
+        op := 'update';
+    END IF;
+
+    INSERT INTO store_revisions (store_id, version, operation, changed_by, before, after)
+    VALUES (
+        NEW.id,
+        NEW.version,
+        op,
+        NULLIF(current_setting('app.user_id', true), '')::INTEGER,
+        CASE WHEN TG_OP = 'UPDATE' THEN to_jsonb(OLD) END,
+        to_jsonb(NEW)
+    );
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER stores_record_revision
+    AFTER INSERT OR UPDATE ON stores
+    FOR EACH ROW EXECUTE FUNCTION record_store_revision();
+
+-- History starts now: existing stores get a create revision with their current state
+INSERT INTO store_revisions (store_id, version, operation, after)
+SELECT id, version, 'create', to_jsonb(stores) FROM stores;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS revenue_adjustments_roll_up ON revenue_adjustments;
+DROP FUNCTION IF EXISTS roll_up_revenue_adjustment();
+DROP TABLE IF EXISTS store_revenue_snapshots;
@@ -0,0 +1,35 @@
+-- Revenue and orders per store per hour, rolled up from the ledger as it is
+-- written. Hourly rather than daily rows let the series query build days,
+-- weeks and months in any time zone with a whole-hour offset.
+-- Opening balances are carried-over totals, not revenue earned in that hour.
+CREATE TABLE store_revenue_snapshots (
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    hour TIMESTAMP WITH TIME ZONE NOT NULL,
+    revenue_cents BIGINT NOT NULL DEFAULT 0,
+    orders INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (store_id, hour)
+);
+
+CREATE FUNCTION roll_up_revenue_adjustment() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO store_revenue_snapshots (store_id, hour, revenue_cents, orders)
+    VALUES (NEW.store_id, date_trunc('hour', NEW.created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', NEW.amount_cents, NEW.orders_delta)
+    ON CONFLICT (store_id, hour) DO UPDATE
+    SET revenue_cents = store_revenue_snapshots.revenue_cents + EXCLUDED.revenue_cents,
+        orders = store_revenue_snapshots.orders + EXCLUDED.orders;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- The ledger is append-only, so inserts are the only thing to roll up
+CREATE TRIGGER revenue_adjustments_roll_up
+    AFTER INSERT ON revenue_adjustments
+    FOR EACH ROW WHEN (NEW.kind <> 'opening_balance')
+    EXECUTE FUNCTION roll_up_revenue_adjustment();
+
+-- Backfill from the ledger written so far
+INSERT INTO store_revenue_snapshots (store_id, hour, revenue_cents, orders)
+SELECT store_id, date_trunc('hour', created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC', SUM(amount_cents), SUM(orders_delta)
+FROM revenue_adjustments
+WHERE kind <> 'opening_balance'
+GROUP BY store_id, date_trunc('hour', created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC';
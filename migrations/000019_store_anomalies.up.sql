@@ -0,0 +1,24 @@
+-- Unusual windows of store sales found by the anomaly detector. The window is
+-- compared with the same hours of the previous days; expected_* are the means
+-- of those baseline windows. notified_at stays NULL until the owner has been
+-- told, so failed notifications are retried on the next run.
+CREATE TABLE store_anomalies (
+    id BIGSERIAL PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('orders_drop', 'revenue_spike')),
+    window_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    window_end TIMESTAMP WITH TIME ZONE NOT NULL,
+    observed_orders INTEGER NOT NULL,
+    observed_revenue_cents BIGINT NOT NULL,
+    expected_orders DOUBLE PRECISION NOT NULL,
+    expected_revenue_cents DOUBLE PRECISION NOT NULL,
+    z_score DOUBLE PRECISION NOT NULL,
+    detected_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    notified_at TIMESTAMP WITH TIME ZONE,
+    UNIQUE (store_id, kind, window_start)
+);
+
+CREATE INDEX idx_store_anomalies_store ON store_anomalies(store_id, detected_at);
+
+-- Delivery only looks for anomalies nobody has been told about yet
+CREATE INDEX idx_store_anomalies_unnotified ON store_anomalies(id) WHERE notified_at IS NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS store_slug_history;
+ALTER TABLE stores DROP COLUMN IF EXISTS slug;
@@ -0,0 +1,47 @@
+-- Public, human-readable store identifiers. A slug is made from the store
+-- name (see slugify in storeslug.go) with -2, -3, ... appended on collision,
+-- and follows the name when the store is renamed. Slugs a store used before
+-- are kept in store_slug_history so old links keep working; a slug is never
+-- handed to another store while it is current or in the history of one.
+ALTER TABLE stores ADD COLUMN slug VARCHAR(80);
+
+CREATE TABLE store_slug_history (
+    slug VARCHAR(80) PRIMARY KEY,
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    retired_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_store_slug_history_store_id ON store_slug_history(store_id);
+
+-- Existing stores get a slug in id order, so the oldest store keeps the plain
+-- one. Adding a slug is not an edit: versions and revisions are left alone.
+ALTER TABLE stores DISABLE TRIGGER stores_bump_version;
+ALTER TABLE stores DISABLE TRIGGER stores_record_revision;
+DO $$
+DECLARE
+    store RECORD;
+    base TEXT;
+    candidate TEXT;
+    n INTEGER;
+BEGIN
+    FOR store IN SELECT id, name FROM stores ORDER BY id LOOP
+        base := left(trim(both '-' from regexp_replace(lower(store.name), '[^a-z0-9]+', '-', 'g')), 60);
+        base := trim(both '-' from base);
+        IF base = '' THEN
+            base := 'store';
+        END IF;
+        candidate := base;
+        n := 1;
+        WHILE EXISTS (SELECT 1 FROM stores WHERE slug = candidate) LOOP
+            n := n + 1;
+            candidate := base || '-' || n;
+        END LOOP;
+        UPDATE stores SET slug = candidate WHERE id = store.id;
+    END LOOP;
+END;
+$$;
+ALTER TABLE stores ENABLE TRIGGER stores_record_revision;
+ALTER TABLE stores ENABLE TRIGGER stores_bump_version;
+
+ALTER TABLE stores ALTER COLUMN slug SET NOT NULL;
+ALTER TABLE stores ADD CONSTRAINT stores_slug_key UNIQUE (slug);
@@ -0,0 +1,4 @@
+ALTER TABLE stores DROP COLUMN IF EXISTS category_id;
+DROP TABLE IF EXISTS categories;
+DROP TABLE IF EXISTS store_tags;
+DROP TABLE IF EXISTS tags;
@@ -0,0 +1,33 @@
+-- Free-form tags chosen by store owners, many per store. Tag names are
+-- normalised (see normalizeTag in storetags.go) so "Laptop Stickers" and
+-- "laptop-stickers" are the same tag.
+CREATE TABLE tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(40) NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE store_tags (
+    store_id INTEGER NOT NULL REFERENCES stores(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (store_id, tag_id)
+);
+
+-- Filtering by tag starts from the tag
+CREATE INDEX idx_store_tags_tag_id ON store_tags(tag_id);
+
+-- The curated category tree, managed by admins. A store sits in at most one
+-- category; filtering by a category includes its subcategories. Categories
+-- with subcategories cannot be deleted; deleting one moves its stores out.
+CREATE TABLE categories (
+    id SERIAL PRIMARY KEY,
+    parent_id INTEGER REFERENCES categories(id) ON DELETE RESTRICT,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Sibling names are unique, ignoring case; top-level categories are siblings too
+CREATE UNIQUE INDEX idx_categories_parent_name ON categories(COALESCE(parent_id, 0), lower(name));
+
+ALTER TABLE stores ADD COLUMN category_id INTEGER REFERENCES categories(id) ON DELETE SET NULL;
+CREATE INDEX idx_stores_category_id ON stores(category_id);
@@ -0,0 +1,14 @@
+-- One row per review posted, kept when the review is deleted, so deleting and
+-- reposting reviews still counts against the hourly rate limit. Rows older than
+-- the limit window are pruned as new ones are written.
+CREATE TABLE review_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_review_attempts_user_created ON review_attempts(user_id, created_at);
+
+-- Reviews posted within the last hour still count after the upgrade
+INSERT INTO review_attempts (user_id, created_at)
+SELECT user_id, created_at FROM reviews WHERE created_at > NOW() - INTERVAL '1 hour';
@@ -0,0 +1,8 @@
+-- Only lines with artwork to print wait for an approved proof; shipping and
+-- other add-ons are left out when deciding whether an order can be printed.
+ALTER TABLE order_items ADD COLUMN needs_proof BOOLEAN NOT NULL DEFAULT TRUE;
+
+-- Existing shipping lines never had artwork, unless a proof was sent for one anyway
+UPDATE order_items SET needs_proof = FALSE
+WHERE category = 'shipping'
+AND NOT EXISTS (SELECT 1 FROM order_proofs p WHERE p.order_item_id = order_items.id);
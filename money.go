@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+//defaultCurrency is used when a store or order does not specify one
+const defaultCurrency = "USD"
+
+//Money is an exact amount in the minor units (e.g. cents) of an ISO 4217 currency
+type Money struct {
+	Amount   int64  //Minor units
+	Currency string //ISO 4217 code
+}
+
+//currencyExponents lists currencies whose minor unit is not 1/100
+var currencyExponents = map[string]int{
+	"BHD": 3, "CLP": 0, "ISK": 0, "JOD": 3, "JPY": 0,
+	"KRW": 0, "KWD": 3, "OMR": 3, "TND": 3, "VND": 0,
+}
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+var decimalPattern = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+//minorUnitExponent returns how many decimal places a currency has
+func minorUnitExponent(currency string) int {
+	if exp, ok := currencyExponents[currency]; ok {
+		return exp
+	}
+	return 2
+}
+
+//normalizeCurrency validates an ISO 4217 code, defaulting to USD when empty
+func normalizeCurrency(code string) (string, error) {
+	if code == "" {
+		return defaultCurrency, nil
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !currencyCodePattern.MatchString(code) {
+		return "", fmt.Errorf("invalid currency code %q", code)
+	}
+	return code, nil
+}
+
+//parseMoney converts a decimal string like "12.50" into Money. More decimal
+//places than the currency allows is an error rather than a silent rounding.
+func parseMoney(decimal string, currency string) (Money, error) {
+	decimal = strings.TrimSpace(decimal)
+	if !decimalPattern.MatchString(decimal) {
+		return Money{}, fmt.Errorf("invalid amount %q", decimal)
+	}
+
+	exp := minorUnitExponent(currency)
+	negative := strings.HasPrefix(decimal, "-")
+	decimal = strings.TrimPrefix(decimal, "-")
+
+	whole, frac, _ := strings.Cut(decimal, ".")
+	frac = strings.TrimRight(frac, "0")
+	if len(frac) > exp {
+		return Money{}, fmt.Errorf("amount %q has more than %d decimal places for %s", decimal, exp, currency)
+	}
+	frac += strings.Repeat("0", exp-len(frac))
+
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("amount %q is out of range", decimal)
+	}
+	if negative {
+		amount = -amount
+	}
+	return Money{Amount: amount, Currency: currency}, nil
+}
+
+//Decimal formats the amount in major units, e.g. "12.50"
+func (m Money) Decimal() string {
+	exp := minorUnitExponent(m.Currency)
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := strconv.FormatInt(amount, 10)
+	if exp == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exp {
+		digits = strings.Repeat("0", exp-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exp] + "." + digits[len(digits)-exp:]
+}
+
+//String formats the amount with its currency, e.g. "12.50 USD"
+func (m Money) String() string {
+	return m.Decimal() + " " + m.Currency
+}
+
+//Add sums two amounts of the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other.Currency, m.Currency)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+//Sub subtracts an amount of the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	return m.Add(other.Neg())
+}
+
+//Neg returns the negated amount
+func (m Money) Neg() Money {
+	return Money{Amount: -m.Amount, Currency: m.Currency}
+}
+
+//Mul multiplies the amount by a whole quantity
+func (m Money) Mul(quantity int64) Money {
+	return Money{Amount: m.Amount * quantity, Currency: m.Currency}
+}
+
+//reportingCurrency is the currency fleet-wide figures are converted to
+func reportingCurrency() string {
+	if code, err := normalizeCurrency(os.Getenv("REPORTING_CURRENCY")); err == nil {
+		return code
+	}
+	return defaultCurrency
+}
+
+//exchangeRate looks up how many units of `to` one unit of `from` is worth,
+//falling back to the inverse of the opposite rate
+func exchangeRate(ctx context.Context, q rowQuerier, from, to string) (*big.Rat, error) {
+	if from == to {
+		return big.NewRat(1, 1), nil
+	}
+
+	var rate string
+	query := "SELECT rate FROM exchange_rates WHERE base_currency = $1 AND quote_currency = $2"
+	err := q.QueryRowContext(ctx, query, from, to).Scan(&rate)
+	inverse := false
+	if err == sql.ErrNoRows {
+		err = q.QueryRowContext(ctx, query, to, from).Scan(&rate)
+		inverse = true
+	}
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no exchange rate from %s to %s", from, to)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, ok := new(big.Rat).SetString(rate)
+	if !ok || r.Sign() <= 0 {
+		return nil, fmt.Errorf("invalid exchange rate %q from %s to %s", rate, from, to)
+	}
+	if inverse {
+		r.Inv(r)
+	}
+	return r, nil
+}
+
+//convertMoney converts an amount with the given rate, rounding half away from zero
+func convertMoney(m Money, to string, rate *big.Rat) Money {
+	//minor(to) = minor(from) / 10^exp(from) * rate * 10^exp(to)
+	value := new(big.Rat).SetInt64(m.Amount)
+	value.Mul(value, rate)
+	value.Mul(value, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(minorUnitExponent(to))), nil)))
+	value.Quo(value, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(minorUnitExponent(m.Currency))), nil)))
+
+	return Money{Amount: roundRat(value), Currency: to}
+}
+
+//roundRat rounds an exact value to the nearest integer, half away from zero
+func roundRat(value *big.Rat) int64 {
+	num, den := value.Num(), value.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		if num.Sign() < 0 {
+			quo.Sub(quo, big.NewInt(1))
+		} else {
+			quo.Add(quo, big.NewInt(1))
+		}
+	}
+	return quo.Int64()
+}
+
+//moneyFromSource unwraps the Money a parent resolver returned
+func moneyFromSource(p graphql.ResolveParams) (Money, bool) {
+	switch m := p.Source.(type) {
+	case Money:
+		return m, true
+	case *Money:
+		if m != nil {
+			return *m, true
+		}
+	}
+	return Money{}, false
+}
+
+//moneyType is the GraphQL shape of Money. Amounts are strings so no precision is lost.
+var moneyType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Money",
+	Fields: graphql.Fields{
+		"amount": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Exact decimal amount in major units, e.g. \"12.50\"",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if m, ok := moneyFromSource(p); ok {
+					return m.Decimal(), nil
+				}
+				return nil, nil
+			},
+		},
+		"currency": &graphql.Field{
+			Type: graphql.String,
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if m, ok := moneyFromSource(p); ok {
+					return m.Currency, nil
+				}
+				return nil, nil
+			},
+		},
+		"minor_units": &graphql.Field{
+			Type:        graphql.String,
+			Description: "Amount in minor units (e.g. cents) as an integer string",
+			Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				if m, ok := moneyFromSource(p); ok {
+					return strconv.FormatInt(m.Amount, 10), nil
+				}
+				return nil, nil
+			},
+		},
+	},
+})
+
+//coerceDecimal turns an input value into a decimal string without going through float math
+func coerceDecimal(value interface{}) interface{} {
+	var s string
+	switch v := value.(type) {
+	case string:
+		s = strings.TrimSpace(v)
+	case int:
+		s = strconv.Itoa(v)
+	case int64:
+		s = strconv.FormatInt(v, 10)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return nil
+	}
+	if !decimalPattern.MatchString(s) {
+		return nil
+	}
+	return s
+}
+
+//decimalScalar accepts amounts as numbers or strings and hands resolvers the exact decimal text
+var decimalScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "Decimal",
+	Description: "An exact decimal amount such as 12.50, given as a number or a string",
+	Serialize:   coerceDecimal,
+	ParseValue:  coerceDecimal,
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		switch v := valueAST.(type) {
+		case *ast.IntValue:
+			return coerceDecimal(v.Value)
+		case *ast.FloatValue:
+			return coerceDecimal(v.Value)
+		case *ast.StringValue:
+			return coerceDecimal(v.Value)
+		}
+		return nil
+	},
+})
+
+//moneyArg reads a Decimal argument as Money in the given currency
+func moneyArg(args map[string]interface{}, name string, currency string) (Money, bool, error) {
+	raw, ok := args[name].(string)
+	if !ok {
+		return Money{}, false, nil
+	}
+	m, err := parseMoney(raw, currency)
+	if err != nil {
+		return Money{}, true, fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return m, true, nil
+}
+
+//reportingRevenueResolver converts a store's revenue to the reporting currency
+func (h *Handler) reportingRevenueResolver(p graphql.ResolveParams) (interface{}, error) {
+	store, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	revenue, ok := store["revenue"].(Money)
+	if !ok {
+		return nil, nil
+	}
+
+	target := reportingCurrency()
+	rate, err := exchangeRate(p.Context, h.database, revenue.Currency, target)
+	if err != nil {
+		h.logger.Warn("cannot convert revenue to reporting currency",
+			"from", revenue.Currency,
+			"to", target,
+			"error", err.Error(),
+		)
+		return nil, err
+	}
+	return convertMoney(revenue, target, rate), nil
+}
+
+//Exchange rates are stored as NUMERIC(20, 10)
+const (
+	exchangeRateIntegerDigits  = 10
+	exchangeRateFractionDigits = 10
+)
+
+//parseExchangeRate validates a positive decimal rate that fits the exchange_rates
+//column exactly, so it is neither rounded nor rejected by the database
+func parseExchangeRate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if !decimalPattern.MatchString(value) {
+		return "", fmt.Errorf("invalid exchange rate %q", value)
+	}
+	if rate, ok := new(big.Rat).SetString(value); !ok || rate.Sign() <= 0 {
+		return "", fmt.Errorf("exchange rate must be positive, got %q", value)
+	}
+	whole, fraction, _ := strings.Cut(value, ".")
+	if len(strings.TrimLeft(whole, "0")) > exchangeRateIntegerDigits {
+		return "", fmt.Errorf("exchange rate %q is too large, at most %d digits before the decimal point", value, exchangeRateIntegerDigits)
+	}
+	if len(strings.TrimRight(fraction, "0")) > exchangeRateFractionDigits {
+		return "", fmt.Errorf("exchange rate %q has more than %d decimal places", value, exchangeRateFractionDigits)
+	}
+	return value, nil
+}
+
+//setExchangeRateResolver stores the rate between two currencies - REQUIRES ADMIN
+func (h *Handler) setExchangeRateResolver(p graphql.ResolveParams) (interface{}, error) {
+	if _, err := h.requireAdmin(p); err != nil {
+		return nil, err
+	}
+
+	baseArg, _ := p.Args["base"].(string)
+	quoteArg, _ := p.Args["quote"].(string)
+	base, err := normalizeCurrency(baseArg)
+	if err != nil {
+		return nil, err
+	}
+	quote, err := normalizeCurrency(quoteArg)
+	if err != nil {
+		return nil, err
+	}
+	rateArg, _ := p.Args["rate"].(string)
+	rate, err := parseExchangeRate(rateArg)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `INSERT INTO exchange_rates (base_currency, quote_currency, rate, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (base_currency, quote_currency) DO UPDATE SET rate = EXCLUDED.rate, updated_at = NOW()`
+	if _, err := h.database.ExecContext(p.Context, query, base, quote, rate); err != nil {
+		h.logger.Error("database error saving exchange rate", "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("exchange rate updated", "base", base, "quote", quote, "rate", rate)
+
+	return map[string]interface{}{
+		"base":  base,
+		"quote": quote,
+		"rate":  rate,
+	}, nil
+}
+
+//exchangeRateType is the GraphQL shape of an exchange rate
+var exchangeRateType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ExchangeRate",
+	Fields: graphql.Fields{
+		"base":  &graphql.Field{Type: graphql.String},
+		"quote": &graphql.Field{Type: graphql.String},
+		"rate":  &graphql.Field{Type: graphql.String},
+	},
+})
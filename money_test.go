@@ -0,0 +1,124 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := []struct {
+		input    string
+		currency string
+		want     int64
+	}{
+		{"12.50", "USD", 1250},
+		{"12.5", "USD", 1250},
+		{"0.01", "USD", 1},
+		{"-3", "USD", -300},
+		{"92233720368547758.07", "USD", 9223372036854775807},
+		{"1500", "JPY", 1500},
+		{"1.005", "KWD", 1005},
+	}
+
+	for _, c := range cases {
+		got, err := parseMoney(c.input, c.currency)
+		if err != nil {
+			t.Errorf("parseMoney(%q, %s) returned error: %v", c.input, c.currency, err)
+			continue
+		}
+		if got.Amount != c.want || got.Currency != c.currency {
+			t.Errorf("parseMoney(%q, %s) = %+v, want %d %s", c.input, c.currency, got, c.want, c.currency)
+		}
+	}
+}
+
+func TestParseMoney_RejectsLossyInput(t *testing.T) {
+	for _, input := range []string{"1.001", "12.5", "abc", "", "1e5"} {
+		currency := "USD"
+		if input == "12.5" {
+			currency = "JPY" //No minor unit
+		}
+		if _, err := parseMoney(input, currency); err == nil {
+			t.Errorf("Expected error parsing %q as %s", input, currency)
+		}
+	}
+}
+
+func TestParseExchangeRate(t *testing.T) {
+	for _, input := range []string{"0.92", " 1.0850000000 ", "9999999999.9999999999", "1.500000000000"} {
+		if _, err := parseExchangeRate(input); err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", input, err)
+		}
+	}
+
+	//Fractions, exponents and values the NUMERIC(20, 10) column would round or refuse
+	for _, input := range []string{"1/3", "1e50", "0", "-1.2", "abc", "12345678901", "0.12345678901"} {
+		if _, err := parseExchangeRate(input); err == nil {
+			t.Errorf("Expected error parsing %q as an exchange rate", input)
+		}
+	}
+}
+
+func TestMoneyDecimal(t *testing.T) {
+	cases := []struct {
+		money Money
+		want  string
+	}{
+		{Money{Amount: 1250, Currency: "USD"}, "12.50"},
+		{Money{Amount: 5, Currency: "USD"}, "0.05"},
+		{Money{Amount: -7000, Currency: "USD"}, "-70.00"},
+		{Money{Amount: 1500, Currency: "JPY"}, "1500"},
+		{Money{Amount: 1005, Currency: "KWD"}, "1.005"},
+	}
+
+	for _, c := range cases {
+		if got := c.money.Decimal(); got != c.want {
+			t.Errorf("%+v.Decimal() = %q, want %q", c.money, got, c.want)
+		}
+	}
+}
+
+func TestMoneyAdd_CurrencyMismatch(t *testing.T) {
+	_, err := Money{Amount: 100, Currency: "USD"}.Add(Money{Amount: 100, Currency: "EUR"})
+	if err == nil {
+		t.Error("Expected error adding EUR to USD, got nil")
+	}
+}
+
+func TestConvertMoney(t *testing.T) {
+	//1 EUR = 1.085 USD: 10.00 EUR -> 10.85 USD
+	got := convertMoney(Money{Amount: 1000, Currency: "EUR"}, "USD", big.NewRat(1085, 1000))
+	if got != (Money{Amount: 1085, Currency: "USD"}) {
+		t.Errorf("Expected 10.85 USD, got %s", got)
+	}
+
+	//Half a cent rounds away from zero: 0.01 EUR * 1.5 = 0.015 USD -> 0.02
+	got = convertMoney(Money{Amount: 1, Currency: "EUR"}, "USD", big.NewRat(3, 2))
+	if got.Amount != 2 {
+		t.Errorf("Expected 0.02 USD, got %s", got)
+	}
+	got = convertMoney(Money{Amount: -1, Currency: "EUR"}, "USD", big.NewRat(3, 2))
+	if got.Amount != -2 {
+		t.Errorf("Expected -0.02 USD, got %s", got)
+	}
+
+	//Different exponents: 1000 JPY at 0.0067 USD/JPY -> 6.70 USD
+	got = convertMoney(Money{Amount: 1000, Currency: "JPY"}, "USD", big.NewRat(67, 10000))
+	if got.Amount != 670 {
+		t.Errorf("Expected 6.70 USD, got %s", got)
+	}
+}
+
+func TestDecimalScalar_KeepsLiteralPrecision(t *testing.T) {
+	//A float literal is passed through as its exact source text
+	got := decimalScalar.ParseLiteral(&ast.FloatValue{Value: "90071992547409.93"})
+	if got != "90071992547409.93" {
+		t.Errorf("Expected exact literal, got %v", got)
+	}
+
+	if got := decimalScalar.ParseLiteral(&ast.StringValue{Value: "not money"}); got != nil {
+		t.Errorf("Expected nil for invalid decimal, got %v", got)
+	}
+}
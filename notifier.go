@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+//webhookTimeout bounds each webhook delivery; anomaly detection sends while
+//holding its advisory lock, so a stalled relay must not hold it forever
+const webhookTimeout = 10 * time.Second
+
+//Notification is a message for a user, e.g. a store owner
+type Notification struct {
+	UserID  int                    `json:"user_id"`
+	Email   string                 `json:"email"`
+	Subject string                 `json:"subject"`
+	Body    string                 `json:"body"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+//Notifier delivers notifications to users. Notify returning nil means the
+//notification was handed off and will not be sent again.
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+//newNotifierFromEnv picks the notifier from NOTIFIER ("log" by default or "webhook")
+func newNotifierFromEnv(logger *slog.Logger) (Notifier, error) {
+	switch os.Getenv("NOTIFIER") {
+	case "", "log":
+		return &LogNotifier{Logger: logger}, nil
+	case "webhook":
+		notifier := &WebhookNotifier{
+			URL:    os.Getenv("NOTIFIER_WEBHOOK_URL"),
+			Secret: os.Getenv("NOTIFIER_WEBHOOK_SECRET"),
+			Client: &http.Client{Timeout: webhookTimeout},
+		}
+		if notifier.URL == "" {
+			return nil, fmt.Errorf("NOTIFIER_WEBHOOK_URL is required for the webhook notifier")
+		}
+		return notifier, nil
+	default:
+		return nil, fmt.Errorf("unknown NOTIFIER %q", os.Getenv("NOTIFIER"))
+	}
+}
+
+//LogNotifier writes notifications to the log, for development and as a fallback
+type LogNotifier struct {
+	Logger *slog.Logger
+}
+
+func (l *LogNotifier) Notify(ctx context.Context, n Notification) error {
+	l.Logger.Info("notification",
+		"user_id", n.UserID,
+		"email", n.Email,
+		"subject", n.Subject,
+		"body", n.Body,
+	)
+	return nil
+}
+
+//WebhookNotifier POSTs notifications as JSON to a URL, e.g. an email or chat
+//relay. With a Secret the body is signed in the X-Signature header as
+//sha256=<hex HMAC-SHA256 of the body>. Without a Client, requests time out
+//after webhookTimeout.
+type WebhookNotifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (w *WebhookNotifier) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return &http.Client{Timeout: webhookTimeout}
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client().Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("notification webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
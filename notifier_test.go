@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookNotifier_SignsBody(t *testing.T) {
+	//ARRANGE: A relay that checks the signature
+	var got Notification
+	var signatureOK bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("shh"))
+		mac.Write(body)
+		signatureOK = r.Header.Get("X-Signature") == "sha256="+hex.EncodeToString(mac.Sum(nil))
+		json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL, Secret: "shh"}
+
+	//ACT: Send a notification
+	err := notifier.Notify(context.Background(), Notification{UserID: 1, Email: "owner@example.com", Subject: "Hello"})
+
+	//ASSERT: Delivered, signed and intact
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !signatureOK {
+		t.Error("Expected a valid signature")
+	}
+	if got.Email != "owner@example.com" || got.Subject != "Hello" {
+		t.Errorf("Unexpected notification: %+v", got)
+	}
+}
+
+func TestWebhookNotifier_ErrorStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	notifier := &WebhookNotifier{URL: server.URL}
+
+	err := notifier.Notify(context.Background(), Notification{UserID: 1})
+
+	if err == nil || err.Error() != "notification webhook returned status 502" {
+		t.Errorf("Expected status error, got %v", err)
+	}
+}
+
+func TestNewNotifierFromEnv(t *testing.T) {
+	t.Setenv("NOTIFIER", "webhook")
+	t.Setenv("NOTIFIER_WEBHOOK_URL", "")
+
+	_, err := newNotifierFromEnv(nil)
+
+	if err == nil || err.Error() != "NOTIFIER_WEBHOOK_URL is required for the webhook notifier" {
+		t.Errorf("Expected missing URL error, got %v", err)
+	}
+}
+
+func TestNewNotifierFromEnv_WebhookTimeout(t *testing.T) {
+	t.Setenv("NOTIFIER", "webhook")
+	t.Setenv("NOTIFIER_WEBHOOK_URL", "https://relay.example.com/notify")
+
+	notifier, err := newNotifierFromEnv(nil)
+
+	//ASSERT: A stalled relay cannot block the sender forever
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if client := notifier.(*WebhookNotifier).client(); client.Timeout != webhookTimeout {
+		t.Errorf("Expected a %v timeout, got %v", webhookTimeout, client.Timeout)
+	}
+	if client := (&WebhookNotifier{}).client(); client.Timeout != webhookTimeout {
+		t.Errorf("Expected the fallback client to time out after %v, got %v", webhookTimeout, client.Timeout)
+	}
+}
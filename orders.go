@@ -0,0 +1,677 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+//Order statuses, see orderTransitions for the allowed moves between them
+const (
+	orderStatusPlaced    = "placed"
+	orderStatusProofSent = "proof_sent"
+	orderStatusApproved  = "approved"
+	orderStatusPrinting  = "printing"
+	orderStatusShipped   = "shipped"
+	orderStatusDelivered = "delivered"
+	orderStatusCancelled = "cancelled"
+)
+
+//Kinds of entries in the revenue_adjustments ledger
+const (
+	adjustmentOpeningBalance = "opening_balance"
+	adjustmentOrderPlaced    = "order_placed"
+	adjustmentOrderCancelled = "order_cancelled"
+	adjustmentRefund         = "refund"
+)
+
+//Order is a purchase made by a user from a store
+type Order struct {
+	ID             int
+	StoreID        int
+	UserID         int
+	Total          Money
+	RefundedAmount Money
+	Status         string
+	CreatedAt      time.Time
+}
+
+//OrderItem is a single line of an order
+type OrderItem struct {
+	ID          int
+	Description string
+	Category    string //Product category, used for tax exemptions
+	Quantity    int
+	UnitPrice   Money
+	NeedsProof  bool //Has artwork that must be approved before printing
+}
+
+//revenueAdjustment is one immutable row of the revenue ledger
+type revenueAdjustment struct {
+	StoreID     int
+	OrderID     sql.NullInt64
+	Kind        string
+	Amount      int64 //Minor units in the store's currency
+	OrdersDelta int
+	Reason      string
+	CreatedBy   sql.NullInt64
+}
+
+//rowQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+//recordRevenueAdjustment appends an entry to the ledger and recomputes the
+//store aggregates from it. Must run inside the caller's transaction with the
+//store row locked, or a concurrent entry could be missed by the recomputed sums.
+func recordRevenueAdjustment(ctx context.Context, tx *sql.Tx, adj revenueAdjustment) error {
+	insertQuery := `INSERT INTO revenue_adjustments (store_id, order_id, kind, amount_cents, orders_delta, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`
+	_, err := tx.ExecContext(ctx, insertQuery,
+		adj.StoreID, adj.OrderID, adj.Kind, adj.Amount, adj.OrdersDelta, adj.Reason, adj.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("failed to record revenue adjustment: %w", err)
+	}
+
+	return recomputeStoreAggregates(ctx, tx, adj.StoreID)
+}
+
+//recomputeStoreAggregates sets stores.revenue_cents and stores.total_orders to the sums of the ledger
+func recomputeStoreAggregates(ctx context.Context, tx *sql.Tx, storeID int) error {
+	query := `UPDATE stores SET
+		revenue_cents = (SELECT COALESCE(SUM(amount_cents), 0) FROM revenue_adjustments WHERE store_id = $1),
+		total_orders = (SELECT COALESCE(SUM(orders_delta), 0) FROM revenue_adjustments WHERE store_id = $1)
+		WHERE id = $1`
+	_, err := tx.ExecContext(ctx, query, storeID)
+	if err != nil {
+		return fmt.Errorf("failed to recompute store aggregates: %w", err)
+	}
+	return nil
+}
+
+//loadOrder fetches an order, locking the row when forUpdate is set
+func loadOrder(ctx context.Context, q rowQuerier, id int, forUpdate bool) (*Order, error) {
+	query := "SELECT id, store_id, user_id, total_cents, refunded_cents, currency, status, created_at FROM orders WHERE id = $1"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+
+	var o Order
+	var currency string
+	err := q.QueryRowContext(ctx, query, id).Scan(
+		&o.ID, &o.StoreID, &o.UserID, &o.Total.Amount, &o.RefundedAmount.Amount, &currency, &o.Status, &o.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	o.Total.Currency = currency
+	o.RefundedAmount.Currency = currency
+	return &o, nil
+}
+
+//lockOrderStore locks the store an order belongs to and returns its id and owner.
+//Callers that change an order's revenue take this lock before locking the order,
+//so two changes to one store's ledger never recompute its aggregates side by side.
+func lockOrderStore(ctx context.Context, tx *sql.Tx, orderID int) (int, int, error) {
+	var storeID, ownerID int
+	err := tx.QueryRowContext(ctx,
+		"SELECT s.id, s.user_id FROM stores s JOIN orders o ON o.store_id = s.id WHERE o.id = $1 FOR UPDATE OF s", orderID).
+		Scan(&storeID, &ownerID)
+	return storeID, ownerID, err
+}
+
+//storeOwnerID returns the user that owns a store
+func storeOwnerID(ctx context.Context, q rowQuerier, storeID int) (int, error) {
+	var ownerID int
+	err := q.QueryRowContext(ctx, "SELECT user_id FROM stores WHERE id = $1", storeID).Scan(&ownerID)
+	return ownerID, err
+}
+
+//newOrderItemType builds the OrderItem GraphQL type, whose proofs are loaded through the Handler
+func newOrderItemType(h *Handler) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "OrderItem",
+		Fields: graphql.Fields{
+			"id":          &graphql.Field{Type: graphql.Int},
+			"description": &graphql.Field{Type: graphql.String},
+			"category":    &graphql.Field{Type: graphql.String},
+			"quantity":    &graphql.Field{Type: graphql.Int},
+			"unit_price":  &graphql.Field{Type: moneyType},
+			"needs_proof": &graphql.Field{Type: graphql.Boolean},
+			"proofs": &graphql.Field{
+				Type:    graphql.NewList(proofType),
+				Resolve: h.orderItemProofsResolver,
+			},
+		},
+	})
+}
+
+//orderItemInputType is the shape of each item passed to placeOrder
+var orderItemInputType = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "OrderItemInput",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"description": &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.String)},
+		"category":    &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"quantity":    &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(graphql.Int)},
+		"unitPrice":   &graphql.InputObjectFieldConfig{Type: graphql.NewNonNull(decimalScalar)},
+		"needsProof": &graphql.InputObjectFieldConfig{
+			Type:        graphql.Boolean,
+			Description: "Whether the line has artwork to approve before printing; defaults to false for shipping and true otherwise",
+		},
+	},
+})
+
+//newOrderType builds the Order GraphQL type, whose items, discount, history and shipments are loaded through the Handler
+func newOrderType(h *Handler) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Order",
+		Fields: graphql.Fields{
+			"id":              &graphql.Field{Type: graphql.Int},
+			"store_id":        &graphql.Field{Type: graphql.Int},
+			"user_id":         &graphql.Field{Type: graphql.Int},
+			"total":           &graphql.Field{Type: moneyType},
+			"refunded_amount": &graphql.Field{Type: moneyType},
+			"status":          &graphql.Field{Type: graphql.String},
+			"created_at":      &graphql.Field{Type: graphql.String},
+			"items": &graphql.Field{
+				Type:    graphql.NewList(newOrderItemType(h)),
+				Resolve: h.orderItemsResolver,
+			},
+			"history": &graphql.Field{
+				Type:    graphql.NewList(orderStatusChangeType),
+				Resolve: h.orderHistoryResolver,
+			},
+			"discount": &graphql.Field{
+				Type:    moneyType,
+				Resolve: h.orderDiscountResolver,
+			},
+			"tax_lines": &graphql.Field{
+				Type:    graphql.NewList(taxLineType),
+				Resolve: h.orderTaxLinesResolver,
+			},
+			"shipments": &graphql.Field{
+				Type:    graphql.NewList(shipmentType),
+				Resolve: h.orderShipmentsResolver,
+			},
+		},
+	})
+}
+
+//orderToMap converts an order to the shape returned by GraphQL
+func orderToMap(o *Order) map[string]interface{} {
+	return map[string]interface{}{
+		"id":              o.ID,
+		"store_id":        o.StoreID,
+		"user_id":         o.UserID,
+		"total":           o.Total,
+		"refunded_amount": o.RefundedAmount,
+		"status":          o.Status,
+		"created_at":      o.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+//parseOrderItems validates the items argument of placeOrder, pricing them in the store's currency
+func parseOrderItems(raw interface{}, currency string) ([]OrderItem, error) {
+	list, ok := raw.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("an order needs at least one item")
+	}
+
+	items := make([]OrderItem, 0, len(list))
+	for i, entry := range list {
+		fields, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("item %d is invalid", i)
+		}
+		description, _ := fields["description"].(string)
+		category, _ := fields["category"].(string)
+		category = strings.ToLower(strings.TrimSpace(category))
+		if category == "" {
+			category = defaultItemCategory
+		}
+		needsProof, ok := fields["needsProof"].(bool)
+		if !ok {
+			needsProof = !proofFreeCategories[category]
+		}
+		quantity, _ := fields["quantity"].(int)
+		unitPrice, _, err := moneyArg(fields, "unitPrice", currency)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %v", i, err)
+		}
+
+		if description == "" {
+			return nil, fmt.Errorf("item %d: description is required", i)
+		}
+		if quantity <= 0 {
+			return nil, fmt.Errorf("item %d: quantity must be positive", i)
+		}
+		if unitPrice.Amount < 0 {
+			return nil, fmt.Errorf("item %d: unit price cannot be negative", i)
+		}
+
+		items = append(items, OrderItem{
+			Description: description,
+			Category:    category,
+			Quantity:    quantity,
+			UnitPrice:   unitPrice,
+			NeedsProof:  needsProof,
+		})
+	}
+	return items, nil
+}
+
+//placeOrderResolver creates an order for the authenticated buyer - REQUIRES AUTH
+func (h *Handler) placeOrderResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized place order attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid storeId")
+	}
+	jurisdictionArg, _ := p.Args["jurisdiction"].(string)
+	jurisdiction, err := normalizeJurisdiction(jurisdictionArg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	//Lock the store so concurrent orders recompute aggregates one at a time
+	var status string
+	var currency string
+	err = tx.QueryRowContext(ctx, "SELECT status, currency FROM stores WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", storeID).Scan(&status, &currency)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		h.logger.Error("database error loading store for order", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if status != storeStatusActive {
+		return nil, fmt.Errorf("store with id %d is not accepting orders", storeID)
+	}
+
+	items, err := parseOrderItems(p.Args["items"], currency)
+	if err != nil {
+		return nil, err
+	}
+
+	var promotion *Promotion
+	discount := Money{Currency: currency}
+	if code, _ := p.Args["promoCode"].(string); code != "" {
+		promotion, discount, err = applyDiscount(ctx, tx, storeID, userID, code, itemsSubtotal(items, currency))
+		if err != nil {
+			h.logger.Warn("promotion rejected", "store_id", storeID, "user_id", userID, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	tax, err := taxOrderItems(ctx, tx, items, discount, jurisdiction, currency)
+	if err != nil {
+		h.logger.Error("failed to calculate tax", "store_id", storeID, "jurisdiction", jurisdiction, "error", err.Error())
+		return nil, err
+	}
+	total := tax.Total
+
+	order := &Order{
+		StoreID:        storeID,
+		UserID:         userID,
+		Total:          total,
+		RefundedAmount: Money{Currency: currency},
+		Status:         orderStatusPlaced,
+	}
+	var promotionID sql.NullInt64
+	if promotion != nil {
+		promotionID = sql.NullInt64{Int64: int64(promotion.ID), Valid: true}
+	}
+	insertOrder := `INSERT INTO orders (store_id, user_id, subtotal_cents, discount_cents, promotion_id, total_cents, currency, jurisdiction, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id, created_at`
+	err = tx.QueryRowContext(ctx, insertOrder, storeID, userID, tax.Subtotal.Amount, tax.Discount.Amount, promotionID, total.Amount, currency,
+		sql.NullString{String: jurisdiction, Valid: jurisdiction != ""}, orderStatusPlaced).Scan(&order.ID, &order.CreatedAt)
+	if err != nil {
+		h.logger.Error("database error inserting order", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+
+	insertItem := "INSERT INTO order_items (order_id, description, category, quantity, unit_price_cents, needs_proof) VALUES ($1, $2, $3, $4, $5, $6)"
+	for _, item := range items {
+		if _, err := tx.ExecContext(ctx, insertItem, order.ID, item.Description, item.Category, item.Quantity, item.UnitPrice.Amount, item.NeedsProof); err != nil {
+			h.logger.Error("database error inserting order item", "order_id", order.ID, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	if promotion != nil {
+		if err := recordRedemption(ctx, tx, promotion.ID, order.ID, userID, tax.Discount); err != nil {
+			h.logger.Error("database error recording promotion redemption", "order_id", order.ID, "error", err.Error())
+			return nil, err
+		}
+	}
+
+	if err := recordOrderTaxLines(ctx, tx, order.ID, tax.TaxLines); err != nil {
+		h.logger.Error("database error inserting tax lines", "order_id", order.ID, "error", err.Error())
+		return nil, err
+	}
+
+	if err := recordInitialStatus(ctx, tx, order.ID, userID); err != nil {
+		h.logger.Error("database error recording order status", "order_id", order.ID, "error", err.Error())
+		return nil, err
+	}
+
+	err = recordRevenueAdjustment(ctx, tx, revenueAdjustment{
+		StoreID:     storeID,
+		OrderID:     sql.NullInt64{Int64: int64(order.ID), Valid: true},
+		Kind:        adjustmentOrderPlaced,
+		Amount:      total.Amount,
+		OrdersDelta: 1,
+		CreatedBy:   sql.NullInt64{Int64: int64(userID), Valid: true},
+	})
+	if err != nil {
+		h.logger.Error("failed to record order revenue", "order_id", order.ID, "error", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit order", "order_id", order.ID, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("order placed",
+		"order_id", order.ID,
+		"store_id", storeID,
+		"user_id", userID,
+		"total", total.String(),
+		"discount", tax.Discount.String(),
+	)
+
+	h.invalidateStoreCache(storeID)
+	h.recordLeaderboardActivity(ctx, storeID, total, 1)
+
+	result := orderToMap(order)
+	result["discount"] = tax.Discount
+	result["tax_lines"] = taxLinesToMaps(tax.TaxLines)
+	return result, nil
+}
+
+//cancelOrderResolver cancels an order and reverses its outstanding revenue - REQUIRES AUTH (buyer or store owner)
+func (h *Handler) cancelOrderResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized cancel order attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+	reason, _ := p.Args["reason"].(string)
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, ownerID, err := lockOrderStore(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error locking order store", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	order, err := loadOrder(ctx, tx, id, true)
+	if err != nil {
+		h.logger.Error("database error loading order", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+	if userID != order.UserID && userID != ownerID {
+		h.logger.Warn("unauthorized cancel attempt",
+			"order_id", id,
+			"requesting_user", userID,
+		)
+		return nil, fmt.Errorf("you can only cancel your own orders or orders of your stores")
+	}
+
+	if order.Status == orderStatusCancelled {
+		return nil, fmt.Errorf("order %d is already cancelled", id)
+	}
+
+	//Only what has not been refunded yet is still counted as revenue
+	outstanding, err := order.Total.Sub(order.RefundedAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	//Once printing has started the order can no longer be cancelled
+	change, err := transitionOrder(ctx, tx, order, orderStatusCancelled, userID, reason)
+	if err != nil {
+		h.logger.Warn("cancel order rejected", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	if err := releaseRedemption(ctx, tx, id); err != nil {
+		h.logger.Error("failed to release promotion redemption", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	err = recordRevenueAdjustment(ctx, tx, revenueAdjustment{
+		StoreID:     order.StoreID,
+		OrderID:     sql.NullInt64{Int64: int64(id), Valid: true},
+		Kind:        adjustmentOrderCancelled,
+		Amount:      -outstanding.Amount,
+		OrdersDelta: -1,
+		Reason:      reason,
+		CreatedBy:   sql.NullInt64{Int64: int64(userID), Valid: true},
+	})
+	if err != nil {
+		h.logger.Error("failed to record cancellation", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit cancellation", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("order cancelled",
+		"order_id", id,
+		"store_id", order.StoreID,
+		"user_id", userID,
+		"reversed_amount", outstanding.String(),
+	)
+
+	h.invalidateStoreCache(order.StoreID)
+	h.recordLeaderboardActivity(ctx, order.StoreID, outstanding.Neg(), -1)
+	h.publishEvent(ctx, eventOrderStatusChanged, change)
+
+	return orderToMap(order), nil
+}
+
+//refundOrderResolver refunds part or all of an order - REQUIRES AUTH + STORE OWNERSHIP
+func (h *Handler) refundOrderResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized refund attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+	reason, _ := p.Args["reason"].(string)
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, ownerID, err := lockOrderStore(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error locking order store", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	order, err := loadOrder(ctx, tx, id, true)
+	if err != nil {
+		h.logger.Error("database error loading order", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+	if userID != ownerID {
+		h.logger.Warn("unauthorized refund attempt - not owner",
+			"order_id", id,
+			"requesting_user", userID,
+			"store_owner", ownerID,
+		)
+		return nil, fmt.Errorf("you can only refund orders of your own stores")
+	}
+
+	if order.Status == orderStatusCancelled {
+		return nil, fmt.Errorf("order %d is cancelled and cannot be refunded", id)
+	}
+
+	amount, _, err := moneyArg(p.Args, "amount", order.Total.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Amount <= 0 {
+		return nil, fmt.Errorf("refund amount must be positive")
+	}
+
+	refundable, err := order.Total.Sub(order.RefundedAmount)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Amount > refundable.Amount {
+		return nil, fmt.Errorf("refund of %s exceeds refundable amount %s", amount, refundable)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE orders SET refunded_cents = refunded_cents + $1, updated_at = NOW() WHERE id = $2", amount.Amount, id)
+	if err != nil {
+		h.logger.Error("database error refunding order", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	err = recordRevenueAdjustment(ctx, tx, revenueAdjustment{
+		StoreID:   order.StoreID,
+		OrderID:   sql.NullInt64{Int64: int64(id), Valid: true},
+		Kind:      adjustmentRefund,
+		Amount:    -amount.Amount,
+		Reason:    reason,
+		CreatedBy: sql.NullInt64{Int64: int64(userID), Valid: true},
+	})
+	if err != nil {
+		h.logger.Error("failed to record refund", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit refund", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("order refunded",
+		"order_id", id,
+		"store_id", order.StoreID,
+		"user_id", userID,
+		"amount", amount.String(),
+	)
+
+	h.invalidateStoreCache(order.StoreID)
+	h.recordLeaderboardActivity(p.Context, order.StoreID, amount.Neg(), 0)
+
+	order.RefundedAmount.Amount += amount.Amount
+	return orderToMap(order), nil
+}
+
+//orderResolver returns a single order - REQUIRES AUTH (buyer or store owner)
+func (h *Handler) orderResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+
+	order, err := loadOrder(p.Context, h.database, id, false)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading order", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	ownerID, err := storeOwnerID(p.Context, h.database, order.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	if userID != order.UserID && userID != ownerID {
+		return nil, fmt.Errorf("order with id %d not found", id)
+	}
+
+	return orderToMap(order), nil
+}
+
+//orderItemsResolver loads the line items of an order
+func (h *Handler) orderItemsResolver(p graphql.ResolveParams) (interface{}, error) {
+	order, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		`SELECT i.id, i.description, i.category, i.quantity, i.unit_price_cents, i.needs_proof, o.currency
+		FROM order_items i JOIN orders o ON o.id = i.order_id
+		WHERE i.order_id = $1 ORDER BY i.id`, order["id"])
+	if err != nil {
+		h.logger.Error("database error loading order items", "order_id", order["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []map[string]interface{}
+	for rows.Next() {
+		var item OrderItem
+		if err := rows.Scan(&item.ID, &item.Description, &item.Category, &item.Quantity, &item.UnitPrice.Amount, &item.NeedsProof, &item.UnitPrice.Currency); err != nil {
+			return nil, err
+		}
+		items = append(items, map[string]interface{}{
+			"id":          item.ID,
+			"description": item.Description,
+			"category":    item.Category,
+			"quantity":    item.Quantity,
+			"unit_price":  item.UnitPrice,
+			"needs_proof": item.NeedsProof,
+		})
+	}
+	return items, rows.Err()
+}
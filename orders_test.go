@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+//authContext builds a resolver context carrying a request authenticated as userID
+func authContext(t *testing.T, fakeDB *sql.DB, userID int) context.Context {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret-key")
+
+	logger := slog.New(slog.NewJSONHandler(io.Discard, nil))
+	authService := NewAuthService(fakeDB, logger, "test-secret-key")
+	token, err := authService.generateToken(&User{ID: userID, Email: "test@example.com"})
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/graphql", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return context.WithValue(context.Background(), httpRequestKey, req)
+}
+
+//authorize sets the Authorization header of a REST request to a token for userID
+func authorize(t *testing.T, fakeDB *sql.DB, req *http.Request, userID int) {
+	t.Helper()
+	authReq := authContext(t, fakeDB, userID).Value(httpRequestKey).(*http.Request)
+	req.Header.Set("Authorization", authReq.Header.Get("Authorization"))
+}
+
+//orderRow returns the columns loadOrder scans
+func orderRow(id, storeID, userID int, totalCents, refundedCents int64, status string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "store_id", "user_id", "total_cents", "refunded_cents", "currency", "status", "created_at"}).
+		AddRow(id, storeID, userID, totalCents, refundedCents, "USD", status, time.Now())
+}
+
+func TestPlaceOrderResolver_Success(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Order, items, taxes and ledger entry are written in one transaction.
+	//California taxes the sticker (25.00 * 7.25% = 1.81) but not shipping.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status, currency FROM stores WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "currency"}).AddRow("active", "USD"))
+	mock.ExpectQuery("SELECT (.+) FROM tax_rules").
+		WithArgs("US-CA").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "jurisdiction", "name", "rate", "inclusive", "exempt_categories"}).
+			AddRow(1, "US-CA", "CA sales tax", "0.072500", false, "{shipping}"))
+	mock.ExpectQuery("INSERT INTO orders").
+		WithArgs(5, 2, int64(2750), int64(0), sqlmock.AnyArg(), int64(2931), "USD", sqlmock.AnyArg(), "placed").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(10, time.Now()))
+	mock.ExpectExec("INSERT INTO order_items").
+		WithArgs(10, "Laptop sticker", "general", 10, int64(250), true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO order_items").
+		WithArgs(10, "Shipping", "shipping", 1, int64(250), false).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectExec("INSERT INTO order_tax_lines").
+		WithArgs(10, 1, "US-CA", "CA sales tax", "0.072500", false, int64(2500), int64(181)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(10, "placed", 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO revenue_adjustments").
+		WithArgs(5, sqlmock.AnyArg(), "order_placed", int64(2931), 1, "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE stores SET").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args: map[string]interface{}{
+			"storeId":      5,
+			"jurisdiction": "us-ca",
+			"items": []interface{}{
+				map[string]interface{}{"description": "Laptop sticker", "quantity": 10, "unitPrice": "2.50"},
+				map[string]interface{}{"description": "Shipping", "category": "Shipping", "quantity": 1, "unitPrice": "2.5"},
+			},
+		},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.placeOrderResolver(params)
+
+	//ASSERT: Order is returned with the computed total, tax included
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	orderMap := result.(map[string]interface{})
+	if orderMap["id"] != 10 {
+		t.Errorf("Expected id=10, got %v", orderMap["id"])
+	}
+	if orderMap["total"] != (Money{Amount: 2931, Currency: "USD"}) {
+		t.Errorf("Expected total=29.31 USD, got %v", orderMap["total"])
+	}
+	if taxLines := orderMap["tax_lines"].([]map[string]interface{}); len(taxLines) != 1 {
+		t.Errorf("Expected one tax line, got %v", taxLines)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCancelOrderResolver_ReversesOutstandingRevenue(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Order of 100 with 30 already refunded, cancelled by the buyer
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT s.id, s.user_id FROM stores s JOIN orders o ON o.store_id = s.id WHERE o.id = \\$1 FOR UPDATE OF s").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(5, 1))
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 3000, "placed"))
+	mock.ExpectExec("UPDATE orders SET status").
+		WithArgs("cancelled", 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(10, "placed", "cancelled", 2, "changed my mind").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	//The promotion use is given back so it no longer counts against the code's limits
+	mock.ExpectExec("DELETE FROM promotion_redemptions WHERE order_id = \\$1 RETURNING promotion_id\\)\\s+UPDATE promotions SET redemption_count = redemption_count - 1").
+		WithArgs(10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO revenue_adjustments").
+		WithArgs(5, sqlmock.AnyArg(), "order_cancelled", int64(-7000), -1, "changed my mind", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE stores SET").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args:    map[string]interface{}{"id": 10, "reason": "changed my mind"},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.cancelOrderResolver(params)
+
+	//ASSERT: Order is cancelled
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.(map[string]interface{})["status"] != "cancelled" {
+		t.Errorf("Expected status=cancelled, got %v", result.(map[string]interface{})["status"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefundOrderResolver_Partial(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store owner (user 1) refunds 25 of a 100 order
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT s.id, s.user_id FROM stores s JOIN orders o ON o.store_id = s.id WHERE o.id = \\$1 FOR UPDATE OF s").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(5, 1))
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 0, "placed"))
+	mock.ExpectExec("UPDATE orders SET refunded_cents").
+		WithArgs(int64(2500), 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO revenue_adjustments").
+		WithArgs(5, sqlmock.AnyArg(), "refund", int64(-2500), 0, "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE stores SET").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 10, "amount": "25"},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.refundOrderResolver(params)
+
+	//ASSERT: Refunded amount is tracked on the order
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.(map[string]interface{})["refunded_amount"] != (Money{Amount: 2500, Currency: "USD"}) {
+		t.Errorf("Expected refunded_amount=25.00 USD, got %v", result.(map[string]interface{})["refunded_amount"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefundOrderResolver_ExceedsRefundable(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Only 20 of the order is left to refund
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT s.id, s.user_id FROM stores s JOIN orders o ON o.store_id = s.id WHERE o.id = \\$1 FOR UPDATE OF s").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(5, 1))
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 8000, "placed"))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 10, "amount": "50.00"},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.refundOrderResolver(params)
+
+	//ASSERT: Refund is rejected and nothing is written
+	if err == nil {
+		t.Error("Expected error for refund above refundable amount, got nil")
+	}
+	if result != nil {
+		t.Errorf("Expected nil result, got %v", result)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRefundOrderResolver_NotOwner(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The buyer (user 2) tries to refund their own order
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT s.id, s.user_id FROM stores s JOIN orders o ON o.store_id = s.id WHERE o.id = \\$1 FOR UPDATE OF s").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(5, 1))
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 0, "placed"))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args:    map[string]interface{}{"id": 10, "amount": "10.00"},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.refundOrderResolver(params)
+
+	//ASSERT: Only the store owner can refund
+	if err == nil {
+		t.Error("Expected error for refund by non-owner, got nil")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestPDFDocument_ValidStructure(t *testing.T) {
+	doc := &pdfDocument{}
+	doc.Text(50, 800, 12, false, "Price (incl. tax) \\ 12.50")
+	doc.AddPage()
+	doc.TextRight(545, 800, 12, true, "Page 2")
+
+	out := doc.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("Expected PDF header and EOF marker")
+	}
+	if !bytes.Contains(out, []byte(`(Price \(incl. tax\) \\ 12.50) Tj`)) {
+		t.Error("Expected parentheses and backslashes to be escaped")
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("Expected two pages")
+	}
+
+	//Every xref entry must point at the start of its object
+	entries := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(out, -1)
+	if len(entries) != 8 {
+		t.Fatalf("Expected 8 objects, got %d", len(entries))
+	}
+	for i, entry := range entries {
+		offset, _ := strconv.Atoi(string(entry[1]))
+		want := strconv.Itoa(i+1) + " 0 obj"
+		if !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("xref entry %d does not point at %q", i+1, want)
+		}
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	//Digits are 556/1000 em in both Helvetica weights
+	if got := textWidth("10.00", 10, false); got != 25.02 {
+		t.Errorf("Expected 25.02pt, got %v", got)
+	}
+	if textWidth("Total", 10, true) <= textWidth("Total", 10, false) {
+		t.Error("Expected bold text to be wider")
+	}
+}
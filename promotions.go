@@ -0,0 +1,669 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+//Kinds of promotions
+const (
+	promotionPercentage = "percentage"
+	promotionFixed      = "fixed"
+)
+
+//promoCodePattern matches normalized codes like SUMMER25 or BACK-TO-SCHOOL
+var promoCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+//Promotion is a discount code of a store
+type Promotion struct {
+	ID                    int
+	StoreID               int
+	Code                  string
+	Kind                  string
+	PercentOff            *big.Rat //Percentage codes, e.g. 15 for 15% off
+	AmountOff             Money    //Fixed codes
+	MinOrder              Money
+	MaxRedemptions        sql.NullInt64 //NULL means unlimited
+	MaxRedemptionsPerUser sql.NullInt64
+	RedemptionCount       int
+	StartsAt              sql.NullTime
+	EndsAt                sql.NullTime
+	Active                bool
+	CreatedAt             time.Time
+}
+
+const promotionColumns = `id, store_id, code, kind, percent_off, amount_off_cents, currency, min_order_cents,
+	max_redemptions, max_redemptions_per_user, redemption_count, starts_at, ends_at, active, created_at`
+
+//normalizePromoCode uppercases a code and checks its format
+func normalizePromoCode(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !promoCodePattern.MatchString(code) {
+		return "", fmt.Errorf("invalid promotion code %q: use 3-32 letters, digits, - or _", code)
+	}
+	return code, nil
+}
+
+//scanPromotion reads a row selected with promotionColumns, followed by any extra columns
+func scanPromotion(row interface{ Scan(...interface{}) error }, extra ...interface{}) (*Promotion, error) {
+	var pr Promotion
+	var percentOff sql.NullString
+	var amountOff sql.NullInt64
+	var currency string
+	dest := []interface{}{&pr.ID, &pr.StoreID, &pr.Code, &pr.Kind, &percentOff, &amountOff, &currency, &pr.MinOrder.Amount,
+		&pr.MaxRedemptions, &pr.MaxRedemptionsPerUser, &pr.RedemptionCount, &pr.StartsAt, &pr.EndsAt, &pr.Active, &pr.CreatedAt}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if percentOff.Valid {
+		rate, ok := new(big.Rat).SetString(percentOff.String)
+		if !ok {
+			return nil, fmt.Errorf("invalid percent_off %q for promotion %d", percentOff.String, pr.ID)
+		}
+		pr.PercentOff = rate
+	}
+	pr.AmountOff = Money{Amount: amountOff.Int64, Currency: currency}
+	pr.MinOrder.Currency = currency
+	return &pr, nil
+}
+
+//loadPromotion fetches a promotion by id, locking the row when forUpdate is set
+func loadPromotion(ctx context.Context, q rowQuerier, id int, forUpdate bool) (*Promotion, error) {
+	query := "SELECT " + promotionColumns + " FROM promotions WHERE id = $1"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	return scanPromotion(q.QueryRowContext(ctx, query, id))
+}
+
+//findPromotion looks up a store's code, locking the row when forUpdate is set
+func findPromotion(ctx context.Context, q rowQuerier, storeID int, code string, forUpdate bool) (*Promotion, error) {
+	query := "SELECT " + promotionColumns + " FROM promotions WHERE store_id = $1 AND code = $2"
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	return scanPromotion(q.QueryRowContext(ctx, query, storeID, code))
+}
+
+//userRedemptions counts how many times a user has used a promotion
+func userRedemptions(ctx context.Context, q rowQuerier, promotionID, userID int) (int, error) {
+	var count int
+	err := q.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM promotion_redemptions WHERE promotion_id = $1 AND user_id = $2", promotionID, userID).Scan(&count)
+	return count, err
+}
+
+//discountFor checks that the promotion can be used on an order and returns the discount it gives.
+//The discount never exceeds the subtotal.
+func (pr *Promotion) discountFor(subtotal Money, usedByUser int, now time.Time) (Money, error) {
+	if !pr.Active {
+		return Money{}, fmt.Errorf("promotion code %s is not valid", pr.Code)
+	}
+	if pr.StartsAt.Valid && now.Before(pr.StartsAt.Time) {
+		return Money{}, fmt.Errorf("promotion code %s is not valid yet", pr.Code)
+	}
+	if pr.EndsAt.Valid && !now.Before(pr.EndsAt.Time) {
+		return Money{}, fmt.Errorf("promotion code %s has expired", pr.Code)
+	}
+	if subtotal.Currency != pr.MinOrder.Currency {
+		return Money{}, fmt.Errorf("promotion code %s is not valid for %s orders", pr.Code, subtotal.Currency)
+	}
+	if subtotal.Amount < pr.MinOrder.Amount {
+		return Money{}, fmt.Errorf("promotion code %s requires a minimum order of %s", pr.Code, pr.MinOrder.String())
+	}
+	if pr.MaxRedemptions.Valid && int64(pr.RedemptionCount) >= pr.MaxRedemptions.Int64 {
+		return Money{}, fmt.Errorf("promotion code %s has been fully redeemed", pr.Code)
+	}
+	if pr.MaxRedemptionsPerUser.Valid && int64(usedByUser) >= pr.MaxRedemptionsPerUser.Int64 {
+		return Money{}, fmt.Errorf("you have already used promotion code %s", pr.Code)
+	}
+
+	discount := Money{Currency: subtotal.Currency}
+	switch pr.Kind {
+	case promotionPercentage:
+		exact := new(big.Rat).Mul(new(big.Rat).SetInt64(subtotal.Amount), pr.PercentOff)
+		discount.Amount = roundRat(exact.Quo(exact, big.NewRat(100, 1)))
+	case promotionFixed:
+		discount.Amount = pr.AmountOff.Amount
+	}
+	if discount.Amount > subtotal.Amount {
+		discount.Amount = subtotal.Amount
+	}
+	return discount, nil
+}
+
+//applyDiscount redeems a code on a new order and returns the discount. It locks the promotion
+//and counts the use in the caller's transaction, so concurrent checkouts cannot exceed the limits.
+func applyDiscount(ctx context.Context, tx *sql.Tx, storeID, userID int, code string, subtotal Money) (*Promotion, Money, error) {
+	code, err := normalizePromoCode(code)
+	if err != nil {
+		return nil, Money{}, err
+	}
+	promotion, err := findPromotion(ctx, tx, storeID, code, true)
+	if err == sql.ErrNoRows {
+		return nil, Money{}, fmt.Errorf("promotion code %s is not valid", code)
+	}
+	if err != nil {
+		return nil, Money{}, err
+	}
+
+	used, err := userRedemptions(ctx, tx, promotion.ID, userID)
+	if err != nil {
+		return nil, Money{}, err
+	}
+	discount, err := promotion.discountFor(subtotal, used, time.Now())
+	if err != nil {
+		return nil, Money{}, err
+	}
+
+	result, err := tx.ExecContext(ctx, `UPDATE promotions SET redemption_count = redemption_count + 1, updated_at = NOW()
+		WHERE id = $1 AND (max_redemptions IS NULL OR redemption_count < max_redemptions)`, promotion.ID)
+	if err != nil {
+		return nil, Money{}, fmt.Errorf("failed to count promotion use: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return nil, Money{}, fmt.Errorf("promotion code %s has been fully redeemed", code)
+	}
+	promotion.RedemptionCount++
+	return promotion, discount, nil
+}
+
+//recordRedemption links a redeemed promotion to its order. Must run inside the caller's transaction.
+func recordRedemption(ctx context.Context, tx *sql.Tx, promotionID, orderID, userID int, discount Money) error {
+	_, err := tx.ExecContext(ctx,
+		"INSERT INTO promotion_redemptions (promotion_id, order_id, user_id, discount_cents) VALUES ($1, $2, $3, $4)",
+		promotionID, orderID, userID, discount.Amount)
+	if err != nil {
+		return fmt.Errorf("failed to record promotion redemption: %w", err)
+	}
+	return nil
+}
+
+//releaseRedemption gives back the promotion use of a cancelled order, so it no longer
+//counts against the code's limits. Must run inside the caller's transaction.
+func releaseRedemption(ctx context.Context, tx *sql.Tx, orderID int) error {
+	_, err := tx.ExecContext(ctx,
+		`WITH released AS (DELETE FROM promotion_redemptions WHERE order_id = $1 RETURNING promotion_id)
+		UPDATE promotions SET redemption_count = redemption_count - 1, updated_at = NOW()
+		WHERE id IN (SELECT promotion_id FROM released)`, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to release promotion redemption: %w", err)
+	}
+	return nil
+}
+
+//allocateDiscount spreads an order discount over its lines in proportion to their amounts,
+//so taxes and exemptions apply to what is actually paid for each line
+func allocateDiscount(lines []taxableLine, discount int64) []taxableLine {
+	var total int64
+	for _, line := range lines {
+		total += line.Amount.Amount
+	}
+	if discount <= 0 || total <= 0 {
+		return lines
+	}
+
+	allocated := make([]taxableLine, len(lines))
+	remaining := discount
+	for i, line := range lines {
+		share := new(big.Int).Mul(big.NewInt(discount), big.NewInt(line.Amount.Amount))
+		share.Quo(share, big.NewInt(total))
+		allocated[i] = line
+		allocated[i].Amount.Amount -= share.Int64()
+		remaining -= share.Int64()
+	}
+	//Rounding leftovers go to the first lines that can still absorb them
+	for i := range allocated {
+		if remaining == 0 {
+			break
+		}
+		take := min64(remaining, allocated[i].Amount.Amount)
+		allocated[i].Amount.Amount -= take
+		remaining -= take
+	}
+	return allocated
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//itemsSubtotal sums the line amounts of order items
+func itemsSubtotal(items []OrderItem, currency string) Money {
+	subtotal := Money{Currency: currency}
+	for _, item := range items {
+		subtotal.Amount += item.UnitPrice.Mul(int64(item.Quantity)).Amount
+	}
+	return subtotal
+}
+
+//previewDiscount checks a code for quoteOrder without redeeming it. Per-user limits
+//are only checked for signed-in callers.
+func (h *Handler) previewDiscount(p graphql.ResolveParams, storeID int, code string, subtotal Money) (Money, error) {
+	code, err := normalizePromoCode(code)
+	if err != nil {
+		return Money{}, err
+	}
+	promotion, err := findPromotion(p.Context, h.database, storeID, code, false)
+	if err == sql.ErrNoRows {
+		return Money{}, fmt.Errorf("promotion code %s is not valid", code)
+	}
+	if err != nil {
+		h.logger.Error("database error loading promotion", "store_id", storeID, "code", code, "error", err.Error())
+		return Money{}, err
+	}
+
+	used := 0
+	if r, ok := p.Context.Value(httpRequestKey).(*http.Request); ok {
+		if userID, err := h.getUserIDFromContext(r); err == nil {
+			if used, err = userRedemptions(p.Context, h.database, promotion.ID, userID); err != nil {
+				return Money{}, err
+			}
+		}
+	}
+	return promotion.discountFor(subtotal, used, time.Now())
+}
+
+//timeArg reads an optional RFC 3339 timestamp argument
+func timeArg(args map[string]interface{}, name string) (sql.NullTime, bool, error) {
+	raw, ok := args[name].(string)
+	if !ok {
+		return sql.NullTime{}, false, nil
+	}
+	if raw == "" {
+		return sql.NullTime{}, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return sql.NullTime{}, true, fmt.Errorf("invalid %s: use RFC 3339, e.g. 2026-06-01T00:00:00Z", name)
+	}
+	return sql.NullTime{Time: t, Valid: true}, true, nil
+}
+
+//limitArg reads an optional usage limit; 0 removes the limit
+func limitArg(args map[string]interface{}, name string) (sql.NullInt64, bool, error) {
+	limit, ok := args[name].(int)
+	if !ok {
+		return sql.NullInt64{}, false, nil
+	}
+	if limit < 0 {
+		return sql.NullInt64{}, true, fmt.Errorf("%s cannot be negative", name)
+	}
+	return sql.NullInt64{Int64: int64(limit), Valid: limit > 0}, true, nil
+}
+
+//promotionToMap converts a promotion to the shape returned by GraphQL
+func promotionToMap(pr *Promotion) map[string]interface{} {
+	promotion := map[string]interface{}{
+		"id":               pr.ID,
+		"store_id":         pr.StoreID,
+		"code":             pr.Code,
+		"kind":             pr.Kind,
+		"min_order":        pr.MinOrder,
+		"redemption_count": pr.RedemptionCount,
+		"active":           pr.Active,
+		"created_at":       pr.CreatedAt.Format(time.RFC3339),
+	}
+	if pr.PercentOff != nil {
+		promotion["percent_off"] = strings.TrimSuffix(strings.TrimRight(pr.PercentOff.FloatString(2), "0"), ".")
+	}
+	if pr.Kind == promotionFixed {
+		promotion["amount_off"] = pr.AmountOff
+	}
+	if pr.MaxRedemptions.Valid {
+		promotion["max_redemptions"] = pr.MaxRedemptions.Int64
+	}
+	if pr.MaxRedemptionsPerUser.Valid {
+		promotion["max_redemptions_per_user"] = pr.MaxRedemptionsPerUser.Int64
+	}
+	if pr.StartsAt.Valid {
+		promotion["starts_at"] = pr.StartsAt.Time.Format(time.RFC3339)
+	}
+	if pr.EndsAt.Valid {
+		promotion["ends_at"] = pr.EndsAt.Time.Format(time.RFC3339)
+	}
+	return promotion
+}
+
+//requireStoreOwner checks that the caller owns a store and returns the store's currency
+func (h *Handler) requireStoreOwner(ctx context.Context, userID, storeID int) (string, error) {
+	var ownerID int
+	var currency string
+	err := h.database.QueryRowContext(ctx, "SELECT user_id, currency FROM stores WHERE id = $1 AND deleted_at IS NULL", storeID).Scan(&ownerID, &currency)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		h.logger.Error("database error checking store owner", "store_id", storeID, "error", err.Error())
+		return "", err
+	}
+	if ownerID != userID {
+		return "", fmt.Errorf("you can only manage promotions of your own stores")
+	}
+	return currency, nil
+}
+
+//createPromotionResolver adds a discount code to a store - REQUIRES AUTH (store owner)
+func (h *Handler) createPromotionResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized create promotion attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid storeId")
+	}
+	codeArg, _ := p.Args["code"].(string)
+	code, err := normalizePromoCode(codeArg)
+	if err != nil {
+		return nil, err
+	}
+	kind, _ := p.Args["kind"].(string)
+
+	currency, err := h.requireStoreOwner(p.Context, userID, storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	pr := &Promotion{StoreID: storeID, Code: code, Kind: kind, Active: true,
+		AmountOff: Money{Currency: currency}, MinOrder: Money{Currency: currency}}
+	value, _ := p.Args["value"].(string)
+	switch kind {
+	case promotionPercentage:
+		percent, ok := new(big.Rat).SetString(value)
+		if !decimalPattern.MatchString(value) || !ok || percent.Sign() <= 0 || percent.Cmp(big.NewRat(100, 1)) > 0 {
+			return nil, fmt.Errorf("percentage must be between 0 and 100, got %q", value)
+		}
+		pr.PercentOff = percent
+	case promotionFixed:
+		amount, _, err := moneyArg(p.Args, "value", currency)
+		if err != nil {
+			return nil, err
+		}
+		if amount.Amount <= 0 {
+			return nil, fmt.Errorf("fixed discount must be positive")
+		}
+		pr.AmountOff = amount
+	default:
+		return nil, fmt.Errorf("invalid kind %q: use %s or %s", kind, promotionPercentage, promotionFixed)
+	}
+
+	if err := applyPromotionArgs(pr, p.Args); err != nil {
+		return nil, err
+	}
+
+	var percentOff sql.NullString
+	var amountOff sql.NullInt64
+	if pr.PercentOff != nil {
+		percentOff = sql.NullString{String: pr.PercentOff.FloatString(2), Valid: true}
+	} else {
+		amountOff = sql.NullInt64{Int64: pr.AmountOff.Amount, Valid: true}
+	}
+
+	insertQuery := `INSERT INTO promotions (store_id, code, kind, percent_off, amount_off_cents, currency, min_order_cents,
+			max_redemptions, max_redemptions_per_user, starts_at, ends_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		ON CONFLICT (store_id, code) DO NOTHING
+		RETURNING id, created_at`
+	err = h.database.QueryRowContext(p.Context, insertQuery, storeID, code, kind, percentOff, amountOff, currency, pr.MinOrder.Amount,
+		pr.MaxRedemptions, pr.MaxRedemptionsPerUser, pr.StartsAt, pr.EndsAt).Scan(&pr.ID, &pr.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("promotion code %s already exists", code)
+	}
+	if err != nil {
+		h.logger.Error("database error creating promotion", "store_id", storeID, "code", code, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("promotion created",
+		"promotion_id", pr.ID,
+		"store_id", storeID,
+		"code", code,
+		"kind", kind,
+		"user_id", userID,
+	)
+	return promotionToMap(pr), nil
+}
+
+//applyPromotionArgs sets the optional limits and validity window shared by create and update
+func applyPromotionArgs(pr *Promotion, args map[string]interface{}) error {
+	if minOrder, ok, err := moneyArg(args, "minOrder", pr.MinOrder.Currency); err != nil {
+		return err
+	} else if ok {
+		if minOrder.Amount < 0 {
+			return fmt.Errorf("minOrder cannot be negative")
+		}
+		pr.MinOrder = minOrder
+	}
+	if limit, ok, err := limitArg(args, "maxRedemptions"); err != nil {
+		return err
+	} else if ok {
+		pr.MaxRedemptions = limit
+	}
+	if limit, ok, err := limitArg(args, "maxRedemptionsPerUser"); err != nil {
+		return err
+	} else if ok {
+		pr.MaxRedemptionsPerUser = limit
+	}
+	if startsAt, ok, err := timeArg(args, "startsAt"); err != nil {
+		return err
+	} else if ok {
+		pr.StartsAt = startsAt
+	}
+	if endsAt, ok, err := timeArg(args, "endsAt"); err != nil {
+		return err
+	} else if ok {
+		pr.EndsAt = endsAt
+	}
+	if active, ok := args["active"].(bool); ok {
+		pr.Active = active
+	}
+
+	if pr.StartsAt.Valid && pr.EndsAt.Valid && !pr.EndsAt.Time.After(pr.StartsAt.Time) {
+		return fmt.Errorf("endsAt must be after startsAt")
+	}
+	return nil
+}
+
+//updatePromotionResolver changes the limits, validity window or active flag of a code - REQUIRES AUTH (store owner)
+func (h *Handler) updatePromotionResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized update promotion attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+
+	pr, err := loadPromotion(p.Context, h.database, id, false)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("promotion with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading promotion", "promotion_id", id, "error", err.Error())
+		return nil, err
+	}
+	if _, err := h.requireStoreOwner(p.Context, userID, pr.StoreID); err != nil {
+		return nil, err
+	}
+
+	if err := applyPromotionArgs(pr, p.Args); err != nil {
+		return nil, err
+	}
+
+	updateQuery := `UPDATE promotions SET min_order_cents = $1, max_redemptions = $2, max_redemptions_per_user = $3,
+		starts_at = $4, ends_at = $5, active = $6, updated_at = NOW() WHERE id = $7`
+	_, err = h.database.ExecContext(p.Context, updateQuery, pr.MinOrder.Amount, pr.MaxRedemptions, pr.MaxRedemptionsPerUser,
+		pr.StartsAt, pr.EndsAt, pr.Active, id)
+	if err != nil {
+		h.logger.Error("database error updating promotion", "promotion_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("promotion updated",
+		"promotion_id", id,
+		"store_id", pr.StoreID,
+		"active", pr.Active,
+		"user_id", userID,
+	)
+	return promotionToMap(pr), nil
+}
+
+//promotionsResolver lists a store's codes with their redemption totals - REQUIRES AUTH (store owner)
+func (h *Handler) promotionsResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid storeId")
+	}
+	if _, err := h.requireStoreOwner(p.Context, userID, storeID); err != nil {
+		return nil, err
+	}
+
+	rows, err := h.database.QueryContext(p.Context, "SELECT "+promotionColumns+`,
+		(SELECT COALESCE(SUM(discount_cents), 0) FROM promotion_redemptions r WHERE r.promotion_id = promotions.id)
+		FROM promotions WHERE store_id = $1 ORDER BY created_at DESC, id DESC`, storeID)
+	if err != nil {
+		h.logger.Error("database error listing promotions", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := []map[string]interface{}{}
+	for rows.Next() {
+		var totalDiscount int64
+		pr, err := scanPromotion(rows, &totalDiscount)
+		if err != nil {
+			return nil, err
+		}
+		promotion := promotionToMap(pr)
+		promotion["total_discount"] = Money{Amount: totalDiscount, Currency: pr.MinOrder.Currency}
+		promotions = append(promotions, promotion)
+	}
+	return promotions, rows.Err()
+}
+
+//promotionRedemptionsResolver lists the orders that used a code - store owner only
+func (h *Handler) promotionRedemptionsResolver(p graphql.ResolveParams) (interface{}, error) {
+	promotion, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	promotionID, _ := promotion["id"].(int)
+	storeID, _ := promotion["store_id"].(int)
+
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	currency, err := h.requireStoreOwner(p.Context, userID, storeID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		`SELECT order_id, user_id, discount_cents, created_at FROM promotion_redemptions
+		WHERE promotion_id = $1 ORDER BY created_at, id`, promotionID)
+	if err != nil {
+		h.logger.Error("database error listing redemptions", "promotion_id", promotionID, "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	redemptions := []map[string]interface{}{}
+	for rows.Next() {
+		var orderID, buyerID int
+		var discount int64
+		var createdAt time.Time
+		if err := rows.Scan(&orderID, &buyerID, &discount, &createdAt); err != nil {
+			return nil, err
+		}
+		redemptions = append(redemptions, map[string]interface{}{
+			"order_id":   orderID,
+			"user_id":    buyerID,
+			"discount":   Money{Amount: discount, Currency: currency},
+			"created_at": createdAt.Format(time.RFC3339),
+		})
+	}
+	return redemptions, rows.Err()
+}
+
+//orderDiscountResolver returns the promotion discount of an order
+func (h *Handler) orderDiscountResolver(p graphql.ResolveParams) (interface{}, error) {
+	order, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	if discount, ok := order["discount"].(Money); ok {
+		return discount, nil
+	}
+	total, _ := order["total"].(Money)
+	id, _ := order["id"].(int)
+
+	discount := Money{Currency: total.Currency}
+	err := h.database.QueryRowContext(p.Context, "SELECT discount_cents FROM orders WHERE id = $1", id).Scan(&discount.Amount)
+	if err != nil {
+		h.logger.Error("database error loading order discount", "order_id", id, "error", err.Error())
+		return nil, err
+	}
+	return discount, nil
+}
+
+//promotionRedemptionType is one use of a code in GraphQL
+var promotionRedemptionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "PromotionRedemption",
+	Fields: graphql.Fields{
+		"order_id":   &graphql.Field{Type: graphql.Int},
+		"user_id":    &graphql.Field{Type: graphql.Int},
+		"discount":   &graphql.Field{Type: moneyType},
+		"created_at": &graphql.Field{Type: graphql.String},
+	},
+})
+
+//newPromotionType builds the Promotion GraphQL type, whose redemptions are loaded through the Handler
+func newPromotionType(h *Handler) *graphql.Object {
+	return graphql.NewObject(graphql.ObjectConfig{
+		Name: "Promotion",
+		Fields: graphql.Fields{
+			"id":                       &graphql.Field{Type: graphql.Int},
+			"store_id":                 &graphql.Field{Type: graphql.Int},
+			"code":                     &graphql.Field{Type: graphql.String},
+			"kind":                     &graphql.Field{Type: graphql.String},
+			"percent_off":              &graphql.Field{Type: graphql.String},
+			"amount_off":               &graphql.Field{Type: moneyType},
+			"min_order":                &graphql.Field{Type: moneyType},
+			"max_redemptions":          &graphql.Field{Type: graphql.Int},
+			"max_redemptions_per_user": &graphql.Field{Type: graphql.Int},
+			"redemption_count":         &graphql.Field{Type: graphql.Int},
+			"total_discount":           &graphql.Field{Type: moneyType},
+			"starts_at":                &graphql.Field{Type: graphql.String},
+			"ends_at":                  &graphql.Field{Type: graphql.String},
+			"active":                   &graphql.Field{Type: graphql.Boolean},
+			"created_at":               &graphql.Field{Type: graphql.String},
+			"redemptions": &graphql.Field{
+				Type:    graphql.NewList(promotionRedemptionType),
+				Resolve: h.promotionRedemptionsResolver,
+			},
+		},
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"database/sql"
+	"io"
+	"log/slog"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+//promotionRow returns the columns scanPromotion reads for a 10% code
+func promotionRow(id, storeID int, redemptions int, maxRedemptions interface{}) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "store_id", "code", "kind", "percent_off", "amount_off_cents", "currency", "min_order_cents",
+		"max_redemptions", "max_redemptions_per_user", "redemption_count", "starts_at", "ends_at", "active", "created_at"}).
+		AddRow(id, storeID, "SAVE10", "percentage", "10.00", nil, "USD", int64(1000), maxRedemptions, 1, redemptions, nil, nil, true, time.Now())
+}
+
+func TestPromotionDiscountFor(t *testing.T) {
+	now := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+	percentage := Promotion{Code: "SAVE15", Kind: promotionPercentage, PercentOff: big.NewRat(15, 1), Active: true,
+		MinOrder: Money{Amount: 1000, Currency: "USD"}}
+	fixed := Promotion{Code: "FIVEOFF", Kind: promotionFixed, AmountOff: Money{Amount: 500, Currency: "USD"}, Active: true,
+		MinOrder: Money{Currency: "USD"}}
+
+	inactive := percentage
+	inactive.Active = false
+	expired := percentage
+	expired.EndsAt = sql.NullTime{Time: now.Add(-time.Hour), Valid: true}
+	upcoming := percentage
+	upcoming.StartsAt = sql.NullTime{Time: now.Add(time.Hour), Valid: true}
+	exhausted := percentage
+	exhausted.MaxRedemptions = sql.NullInt64{Int64: 100, Valid: true}
+	exhausted.RedemptionCount = 100
+	oncePerUser := percentage
+	oncePerUser.MaxRedemptionsPerUser = sql.NullInt64{Int64: 1, Valid: true}
+
+	cases := []struct {
+		name       string
+		promotion  Promotion
+		subtotal   int64
+		usedByUser int
+		want       int64
+		wantErr    bool
+	}{
+		{"percentage rounds half away from zero", percentage, 2750, 0, 413, false},
+		{"fixed amount", fixed, 2750, 0, 500, false},
+		{"fixed amount capped at subtotal", fixed, 300, 0, 300, false},
+		{"below minimum order", percentage, 999, 0, 0, true},
+		{"inactive", inactive, 2750, 0, 0, true},
+		{"expired", expired, 2750, 0, 0, true},
+		{"not started", upcoming, 2750, 0, 0, true},
+		{"global limit reached", exhausted, 2750, 0, 0, true},
+		{"per-user limit reached", oncePerUser, 2750, 1, 0, true},
+		{"per-user limit not reached", oncePerUser, 2750, 0, 413, false},
+	}
+
+	for _, c := range cases {
+		got, err := c.promotion.discountFor(Money{Amount: c.subtotal, Currency: "USD"}, c.usedByUser, now)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: expected error=%v, got %v", c.name, c.wantErr, err)
+			continue
+		}
+		if !c.wantErr && got.Amount != c.want {
+			t.Errorf("%s: expected discount %d, got %d", c.name, c.want, got.Amount)
+		}
+	}
+}
+
+func TestAllocateDiscount(t *testing.T) {
+	lines := []taxableLine{
+		{Category: "general", Amount: Money{Amount: 1000, Currency: "USD"}},
+		{Category: "general", Amount: Money{Amount: 1000, Currency: "USD"}},
+		{Category: "shipping", Amount: Money{Amount: 1000, Currency: "USD"}},
+	}
+
+	allocated := allocateDiscount(lines, 100)
+
+	//100 does not split evenly in three; the leftover cent goes to the first line
+	want := []int64{966, 967, 967}
+	for i, line := range allocated {
+		if line.Amount.Amount != want[i] {
+			t.Errorf("Line %d: expected %d, got %d", i, want[i], line.Amount.Amount)
+		}
+	}
+	if lines[0].Amount.Amount != 1000 {
+		t.Error("Expected the input lines to be left untouched")
+	}
+}
+
+func TestPlaceOrderResolver_AppliesPromotion(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: SAVE10 takes 10% off a 25.00 order; the use is counted and recorded in the order's transaction
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status, currency FROM stores WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "currency"}).AddRow("active", "USD"))
+	mock.ExpectQuery("SELECT (.+) FROM promotions WHERE store_id = \\$1 AND code = \\$2 FOR UPDATE").
+		WithArgs(5, "SAVE10").
+		WillReturnRows(promotionRow(3, 5, 7, 100))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM promotion_redemptions").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("UPDATE promotions SET redemption_count = redemption_count \\+ 1").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO orders").
+		WithArgs(5, 2, int64(2500), int64(250), sqlmock.AnyArg(), int64(2250), "USD", sqlmock.AnyArg(), "placed").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(10, time.Now()))
+	mock.ExpectExec("INSERT INTO order_items").
+		WithArgs(10, "Laptop sticker", "general", 10, int64(250), true).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO promotion_redemptions").
+		WithArgs(3, 10, 2, int64(250)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(10, "placed", 2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO revenue_adjustments").
+		WithArgs(5, sqlmock.AnyArg(), "order_placed", int64(2250), 1, "", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE stores SET").
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args: map[string]interface{}{
+			"storeId":   5,
+			"promoCode": "save10",
+			"items": []interface{}{
+				map[string]interface{}{"description": "Laptop sticker", "quantity": 10, "unitPrice": "2.50"},
+			},
+		},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.placeOrderResolver(params)
+
+	//ASSERT: The order total is discounted
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	orderMap := result.(map[string]interface{})
+	if orderMap["total"] != (Money{Amount: 2250, Currency: "USD"}) {
+		t.Errorf("Expected total=22.50 USD, got %v", orderMap["total"])
+	}
+	if orderMap["discount"] != (Money{Amount: 250, Currency: "USD"}) {
+		t.Errorf("Expected discount=2.50 USD, got %v", orderMap["discount"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPlaceOrderResolver_PromotionFullyRedeemed(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The last use of the code was taken by a concurrent checkout
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT status, currency FROM stores WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "currency"}).AddRow("active", "USD"))
+	mock.ExpectQuery("SELECT (.+) FROM promotions WHERE store_id = \\$1 AND code = \\$2 FOR UPDATE").
+		WithArgs(5, "SAVE10").
+		WillReturnRows(promotionRow(3, 5, 99, 100))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM promotion_redemptions").
+		WithArgs(3, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("UPDATE promotions SET redemption_count = redemption_count \\+ 1").
+		WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args: map[string]interface{}{
+			"storeId":   5,
+			"promoCode": "SAVE10",
+			"items": []interface{}{
+				map[string]interface{}{"description": "Laptop sticker", "quantity": 10, "unitPrice": "2.50"},
+			},
+		},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.placeOrderResolver(params)
+
+	//ASSERT: The order is rejected and nothing is written
+	if err == nil {
+		t.Fatal("Expected an error for a fully redeemed code")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreatePromotionResolver_DuplicateCode(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The owner of store 5 adds a code that already exists
+	mock.ExpectQuery("SELECT user_id, currency FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency"}).AddRow(1, "USD"))
+	mock.ExpectQuery("INSERT INTO promotions").
+		WithArgs(5, "SUMMER", "fixed", nil, int64(500), "USD", int64(0), nil, nil, nil, nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args: map[string]interface{}{
+			"storeId": 5,
+			"code":    "summer",
+			"kind":    "fixed",
+			"value":   "5",
+		},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.createPromotionResolver(params)
+
+	//ASSERT: The conflict is reported
+	if err == nil || err.Error() != "promotion code SUMMER already exists" {
+		t.Errorf("Expected duplicate code error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
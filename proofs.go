@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+//Proof statuses. Only the latest version of an item's proof can be pending.
+const (
+	proofStatusPending          = "pending"
+	proofStatusApproved         = "approved"
+	proofStatusChangesRequested = "changes_requested"
+	proofStatusSuperseded       = "superseded"
+)
+
+//Proof is one version of the artwork proof for an order line
+type Proof struct {
+	ID          int
+	OrderItemID int
+	OrderID     int
+	Version     int
+	FileURL     string
+	Status      string
+	Comment     string
+	CreatedAt   time.Time
+	ReviewedAt  sql.NullTime
+}
+
+//proofToMap converts a proof to the shape returned by GraphQL
+func proofToMap(pr *Proof) map[string]interface{} {
+	proof := map[string]interface{}{
+		"id":            pr.ID,
+		"order_item_id": pr.OrderItemID,
+		"version":       pr.Version,
+		"file_url":      pr.FileURL,
+		"status":        pr.Status,
+		"comment":       pr.Comment,
+		"created_at":    pr.CreatedAt.Format(time.RFC3339),
+		"reviewed_at":   nil,
+	}
+	if pr.ReviewedAt.Valid {
+		proof["reviewed_at"] = pr.ReviewedAt.Time.Format(time.RFC3339)
+	}
+	return proof
+}
+
+//loadProof fetches a proof together with the order it belongs to
+func loadProof(ctx context.Context, q rowQuerier, id int) (*Proof, error) {
+	query := `SELECT p.id, p.order_item_id, i.order_id, p.version, p.file_url, p.status, COALESCE(p.comment, ''), p.created_at, p.reviewed_at
+		FROM order_proofs p JOIN order_items i ON i.id = p.order_item_id
+		WHERE p.id = $1`
+
+	var pr Proof
+	err := q.QueryRowContext(ctx, query, id).Scan(
+		&pr.ID, &pr.OrderItemID, &pr.OrderID, &pr.Version, &pr.FileURL, &pr.Status, &pr.Comment, &pr.CreatedAt, &pr.ReviewedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &pr, nil
+}
+
+//proofFreeCategories are item categories with no artwork to print; their lines
+//need no proof unless placeOrder asks for one
+var proofFreeCategories = map[string]bool{"shipping": true}
+
+//allProofsApproved reports whether the latest proof of every line of an order
+//that needs one is approved. Lines without artwork, such as shipping, are skipped.
+func allProofsApproved(ctx context.Context, q rowQuerier, orderID int) (bool, error) {
+	query := `SELECT COUNT(*) FROM order_items i
+		WHERE i.order_id = $1 AND i.needs_proof AND NOT EXISTS (
+			SELECT 1 FROM order_proofs p
+			WHERE p.order_item_id = i.id AND p.status = 'approved'
+			AND p.version = (SELECT MAX(version) FROM order_proofs WHERE order_item_id = i.id)
+		)`
+
+	var missing int
+	if err := q.QueryRowContext(ctx, query, orderID).Scan(&missing); err != nil {
+		return false, fmt.Errorf("failed to check proofs: %w", err)
+	}
+	return missing == 0, nil
+}
+
+//uploadProofResolver attaches a new proof version to an order line - REQUIRES AUTH + STORE OWNERSHIP
+func (h *Handler) uploadProofResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized proof upload attempt", "error", err.Error())
+		return nil, err
+	}
+
+	itemID, ok := p.Args["orderItemId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid order item id")
+	}
+	fileURL, _ := p.Args["fileUrl"].(string)
+	fileURL = strings.TrimSpace(fileURL)
+	if fileURL == "" {
+		return nil, fmt.Errorf("file url is required")
+	}
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var orderID int
+	err = tx.QueryRowContext(ctx, "SELECT order_id FROM order_items WHERE id = $1", itemID).Scan(&orderID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("order item with id %d not found", itemID)
+	}
+	if err != nil {
+		h.logger.Error("database error loading order item", "order_item_id", itemID, "error", err.Error())
+		return nil, err
+	}
+
+	order, err := lockOrderForOwner(ctx, tx, orderID, userID)
+	if err != nil {
+		h.logger.Warn("proof upload rejected", "order_id", orderID, "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+	if order.Status != orderStatusPlaced && order.Status != orderStatusProofSent {
+		return nil, fmt.Errorf("proofs can only be uploaded before the order is approved")
+	}
+
+	//A new version replaces any proof still waiting for review
+	_, err = tx.ExecContext(ctx, "UPDATE order_proofs SET status = $1 WHERE order_item_id = $2 AND status = $3",
+		proofStatusSuperseded, itemID, proofStatusPending)
+	if err != nil {
+		h.logger.Error("database error superseding proofs", "order_item_id", itemID, "error", err.Error())
+		return nil, err
+	}
+
+	proof := &Proof{OrderItemID: itemID, OrderID: orderID, FileURL: fileURL, Status: proofStatusPending}
+	insertQuery := `INSERT INTO order_proofs (order_item_id, version, file_url, uploaded_by)
+		VALUES ($1, (SELECT COALESCE(MAX(version), 0) + 1 FROM order_proofs WHERE order_item_id = $1), $2, $3)
+		RETURNING id, version, created_at`
+	err = tx.QueryRowContext(ctx, insertQuery, itemID, fileURL, userID).Scan(&proof.ID, &proof.Version, &proof.CreatedAt)
+	if err != nil {
+		h.logger.Error("database error inserting proof", "order_item_id", itemID, "error", err.Error())
+		return nil, err
+	}
+
+	var change *orderStatusChange
+	if order.Status == orderStatusPlaced {
+		note := fmt.Sprintf("proof v%d for item %d", proof.Version, itemID)
+		change, err = transitionOrder(ctx, tx, order, orderStatusProofSent, userID, note)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit proof", "order_item_id", itemID, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("proof uploaded",
+		"proof_id", proof.ID,
+		"order_id", orderID,
+		"order_item_id", itemID,
+		"version", proof.Version,
+		"user_id", userID,
+	)
+	if change != nil {
+		h.publishEvent(ctx, eventOrderStatusChanged, change)
+	}
+
+	return proofToMap(proof), nil
+}
+
+//approveProofResolver approves a proof; the order is approved once every line is - REQUIRES AUTH (buyer)
+func (h *Handler) approveProofResolver(p graphql.ResolveParams) (interface{}, error) {
+	return h.reviewProof(p, proofStatusApproved, "")
+}
+
+//requestProofChangesResolver rejects a proof and sends the order back to the store - REQUIRES AUTH (buyer)
+func (h *Handler) requestProofChangesResolver(p graphql.ResolveParams) (interface{}, error) {
+	comment, _ := p.Args["comment"].(string)
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		return nil, fmt.Errorf("a comment describing the changes is required")
+	}
+	return h.reviewProof(p, proofStatusChangesRequested, comment)
+}
+
+//reviewProof records the buyer's decision on a pending proof and moves the order accordingly
+func (h *Handler) reviewProof(p graphql.ResolveParams, decision string, comment string) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized proof review attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["proofId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid proof id")
+	}
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	proof, err := loadProof(ctx, tx, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("proof with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading proof", "proof_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	order, err := loadOrder(ctx, tx, proof.OrderID, true)
+	if err != nil {
+		h.logger.Error("database error loading order", "order_id", proof.OrderID, "error", err.Error())
+		return nil, err
+	}
+	if order.UserID != userID {
+		h.logger.Warn("unauthorized proof review attempt",
+			"proof_id", id,
+			"requesting_user", userID,
+		)
+		return nil, fmt.Errorf("only the buyer can review proofs")
+	}
+	if order.Status != orderStatusPlaced && order.Status != orderStatusProofSent {
+		return nil, fmt.Errorf("proofs of order %d can no longer be reviewed", order.ID)
+	}
+
+	//Only the pending proof can be reviewed; older versions are superseded
+	result, err := tx.ExecContext(ctx,
+		"UPDATE order_proofs SET status = $1, comment = $2, reviewed_by = $3, reviewed_at = NOW() WHERE id = $4 AND status = $5",
+		decision, comment, userID, id, proofStatusPending)
+	if err != nil {
+		h.logger.Error("database error reviewing proof", "proof_id", id, "error", err.Error())
+		return nil, err
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, fmt.Errorf("proof %d is not awaiting review", id)
+	}
+
+	var change *orderStatusChange
+	switch {
+	case decision == proofStatusChangesRequested && order.Status == orderStatusProofSent:
+		change, err = transitionOrder(ctx, tx, order, orderStatusPlaced, userID, comment)
+	case decision == proofStatusApproved && order.Status == orderStatusProofSent:
+		var approved bool
+		approved, err = allProofsApproved(ctx, tx, order.ID)
+		if err == nil && approved {
+			change, err = transitionOrder(ctx, tx, order, orderStatusApproved, userID, "all proofs approved")
+		}
+	}
+	if err != nil {
+		h.logger.Error("failed to update order after proof review", "order_id", order.ID, "error", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit proof review", "proof_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("proof reviewed",
+		"proof_id", id,
+		"order_id", order.ID,
+		"decision", decision,
+		"user_id", userID,
+	)
+	if change != nil {
+		h.publishEvent(ctx, eventOrderStatusChanged, change)
+	}
+
+	proof.Status = decision
+	proof.Comment = comment
+	proof.ReviewedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	return proofToMap(proof), nil
+}
+
+//orderItemProofsResolver loads every proof version of an order line
+func (h *Handler) orderItemProofsResolver(p graphql.ResolveParams) (interface{}, error) {
+	item, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		`SELECT id, version, file_url, status, COALESCE(comment, ''), created_at, reviewed_at
+		FROM order_proofs WHERE order_item_id = $1 ORDER BY version`, item["id"])
+	if err != nil {
+		h.logger.Error("database error loading proofs", "order_item_id", item["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var proofs []map[string]interface{}
+	for rows.Next() {
+		pr := Proof{OrderItemID: item["id"].(int)}
+		if err := rows.Scan(&pr.ID, &pr.Version, &pr.FileURL, &pr.Status, &pr.Comment, &pr.CreatedAt, &pr.ReviewedAt); err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, proofToMap(&pr))
+	}
+	return proofs, rows.Err()
+}
+
+//proofType is an artwork proof in GraphQL
+var proofType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Proof",
+	Fields: graphql.Fields{
+		"id":            &graphql.Field{Type: graphql.Int},
+		"order_item_id": &graphql.Field{Type: graphql.Int},
+		"version":       &graphql.Field{Type: graphql.Int},
+		"file_url":      &graphql.Field{Type: graphql.String},
+		"status":        &graphql.Field{Type: graphql.String},
+		"comment":       &graphql.Field{Type: graphql.String},
+		"created_at":    &graphql.Field{Type: graphql.String},
+		"reviewed_at":   &graphql.Field{Type: graphql.String},
+	},
+})
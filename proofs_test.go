@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+//proofRow returns the columns loadProof scans
+func proofRow(id, itemID, orderID, version int, status string) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "order_item_id", "order_id", "version", "file_url", "status", "comment", "created_at", "reviewed_at"}).
+		AddRow(id, itemID, orderID, version, "https://example.com/proof.png", status, "", time.Now(), nil)
+}
+
+func TestUploadProofResolver_SendsProof(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store owner (user 1) uploads the first proof of a placed order
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT order_id FROM order_items WHERE id = \\$1").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"order_id"}).AddRow(10))
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 0, "placed"))
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectExec("UPDATE order_proofs SET status").
+		WithArgs("superseded", 7, "pending").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("INSERT INTO order_proofs").
+		WithArgs(7, "https://example.com/proof.png", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "created_at"}).AddRow(3, 1, time.Now()))
+	mock.ExpectExec("UPDATE orders SET status").
+		WithArgs("proof_sent", 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(10, "placed", "proof_sent", 1, "proof v1 for item 7").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"orderItemId": 7, "fileUrl": "https://example.com/proof.png"},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.uploadProofResolver(params)
+
+	//ASSERT: Version 1 is pending review
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	proof := result.(map[string]interface{})
+	if proof["version"] != 1 || proof["status"] != "pending" {
+		t.Errorf("Expected pending version 1, got %v", proof)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestApproveProofResolver_ApprovesOrder(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The buyer (user 2) approves the last pending proof
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM order_proofs p JOIN order_items i").
+		WithArgs(3).
+		WillReturnRows(proofRow(3, 7, 10, 1, "pending"))
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 0, "proof_sent"))
+	mock.ExpectExec("UPDATE order_proofs SET status").
+		WithArgs("approved", "", 2, 3, "pending").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM order_items").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec("UPDATE orders SET status").
+		WithArgs("approved", 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(10, "proof_sent", "approved", 2, "all proofs approved").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args:    map[string]interface{}{"proofId": 3},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.approveProofResolver(params)
+
+	//ASSERT: Proof is approved
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.(map[string]interface{})["status"] != "approved" {
+		t.Errorf("Expected status=approved, got %v", result.(map[string]interface{})["status"])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRequestProofChangesResolver_NotBuyer(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The store owner (user 1) tries to review their own proof
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM order_proofs p JOIN order_items i").
+		WithArgs(3).
+		WillReturnRows(proofRow(3, 7, 10, 1, "pending"))
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 10000, 0, "proof_sent"))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"proofId": 3, "comment": "bigger logo"},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.requestProofChangesResolver(params)
+
+	//ASSERT: Only the buyer can review
+	if err == nil {
+		t.Error("Expected error for review by non-buyer, got nil")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAllProofsApproved_MixedOrder(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: A sticker with an approved proof and a shipping line that never gets one
+	items, err := parseOrderItems([]interface{}{
+		map[string]interface{}{"description": "Laptop sticker", "quantity": 10, "unitPrice": "2.50"},
+		map[string]interface{}{"description": "Shipping", "category": "Shipping", "quantity": 1, "unitPrice": "2.50"},
+		map[string]interface{}{"description": "Gift wrap", "category": "add-on", "quantity": 1, "unitPrice": "1.00", "needsProof": false},
+	}, "USD")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM order_items i WHERE i.order_id = \\$1 AND i.needs_proof AND NOT EXISTS").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	//ACT: Check the order
+	approved, err := allProofsApproved(context.Background(), fakeDB, 10)
+
+	//ASSERT: Only the sticker needs a proof, so the order can go to print
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !items[0].NeedsProof || items[1].NeedsProof || items[2].NeedsProof {
+		t.Errorf("Expected only the sticker to need a proof, got %+v", items)
+	}
+	if !approved {
+		t.Error("Expected the order to count as approved")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//reconcileLockID is the Postgres advisory lock held while a reconciliation runs,
+//so several app instances never reconcile at the same time
+const reconcileLockID = 720027
+
+var (
+	//reconciliationDiscrepancies is the number of stores whose aggregates disagree with the ledger
+	reconciliationDiscrepancies = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "revenue_reconciliation_discrepancies",
+			Help: "Number of stores whose revenue or order count disagreed with the ledger in the last reconciliation",
+		},
+	)
+
+	//reconciliationDrift is the summed absolute revenue difference found in the last run, per currency
+	reconciliationDrift = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "revenue_reconciliation_drift",
+			Help: "Sum of absolute revenue differences (major units) between stores and the ledger in the last reconciliation",
+		},
+		[]string{"currency"},
+	)
+
+	//reconciliationLastRun is the unix time the last reconciliation finished
+	reconciliationLastRun = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "revenue_reconciliation_last_run_timestamp_seconds",
+			Help: "Unix time of the last completed revenue reconciliation",
+		},
+	)
+)
+
+//storeDiscrepancy describes a store whose columns differ from its ledger
+type storeDiscrepancy struct {
+	StoreID         int
+	RecordedRevenue Money
+	LedgerRevenue   Money
+	RecordedOrders  int
+	LedgerOrders    int
+	Corrected       bool
+}
+
+//orderMismatch describes an order whose ledger entries do not add up to what it is still worth
+type orderMismatch struct {
+	OrderID      int
+	StoreID      int
+	ExpectedNet  Money
+	LedgerAmount Money
+}
+
+//reconciliationReport is the outcome of one reconciliation run
+type reconciliationReport struct {
+	StartedAt       time.Time
+	StoresChecked   int
+	Discrepancies   []storeDiscrepancy
+	OrderMismatches []orderMismatch
+	Corrected       int
+}
+
+//reconcileRevenue compares every store's revenue and total_orders with the
+//ledger, logs each discrepancy and, when autoCorrect is set, rewrites the
+//store from the ledger.
+func (h *Handler) reconcileRevenue(ctx context.Context, autoCorrect bool) (*reconciliationReport, error) {
+	conn, err := h.database.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", reconcileLockID).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("failed to acquire reconciliation lock: %w", err)
+	}
+	if !locked {
+		return nil, fmt.Errorf("a reconciliation is already running")
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", reconcileLockID)
+
+	report := &reconciliationReport{StartedAt: time.Now()}
+	h.logger.Info("revenue reconciliation started", "auto_correct", autoCorrect)
+
+	storesQuery := `SELECT s.id, s.currency, s.revenue_cents, s.total_orders, COALESCE(l.revenue, 0), COALESCE(l.orders, 0)
+		FROM stores s
+		LEFT JOIN (
+			SELECT store_id, SUM(amount_cents) AS revenue, SUM(orders_delta) AS orders
+			FROM revenue_adjustments GROUP BY store_id
+		) l ON l.store_id = s.id
+		ORDER BY s.id`
+	rows, err := conn.QueryContext(ctx, storesQuery)
+	if err != nil {
+		h.logger.Error("database error during reconciliation", "error", err.Error())
+		return nil, err
+	}
+
+	drift := map[string]int64{}
+	for rows.Next() {
+		var d storeDiscrepancy
+		var currency string
+		if err := rows.Scan(&d.StoreID, &currency, &d.RecordedRevenue.Amount, &d.RecordedOrders, &d.LedgerRevenue.Amount, &d.LedgerOrders); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.RecordedRevenue.Currency = currency
+		d.LedgerRevenue.Currency = currency
+		report.StoresChecked++
+
+		if d.RecordedRevenue.Amount == d.LedgerRevenue.Amount && d.RecordedOrders == d.LedgerOrders {
+			continue
+		}
+		diff := d.RecordedRevenue.Amount - d.LedgerRevenue.Amount
+		if diff < 0 {
+			diff = -diff
+		}
+		drift[currency] += diff
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	//Every order should net to what it is still worth: nothing once cancelled,
+	//otherwise its total minus refunds
+	ordersQuery := `SELECT o.id, o.store_id, o.currency,
+			CASE WHEN o.status = 'cancelled' THEN 0 ELSE o.total_cents - o.refunded_cents END AS expected,
+			COALESCE(SUM(a.amount_cents), 0) AS ledger
+		FROM orders o
+		LEFT JOIN revenue_adjustments a ON a.order_id = o.id
+		GROUP BY o.id
+		HAVING COALESCE(SUM(a.amount_cents), 0) <> CASE WHEN o.status = 'cancelled' THEN 0 ELSE o.total_cents - o.refunded_cents END
+		ORDER BY o.id`
+	orderRows, err := conn.QueryContext(ctx, ordersQuery)
+	if err != nil {
+		h.logger.Error("database error during order reconciliation", "error", err.Error())
+		return nil, err
+	}
+	for orderRows.Next() {
+		var m orderMismatch
+		var currency string
+		if err := orderRows.Scan(&m.OrderID, &m.StoreID, &currency, &m.ExpectedNet.Amount, &m.LedgerAmount.Amount); err != nil {
+			orderRows.Close()
+			return nil, err
+		}
+		m.ExpectedNet.Currency = currency
+		m.LedgerAmount.Currency = currency
+		report.OrderMismatches = append(report.OrderMismatches, m)
+		h.logger.Warn("order ledger mismatch",
+			"order_id", m.OrderID,
+			"store_id", m.StoreID,
+			"expected_net", m.ExpectedNet.String(),
+			"ledger_amount", m.LedgerAmount.String(),
+		)
+	}
+	orderRows.Close()
+	if err := orderRows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range report.Discrepancies {
+		d := &report.Discrepancies[i]
+		h.logger.Warn("store revenue discrepancy",
+			"store_id", d.StoreID,
+			"recorded_revenue", d.RecordedRevenue.String(),
+			"ledger_revenue", d.LedgerRevenue.String(),
+			"recorded_orders", d.RecordedOrders,
+			"ledger_orders", d.LedgerOrders,
+		)
+		if !autoCorrect {
+			continue
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		//Lock the store like every other ledger writer so an order or refund
+		//committing meanwhile cannot leave stale totals behind
+		var lockedID int
+		err = tx.QueryRowContext(ctx, "SELECT id FROM stores WHERE id = $1 FOR UPDATE", d.StoreID).Scan(&lockedID)
+		if err == sql.ErrNoRows {
+			//Purged since the comparison; nothing left to correct
+			tx.Rollback()
+			continue
+		}
+		if err != nil {
+			tx.Rollback()
+			h.logger.Error("failed to lock store for correction", "store_id", d.StoreID, "error", err.Error())
+			return nil, err
+		}
+		if err := recomputeStoreAggregates(ctx, tx, d.StoreID); err != nil {
+			tx.Rollback()
+			h.logger.Error("failed to correct store", "store_id", d.StoreID, "error", err.Error())
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+
+		d.Corrected = true
+		report.Corrected++
+		h.invalidateStoreCache(d.StoreID)
+		h.refreshStoreLeaderboard(ctx, d.StoreID)
+		h.logger.Info("store aggregates corrected from ledger", "store_id", d.StoreID)
+	}
+
+	reconciliationDiscrepancies.Set(float64(len(report.Discrepancies)))
+	reconciliationDrift.Reset()
+	for currency, cents := range drift {
+		major, _ := strconv.ParseFloat(Money{Amount: cents, Currency: currency}.Decimal(), 64)
+		reconciliationDrift.WithLabelValues(currency).Set(major)
+	}
+	reconciliationLastRun.SetToCurrentTime()
+
+	h.logger.Info("revenue reconciliation finished",
+		"stores_checked", report.StoresChecked,
+		"discrepancies", len(report.Discrepancies),
+		"order_mismatches", len(report.OrderMismatches),
+		"corrected", report.Corrected,
+		"duration_ms", time.Since(report.StartedAt).Milliseconds(),
+	)
+
+	return report, nil
+}
+
+//runNightlyReconciliation reconciles revenue once a day at the given UTC hour until ctx is done
+func (h *Handler) runNightlyReconciliation(ctx context.Context, hourUTC int, autoCorrect bool) {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day(), hourUTC, 0, 0, 0, time.UTC)
+		if !next.After(now) {
+			next = next.Add(24 * time.Hour)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		if _, err := h.reconcileRevenue(ctx, autoCorrect); err != nil {
+			h.logger.Error("nightly reconciliation failed", "error", err.Error())
+		}
+	}
+}
+
+//reconciliationReportType is the GraphQL shape of a reconciliation report
+var reconciliationReportType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ReconciliationReport",
+	Fields: graphql.Fields{
+		"stores_checked": &graphql.Field{Type: graphql.Int},
+		"corrected":      &graphql.Field{Type: graphql.Int},
+		"discrepancies": &graphql.Field{Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+			Name: "StoreDiscrepancy",
+			Fields: graphql.Fields{
+				"store_id":         &graphql.Field{Type: graphql.Int},
+				"recorded_revenue": &graphql.Field{Type: moneyType},
+				"ledger_revenue":   &graphql.Field{Type: moneyType},
+				"recorded_orders":  &graphql.Field{Type: graphql.Int},
+				"ledger_orders":    &graphql.Field{Type: graphql.Int},
+				"corrected":        &graphql.Field{Type: graphql.Boolean},
+			},
+		}))},
+		"order_mismatches": &graphql.Field{Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+			Name: "OrderLedgerMismatch",
+			Fields: graphql.Fields{
+				"order_id":      &graphql.Field{Type: graphql.Int},
+				"store_id":      &graphql.Field{Type: graphql.Int},
+				"expected_net":  &graphql.Field{Type: moneyType},
+				"ledger_amount": &graphql.Field{Type: moneyType},
+			},
+		}))},
+	},
+})
+
+//reconcileRevenueResolver runs a reconciliation on demand - REQUIRES ADMIN
+func (h *Handler) reconcileRevenueResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireAdmin(p)
+	if err != nil {
+		h.logger.Warn("unauthorized reconciliation attempt", "error", err.Error())
+		return nil, err
+	}
+
+	autoCorrect, _ := p.Args["autoCorrect"].(bool)
+	h.logger.Info("reconciliation requested", "user_id", userID, "auto_correct", autoCorrect)
+
+	report, err := h.reconcileRevenue(p.Context, autoCorrect)
+	if err != nil {
+		return nil, err
+	}
+
+	discrepancies := make([]map[string]interface{}, 0, len(report.Discrepancies))
+	for _, d := range report.Discrepancies {
+		discrepancies = append(discrepancies, map[string]interface{}{
+			"store_id":         d.StoreID,
+			"recorded_revenue": d.RecordedRevenue,
+			"ledger_revenue":   d.LedgerRevenue,
+			"recorded_orders":  d.RecordedOrders,
+			"ledger_orders":    d.LedgerOrders,
+			"corrected":        d.Corrected,
+		})
+	}
+	mismatches := make([]map[string]interface{}, 0, len(report.OrderMismatches))
+	for _, m := range report.OrderMismatches {
+		mismatches = append(mismatches, map[string]interface{}{
+			"order_id":      m.OrderID,
+			"store_id":      m.StoreID,
+			"expected_net":  m.ExpectedNet,
+			"ledger_amount": m.LedgerAmount,
+		})
+	}
+
+	return map[string]interface{}{
+		"stores_checked":   report.StoresChecked,
+		"corrected":        report.Corrected,
+		"discrepancies":    discrepancies,
+		"order_mismatches": mismatches,
+	}, nil
+}
+
+//runReconcileCommand implements `server reconcile [-fix]`
+func runReconcileCommand(h *Handler, args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "rewrite drifted stores from the ledger")
+	flags.Parse(args)
+
+	report, err := h.reconcileRevenue(context.Background(), *fix)
+	if err != nil {
+		log.Fatal("Reconciliation failed:", err)
+	}
+
+	fmt.Printf("Checked %d stores: %d discrepancies, %d order mismatches, %d corrected\n",
+		report.StoresChecked, len(report.Discrepancies), len(report.OrderMismatches), report.Corrected)
+	for _, d := range report.Discrepancies {
+		fmt.Printf("  store %d: revenue %s (ledger %s), orders %d (ledger %d), corrected=%t\n",
+			d.StoreID, d.RecordedRevenue, d.LedgerRevenue, d.RecordedOrders, d.LedgerOrders, d.Corrected)
+	}
+	for _, m := range report.OrderMismatches {
+		fmt.Printf("  order %d (store %d): expected net %s, ledger %s\n",
+			m.OrderID, m.StoreID, m.ExpectedNet, m.LedgerAmount)
+	}
+}
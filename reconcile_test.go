@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReconcileRevenue_ReportsDiscrepancies(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 1 matches its ledger, store 2 was overwritten by its owner
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectQuery("SELECT s.id, s.currency, s.revenue_cents, s.total_orders").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency", "revenue_cents", "total_orders", "ledger_revenue", "ledger_orders"}).
+			AddRow(1, "USD", 10000, 2, 10000, 2).
+			AddRow(2, "USD", 99900, 50, 12050, 3))
+	mock.ExpectQuery("SELECT o.id, o.store_id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "currency", "expected", "ledger"}))
+	mock.ExpectExec("SELECT pg_advisory_unlock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Reconcile without correcting
+	report, err := handler.reconcileRevenue(context.Background(), false)
+
+	//ASSERT: Only store 2 is reported and nothing is rewritten
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if report.StoresChecked != 2 {
+		t.Errorf("Expected 2 stores checked, got %d", report.StoresChecked)
+	}
+	if len(report.Discrepancies) != 1 || report.Discrepancies[0].StoreID != 2 {
+		t.Fatalf("Expected a single discrepancy for store 2, got %+v", report.Discrepancies)
+	}
+	if report.Corrected != 0 {
+		t.Errorf("Expected no corrections, got %d", report.Corrected)
+	}
+	if got := testutil.ToFloat64(reconciliationDiscrepancies); got != 1 {
+		t.Errorf("Expected discrepancy gauge = 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(reconciliationDrift.WithLabelValues("USD")); got != 878.5 {
+		t.Errorf("Expected drift gauge = 878.5, got %v", got)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestReconcileRevenue_AutoCorrect(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 2 drifted and gets recomputed from the ledger
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(true))
+	mock.ExpectQuery("SELECT s.id, s.currency, s.revenue_cents, s.total_orders").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency", "revenue_cents", "total_orders", "ledger_revenue", "ledger_orders"}).
+			AddRow(2, "USD", 99900, 50, 12050, 3))
+	mock.ExpectQuery("SELECT o.id, o.store_id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "currency", "expected", "ledger"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec("UPDATE stores SET").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Reconcile with auto-correct
+	report, err := handler.reconcileRevenue(context.Background(), true)
+
+	//ASSERT: The store is marked as corrected
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if report.Corrected != 1 || !report.Discrepancies[0].Corrected {
+		t.Errorf("Expected store 2 to be corrected, got %+v", report.Discrepancies)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestReconcileRevenue_AlreadyRunning(t *testing.T) {
+	//ARRANGE: Create mock database where another instance holds the lock
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WillReturnRows(sqlmock.NewRows([]string{"locked"}).AddRow(false))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Try to reconcile
+	_, err = handler.reconcileRevenue(context.Background(), true)
+
+	//ASSERT: The run is refused
+	if err == nil {
+		t.Error("Expected error while another reconciliation holds the lock, got nil")
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	//forecastHistoryDays is how much daily revenue the model is fitted on
+	forecastHistoryDays = 182
+	//forecastSeason is the length of the weekly cycle in days
+	forecastSeason       = 7
+	defaultForecastDays  = 14
+	maxForecastDays      = 90
+	minForecastHistory   = 2
+	forecastConfidence   = 0.95
+	forecastConfidenceZ  = 1.959964
+	modelHoltWinters     = "holt-winters"
+	modelHoltLinearTrend = "holt"
+)
+
+//holtWintersModel is an additive Holt-Winters model fitted to a series. With
+//Season 0 it is Holt's linear trend model without a seasonal component.
+type holtWintersModel struct {
+	Alpha, Beta, Gamma float64
+	Season             int
+	//Sigma is the standard deviation of the one step ahead errors
+	Sigma    float64
+	n        int
+	level    float64
+	trend    float64
+	seasonal []float64
+}
+
+//forecastPoint is a prediction and its confidence band
+type forecastPoint struct {
+	Value, Lower, Upper float64
+}
+
+//fitHoltWinters fits a model to series, picking the smoothing parameters
+//with the smallest one step ahead squared error. A series shorter than two
+//seasons is fitted without seasonality.
+func fitHoltWinters(series []float64, season int) (*holtWintersModel, error) {
+	if len(series) < minForecastHistory {
+		return nil, fmt.Errorf("need at least %d values to fit a model, got %d", minForecastHistory, len(series))
+	}
+	if season < 2 || len(series) < 2*season {
+		season = 0
+	}
+
+	grid := []float64{0.05, 0.15, 0.25, 0.35, 0.45, 0.55, 0.65, 0.75, 0.85, 0.95}
+	gammas := grid
+	if season == 0 {
+		gammas = []float64{0}
+	}
+
+	var best *holtWintersModel
+	bestSSE := math.Inf(1)
+	for _, alpha := range grid {
+		for _, beta := range grid {
+			for _, gamma := range gammas {
+				model, sse := runHoltWinters(series, season, alpha, beta, gamma)
+				if sse < bestSSE {
+					best, bestSSE = model, sse
+				}
+			}
+		}
+	}
+	return best, nil
+}
+
+//runHoltWinters smooths series with fixed parameters and returns the final
+//state and the sum of squared one step ahead errors
+func runHoltWinters(series []float64, season int, alpha, beta, gamma float64) (*holtWintersModel, float64) {
+	m := &holtWintersModel{Alpha: alpha, Beta: beta, Gamma: gamma, Season: season, n: len(series)}
+
+	start := 1
+	if season > 0 {
+		//Initialise from the first two seasons: their means give level and trend,
+		//the first season's deviations from its mean give the seasonal indices
+		first, second := mean(series[:season]), mean(series[season:2*season])
+		m.level = first
+		m.trend = (second - first) / float64(season)
+		m.seasonal = make([]float64, season)
+		for i := 0; i < season; i++ {
+			m.seasonal[i] = series[i] - first
+		}
+		//The level is of the middle of the first season; move it to its end
+		m.level += m.trend * float64(season-1) / 2
+		start = season
+	} else {
+		m.level = series[0]
+		m.trend = series[1] - series[0]
+	}
+
+	var sse float64
+	for t := start; t < len(series); t++ {
+		s := m.seasonalAt(t)
+		err := series[t] - (m.level + m.trend + s)
+		sse += err * err
+
+		level := alpha*(series[t]-s) + (1-alpha)*(m.level+m.trend)
+		m.trend = beta*(level-m.level) + (1-beta)*m.trend
+		m.level = level
+		if m.Season > 0 {
+			m.seasonal[t%m.Season] = gamma*(series[t]-level) + (1-gamma)*s
+		}
+	}
+	if steps := len(series) - start; steps > 0 {
+		m.Sigma = math.Sqrt(sse / float64(steps))
+	}
+	return m, sse
+}
+
+//seasonalAt returns the seasonal index of time t, 0 without seasonality
+func (m *holtWintersModel) seasonalAt(t int) float64 {
+	if m.Season == 0 {
+		return 0
+	}
+	return m.seasonal[t%m.Season]
+}
+
+//forecast predicts the next horizon values with bands of z standard
+//deviations. The bands widen with the horizon as errors accumulate.
+func (m *holtWintersModel) forecast(horizon int, z float64) []forecastPoint {
+	points := make([]forecastPoint, 0, horizon)
+	variance := 1.0
+	for h := 1; h <= horizon; h++ {
+		if h > 1 {
+			j := h - 1
+			c := m.Alpha * (1 + float64(j)*m.Beta)
+			if m.Season > 0 && j%m.Season == 0 {
+				c += m.Gamma * (1 - m.Alpha)
+			}
+			variance += c * c
+		}
+		value := m.level + float64(h)*m.trend + m.seasonalAt(m.n+h-1)
+		band := z * m.Sigma * math.Sqrt(variance)
+		points = append(points, forecastPoint{Value: value, Lower: value - band, Upper: value + band})
+	}
+	return points
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+//revenueForecastResolver predicts a store's daily revenue from its hourly
+//snapshots - REQUIRES AUTH + OWNERSHIP OR ADMIN
+func (h *Handler) revenueForecastResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized revenue forecast attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid storeId")
+	}
+	horizon := defaultForecastDays
+	if days, ok := p.Args["horizonDays"].(int); ok {
+		horizon = days
+	}
+	if horizon < 1 || horizon > maxForecastDays {
+		return nil, fmt.Errorf("horizonDays must be between 1 and %d", maxForecastDays)
+	}
+	timezone, _ := p.Args["timezone"].(string)
+
+	return h.revenueForecast(p.Context, userID, storeID, horizon, timezone, time.Now())
+}
+
+//revenueForecast fits a model to the daily revenue of the whole days before
+//now and predicts the following horizon days, starting today
+func (h *Handler) revenueForecast(ctx context.Context, userID, storeID, horizon int, timezone string, now time.Time) (map[string]interface{}, error) {
+	//The series request validates the timezone, so load it through there
+	probe, err := newRevenueSeriesRequest(storeID, now, now.Add(time.Nanosecond), granularityDay, timezone)
+	if err != nil {
+		return nil, err
+	}
+	today := probe.From
+	req, err := newRevenueSeriesRequest(storeID, today.AddDate(0, 0, -forecastHistoryDays), today, granularityDay, timezone)
+	if err != nil {
+		return nil, err
+	}
+	history, err := h.revenueSeries(ctx, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	//Days before the first sale are before the store was trading, not quiet days
+	for len(history) > 0 && history[0].Revenue.Amount == 0 && history[0].Orders == 0 {
+		history = history[1:]
+	}
+	if len(history) < minForecastHistory {
+		return nil, fmt.Errorf("store %d needs at least %d days of sales history for a forecast", storeID, minForecastHistory)
+	}
+	currency := history[0].Revenue.Currency
+	series := make([]float64, len(history))
+	for i, day := range history {
+		series[i] = float64(day.Revenue.Amount)
+	}
+
+	model, err := fitHoltWinters(series, forecastSeason)
+	if err != nil {
+		return nil, err
+	}
+	name := modelHoltWinters
+	if model.Season == 0 {
+		name = modelHoltLinearTrend
+	}
+
+	//Revenue does not go below zero, so neither do predictions or bands
+	cents := func(v float64) Money {
+		return Money{Amount: int64(math.Round(math.Max(v, 0))), Currency: currency}
+	}
+	points := []map[string]interface{}{}
+	for i, point := range model.forecast(horizon, forecastConfidenceZ) {
+		start := today.AddDate(0, 0, i)
+		points = append(points, map[string]interface{}{
+			"start":   start,
+			"end":     start.AddDate(0, 0, 1),
+			"revenue": cents(point.Value),
+			"lower":   cents(point.Lower),
+			"upper":   cents(point.Upper),
+		})
+	}
+
+	h.logger.Info("revenue forecast computed",
+		"store_id", storeID,
+		"model", name,
+		"history_days", len(history),
+		"alpha", model.Alpha,
+		"beta", model.Beta,
+		"gamma", model.Gamma,
+	)
+
+	return map[string]interface{}{
+		"store_id":     storeID,
+		"model":        name,
+		"history_days": len(history),
+		"confidence":   forecastConfidence,
+		"points":       points,
+	}, nil
+}
+
+//revenueForecastType is a store's predicted daily revenue in GraphQL
+var revenueForecastType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "RevenueForecast",
+	Fields: graphql.Fields{
+		"store_id":     &graphql.Field{Type: graphql.Int},
+		"model":        &graphql.Field{Type: graphql.String, Description: "holt-winters with a weekly season, or holt with a trend only for stores younger than two weeks"},
+		"history_days": &graphql.Field{Type: graphql.Int, Description: "Days of sales the model was fitted on"},
+		"confidence":   &graphql.Field{Type: graphql.Float, Description: "Probability the actual revenue falls between lower and upper"},
+		"points": &graphql.Field{Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+			Name: "RevenueForecastPoint",
+			Fields: graphql.Fields{
+				"start":   &graphql.Field{Type: dateTimeScalar},
+				"end":     &graphql.Field{Type: dateTimeScalar, Description: "Exclusive"},
+				"revenue": &graphql.Field{Type: moneyType},
+				"lower":   &graphql.Field{Type: moneyType},
+				"upper":   &graphql.Field{Type: moneyType},
+			},
+		}))},
+	},
+})
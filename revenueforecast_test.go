@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+//weeklyPattern is a store that sells most on weekends
+var weeklyPattern = []float64{-300, -200, -100, 0, 100, 600, -100}
+
+//syntheticSeries builds base + slope*t + the weekly pattern + noise
+func syntheticSeries(days int, base, slope, noise float64, seed int64) []float64 {
+	random := rand.New(rand.NewSource(seed))
+	series := make([]float64, days)
+	for t := range series {
+		series[t] = base + slope*float64(t) + weeklyPattern[t%7] + noise*random.NormFloat64()
+	}
+	return series
+}
+
+func TestFitHoltWinters_LinearTrend(t *testing.T) {
+	//ARRANGE: A straight line without noise or seasonality
+	series := make([]float64, 10)
+	for i := range series {
+		series[i] = 1000 + 50*float64(i)
+	}
+
+	//ACT: Fit it; ten days is too short for a weekly season
+	model, err := fitHoltWinters(series, 7)
+
+	//ASSERT: Holt's trend model continues the line exactly
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if model.Season != 0 {
+		t.Errorf("Expected no season for a short series, got %d", model.Season)
+	}
+	for h, point := range model.forecast(5, forecastConfidenceZ) {
+		want := 1000 + 50*float64(10+h)
+		if math.Abs(point.Value-want) > 1e-6 {
+			t.Errorf("Day %d: expected %.2f, got %.2f", h+1, want, point.Value)
+		}
+	}
+}
+
+func TestFitHoltWinters_Seasonal(t *testing.T) {
+	//ARRANGE: Twelve weeks of a growing store with weekend peaks
+	series := syntheticSeries(84, 5000, 10, 20, 1)
+
+	//ACT: Fit and forecast two weeks
+	model, err := fitHoltWinters(series, 7)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	points := model.forecast(14, forecastConfidenceZ)
+
+	//ASSERT: The forecast follows trend and weekday, within the noise
+	if model.Season != 7 {
+		t.Fatalf("Expected a weekly season, got %d", model.Season)
+	}
+	for h, point := range points {
+		day := 84 + h
+		want := 5000 + 10*float64(day) + weeklyPattern[day%7]
+		if math.Abs(point.Value-want) > 100 {
+			t.Errorf("Day %d: expected about %.0f, got %.0f", h+1, want, point.Value)
+		}
+	}
+	//ASSERT: Saturdays are forecast above the following Sunday
+	if points[5].Value <= points[6].Value {
+		t.Errorf("Expected the weekend peak, got %.0f then %.0f", points[5].Value, points[6].Value)
+	}
+}
+
+func TestFitHoltWinters_BandsCoverAndWiden(t *testing.T) {
+	//ARRANGE: A noisy series and its next four weeks drawn from the same process
+	full := syntheticSeries(140, 5000, 5, 200, 42)
+	history, future := full[:112], full[112:]
+
+	//ACT: Forecast the held out weeks
+	model, err := fitHoltWinters(history, 7)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	points := model.forecast(len(future), forecastConfidenceZ)
+
+	//ASSERT: Most actual values fall inside the 95% band
+	inside := 0
+	for i, point := range points {
+		if point.Lower > point.Value || point.Upper < point.Value {
+			t.Fatalf("Day %d: band %.0f-%.0f does not contain %.0f", i+1, point.Lower, point.Upper, point.Value)
+		}
+		if future[i] >= point.Lower && future[i] <= point.Upper {
+			inside++
+		}
+	}
+	if inside < len(future)*8/10 {
+		t.Errorf("Expected most of %d days inside the band, got %d", len(future), inside)
+	}
+	//ASSERT: Uncertainty grows with the horizon
+	first := points[0].Upper - points[0].Lower
+	last := points[len(points)-1].Upper - points[len(points)-1].Lower
+	if last <= first {
+		t.Errorf("Expected the band to widen, got %.0f then %.0f", first, last)
+	}
+}
+
+func TestFitHoltWinters_TooShort(t *testing.T) {
+	_, err := fitHoltWinters([]float64{100}, 7)
+
+	if err == nil || err.Error() != "need at least 2 values to fit a model, got 1" {
+		t.Errorf("Expected too short error, got %v", err)
+	}
+}
+
+func TestRevenueForecast(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 5 has sold for three weeks up to yesterday
+	now := time.Date(2026, 3, 22, 10, 30, 0, 0, time.UTC)
+	today := time.Date(2026, 3, 22, 0, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT user_id, currency FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency"}).AddRow(1, "USD"))
+	rows := sqlmock.NewRows([]string{"date_trunc", "sum", "sum"})
+	for i, revenue := range syntheticSeries(21, 5000, 10, 0, 1) {
+		rows.AddRow(today.AddDate(0, 0, i-21), int64(revenue), 3)
+	}
+	mock.ExpectQuery("FROM store_revenue_snapshots").
+		WithArgs(5, "day", "UTC", today.AddDate(0, 0, -forecastHistoryDays), today).
+		WillReturnRows(rows)
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Forecast a week
+	result, err := handler.revenueForecast(context.Background(), 1, 5, 7, "UTC", now)
+
+	//ASSERT: Fitted on the trading days only and forecast from today
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result["model"] != modelHoltWinters || result["history_days"] != 21 {
+		t.Errorf("Unexpected model: %v/%v", result["model"], result["history_days"])
+	}
+	points := result["points"].([]map[string]interface{})
+	if len(points) != 7 {
+		t.Fatalf("Expected 7 points, got %d", len(points))
+	}
+	if start := points[0]["start"].(time.Time); !start.Equal(today) {
+		t.Errorf("Expected the forecast to start today, got %s", start)
+	}
+	for i, point := range points {
+		revenue, lower, upper := point["revenue"].(Money), point["lower"].(Money), point["upper"].(Money)
+		if revenue.Currency != "USD" || lower.Amount > revenue.Amount || upper.Amount < revenue.Amount {
+			t.Errorf("Day %d: unexpected band %v <= %v <= %v", i+1, lower, revenue, upper)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRevenueForecast_NotEnoughHistory(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The first sale was yesterday
+	now := time.Date(2026, 3, 22, 10, 30, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT user_id, currency FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency"}).AddRow(1, "USD"))
+	mock.ExpectQuery("FROM store_revenue_snapshots").
+		WillReturnRows(sqlmock.NewRows([]string{"date_trunc", "sum", "sum"}).
+			AddRow(time.Date(2026, 3, 21, 0, 0, 0, 0, time.UTC), int64(1999), 1))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	_, err = handler.revenueForecast(context.Background(), 1, 5, 7, "UTC", now)
+
+	if err == nil || err.Error() != "store 5 needs at least 2 days of sales history for a forecast" {
+		t.Errorf("Expected history error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
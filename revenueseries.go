@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" //The runtime image has no zoneinfo
+
+	"github.com/graphql-go/graphql"
+)
+
+//Bucket sizes of a revenue series
+const (
+	granularityDay   = "day"
+	granularityWeek  = "week"
+	granularityMonth = "month"
+)
+
+//maxRevenueBuckets bounds a series so a typo in a date cannot ask for decades of days
+const maxRevenueBuckets = 1000
+
+//revenueGranularityEnum is the bucket size of a revenue series in GraphQL
+var revenueGranularityEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "RevenueGranularity",
+	Values: graphql.EnumValueConfigMap{
+		"DAY":   &graphql.EnumValueConfig{Value: granularityDay},
+		"WEEK":  &graphql.EnumValueConfig{Value: granularityWeek, Description: "Weeks start on Monday"},
+		"MONTH": &graphql.EnumValueConfig{Value: granularityMonth},
+	},
+})
+
+//revenueSeriesRequest is a validated revenue series query. From and To are
+//aligned to bucket boundaries in Location.
+type revenueSeriesRequest struct {
+	StoreID     int
+	From        time.Time
+	To          time.Time
+	Granularity string
+	Location    *time.Location
+}
+
+//revenueBucket is the revenue and orders of a store in [Start, End)
+type revenueBucket struct {
+	Start   time.Time
+	End     time.Time
+	Revenue Money
+	Orders  int
+}
+
+//bucketStart returns the start of the bucket containing t, in t's location
+func bucketStart(t time.Time, granularity string) time.Time {
+	y, m, d := t.Date()
+	switch granularity {
+	case granularityMonth:
+		return time.Date(y, m, 1, 0, 0, 0, 0, t.Location())
+	case granularityWeek:
+		return time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	default:
+		return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	}
+}
+
+//nextBucket returns the start of the bucket after the one starting at start
+func nextBucket(start time.Time, granularity string) time.Time {
+	switch granularity {
+	case granularityMonth:
+		return start.AddDate(0, 1, 0)
+	case granularityWeek:
+		return start.AddDate(0, 0, 7)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+//newRevenueSeriesRequest validates a series query. An empty granularity means
+//days and an empty timezone means UTC; to is exclusive.
+func newRevenueSeriesRequest(storeID int, from, to time.Time, granularity, timezone string) (revenueSeriesRequest, error) {
+	granularity = strings.ToLower(granularity)
+	switch granularity {
+	case "":
+		granularity = granularityDay
+	case granularityDay, granularityWeek, granularityMonth:
+	default:
+		return revenueSeriesRequest{}, &requestError{Status: http.StatusBadRequest, Message: "granularity must be day, week or month"}
+	}
+
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil || timezone == "Local" {
+		return revenueSeriesRequest{}, &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown timezone %q", timezone)}
+	}
+
+	if !to.After(from) {
+		return revenueSeriesRequest{}, &requestError{Status: http.StatusBadRequest, Message: "to must be after from"}
+	}
+
+	//Widen the range to whole buckets so the first and last are complete
+	first := bucketStart(from.In(location), granularity)
+	last := bucketStart(to.In(location).Add(-time.Nanosecond), granularity)
+	offsetErr := &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf(
+		"timezone %q is not a whole number of hours from UTC; revenue is recorded per UTC hour", timezone)}
+	buckets := 1
+	for start := first; ; start = nextBucket(start, granularity) {
+		//Snapshots cover whole UTC hours, so a bucket edge in the middle of one
+		//would put half an hour of sales in the wrong bucket
+		if _, offset := start.Zone(); offset%3600 != 0 {
+			return revenueSeriesRequest{}, offsetErr
+		}
+		if !start.Before(last) {
+			break
+		}
+		buckets++
+		if buckets > maxRevenueBuckets {
+			return revenueSeriesRequest{}, &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("a series can have at most %d buckets", maxRevenueBuckets)}
+		}
+	}
+	if _, offset := nextBucket(last, granularity).Zone(); offset%3600 != 0 {
+		return revenueSeriesRequest{}, offsetErr
+	}
+
+	return revenueSeriesRequest{
+		StoreID:     storeID,
+		From:        first,
+		To:          nextBucket(last, granularity),
+		Granularity: granularity,
+		Location:    location,
+	}, nil
+}
+
+//revenueSeries returns a store's revenue and orders per bucket, with a zero
+//bucket for every period without sales - REQUIRES OWNERSHIP OR ADMIN
+func (h *Handler) revenueSeries(ctx context.Context, userID int, req revenueSeriesRequest) ([]revenueBucket, error) {
+	var ownerID int
+	var currency string
+	err := h.database.QueryRowContext(ctx, "SELECT user_id, currency FROM stores WHERE id = $1 AND deleted_at IS NULL", req.StoreID).
+		Scan(&ownerID, &currency)
+	if err == sql.ErrNoRows {
+		return nil, &requestError{Status: http.StatusNotFound, Message: fmt.Sprintf("store with id %d not found", req.StoreID)}
+	}
+	if err != nil {
+		h.logger.Error("database error loading store for revenue series", "store_id", req.StoreID, "error", err.Error())
+		return nil, err
+	}
+	if ownerID != userID {
+		admin, err := isAdmin(ctx, h.database, userID)
+		if err != nil {
+			h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+			return nil, err
+		}
+		if !admin {
+			return nil, &requestError{Status: http.StatusForbidden, Message: "you can only view the revenue of your own stores"}
+		}
+	}
+
+	//Hourly snapshots are grouped into local buckets; the bucket comes back as local wall time
+	rows, err := h.database.QueryContext(ctx,
+		`SELECT date_trunc($2, hour AT TIME ZONE $3), SUM(revenue_cents), SUM(orders)
+		FROM store_revenue_snapshots
+		WHERE store_id = $1 AND hour >= $4 AND hour < $5
+		GROUP BY 1`,
+		req.StoreID, req.Granularity, req.Location.String(), req.From, req.To)
+	if err != nil {
+		h.logger.Error("database error loading revenue series", "store_id", req.StoreID, "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	type totals struct {
+		revenue int64
+		orders  int
+	}
+	byBucket := map[string]totals{}
+	for rows.Next() {
+		var bucket time.Time
+		var t totals
+		if err := rows.Scan(&bucket, &t.revenue, &t.orders); err != nil {
+			return nil, err
+		}
+		byBucket[bucket.Format("2006-01-02")] = t
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var series []revenueBucket
+	for start := req.From; start.Before(req.To); start = nextBucket(start, req.Granularity) {
+		t := byBucket[start.Format("2006-01-02")]
+		series = append(series, revenueBucket{
+			Start:   start,
+			End:     nextBucket(start, req.Granularity),
+			Revenue: Money{Amount: t.revenue, Currency: currency},
+			Orders:  t.orders,
+		})
+	}
+	return series, nil
+}
+
+//revenueSeriesResolver serves the revenueSeries query - REQUIRES AUTH + OWNERSHIP OR ADMIN
+func (h *Handler) revenueSeriesResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized revenue series attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid storeId")
+	}
+	from, fromOk := p.Args["from"].(time.Time)
+	to, toOk := p.Args["to"].(time.Time)
+	if !fromOk || !toOk {
+		return nil, fmt.Errorf("from and to must be RFC 3339 timestamps")
+	}
+	granularity, _ := p.Args["granularity"].(string)
+	timezone, _ := p.Args["timezone"].(string)
+
+	req, err := newRevenueSeriesRequest(storeID, from, to, granularity, timezone)
+	if err != nil {
+		return nil, err
+	}
+	series, err := h.revenueSeries(p.Context, userID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make([]map[string]interface{}, 0, len(series))
+	for _, b := range series {
+		buckets = append(buckets, map[string]interface{}{
+			"start":   b.Start,
+			"end":     b.End,
+			"revenue": b.Revenue,
+			"orders":  b.Orders,
+		})
+	}
+	return buckets, nil
+}
+
+//revenueSeriesCSV serves GET /stores/{id}/revenue as CSV for spreadsheets - REQUIRES AUTH + OWNERSHIP OR ADMIN.
+//Takes the same from, to, granularity and timezone as the revenueSeries query.
+func (h *Handler) revenueSeriesCSV(w http.ResponseWriter, r *http.Request, storeID int) {
+	series, err := h.revenueSeriesRequest(r, storeID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			w.WriteHeader(reqErr.Status)
+			json.NewEncoder(w).Encode(map[string]string{"error": reqErr.Message})
+			return
+		}
+		h.logger.Error("revenue series request failed", "store_id", storeID, "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Database error"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="store-%d-revenue.csv"`, storeID))
+	out := csv.NewWriter(w)
+	out.Write([]string{"start", "end", "revenue", "currency", "orders"})
+	for _, b := range series {
+		out.Write([]string{
+			b.Start.Format(time.RFC3339),
+			b.End.Format(time.RFC3339),
+			b.Revenue.Decimal(),
+			b.Revenue.Currency,
+			strconv.Itoa(b.Orders),
+		})
+	}
+	out.Flush()
+}
+
+//revenueSeriesRequest authenticates a CSV request and loads the series it asks for
+func (h *Handler) revenueSeriesRequest(r *http.Request, storeID int) ([]revenueBucket, error) {
+	if r.Method != http.MethodGet {
+		return nil, &requestError{Status: http.StatusMethodNotAllowed, Message: "method not allowed"}
+	}
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		h.logger.Warn("unauthorized revenue series attempt", "store_id", storeID, "error", err.Error())
+		return nil, &requestError{Status: http.StatusUnauthorized, Message: "authentication required"}
+	}
+
+	query := r.URL.Query()
+	from, err := time.Parse(time.RFC3339, query.Get("from"))
+	if err != nil {
+		return nil, &requestError{Status: http.StatusBadRequest, Message: "from must be an RFC 3339 timestamp, e.g. 2026-06-01T00:00:00Z"}
+	}
+	to, err := time.Parse(time.RFC3339, query.Get("to"))
+	if err != nil {
+		return nil, &requestError{Status: http.StatusBadRequest, Message: "to must be an RFC 3339 timestamp, e.g. 2026-07-01T00:00:00Z"}
+	}
+
+	req, err := newRevenueSeriesRequest(storeID, from, to, query.Get("granularity"), query.Get("timezone"))
+	if err != nil {
+		return nil, err
+	}
+	return h.revenueSeries(r.Context(), userID, req)
+}
+
+//revenueBucketType is one period of a revenue series in GraphQL
+var revenueBucketType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "RevenueBucket",
+	Fields: graphql.Fields{
+		"start":   &graphql.Field{Type: dateTimeScalar},
+		"end":     &graphql.Field{Type: dateTimeScalar, Description: "Exclusive"},
+		"revenue": &graphql.Field{Type: moneyType},
+		"orders":  &graphql.Field{Type: graphql.Int},
+	},
+})
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+func TestNewRevenueSeriesRequest_WholeBuckets(t *testing.T) {
+	from := time.Date(2026, 3, 4, 15, 0, 0, 0, time.UTC) //a Wednesday
+	to := time.Date(2026, 3, 18, 9, 0, 0, 0, time.UTC)
+
+	//ACT: Ask for weeks in New York
+	req, err := newRevenueSeriesRequest(1, from, to, "week", "America/New_York")
+
+	//ASSERT: Widened to Monday midnight local time on both ends
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if got := req.From.Format(time.RFC3339); got != "2026-03-02T00:00:00-05:00" {
+		t.Errorf("Expected from 2026-03-02T00:00:00-05:00, got %s", got)
+	}
+	//Daylight saving time starts on March 8th
+	if got := req.To.Format(time.RFC3339); got != "2026-03-23T00:00:00-04:00" {
+		t.Errorf("Expected to 2026-03-23T00:00:00-04:00, got %s", got)
+	}
+}
+
+func TestNewRevenueSeriesRequest_Invalid(t *testing.T) {
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		to                    time.Time
+		granularity, timezone string
+		want                  string
+	}{
+		{from, "day", "UTC", "to must be after from"},
+		{from.AddDate(0, 0, 1), "hour", "UTC", "granularity must be day, week or month"},
+		{from.AddDate(0, 0, 1), "day", "Mars/Olympus_Mons", `unknown timezone "Mars/Olympus_Mons"`},
+		{from.AddDate(0, 0, 1), "day", "Local", `unknown timezone "Local"`},
+		{from.AddDate(5, 0, 0), "day", "UTC", "a series can have at most 1000 buckets"},
+		{from.AddDate(0, 0, 1), "day", "Asia/Kolkata", `timezone "Asia/Kolkata" is not a whole number of hours from UTC; revenue is recorded per UTC hour`},
+		{from.AddDate(0, 1, 0), "month", "Australia/Adelaide", `timezone "Australia/Adelaide" is not a whole number of hours from UTC; revenue is recorded per UTC hour`},
+	}
+	for _, c := range cases {
+		_, err := newRevenueSeriesRequest(1, from, c.to, c.granularity, c.timezone)
+		if err == nil || err.Error() != c.want {
+			t.Errorf("%s/%s: expected %q, got %v", c.granularity, c.timezone, c.want, err)
+		}
+	}
+}
+
+func TestRevenueSeriesResolver_FillsGaps(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Sales on the first and third day only
+	location, _ := time.LoadLocation("Europe/Berlin")
+	from := time.Date(2026, 3, 1, 0, 0, 0, 0, location)
+	to := time.Date(2026, 3, 4, 0, 0, 0, 0, location)
+	mock.ExpectQuery("SELECT user_id, currency FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency"}).AddRow(1, "EUR"))
+	mock.ExpectQuery("SELECT date_trunc\\(\\$2, hour AT TIME ZONE \\$3\\), SUM\\(revenue_cents\\), SUM\\(orders\\) FROM store_revenue_snapshots").
+		WithArgs(5, "day", "Europe/Berlin", from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"date_trunc", "sum", "sum"}).
+			AddRow(time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC), int64(500), 1).
+			AddRow(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), int64(1999), 2))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args: map[string]interface{}{
+			"storeId":     5,
+			"from":        from.UTC(),
+			"to":          to.UTC(),
+			"granularity": "day",
+			"timezone":    "Europe/Berlin",
+		},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.revenueSeriesResolver(params)
+
+	//ASSERT: Three days in order, the quiet one as zero
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	buckets := result.([]map[string]interface{})
+	if len(buckets) != 3 {
+		t.Fatalf("Expected 3 buckets, got %d", len(buckets))
+	}
+	wantRevenue := []Money{{Amount: 1999, Currency: "EUR"}, {Amount: 0, Currency: "EUR"}, {Amount: 500, Currency: "EUR"}}
+	wantOrders := []int{2, 0, 1}
+	for i, b := range buckets {
+		if b["revenue"] != wantRevenue[i] || b["orders"] != wantOrders[i] {
+			t.Errorf("Bucket %d: expected %v/%d, got %v/%v", i, wantRevenue[i], wantOrders[i], b["revenue"], b["orders"])
+		}
+	}
+	if start := buckets[1]["start"].(time.Time).Format(time.RFC3339); start != "2026-03-02T00:00:00+01:00" {
+		t.Errorf("Expected second bucket to start at local midnight, got %s", start)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_RevenueCSV(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT user_id, currency FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "currency"}).AddRow(1, "USD"))
+	mock.ExpectQuery("FROM store_revenue_snapshots").
+		WillReturnRows(sqlmock.NewRows([]string{"date_trunc", "sum", "sum"}).
+			AddRow(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC), int64(123456), 40))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/5/revenue?from=2026-01-15T00:00:00Z&to=2026-03-01T00:00:00Z&granularity=month", nil)
+	authorize(t, fakeDB, req, 1)
+	w := httptest.NewRecorder()
+
+	//ACT: Download the monthly series
+	handler.storesHandler(w, req)
+
+	//ASSERT: One row per month, gaps included
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("Expected CSV content type, got %s", ct)
+	}
+	want := "start,end,revenue,currency,orders\n" +
+		"2026-01-01T00:00:00Z,2026-02-01T00:00:00Z,0.00,USD,0\n" +
+		"2026-02-01T00:00:00Z,2026-03-01T00:00:00Z,1234.56,USD,40\n"
+	if w.Body.String() != want {
+		t.Errorf("Unexpected CSV:\n%s", w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_RevenueCSVRequiresAuth(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/5/revenue?from=2026-01-01T00:00:00Z&to=2026-02-01T00:00:00Z", nil)
+	w := httptest.NewRecorder()
+
+	handler.storesHandler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	maxReviewLength       = 2000
+	defaultReviewPageSize = 20
+	maxReviewPageSize     = 100
+	defaultReviewsPerHour = 5
+
+	//reviewRateLockID is the first key of the transaction advisory lock, taken
+	//per user, that makes concurrent reviews from one user wait for each other's count
+	reviewRateLockID = 720035
+)
+
+//reviewsPerHour reads REVIEW_RATE_LIMIT, the number of reviews a user may post per hour
+func reviewsPerHour() int {
+	if v := os.Getenv("REVIEW_RATE_LIMIT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultReviewsPerHour
+}
+
+//encodeReviewCursor and decodeReviewCursor turn the id of the last review on a page into an opaque cursor
+func encodeReviewCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("review:" + strconv.Itoa(id)))
+}
+
+func decodeReviewCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), "review:") {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(raw), "review:"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return id, nil
+}
+
+//averageRating returns the mean rating rounded to two decimals, or nil for a store without reviews
+func averageRating(count, sum int) interface{} {
+	if count == 0 {
+		return nil
+	}
+	return math.Round(float64(sum)/float64(count)*100) / 100
+}
+
+//storeRatingResolver returns averageRating or reviewCount of a store
+func (h *Handler) storeRatingResolver(average bool) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		store, ok := p.Source.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+
+		var count, sum int
+		err := h.database.QueryRowContext(p.Context, "SELECT review_count, rating_sum FROM stores WHERE id = $1", store["id"]).Scan(&count, &sum)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			h.logger.Error("database error loading store rating", "store_id", store["id"], "error", err.Error())
+			return nil, err
+		}
+
+		if average {
+			return averageRating(count, sum), nil
+		}
+		return count, nil
+	}
+}
+
+//reviewColumns is the select list scanned by scanReview
+const reviewColumns = "id, store_id, user_id, order_id, rating, body, verified_purchase, reply, replied_at, created_at"
+
+//scanReview reads a row selected with reviewColumns
+func scanReview(row interface{ Scan(...interface{}) error }) (map[string]interface{}, error) {
+	var id, storeID, userID, rating int
+	var orderID sql.NullInt64
+	var body string
+	var verified bool
+	var reply sql.NullString
+	var repliedAt sql.NullTime
+	var createdAt time.Time
+	if err := row.Scan(&id, &storeID, &userID, &orderID, &rating, &body, &verified, &reply, &repliedAt, &createdAt); err != nil {
+		return nil, err
+	}
+
+	review := map[string]interface{}{
+		"id":                id,
+		"store_id":          storeID,
+		"user_id":           userID,
+		"order_id":          nil,
+		"rating":            rating,
+		"body":              body,
+		"verified_purchase": verified,
+		"reply":             nil,
+		"replied_at":        nil,
+		"created_at":        createdAt.Format(time.RFC3339),
+	}
+	if orderID.Valid {
+		review["order_id"] = int(orderID.Int64)
+	}
+	if reply.Valid {
+		review["reply"] = reply.String
+		review["replied_at"] = repliedAt.Time.Format(time.RFC3339)
+	}
+	return review, nil
+}
+
+//storeReviewsResolver pages through a store's reviews, newest first
+func (h *Handler) storeReviewsResolver(p graphql.ResolveParams) (interface{}, error) {
+	store, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	first := defaultReviewPageSize
+	if v, ok := p.Args["first"].(int); ok {
+		if v <= 0 || v > maxReviewPageSize {
+			return nil, fmt.Errorf("first must be between 1 and %d", maxReviewPageSize)
+		}
+		first = v
+	}
+	query := "SELECT " + reviewColumns + " FROM reviews WHERE store_id = $1"
+	args := []interface{}{store["id"]}
+	if after, ok := p.Args["after"].(string); ok && after != "" {
+		afterID, err := decodeReviewCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		query += " AND id < $3"
+		args = append(args, first+1, afterID)
+	} else {
+		args = append(args, first+1)
+	}
+	query += " ORDER BY id DESC LIMIT $2"
+
+	rows, err := h.database.QueryContext(p.Context, query, args...)
+	if err != nil {
+		h.logger.Error("database error loading reviews", "store_id", store["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []map[string]interface{}{}
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	//One extra row was fetched to tell whether another page exists
+	page := map[string]interface{}{"has_more": false, "next_cursor": nil}
+	if len(reviews) > first {
+		reviews = reviews[:first]
+		page["has_more"] = true
+		page["next_cursor"] = encodeReviewCursor(reviews[first-1]["id"].(int))
+	}
+	page["reviews"] = reviews
+	return page, nil
+}
+
+//checkReviewRateLimit rejects a new review when the user has posted too many in the last hour.
+//Posts are counted from review_attempts, which deleting a review leaves alone. The
+//user's lock is held until the caller's transaction ends, so a concurrent post
+//counts only after this one has recorded its attempt.
+func checkReviewRateLimit(ctx context.Context, tx *sql.Tx, userID int) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, $2)", reviewRateLockID, userID); err != nil {
+		return fmt.Errorf("failed to lock review rate limit: %w", err)
+	}
+
+	var recent int
+	err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM review_attempts WHERE user_id = $1 AND created_at > NOW() - INTERVAL '1 hour'", userID).Scan(&recent)
+	if err != nil {
+		return err
+	}
+	if recent >= reviewsPerHour() {
+		return fmt.Errorf("too many reviews, please try again later")
+	}
+	return nil
+}
+
+//recordReviewAttempt counts a posted review toward the rate limit and prunes the
+//user's attempts that have left the window. Must run inside the caller's transaction.
+func recordReviewAttempt(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx,
+		"DELETE FROM review_attempts WHERE user_id = $1 AND created_at <= NOW() - INTERVAL '1 hour'", userID)
+	if err != nil {
+		return fmt.Errorf("failed to prune review attempts: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO review_attempts (user_id) VALUES ($1)", userID); err != nil {
+		return fmt.Errorf("failed to record review attempt: %w", err)
+	}
+	return nil
+}
+
+//createReviewResolver posts a buyer's review of a store - REQUIRES AUTH
+func (h *Handler) createReviewResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized create review attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid storeId")
+	}
+	rating, _ := p.Args["rating"].(int)
+	if rating < 1 || rating > 5 {
+		return nil, fmt.Errorf("rating must be between 1 and 5")
+	}
+	body, _ := p.Args["body"].(string)
+	body = strings.TrimSpace(body)
+	if len(body) > maxReviewLength {
+		return nil, fmt.Errorf("review cannot be longer than %d characters", maxReviewLength)
+	}
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	//Deleted stores and drafts cannot be reviewed; drafts are hidden from everyone
+	//but their owner, who cannot review their own store anyway
+	var ownerID int
+	var status string
+	err = tx.QueryRowContext(ctx, "SELECT user_id, status FROM stores WHERE id = $1 AND deleted_at IS NULL", storeID).Scan(&ownerID, &status)
+	if err == sql.ErrNoRows || (err == nil && status == storeStatusDraft) {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		h.logger.Error("database error checking store owner", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if ownerID == userID {
+		return nil, fmt.Errorf("you cannot review your own store")
+	}
+
+	if err := checkReviewRateLimit(ctx, tx, userID); err != nil {
+		h.logger.Warn("review rate limited", "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+
+	//A review linked to one of the reviewer's orders at the store is a verified purchase
+	var orderID sql.NullInt64
+	if id, ok := p.Args["orderId"].(int); ok {
+		order, err := loadOrder(ctx, tx, id, false)
+		if err == sql.ErrNoRows || (err == nil && (order.UserID != userID || order.StoreID != storeID)) {
+			return nil, fmt.Errorf("order with id %d not found", id)
+		}
+		if err != nil {
+			h.logger.Error("database error loading order", "order_id", id, "error", err.Error())
+			return nil, err
+		}
+		if order.Status == orderStatusCancelled {
+			return nil, fmt.Errorf("order %d was cancelled", id)
+		}
+		orderID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	insertQuery := `INSERT INTO reviews (store_id, user_id, order_id, rating, body, verified_purchase)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (store_id, user_id) DO NOTHING
+		RETURNING ` + reviewColumns
+	review, err := scanReview(tx.QueryRowContext(ctx, insertQuery, storeID, userID, orderID, rating, body, orderID.Valid))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("you have already reviewed this store")
+	}
+	if err != nil {
+		h.logger.Error("database error inserting review", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if err := recordReviewAttempt(ctx, tx, userID); err != nil {
+		h.logger.Error("database error recording review attempt", "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE stores SET review_count = review_count + 1, rating_sum = rating_sum + $1 WHERE id = $2", rating, storeID)
+	if err != nil {
+		h.logger.Error("database error updating store rating", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit review", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+
+	h.invalidateStoreCache(storeID)
+	h.refreshStoreLeaderboard(ctx, storeID)
+	h.logger.Info("review created",
+		"review_id", review["id"],
+		"store_id", storeID,
+		"user_id", userID,
+		"rating", rating,
+		"verified_purchase", orderID.Valid,
+	)
+	return review, nil
+}
+
+//deleteReviewResolver removes the caller's own review - REQUIRES AUTH
+func (h *Handler) deleteReviewResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized delete review attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var storeID, rating int
+	err = tx.QueryRowContext(ctx,
+		"DELETE FROM reviews WHERE id = $1 AND user_id = $2 RETURNING store_id, rating", id, userID).Scan(&storeID, &rating)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("review with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error deleting review", "review_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE stores SET review_count = review_count - 1, rating_sum = rating_sum - $1 WHERE id = $2", rating, storeID)
+	if err != nil {
+		h.logger.Error("database error updating store rating", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit review deletion", "review_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.invalidateStoreCache(storeID)
+	h.refreshStoreLeaderboard(ctx, storeID)
+	h.logger.Info("review deleted", "review_id", id, "store_id", storeID, "user_id", userID)
+	return map[string]interface{}{
+		"success": true,
+		"id":      id,
+	}, nil
+}
+
+//replyToReviewResolver sets the store owner's answer to a review - REQUIRES AUTH (store owner)
+func (h *Handler) replyToReviewResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized review reply attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["reviewId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid reviewId")
+	}
+	reply, _ := p.Args["reply"].(string)
+	reply = strings.TrimSpace(reply)
+	if reply == "" {
+		return nil, fmt.Errorf("reply cannot be empty")
+	}
+	if len(reply) > maxReviewLength {
+		return nil, fmt.Errorf("reply cannot be longer than %d characters", maxReviewLength)
+	}
+
+	var storeID int
+	err = h.database.QueryRowContext(p.Context, "SELECT store_id FROM reviews WHERE id = $1", id).Scan(&storeID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("review with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading review", "review_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	ownerID, err := storeOwnerID(p.Context, h.database, storeID)
+	if err != nil {
+		h.logger.Error("database error checking store owner", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if ownerID != userID {
+		return nil, fmt.Errorf("you can only reply to reviews of your own stores")
+	}
+
+	review, err := scanReview(h.database.QueryRowContext(p.Context,
+		"UPDATE reviews SET reply = $1, replied_at = NOW() WHERE id = $2 RETURNING "+reviewColumns, reply, id))
+	if err != nil {
+		h.logger.Error("database error saving review reply", "review_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("review reply saved", "review_id", id, "store_id", storeID, "user_id", userID)
+	return review, nil
+}
+
+//reviewType is a buyer's review of a store in GraphQL
+var reviewType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Review",
+	Fields: graphql.Fields{
+		"id":                &graphql.Field{Type: graphql.Int},
+		"store_id":          &graphql.Field{Type: graphql.Int},
+		"user_id":           &graphql.Field{Type: graphql.Int},
+		"order_id":          &graphql.Field{Type: graphql.Int},
+		"rating":            &graphql.Field{Type: graphql.Int},
+		"body":              &graphql.Field{Type: graphql.String},
+		"verified_purchase": &graphql.Field{Type: graphql.Boolean},
+		"reply":             &graphql.Field{Type: graphql.String},
+		"replied_at":        &graphql.Field{Type: graphql.String},
+		"created_at":        &graphql.Field{Type: graphql.String},
+	},
+})
+
+//reviewPageType is one page of Store.reviews
+var reviewPageType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "ReviewPage",
+	Fields: graphql.Fields{
+		"reviews":     &graphql.Field{Type: graphql.NewList(reviewType)},
+		"has_more":    &graphql.Field{Type: graphql.Boolean},
+		"next_cursor": &graphql.Field{Type: graphql.String},
+	},
+})
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+//reviewRows returns the columns scanReview reads
+func reviewRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "store_id", "user_id", "order_id", "rating", "body", "verified_purchase", "reply", "replied_at", "created_at"})
+}
+
+func TestCreateReviewResolver_VerifiedPurchase(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Buyer 2 reviews store 5 and links their order 10
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "active"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(reviewRateLockID, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM review_attempts WHERE user_id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("SELECT (.+) FROM orders WHERE id = \\$1$").
+		WithArgs(10).
+		WillReturnRows(orderRow(10, 5, 2, 2750, 0, "delivered"))
+	mock.ExpectQuery("INSERT INTO reviews").
+		WithArgs(5, 2, sqlmock.AnyArg(), 4, "Great stickers", true).
+		WillReturnRows(reviewRows().AddRow(7, 5, 2, 10, 4, "Great stickers", true, nil, nil, time.Now()))
+	mock.ExpectExec("DELETE FROM review_attempts WHERE user_id = \\$1").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO review_attempts \\(user_id\\) VALUES \\(\\$1\\)").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE stores SET review_count = review_count \\+ 1, rating_sum = rating_sum \\+ \\$1").
+		WithArgs(4, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args:    map[string]interface{}{"storeId": 5, "rating": 4, "body": "  Great stickers ", "orderId": 10},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.createReviewResolver(params)
+
+	//ASSERT: The review is a verified purchase and the store counters were bumped
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	review := result.(map[string]interface{})
+	if review["verified_purchase"] != true || review["order_id"] != 10 {
+		t.Errorf("Expected a verified review of order 10, got %v", review)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateReviewResolver_RateLimited(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Buyer 2 already posted 5 reviews in the last hour
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "active"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(reviewRateLockID, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM review_attempts WHERE user_id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args:    map[string]interface{}{"storeId": 5, "rating": 1},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.createReviewResolver(params)
+
+	//ASSERT: The review is rejected
+	if err == nil {
+		t.Fatal("Expected rate limit error")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateReviewResolver_DeletingDoesNotResetRateLimit(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+	t.Setenv("REVIEW_RATE_LIMIT", "1")
+
+	//ARRANGE: Buyer 2 posts a review, deletes it and posts again within the hour
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "active"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(reviewRateLockID, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM review_attempts WHERE user_id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery("INSERT INTO reviews").
+		WithArgs(5, 2, sqlmock.AnyArg(), 1, "", false).
+		WillReturnRows(reviewRows().AddRow(7, 5, 2, nil, 1, "", false, nil, nil, time.Now()))
+	mock.ExpectExec("DELETE FROM review_attempts WHERE user_id = \\$1").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO review_attempts").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE stores SET review_count = review_count \\+ 1").
+		WithArgs(1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	//Deleting touches the review and the store counters, not the attempts
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM reviews WHERE id = \\$1 AND user_id = \\$2 RETURNING store_id, rating").
+		WithArgs(7, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"store_id", "rating"}).AddRow(5, 1))
+	mock.ExpectExec("UPDATE stores SET review_count = review_count - 1").
+		WithArgs(1, 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "active"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(reviewRateLockID, 2).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM review_attempts WHERE user_id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	ctx := authContext(t, fakeDB, 2)
+	create := graphql.ResolveParams{Context: ctx, Args: map[string]interface{}{"storeId": 5, "rating": 1}}
+
+	//ACT: Create, delete, create
+	if _, err := handler.createReviewResolver(create); err != nil {
+		t.Fatalf("Expected the first review to be posted, got: %v", err)
+	}
+	if _, err := handler.deleteReviewResolver(graphql.ResolveParams{Context: ctx, Args: map[string]interface{}{"id": 7}}); err != nil {
+		t.Fatalf("Expected the review to be deleted, got: %v", err)
+	}
+	_, err = handler.createReviewResolver(create)
+
+	//ASSERT: The repost is still rate limited
+	if err == nil || err.Error() != "too many reviews, please try again later" {
+		t.Errorf("Expected rate limit error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateReviewResolver_OwnStore(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "active"))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"storeId": 5, "rating": 5},
+	}
+
+	//ACT: The owner reviews their own store
+	_, err = handler.createReviewResolver(params)
+
+	//ASSERT: Rejected
+	if err == nil || err.Error() != "you cannot review your own store" {
+		t.Errorf("Expected own store error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateReviewResolver_DraftStore(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "draft"))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Someone else reviews a store that is still a draft
+	_, err = handler.createReviewResolver(graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args:    map[string]interface{}{"storeId": 5, "rating": 5},
+	})
+
+	//ASSERT: The draft is not revealed
+	if err == nil || err.Error() != "store with id 5 not found" {
+		t.Errorf("Expected store not found, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoreReviewsResolver_Pagination(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: A page of 2 after review 9; a third row tells there is more
+	mock.ExpectQuery("SELECT (.+) FROM reviews WHERE store_id = \\$1 AND id < \\$3 ORDER BY id DESC LIMIT \\$2").
+		WithArgs(5, 3, 9).
+		WillReturnRows(reviewRows().
+			AddRow(8, 5, 2, nil, 5, "Love it", false, "Thanks!", time.Now(), time.Now()).
+			AddRow(6, 5, 3, nil, 3, "OK", false, nil, nil, time.Now()).
+			AddRow(4, 5, 4, nil, 4, "Nice", false, nil, nil, time.Now()))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: context.Background(),
+		Source:  map[string]interface{}{"id": 5},
+		Args:    map[string]interface{}{"first": 2, "after": encodeReviewCursor(9)},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.storeReviewsResolver(params)
+
+	//ASSERT: Two reviews and a cursor pointing after the last one
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	page := result.(map[string]interface{})
+	reviews := page["reviews"].([]map[string]interface{})
+	if len(reviews) != 2 || page["has_more"] != true {
+		t.Fatalf("Expected 2 reviews and more to come, got %d, %v", len(reviews), page["has_more"])
+	}
+	if reviews[0]["reply"] != "Thanks!" {
+		t.Errorf("Expected owner reply, got %v", reviews[0]["reply"])
+	}
+	if id, err := decodeReviewCursor(page["next_cursor"].(string)); err != nil || id != 6 {
+		t.Errorf("Expected cursor after review 6, got %d, %v", id, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestAverageRating(t *testing.T) {
+	if got := averageRating(0, 0); got != nil {
+		t.Errorf("Expected nil without reviews, got %v", got)
+	}
+	if got := averageRating(3, 13); got != 4.33 {
+		t.Errorf("Expected 4.33, got %v", got)
+	}
+	if _, err := decodeReviewCursor("not-a-cursor"); err == nil {
+		t.Error("Expected invalid cursor error")
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+const maxCategoryNameLength = 100
+
+//category is one node of the category tree
+type category struct {
+	ID       int
+	ParentID sql.NullInt64
+	Name     string
+}
+
+//categoryMap turns a category into the map the Category GraphQL type resolves
+//from; path names the categories from the top of the tree down to this one
+func categoryMap(c category, path []string) map[string]interface{} {
+	node := map[string]interface{}{
+		"id":        c.ID,
+		"name":      c.Name,
+		"parent_id": nil,
+		"path":      path,
+		"children":  []map[string]interface{}{},
+	}
+	if c.ParentID.Valid {
+		node["parent_id"] = int(c.ParentID.Int64)
+	}
+	return node
+}
+
+//loadCategoryTree reads every category and nests them, returning the top-level
+//ones. The tree is curated by admins and small, so it is read in one go.
+func loadCategoryTree(ctx context.Context, db *sql.DB) ([]map[string]interface{}, error) {
+	rows, err := db.QueryContext(ctx, "SELECT id, parent_id, name FROM categories ORDER BY lower(name), id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []category
+	for rows.Next() {
+		var c category
+		if err := rows.Scan(&c.ID, &c.ParentID, &c.Name); err != nil {
+			return nil, err
+		}
+		all = append(all, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	children := map[int64][]category{}
+	var roots []category
+	for _, c := range all {
+		if c.ParentID.Valid {
+			children[c.ParentID.Int64] = append(children[c.ParentID.Int64], c)
+		} else {
+			roots = append(roots, c)
+		}
+	}
+	var build func(c category, path []string) map[string]interface{}
+	build = func(c category, path []string) map[string]interface{} {
+		path = append(append([]string{}, path...), c.Name)
+		node := categoryMap(c, path)
+		nested := []map[string]interface{}{}
+		for _, child := range children[int64(c.ID)] {
+			nested = append(nested, build(child, path))
+		}
+		node["children"] = nested
+		return node
+	}
+	tree := []map[string]interface{}{}
+	for _, root := range roots {
+		tree = append(tree, build(root, nil))
+	}
+	return tree, nil
+}
+
+//categoriesResolver returns the category tree; it needs no login
+func (h *Handler) categoriesResolver(p graphql.ResolveParams) (interface{}, error) {
+	tree, err := loadCategoryTree(p.Context, h.database)
+	if err != nil {
+		h.logger.Error("database error loading categories", "error", err.Error())
+		return nil, err
+	}
+	return tree, nil
+}
+
+//storeCategoryResolver resolves Store.category with its path, without children
+func (h *Handler) storeCategoryResolver(p graphql.ResolveParams) (interface{}, error) {
+	store, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		`WITH RECURSIVE lineage AS (
+			SELECT c.id, c.parent_id, c.name, 0 AS depth FROM categories c JOIN stores s ON s.category_id = c.id WHERE s.id = $1
+			UNION ALL
+			SELECT c.id, c.parent_id, c.name, l.depth + 1 FROM categories c JOIN lineage l ON c.id = l.parent_id
+		)
+		SELECT id, parent_id, name FROM lineage ORDER BY depth DESC`, store["id"])
+	if err != nil {
+		h.logger.Error("database error loading store category", "store_id", store["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var path []string
+	var last category
+	for rows.Next() {
+		if err := rows.Scan(&last.ID, &last.ParentID, &last.Name); err != nil {
+			return nil, err
+		}
+		path = append(path, last.Name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if path == nil {
+		return nil, nil
+	}
+	node := categoryMap(last, path)
+	delete(node, "children")
+	return node, nil
+}
+
+//setStoreCategoryResolver files a store under a category, or takes it out of
+//one when categoryId is null - REQUIRES AUTH + OWNERSHIP
+func (h *Handler) setStoreCategoryResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized set category attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid store id")
+	}
+	var categoryID sql.NullInt64
+	if id, ok := p.Args["categoryId"].(int); ok {
+		categoryID = sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+
+	ctx := p.Context
+	ownerID, err := storeOwnerID(ctx, h.database, storeID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		h.logger.Error("database error checking store owner", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if ownerID != userID {
+		return nil, fmt.Errorf("you can only update your own stores")
+	}
+
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setRevisionActor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	if categoryID.Valid {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)", categoryID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("category with id %d not found", categoryID.Int64)
+		}
+	}
+
+	result, err := tx.ExecContext(ctx,
+		"UPDATE stores SET category_id = $1 WHERE id = $2 AND deleted_at IS NULL AND category_id IS DISTINCT FROM $1",
+		categoryID, storeID)
+	if err != nil {
+		h.logger.Error("database error setting store category", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := loadStore(ctx, tx, storeID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit store category", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+
+	if changed > 0 {
+		h.logger.Info("store category updated", "store_id", storeID, "category_id", categoryID.Int64, "user_id", userID)
+		h.invalidateStoreCache(storeID)
+	}
+	return store, nil
+}
+
+//categoryName validates a category name argument
+func categoryName(args map[string]interface{}) (string, error) {
+	name, _ := args["name"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("name is required")
+	}
+	if len(name) > maxCategoryNameLength {
+		return "", fmt.Errorf("name is longer than %d characters", maxCategoryNameLength)
+	}
+	return name, nil
+}
+
+//createCategoryResolver adds a category, at the top of the tree or under
+//parentId - REQUIRES ADMIN
+func (h *Handler) createCategoryResolver(p graphql.ResolveParams) (interface{}, error) {
+	adminID, err := h.requireAdmin(p)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := categoryName(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	c := category{Name: name}
+	if parentID, ok := p.Args["parentId"].(int); ok {
+		c.ParentID = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+
+	if c.ParentID.Valid {
+		var exists bool
+		err := h.database.QueryRowContext(p.Context, "SELECT EXISTS (SELECT 1 FROM categories WHERE id = $1)", c.ParentID).Scan(&exists)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("category with id %d not found", c.ParentID.Int64)
+		}
+	}
+
+	//Sibling names are unique, so a clash inserts nothing
+	err = h.database.QueryRowContext(p.Context,
+		"INSERT INTO categories (parent_id, name) VALUES ($1, $2) ON CONFLICT DO NOTHING RETURNING id",
+		c.ParentID, name).Scan(&c.ID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("a category named %q already exists there", name)
+	}
+	if err != nil {
+		h.logger.Error("database error creating category", "name", name, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("category created", "category_id", c.ID, "name", name, "admin_id", adminID)
+	return h.categoryNode(p.Context, c.ID)
+}
+
+//updateCategoryResolver renames a category or moves it under another parent
+//(moveToTop moves it to the top level) - REQUIRES ADMIN
+func (h *Handler) updateCategoryResolver(p graphql.ResolveParams) (interface{}, error) {
+	adminID, err := h.requireAdmin(p)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid category id")
+	}
+	ctx := p.Context
+	var c category
+	err = h.database.QueryRowContext(ctx, "SELECT id, parent_id, name FROM categories WHERE id = $1", id).Scan(&c.ID, &c.ParentID, &c.Name)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("category with id %d not found", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := p.Args["name"]; ok {
+		if c.Name, err = categoryName(p.Args); err != nil {
+			return nil, err
+		}
+	}
+	parentID, moving := p.Args["parentId"].(int)
+	moveToTop, _ := p.Args["moveToTop"].(bool)
+	if moving && moveToTop {
+		return nil, fmt.Errorf("pass either parentId or moveToTop, not both")
+	}
+	if moveToTop {
+		c.ParentID = sql.NullInt64{}
+	}
+	if moving {
+		//A category cannot go under itself or one of its own subcategories
+		var cycle bool
+		err := h.database.QueryRowContext(ctx,
+			`WITH RECURSIVE subtree AS (
+				SELECT id FROM categories WHERE id = $1
+				UNION ALL
+				SELECT c.id FROM categories c JOIN subtree ON c.parent_id = subtree.id
+			)
+			SELECT EXISTS (SELECT 1 FROM subtree WHERE id = $2)`, id, parentID).Scan(&cycle)
+		if err != nil {
+			return nil, err
+		}
+		if cycle {
+			return nil, fmt.Errorf("a category cannot be moved under itself or its subcategories")
+		}
+		c.ParentID = sql.NullInt64{Int64: int64(parentID), Valid: true}
+	}
+
+	var taken bool
+	err = h.database.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM categories WHERE COALESCE(parent_id, 0) = COALESCE($1, 0) AND lower(name) = lower($2) AND id <> $3)",
+		c.ParentID, c.Name, id).Scan(&taken)
+	if err != nil {
+		return nil, err
+	}
+	if taken {
+		return nil, fmt.Errorf("a category named %q already exists there", c.Name)
+	}
+
+	result, err := h.database.ExecContext(ctx,
+		"UPDATE categories SET name = $1, parent_id = $2 WHERE id = $3 AND ($2::int IS NULL OR EXISTS (SELECT 1 FROM categories WHERE id = $2))",
+		c.Name, c.ParentID, id)
+	if err != nil {
+		h.logger.Error("database error updating category", "category_id", id, "error", err.Error())
+		return nil, err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if updated == 0 {
+		//The new parent is gone, or the category itself was deleted meanwhile
+		missing := id
+		if moving {
+			missing = parentID
+		}
+		return nil, fmt.Errorf("category with id %d not found", missing)
+	}
+
+	h.logger.Info("category updated", "category_id", id, "name", c.Name, "admin_id", adminID)
+	return h.categoryNode(ctx, id)
+}
+
+//deleteCategoryResolver removes a category without subcategories; its stores
+//are left uncategorised - REQUIRES ADMIN
+func (h *Handler) deleteCategoryResolver(p graphql.ResolveParams) (interface{}, error) {
+	adminID, err := h.requireAdmin(p)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid category id")
+	}
+	ctx := p.Context
+
+	var hasChildren bool
+	err = h.database.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM categories WHERE parent_id = $1)", id).Scan(&hasChildren)
+	if err != nil {
+		return nil, err
+	}
+	if hasChildren {
+		return nil, fmt.Errorf("category with id %d has subcategories; move or delete them first", id)
+	}
+
+	result, err := h.database.ExecContext(ctx, "DELETE FROM categories WHERE id = $1", id)
+	if err != nil {
+		h.logger.Error("database error deleting category", "category_id", id, "error", err.Error())
+		return nil, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		return nil, fmt.Errorf("category with id %d not found", id)
+	}
+
+	h.logger.Info("category deleted", "category_id", id, "admin_id", adminID)
+	h.invalidateStoreStats(ctx)
+	return map[string]interface{}{"success": true, "id": id}, nil
+}
+
+//categoryNode finds one category in the tree, with its path and children
+func (h *Handler) categoryNode(ctx context.Context, id int) (map[string]interface{}, error) {
+	tree, err := loadCategoryTree(ctx, h.database)
+	if err != nil {
+		return nil, err
+	}
+	var find func(nodes []map[string]interface{}) map[string]interface{}
+	find = func(nodes []map[string]interface{}) map[string]interface{} {
+		for _, node := range nodes {
+			if node["id"] == id {
+				return node
+			}
+			if found := find(node["children"].([]map[string]interface{})); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	if node := find(tree); node != nil {
+		return node, nil
+	}
+	return nil, fmt.Errorf("category with id %d not found", id)
+}
+
+//newCategoryType builds the Category GraphQL type, whose children are categories too
+func newCategoryType() *graphql.Object {
+	var categoryType *graphql.Object
+	categoryType = graphql.NewObject(graphql.ObjectConfig{
+		Name: "Category",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id":        &graphql.Field{Type: graphql.Int},
+				"name":      &graphql.Field{Type: graphql.String},
+				"parent_id": &graphql.Field{Type: graphql.Int},
+				"path": &graphql.Field{
+					Type:        graphql.NewList(graphql.String),
+					Description: "Names from the top of the tree down to this category",
+				},
+				"children": &graphql.Field{
+					Type:        graphql.NewList(categoryType),
+					Description: "Subcategories by name; not loaded on Store.category",
+				},
+			}
+		}),
+	})
+	return categoryType
+}
+
+var categoryType = newCategoryType()
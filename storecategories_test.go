@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+func TestCategoriesResolver(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT id, parent_id, name FROM categories ORDER BY lower\\(name\\), id").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name"}).
+			AddRow(2, nil, "Anime").
+			AddRow(4, 1, "Laptop").
+			AddRow(5, 4, "MacBook").
+			AddRow(1, nil, "Stickers"))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Load the tree
+	result, err := handler.categoriesResolver(graphql.ResolveParams{Context: context.Background()})
+
+	//ASSERT: Top-level categories with their subcategories nested and paths filled in
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	tree := result.([]map[string]interface{})
+	if len(tree) != 2 || tree[0]["name"] != "Anime" || tree[1]["name"] != "Stickers" {
+		t.Fatalf("Unexpected roots: %v", tree)
+	}
+	laptop := tree[1]["children"].([]map[string]interface{})[0]
+	macbook := laptop["children"].([]map[string]interface{})[0]
+	if laptop["parent_id"] != 1 || !reflect.DeepEqual(macbook["path"], []string{"Stickers", "Laptop", "MacBook"}) {
+		t.Errorf("Unexpected subtree: %v", laptop)
+	}
+}
+
+func TestStoreCategoryResolver(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 5 is in Stickers > Laptop
+	mock.ExpectQuery("WITH RECURSIVE lineage AS").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name"}).
+			AddRow(1, nil, "Stickers").
+			AddRow(4, 1, "Laptop"))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Resolve the field
+	result, err := handler.storeCategoryResolver(graphql.ResolveParams{
+		Context: context.Background(),
+		Source:  map[string]interface{}{"id": 5},
+	})
+
+	//ASSERT: The store's own category with the path to it
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	category := result.(map[string]interface{})
+	if category["id"] != 4 || !reflect.DeepEqual(category["path"], []string{"Stickers", "Laptop"}) {
+		t.Errorf("Unexpected category: %v", category)
+	}
+}
+
+func TestSetStoreCategoryResolver_UnknownCategory(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM categories WHERE id = \\$1\\)").
+		WithArgs(99).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: File the store under a category that does not exist
+	_, err = handler.setStoreCategoryResolver(graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"storeId": 5, "categoryId": 99},
+	})
+
+	//ASSERT: Rejected without touching the store
+	if err == nil || err.Error() != "category with id 99 not found" {
+		t.Errorf("Expected unknown category error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestCreateCategoryResolver_Duplicate(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM categories WHERE id = \\$1\\)").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("INSERT INTO categories \\(parent_id, name\\) VALUES \\(\\$1, \\$2\\) ON CONFLICT DO NOTHING RETURNING id").
+		WithArgs(1, "Laptop").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Add a second Laptop under Stickers
+	_, err = handler.createCategoryResolver(graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"name": " Laptop ", "parentId": 1},
+	})
+
+	//ASSERT: Sibling names are unique
+	if err == nil || err.Error() != `a category named "Laptop" already exists there` {
+		t.Errorf("Expected duplicate error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateCategoryResolver_Cycle(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: MacBook (5) is under Laptop (4)
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+	mock.ExpectQuery("SELECT id, parent_id, name FROM categories WHERE id = \\$1").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name"}).AddRow(4, 1, "Laptop"))
+	mock.ExpectQuery("WITH RECURSIVE subtree AS").
+		WithArgs(4, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Move Laptop under its own subcategory
+	_, err = handler.updateCategoryResolver(graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 4, "parentId": 5},
+	})
+
+	//ASSERT: Refused
+	if err == nil || err.Error() != "a category cannot be moved under itself or its subcategories" {
+		t.Errorf("Expected cycle error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
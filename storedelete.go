@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//purgeLockID is the Postgres advisory lock held while deleted stores are purged,
+//so several app instances never purge at the same time
+const purgeLockID = 720041
+
+//defaultStoreRetention is how long a deleted store can be restored before it is purged
+const defaultStoreRetention = 30 * 24 * time.Hour
+
+//storesPurged counts stores hard-deleted by the retention purge
+var storesPurged = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "stores_purged_total",
+		Help: "Total number of deleted stores hard-deleted after the retention period",
+	},
+)
+
+//storeRetention reads STORE_RETENTION, how long a deleted store is kept (and
+//can be restored) before the purge removes it for good
+func storeRetention() time.Duration {
+	if v := os.Getenv("STORE_RETENTION"); v != "" {
+		if retention, err := time.ParseDuration(v); err == nil && retention > 0 {
+			return retention
+		}
+	}
+	return defaultStoreRetention
+}
+
+//restoreStoreResolver brings back a deleted store within the retention period - REQUIRES AUTH + OWNERSHIP OR ADMIN
+func (h *Handler) restoreStoreResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized restore store attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+
+	ctx := p.Context
+	var ownerID int
+	var deletedAt sql.NullTime
+	err = h.database.QueryRowContext(ctx, "SELECT user_id, deleted_at FROM stores WHERE id = $1", id).Scan(&ownerID, &deletedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading store for restore", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	if ownerID != userID {
+		admin, err := isAdmin(ctx, h.database, userID)
+		if err != nil {
+			h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+			return nil, err
+		}
+		if !admin {
+			h.logger.Warn("unauthorized restore attempt - not owner", "store_id", id, "requesting_user", userID, "store_owner", ownerID)
+			return nil, fmt.Errorf("you can only restore your own stores")
+		}
+	}
+
+	if !deletedAt.Valid {
+		return nil, fmt.Errorf("store with id %d is not deleted", id)
+	}
+	retention := storeRetention()
+	if time.Since(deletedAt.Time) >= retention {
+		return nil, fmt.Errorf("store with id %d was deleted more than %s ago and can no longer be restored", id, retention)
+	}
+
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setRevisionActor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	//The window is checked again in SQL so a purge running right now cannot race the restore
+	result, err := tx.ExecContext(ctx,
+		"UPDATE stores SET deleted_at = NULL WHERE id = $1 AND deleted_at > NOW() - make_interval(secs => $2)",
+		id, retention.Seconds())
+	if err != nil {
+		h.logger.Error("database error during restore", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, fmt.Errorf("store with id %d can no longer be restored", id)
+	}
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit store restore", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("store restored", "store_id", id, "user_id", userID, "deleted_at", deletedAt.Time)
+	h.invalidateStoreCache(id)
+	h.refreshStoreLeaderboard(ctx, id)
+
+	return loadStore(ctx, h.database, id)
+}
+
+//purgeDeletedStores hard-deletes every store deleted longer than retention ago,
+//together with everything that cascades from it, and returns how many were removed
+func (h *Handler) purgeDeletedStores(ctx context.Context, retention time.Duration) (int, error) {
+	conn, err := h.database.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", purgeLockID).Scan(&locked); err != nil {
+		return 0, fmt.Errorf("failed to acquire purge lock: %w", err)
+	}
+	if !locked {
+		//Another instance is purging; it will get these stores too
+		return 0, nil
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", purgeLockID)
+
+	rows, err := conn.QueryContext(ctx,
+		"DELETE FROM stores WHERE deleted_at <= NOW() - make_interval(secs => $1) RETURNING id", retention.Seconds())
+	if err != nil {
+		h.logger.Error("database error purging deleted stores", "error", err.Error())
+		return 0, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	storesPurged.Add(float64(len(ids)))
+	if len(ids) > 0 {
+		h.logger.Info("deleted stores purged", "count", len(ids), "store_ids", ids, "retention", retention.String())
+	}
+	return len(ids), nil
+}
+
+//runStoreRetentionPurge purges stores past their retention every interval until ctx is done
+func (h *Handler) runStoreRetentionPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := h.purgeDeletedStores(ctx, storeRetention()); err != nil {
+			h.logger.Error("store retention purge failed", "error", err.Error())
+		}
+	}
+}
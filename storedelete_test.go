@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+func TestRestoreStoreResolver_WithinRetention(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Owner 1 deleted store 5 an hour ago
+	mock.ExpectQuery("SELECT user_id, deleted_at FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "deleted_at"}).AddRow(1, time.Now().Add(-time.Hour)))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE stores SET deleted_at = NULL WHERE id = \\$1 AND deleted_at > NOW\\(\\) - make_interval").
+		WithArgs(5, defaultStoreRetention.Seconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(storeRows().AddRow(5, "Sticker Shop", int64(10000), "USD", 4, "active", 3, 1, "sticker-shop", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 5},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.restoreStoreResolver(params)
+
+	//ASSERT: The store is back
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if name := result.(map[string]interface{})["name"]; name != "Sticker Shop" {
+		t.Errorf("Expected restored store, got %v", result)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRestoreStoreResolver_RetentionExpired(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: A one day retention and a store deleted two days ago
+	t.Setenv("STORE_RETENTION", "24h")
+	mock.ExpectQuery("SELECT user_id, deleted_at FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "deleted_at"}).AddRow(1, time.Now().Add(-48*time.Hour)))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 5},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.restoreStoreResolver(params)
+
+	//ASSERT: Too late, and nothing was written
+	if err == nil || err.Error() != "store with id 5 was deleted more than 24h0m0s ago and can no longer be restored" {
+		t.Errorf("Expected retention error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestRestoreStoreResolver_NotDeleted(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT user_id, deleted_at FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "deleted_at"}).AddRow(1, nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 5},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.restoreStoreResolver(params)
+
+	//ASSERT: Nothing to restore
+	if err == nil || err.Error() != "store with id 5 is not deleted" {
+		t.Errorf("Expected not deleted error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestPurgeDeletedStores(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Two stores are past a one day retention
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").
+		WithArgs(purgeLockID).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectQuery("DELETE FROM stores WHERE deleted_at <= NOW\\(\\) - make_interval\\(secs => \\$1\\) RETURNING id").
+		WithArgs(float64(86400)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3).AddRow(8))
+	mock.ExpectExec("SELECT pg_advisory_unlock").
+		WithArgs(purgeLockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Run the purge
+	purged, err := handler.purgeDeletedStores(context.Background(), 24*time.Hour)
+
+	//ASSERT: Both stores are gone
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("Expected 2 purged stores, got %d", purged)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
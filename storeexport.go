@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+//storeExportBatch is how many rows each FETCH pulls from the export cursor
+const storeExportBatch = 1000
+
+//storeExportFormats maps each format to its content type and file extension
+var storeExportFormats = map[string][2]string{
+	"csv":     {"text/csv; charset=utf-8", "csv"},
+	"ndjson":  {"application/x-ndjson", "ndjson"},
+	"parquet": {"application/vnd.apache.parquet", "parquet"},
+}
+
+//storeExportRecord is one exported store. Revenue is kept as a decimal string
+//so no format rounds it, with the minor units alongside for arithmetic
+type storeExportRecord struct {
+	ID           int64       `json:"id" parquet:"name=id, type=INT64"`
+	Name         string      `json:"name" parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Status       string      `json:"status" parquet:"name=status, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Currency     string      `json:"currency" parquet:"name=currency, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Revenue      json.Number `json:"revenue" parquet:"name=revenue, type=BYTE_ARRAY, convertedtype=UTF8"`
+	RevenueMinor int64       `json:"revenue_minor" parquet:"name=revenue_minor, type=INT64"`
+	TotalOrders  int64       `json:"total_orders" parquet:"name=total_orders, type=INT64"`
+	Version      int64       `json:"version" parquet:"name=version, type=INT64"`
+	UserID       *int64      `json:"user_id" parquet:"name=user_id, type=INT64, repetitiontype=OPTIONAL"`
+}
+
+//storeExportWriter encodes records in one export format
+type storeExportWriter interface {
+	Write(record storeExportRecord) error
+	Close() error
+}
+
+func newStoreExportWriter(format string, w io.Writer) (storeExportWriter, error) {
+	switch format {
+	case "csv":
+		out := csv.NewWriter(w)
+		err := out.Write([]string{"id", "name", "status", "currency", "revenue", "revenue_minor", "total_orders", "version", "user_id"})
+		return &csvExportWriter{out: out}, err
+	case "ndjson":
+		return &ndjsonExportWriter{out: json.NewEncoder(w)}, nil
+	case "parquet":
+		out, err := writer.NewParquetWriterFromWriter(w, new(storeExportRecord), 1)
+		if err != nil {
+			return nil, err
+		}
+		//Smaller row groups than the 128MB default keep memory bounded
+		out.RowGroupSize = 4 * 1024 * 1024
+		out.CompressionType = parquet.CompressionCodec_SNAPPY
+		return &parquetExportWriter{out: out}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+type csvExportWriter struct {
+	out *csv.Writer
+}
+
+func (c *csvExportWriter) Write(record storeExportRecord) error {
+	userID := ""
+	if record.UserID != nil {
+		userID = strconv.FormatInt(*record.UserID, 10)
+	}
+	return c.out.Write([]string{
+		strconv.FormatInt(record.ID, 10),
+		record.Name,
+		record.Status,
+		record.Currency,
+		record.Revenue.String(),
+		strconv.FormatInt(record.RevenueMinor, 10),
+		strconv.FormatInt(record.TotalOrders, 10),
+		strconv.FormatInt(record.Version, 10),
+		userID,
+	})
+}
+
+func (c *csvExportWriter) Close() error {
+	c.out.Flush()
+	return c.out.Error()
+}
+
+type ndjsonExportWriter struct {
+	out *json.Encoder
+}
+
+func (n *ndjsonExportWriter) Write(record storeExportRecord) error {
+	return n.out.Encode(record)
+}
+
+func (n *ndjsonExportWriter) Close() error {
+	return nil
+}
+
+type parquetExportWriter struct {
+	out *writer.ParquetWriter
+}
+
+func (p *parquetExportWriter) Write(record storeExportRecord) error {
+	return p.out.Write(record)
+}
+
+//Close writes the last row group and the footer
+func (p *parquetExportWriter) Close() error {
+	return p.out.WriteStop()
+}
+
+//storeExportHandler serves GET /stores/export
+func (h *Handler) storeExportHandler(w http.ResponseWriter, r *http.Request) {
+	format, filter, err := h.storeExportRequest(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		var reqErr *requestError
+		if errors.As(err, &reqErr) {
+			if reqErr.Status == http.StatusMethodNotAllowed {
+				w.Header().Set("Allow", "GET")
+			}
+			w.WriteHeader(reqErr.Status)
+			json.NewEncoder(w).Encode(map[string]string{"error": reqErr.Message})
+			return
+		}
+		h.logger.Error("store export request failed", "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Database error"}`))
+		return
+	}
+
+	w.Header().Set("Content-Type", storeExportFormats[format][0])
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="stores.%s"`, storeExportFormats[format][1]))
+	count, err := h.exportStores(r.Context(), w, format, filter)
+	if err != nil {
+		//The status line has gone out, so all that is left is to cut the
+		//response short and let the client see it is incomplete
+		h.logger.Error("store export failed", "format", format, "rows", count, "error", err.Error())
+		panic(http.ErrAbortHandler)
+	}
+	h.logger.Info("stores exported", "format", format, "rows", count)
+}
+
+//storeExportRequest authenticates an export and works out what it may
+//include: everything for admins, otherwise only the caller's own stores
+func (h *Handler) storeExportRequest(r *http.Request) (string, storeFilter, error) {
+	if r.Method != http.MethodGet {
+		return "", storeFilter{}, &requestError{Status: http.StatusMethodNotAllowed, Message: "method not allowed"}
+	}
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		h.logger.Warn("unauthorized store export attempt", "error", err.Error())
+		return "", storeFilter{}, &requestError{Status: http.StatusUnauthorized, Message: "authentication required"}
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if _, ok := storeExportFormats[format]; !ok {
+		return "", storeFilter{}, &requestError{Status: http.StatusBadRequest, Message: "format must be csv, ndjson or parquet"}
+	}
+	filter, err := storeFilterQuery(r.URL.Query())
+	if err != nil {
+		return "", storeFilter{}, err
+	}
+
+	admin, err := isAdmin(r.Context(), h.database, userID)
+	if err != nil {
+		return "", storeFilter{}, err
+	}
+	if !admin {
+		if filter.OwnerID != 0 && filter.OwnerID != userID {
+			return "", storeFilter{}, &requestError{Status: http.StatusForbidden, Message: "you can only export your own stores"}
+		}
+		filter.OwnerID = userID
+	}
+	return format, filter, nil
+}
+
+//exportStores streams the filtered stores to w through a server-side cursor,
+//a batch at a time, so the export never holds more than one batch in memory
+func (h *Handler) exportStores(ctx context.Context, w io.Writer, format string, filter storeFilter) (int, error) {
+	tx, err := h.database.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	where, args := filter.where()
+	_, err = tx.ExecContext(ctx, "DECLARE store_export NO SCROLL CURSOR FOR "+
+		"SELECT id, name, status, currency, revenue_cents, total_orders, version, user_id FROM stores s "+where+" ORDER BY id", args...)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := newStoreExportWriter(format, w)
+	if err != nil {
+		return 0, err
+	}
+	flusher, _ := w.(http.Flusher)
+	count := 0
+	for {
+		fetched, err := fetchStoreExportBatch(ctx, tx, out)
+		count += fetched
+		if err != nil {
+			return count, err
+		}
+		if fetched == 0 {
+			break
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	if err := out.Close(); err != nil {
+		return count, err
+	}
+	return count, tx.Commit()
+}
+
+//fetchStoreExportBatch writes the next batch from the export cursor and
+//returns how many rows it held
+func fetchStoreExportBatch(ctx context.Context, tx *sql.Tx, out storeExportWriter) (int, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM store_export", storeExportBatch))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		var record storeExportRecord
+		var revenue Money
+		var userID sql.NullInt64
+		if err := rows.Scan(&record.ID, &record.Name, &record.Status, &revenue.Currency, &revenue.Amount, &record.TotalOrders, &record.Version, &userID); err != nil {
+			return count, err
+		}
+		record.Currency = revenue.Currency
+		record.Revenue = json.Number(revenue.Decimal())
+		record.RevenueMinor = revenue.Amount
+		if userID.Valid {
+			record.UserID = &userID.Int64
+		}
+		if err := out.Write(record); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+)
+
+var storeExportColumns = []string{"id", "name", "status", "currency", "revenue_cents", "total_orders", "version", "user_id"}
+
+//expectStoreExport sets up an export cursor that yields rows in one batch
+func expectStoreExport(mock sqlmock.Sqlmock, rows *sqlmock.Rows, args ...driver.Value) {
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE store_export NO SCROLL CURSOR FOR SELECT (.+) FROM stores s WHERE s.deleted_at IS NULL").
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FETCH 1000 FROM store_export").WillReturnRows(rows)
+	mock.ExpectQuery("FETCH 1000 FROM store_export").WillReturnRows(sqlmock.NewRows(storeExportColumns))
+	mock.ExpectCommit()
+}
+
+func TestStoresHandler_ExportCSV(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: An admin exporting active stores, one of them without an owner
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+	expectStoreExport(mock, sqlmock.NewRows(storeExportColumns).
+		AddRow(5, "Sticker Shop", "active", "USD", int64(1250), 3, 2, 7).
+		AddRow(6, "Quoted, Name", "active", "JPY", int64(500), 0, 1, nil),
+		pq.Array([]string{"active"}))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/export?status=active", nil)
+	authorize(t, fakeDB, req, 1)
+	w := httptest.NewRecorder()
+
+	//ACT: Export as CSV, the default
+	handler.storesHandler(w, req)
+
+	//ASSERT: A CSV attachment with a header and one line per store
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Disposition") != `attachment; filename="stores.csv"` {
+		t.Errorf("Unexpected Content-Disposition %q", w.Header().Get("Content-Disposition"))
+	}
+	want := "id,name,status,currency,revenue,revenue_minor,total_orders,version,user_id\n" +
+		"5,Sticker Shop,active,USD,12.50,1250,3,2,7\n" +
+		"6,\"Quoted, Name\",active,JPY,500,500,0,1,\n"
+	if w.Body.String() != want {
+		t.Errorf("Expected:\n%s\ngot:\n%s", want, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_ExportNDJSONOwnStores(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: A store owner is limited to their own stores
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	expectStoreExport(mock, sqlmock.NewRows(storeExportColumns).
+		AddRow(5, "Sticker Shop", "active", "USD", int64(1250), 3, 2, 7),
+		7)
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/export?format=ndjson", nil)
+	authorize(t, fakeDB, req, 7)
+	w := httptest.NewRecorder()
+
+	//ACT: Export as NDJSON
+	handler.storesHandler(w, req)
+
+	//ASSERT: One object per line with the revenue as an exact number
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Unexpected Content-Type %q", w.Header().Get("Content-Type"))
+	}
+	want := `{"id":5,"name":"Sticker Shop","status":"active","currency":"USD","revenue":12.50,"revenue_minor":1250,"total_orders":3,"version":2,"user_id":7}` + "\n"
+	if w.Body.String() != want {
+		t.Errorf("Expected %s, got %s", want, w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_ExportParquet(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+	expectStoreExport(mock, sqlmock.NewRows(storeExportColumns).
+		AddRow(5, "Sticker Shop", "active", "USD", int64(1250), 3, 2, 7).
+		AddRow(6, "Label Shop", "draft", "EUR", int64(0), 0, 1, nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/export?format=parquet", nil)
+	authorize(t, fakeDB, req, 1)
+	w := httptest.NewRecorder()
+
+	//ACT: Export as Parquet
+	handler.storesHandler(w, req)
+
+	//ASSERT: A Parquet file that reads back to the same stores
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	file, err := buffer.NewBufferFile(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("Failed to open export: %v", err)
+	}
+	type parquetStore struct {
+		Name    string `parquet:"name=name, type=BYTE_ARRAY, convertedtype=UTF8"`
+		Revenue string `parquet:"name=revenue, type=BYTE_ARRAY, convertedtype=UTF8"`
+		UserID  *int64 `parquet:"name=user_id, type=INT64, repetitiontype=OPTIONAL"`
+	}
+	pr, err := reader.NewParquetReader(file, new(parquetStore), 1)
+	if err != nil {
+		t.Fatalf("Failed to read export: %v", err)
+	}
+	defer pr.ReadStop()
+	records := make([]parquetStore, pr.GetNumRows())
+	if err := pr.Read(&records); err != nil {
+		t.Fatalf("Failed to read rows: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(records))
+	}
+	if records[0].Name != "Sticker Shop" || records[0].Revenue != "12.50" || records[0].UserID == nil || *records[0].UserID != 7 {
+		t.Errorf("Unexpected first row: %+v", records[0])
+	}
+	if records[1].UserID != nil {
+		t.Errorf("Expected no owner on the second row, got %d", *records[1].UserID)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_ExportRejected(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	cases := []struct {
+		query  string
+		status int
+		error  string
+	}{
+		{"format=xlsx", http.StatusBadRequest, "format must be csv, ndjson or parquet"},
+		{"status=open", http.StatusBadRequest, `unknown status "open"`},
+		{"ownerId=8", http.StatusForbidden, "you can only export your own stores"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/stores/export?"+c.query, nil)
+		authorize(t, fakeDB, req, 7)
+		w := httptest.NewRecorder()
+
+		handler.storesHandler(w, req)
+
+		var body map[string]string
+		json.NewDecoder(strings.NewReader(w.Body.String())).Decode(&body)
+		if w.Code != c.status || body["error"] != c.error {
+			t.Errorf("%s: expected %d %q, got %d %s", c.query, c.status, c.error, w.Code, w.Body.String())
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+)
+
+//storeFilter narrows a listing of live stores by status, currency, owner,
+//tags (a store needs all of them) and category (subcategories included)
+type storeFilter struct {
+	Statuses   []string `json:"statuses,omitempty"`
+	Currency   string   `json:"currency,omitempty"`
+	OwnerID    int      `json:"owner_id,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	CategoryID int      `json:"category_id,omitempty"`
+}
+
+//where builds the WHERE clause for stores aliased as s, numbered from $1
+func (f storeFilter) where() (string, []interface{}) {
+	conditions := []string{"s.deleted_at IS NULL"}
+	var args []interface{}
+	if len(f.Statuses) > 0 {
+		args = append(args, pq.Array(f.Statuses))
+		conditions = append(conditions, fmt.Sprintf("s.status = ANY($%d)", len(args)))
+	}
+	if f.Currency != "" {
+		args = append(args, f.Currency)
+		conditions = append(conditions, fmt.Sprintf("s.currency = $%d", len(args)))
+	}
+	if f.OwnerID != 0 {
+		args = append(args, f.OwnerID)
+		conditions = append(conditions, fmt.Sprintf("s.user_id = $%d", len(args)))
+	}
+	if len(f.Tags) > 0 {
+		args = append(args, pq.Array(f.Tags), len(f.Tags))
+		conditions = append(conditions, fmt.Sprintf(
+			"s.id IN (SELECT st.store_id FROM store_tags st JOIN tags t ON t.id = st.tag_id WHERE t.name = ANY($%d) GROUP BY st.store_id HAVING COUNT(*) = $%d)",
+			len(args)-1, len(args)))
+	}
+	if f.CategoryID != 0 {
+		args = append(args, f.CategoryID)
+		conditions = append(conditions, fmt.Sprintf(
+			"s.category_id IN (WITH RECURSIVE subtree AS (SELECT id FROM categories WHERE id = $%d UNION ALL SELECT c.id FROM categories c JOIN subtree ON c.parent_id = subtree.id) SELECT id FROM subtree)",
+			len(args)))
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+//storeFilterArg reads the optional filter argument of a GraphQL field
+func storeFilterArg(args map[string]interface{}) (storeFilter, error) {
+	var filter storeFilter
+	input, ok := args["filter"].(map[string]interface{})
+	if !ok {
+		return filter, nil
+	}
+	if statuses, ok := input["status"].([]interface{}); ok {
+		for _, status := range statuses {
+			if s, ok := status.(string); ok {
+				filter.Statuses = append(filter.Statuses, s)
+			}
+		}
+	}
+	if currency, ok := input["currency"].(string); ok && currency != "" {
+		code, err := normalizeCurrency(currency)
+		if err != nil {
+			return filter, err
+		}
+		filter.Currency = code
+	}
+	if ownerID, ok := input["ownerId"].(int); ok {
+		filter.OwnerID = ownerID
+	}
+	if list, ok := input["tags"].([]interface{}); ok {
+		var tags []string
+		for _, tag := range list {
+			if t, ok := tag.(string); ok {
+				tags = append(tags, t)
+			}
+		}
+		normalized, err := normalizeTags(tags)
+		if err != nil {
+			return filter, err
+		}
+		filter.Tags = normalized
+	}
+	if categoryID, ok := input["categoryId"].(int); ok {
+		filter.CategoryID = categoryID
+	}
+	return filter, nil
+}
+
+//storeFilterQuery reads the same filter from a REST query string: status and
+//tag (repeated or comma separated), currency, ownerId and categoryId
+func storeFilterQuery(query url.Values) (storeFilter, error) {
+	var filter storeFilter
+	for _, value := range query["status"] {
+		for _, status := range strings.Split(value, ",") {
+			status = strings.ToLower(strings.TrimSpace(status))
+			switch status {
+			case storeStatusDraft, storeStatusActive, storeStatusPaused, storeStatusSuspended, storeStatusClosed:
+			default:
+				return filter, &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown status %q", status)}
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	if currency := query.Get("currency"); currency != "" {
+		code, err := normalizeCurrency(currency)
+		if err != nil {
+			return filter, &requestError{Status: http.StatusBadRequest, Message: err.Error()}
+		}
+		filter.Currency = code
+	}
+	if owner := query.Get("ownerId"); owner != "" {
+		ownerID, err := strconv.Atoi(owner)
+		if err != nil || ownerID < 1 {
+			return filter, &requestError{Status: http.StatusBadRequest, Message: "ownerId must be a user id"}
+		}
+		filter.OwnerID = ownerID
+	}
+	var tags []string
+	for _, value := range query["tag"] {
+		tags = append(tags, strings.Split(value, ",")...)
+	}
+	if len(tags) > 0 {
+		normalized, err := normalizeTags(tags)
+		if err != nil {
+			return filter, &requestError{Status: http.StatusBadRequest, Message: err.Error()}
+		}
+		filter.Tags = normalized
+	}
+	if category := query.Get("categoryId"); category != "" {
+		categoryID, err := strconv.Atoi(category)
+		if err != nil || categoryID < 1 {
+			return filter, &requestError{Status: http.StatusBadRequest, Message: "categoryId must be a category id"}
+		}
+		filter.CategoryID = categoryID
+	}
+	return filter, nil
+}
+
+//storeFilterInput is the GraphQL form of storeFilter
+var storeFilterInput = graphql.NewInputObject(graphql.InputObjectConfig{
+	Name: "StoreFilter",
+	Fields: graphql.InputObjectConfigFieldMap{
+		"status":   &graphql.InputObjectFieldConfig{Type: graphql.NewList(graphql.NewNonNull(storeStatusEnum))},
+		"currency": &graphql.InputObjectFieldConfig{Type: graphql.String},
+		"ownerId":  &graphql.InputObjectFieldConfig{Type: graphql.Int},
+		"tags": &graphql.InputObjectFieldConfig{
+			Type:        graphql.NewList(graphql.NewNonNull(graphql.String)),
+			Description: "Only stores with all of these tags",
+		},
+		"categoryId": &graphql.InputObjectFieldConfig{
+			Type:        graphql.Int,
+			Description: "Only stores in this category or one of its subcategories",
+		},
+	},
+})
@@ -0,0 +1,643 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+//Formats of a bulk store import
+const (
+	importFormatCSV    = "csv"
+	importFormatNDJSON = "ndjson"
+)
+
+//Results of one imported row
+const (
+	importRowCreated = "created"
+	importRowValid   = "valid" //dry run: would have been created
+	importRowFailed  = "failed"
+)
+
+//States of a background import
+const (
+	importRunning   = "running"
+	importSucceeded = "succeeded"
+	importFailed    = "failed"
+)
+
+const (
+	defaultMaxImportBytes = 10 << 20
+	maxImportRows         = 50000
+	//importSyncRows is the most rows imported within the request; more run as a job
+	importSyncRows = 1000
+	//importBatchSize rows are copied and committed together
+	importBatchSize = 500
+	//importStaleAfter without progress marks a running import as failed, e.g.
+	//because the instance running it restarted
+	importStaleAfter = 5 * time.Minute
+	//maxStoreNameLength keeps one long name from failing a whole COPY batch
+	maxStoreNameLength = 255
+)
+
+//importRow is one store as it appeared in the file
+type importRow struct {
+	Line     int
+	Name     string
+	Revenue  string
+	Currency string
+	Status   string
+	Err      string //set when the line could not be read at all
+}
+
+//importRowResult is what happened to one row
+type importRowResult struct {
+	Line    int      `json:"line"`
+	Name    string   `json:"name,omitempty"`
+	Status  string   `json:"status"`
+	StoreID int      `json:"store_id,omitempty"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+//importReport summarises an import
+type importReport struct {
+	DryRun    bool              `json:"dry_run"`
+	TotalRows int               `json:"total_rows"`
+	Created   int               `json:"created"`
+	Valid     int               `json:"valid"`
+	Failed    int               `json:"failed"`
+	Rows      []importRowResult `json:"rows"`
+}
+
+//importStore is a validated row ready to insert
+type importStore struct {
+	line    int
+	name    string
+	revenue Money
+	status  string
+}
+
+//maxImportBytes reads MAX_IMPORT_BYTES, falling back to 10 MB
+func maxImportBytes() int64 {
+	if v := os.Getenv("MAX_IMPORT_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxImportBytes
+}
+
+//importFormat maps a request Content-Type to an import format
+func importFormat(contentType string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = contentType
+	}
+	switch mediaType {
+	case "text/csv":
+		return importFormatCSV, nil
+	case "application/x-ndjson", "application/ndjson", "application/jsonl":
+		return importFormatNDJSON, nil
+	}
+	return "", &requestError{Status: http.StatusUnsupportedMediaType, Message: "Content-Type must be text/csv or application/x-ndjson"}
+}
+
+//parseImport reads every row of an import. A malformed line becomes a row with
+//Err set; only a file that cannot be read as a whole is an error.
+func parseImport(format string, body io.Reader) ([]importRow, error) {
+	if format == importFormatCSV {
+		return parseCSVImport(body)
+	}
+	return parseNDJSONImport(body)
+}
+
+//importReadError reports a file that could not be read. A body over the size
+//limit is passed through so the handler can answer 413.
+func importReadError(format string, err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("malformed %s: %v", format, err)}
+}
+
+//parseCSVImport reads a CSV file whose header names the columns: name is
+//required, revenue, currency and status are optional
+func parseCSVImport(body io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, &requestError{Status: http.StatusBadRequest, Message: "the file is empty"}
+	}
+	if err != nil {
+		return nil, importReadError("CSV", err)
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		switch column {
+		case "name", "revenue", "currency", "status":
+		default:
+			return nil, &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown column %q; expected name, revenue, currency and status", column)}
+		}
+		columns[column] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, &requestError{Status: http.StatusBadRequest, Message: "the header must have a name column"}
+	}
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, importReadError("CSV", err)
+		}
+		line, _ := reader.FieldPos(0)
+		row := importRow{Line: line}
+		if len(record) != len(header) {
+			row.Err = fmt.Sprintf("expected %d fields, got %d", len(header), len(record))
+		} else {
+			field := func(column string) string {
+				if i, ok := columns[column]; ok {
+					return strings.TrimSpace(record[i])
+				}
+				return ""
+			}
+			row.Name, row.Revenue, row.Currency, row.Status = field("name"), field("revenue"), field("currency"), field("status")
+		}
+		rows = append(rows, row)
+		if len(rows) > maxImportRows {
+			return nil, &requestError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("an import can have at most %d rows", maxImportRows)}
+		}
+	}
+	if len(rows) == 0 {
+		return nil, &requestError{Status: http.StatusBadRequest, Message: "the file has no rows"}
+	}
+	return rows, nil
+}
+
+//parseNDJSONImport reads one JSON object per line; blank lines are skipped
+func parseNDJSONImport(body io.Reader) ([]importRow, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var object struct {
+			Name     *string     `json:"name"`
+			Revenue  json.Number `json:"revenue"`
+			Currency string      `json:"currency"`
+			Status   string      `json:"status"`
+		}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		row := importRow{Line: line}
+		if err := decoder.Decode(&object); err != nil {
+			row.Err = fmt.Sprintf("invalid JSON: %v", err)
+		} else {
+			if object.Name != nil {
+				row.Name = strings.TrimSpace(*object.Name)
+			}
+			row.Revenue = object.Revenue.String()
+			row.Currency = strings.TrimSpace(object.Currency)
+			row.Status = strings.TrimSpace(object.Status)
+		}
+		rows = append(rows, row)
+		if len(rows) > maxImportRows {
+			return nil, &requestError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("an import can have at most %d rows", maxImportRows)}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, importReadError("NDJSON", err)
+	}
+	if len(rows) == 0 {
+		return nil, &requestError{Status: http.StatusBadRequest, Message: "the file is empty"}
+	}
+	return rows, nil
+}
+
+//validateImportRow applies the createStore rules to a row and reports every problem with it.
+//Revenue defaults to zero and status to active.
+func validateImportRow(row importRow) (importStore, []string) {
+	if row.Err != "" {
+		return importStore{}, []string{row.Err}
+	}
+
+	var problems []string
+	store := importStore{line: row.Line, name: row.Name, status: row.Status}
+	if store.name == "" {
+		problems = append(problems, "name is required")
+	} else if utf8.RuneCountInString(store.name) > maxStoreNameLength {
+		problems = append(problems, fmt.Sprintf("name must be at most %d characters", maxStoreNameLength))
+	}
+
+	currency, err := normalizeCurrency(row.Currency)
+	if err != nil {
+		problems = append(problems, err.Error())
+	} else {
+		revenue := row.Revenue
+		if revenue == "" {
+			revenue = "0"
+		}
+		store.revenue, err = parseMoney(revenue, currency)
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else if store.revenue.Amount < 0 {
+			problems = append(problems, "revenue cannot be negative")
+		}
+	}
+
+	if store.status == "" {
+		store.status = storeStatusActive
+	}
+	store.status = strings.ToLower(store.status)
+	if store.status != storeStatusActive && store.status != storeStatusDraft {
+		problems = append(problems, "new stores start as draft or active")
+	}
+	return store, problems
+}
+
+//importStores validates rows and, unless dryRun, creates the valid ones for
+//userID in batches of importBatchSize, each batch in its own transaction. A
+//failed batch fails its rows and the import carries on. progress is called
+//after every batch with the counts so far.
+func (h *Handler) importStores(ctx context.Context, userID int, rows []importRow, dryRun bool, progress func(report *importReport, processed int)) *importReport {
+	report := &importReport{DryRun: dryRun, TotalRows: len(rows), Rows: make([]importRowResult, len(rows))}
+
+	for start := 0; start < len(rows); start += importBatchSize {
+		end := min(start+importBatchSize, len(rows))
+
+		var batch []importStore
+		var positions []int
+		for i := start; i < end; i++ {
+			store, problems := validateImportRow(rows[i])
+			report.Rows[i] = importRowResult{Line: rows[i].Line, Name: rows[i].Name}
+			if len(problems) > 0 {
+				report.Rows[i].Status = importRowFailed
+				report.Rows[i].Errors = problems
+				report.Failed++
+				continue
+			}
+			if dryRun {
+				report.Rows[i].Status = importRowValid
+				report.Valid++
+				continue
+			}
+			batch = append(batch, store)
+			positions = append(positions, i)
+		}
+
+		if len(batch) > 0 {
+			ids, err := h.insertStoreBatch(ctx, userID, batch)
+			for j, i := range positions {
+				if err != nil {
+					report.Rows[i].Status = importRowFailed
+					report.Rows[i].Errors = []string{"batch failed: " + err.Error()}
+					report.Failed++
+					continue
+				}
+				report.Rows[i].Status = importRowCreated
+				report.Rows[i].StoreID = ids[j]
+				report.Created++
+			}
+			if err != nil {
+				h.logger.Error("store import batch failed",
+					"user_id", userID,
+					"first_line", batch[0].line,
+					"rows", len(batch),
+					"error", err.Error(),
+				)
+			}
+		}
+
+		if progress != nil {
+			progress(report, end)
+		}
+	}
+
+	if report.Created > 0 {
+		h.invalidateStoreStats(ctx)
+		//One rebuild picks up every imported store instead of a refresh per store
+		if h.redis != nil {
+			h.leaderboardStale.Store(true)
+			h.redis.Del(ctx, leaderboardBuiltKey)
+		}
+	}
+	h.logger.Info("stores imported",
+		"user_id", userID,
+		"dry_run", dryRun,
+		"rows", report.TotalRows,
+		"created", report.Created,
+		"failed", report.Failed,
+	)
+	return report
+}
+
+//insertStoreBatch creates stores with COPY, together with their opening ledger
+//entries and initial status, and returns their ids in batch order
+func (h *Handler) insertStoreBatch(ctx context.Context, userID int, batch []importStore) ([]int, error) {
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := setRevisionActor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	//COPY cannot return ids, so take them from the sequence up front
+	rows, err := tx.QueryContext(ctx, "SELECT nextval(pg_get_serial_sequence('stores', 'id')) FROM generate_series(1, $1)", len(batch))
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate store ids: %w", err)
+	}
+	ids := make([]int, 0, len(batch))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) != len(batch) {
+		return nil, fmt.Errorf("allocated %d store ids for %d rows", len(ids), len(batch))
+	}
+
+	names := make([]string, len(batch))
+	for i, store := range batch {
+		names[i] = store.name
+	}
+	slugs, err := allocateStoreSlugs(ctx, tx, names, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate store slugs: %w", err)
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("stores", "id", "name", "revenue_cents", "currency", "total_orders", "status", "user_id", "slug"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start copy: %w", err)
+	}
+	for i, store := range batch {
+		if _, err := stmt.ExecContext(ctx, ids[i], store.name, store.revenue.Amount, store.revenue.Currency, 0, store.status, userID, slugs[i]); err != nil {
+			stmt.Close()
+			return nil, fmt.Errorf("failed to copy store on line %d: %w", store.line, err)
+		}
+	}
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return nil, fmt.Errorf("failed to copy stores: %w", err)
+	}
+	if err := stmt.Close(); err != nil {
+		return nil, err
+	}
+
+	//The same opening balance and initial status createStore records, for the whole batch
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO revenue_adjustments (store_id, kind, amount_cents, orders_delta, created_by)
+		SELECT id, $2, revenue_cents, 0, user_id FROM stores WHERE id = ANY($1)`,
+		pq.Array(ids), adjustmentOpeningBalance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record opening balances: %w", err)
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO store_status_history (store_id, from_status, to_status, changed_by)
+		SELECT id, NULL, status, user_id FROM stores WHERE id = ANY($1)`,
+		pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record store status history: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+//storeImportHandler serves POST /stores/import and GET /stores/import/{id} - REQUIRES AUTH
+func (h *Handler) storeImportHandler(w http.ResponseWriter, r *http.Request, path string) {
+	w.Header().Set("Content-Type", "application/json")
+
+	status := http.StatusOK
+	var body interface{}
+	var err error
+	allow := "POST"
+	if path == "import" {
+		status, body, err = h.importStoresRequest(w, r)
+	} else if id, convErr := strconv.ParseInt(strings.TrimPrefix(path, "import/"), 10, 64); convErr == nil {
+		allow = "GET"
+		body, err = h.storeImportStatusRequest(r, id)
+	} else {
+		err = &requestError{Status: http.StatusNotFound, Message: "import not found"}
+	}
+
+	if err == nil {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+		return
+	}
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		if reqErr.Status == http.StatusMethodNotAllowed {
+			w.Header().Set("Allow", allow)
+		}
+		w.WriteHeader(reqErr.Status)
+		json.NewEncoder(w).Encode(map[string]string{"error": reqErr.Message})
+		return
+	}
+	h.logger.Error("store import request failed", "path", path, "error", err.Error())
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write([]byte(`{"error": "Database error"}`))
+}
+
+//importStoresRequest reads an import and runs it, within the request when it
+//is small and as a background job otherwise or when async=true
+func (h *Handler) importStoresRequest(w http.ResponseWriter, r *http.Request) (int, interface{}, error) {
+	if r.Method != http.MethodPost {
+		return 0, nil, &requestError{Status: http.StatusMethodNotAllowed, Message: "method not allowed"}
+	}
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		h.logger.Warn("unauthorized store import attempt", "error", err.Error())
+		return 0, nil, &requestError{Status: http.StatusUnauthorized, Message: "authentication required"}
+	}
+
+	format, err := importFormat(r.Header.Get("Content-Type"))
+	if err != nil {
+		return 0, nil, err
+	}
+	query := r.URL.Query()
+	dryRun := query.Get("dryRun") == "true"
+	async := query.Get("async") == "true"
+
+	limit := maxImportBytes()
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	rows, err := parseImport(format, r.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return 0, nil, &requestError{Status: http.StatusRequestEntityTooLarge, Message: fmt.Sprintf("the file exceeds %d bytes", limit)}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if len(rows) <= importSyncRows && !async {
+		return http.StatusOK, h.importStores(r.Context(), userID, rows, dryRun, nil), nil
+	}
+
+	var jobID int64
+	err = h.database.QueryRowContext(r.Context(),
+		"INSERT INTO store_imports (user_id, format, dry_run, total_rows) VALUES ($1, $2, $3, $4) RETURNING id",
+		userID, format, dryRun, len(rows)).Scan(&jobID)
+	if err != nil {
+		return 0, nil, err
+	}
+	h.logger.Info("store import queued", "import_id", jobID, "user_id", userID, "rows", len(rows), "dry_run", dryRun)
+
+	//The request is over once the job is accepted, so the job gets its own context
+	go h.runImportJob(context.Background(), jobID, userID, rows, dryRun)
+
+	statusURL := fmt.Sprintf("/stores/import/%d", jobID)
+	w.Header().Set("Location", statusURL)
+	return http.StatusAccepted, map[string]interface{}{
+		"id":         jobID,
+		"status":     importRunning,
+		"total_rows": len(rows),
+		"status_url": statusURL,
+	}, nil
+}
+
+//runImportJob runs a background import, recording progress after every batch and the report at the end
+func (h *Handler) runImportJob(ctx context.Context, jobID int64, userID int, rows []importRow, dryRun bool) {
+	progress := func(report *importReport, processed int) {
+		_, err := h.database.ExecContext(ctx,
+			"UPDATE store_imports SET processed_rows = $2, created_rows = $3, failed_rows = $4, updated_at = NOW() WHERE id = $1",
+			jobID, processed, report.Created, report.Failed)
+		if err != nil {
+			h.logger.Warn("failed to record import progress", "import_id", jobID, "error", err.Error())
+		}
+	}
+
+	report := h.importStores(ctx, userID, rows, dryRun, progress)
+
+	body, err := json.Marshal(report.Rows)
+	if err != nil {
+		h.logger.Error("failed to encode import report", "import_id", jobID, "error", err.Error())
+		return
+	}
+	_, err = h.database.ExecContext(ctx,
+		`UPDATE store_imports SET status = $2, processed_rows = $3, created_rows = $4, failed_rows = $5,
+			report = $6, updated_at = NOW(), finished_at = NOW()
+		WHERE id = $1`,
+		jobID, importSucceeded, report.TotalRows, report.Created, report.Failed, body)
+	if err != nil {
+		h.logger.Error("failed to record import report", "import_id", jobID, "error", err.Error())
+	}
+}
+
+//storeImportStatusRequest reports a background import to its owner or an admin
+func (h *Handler) storeImportStatusRequest(r *http.Request, id int64) (map[string]interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, &requestError{Status: http.StatusMethodNotAllowed, Message: "method not allowed"}
+	}
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		h.logger.Warn("unauthorized store import status attempt", "import_id", id, "error", err.Error())
+		return nil, &requestError{Status: http.StatusUnauthorized, Message: "authentication required"}
+	}
+
+	ctx := r.Context()
+	var ownerID, total, processed, created, failed int
+	var format, status string
+	var dryRun bool
+	var report []byte
+	var jobErr sql.NullString
+	var createdAt, updatedAt time.Time
+	var finishedAt sql.NullTime
+	err = h.database.QueryRowContext(ctx,
+		`SELECT user_id, format, dry_run, status, total_rows, processed_rows, created_rows, failed_rows,
+			report, error, created_at, updated_at, finished_at
+		FROM store_imports WHERE id = $1`, id).
+		Scan(&ownerID, &format, &dryRun, &status, &total, &processed, &created, &failed,
+			&report, &jobErr, &createdAt, &updatedAt, &finishedAt)
+	if err == sql.ErrNoRows {
+		return nil, &requestError{Status: http.StatusNotFound, Message: "import not found"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ownerID != userID {
+		admin, err := isAdmin(ctx, h.database, userID)
+		if err != nil {
+			return nil, err
+		}
+		if !admin {
+			//Someone else's import is as good as missing
+			return nil, &requestError{Status: http.StatusNotFound, Message: "import not found"}
+		}
+	}
+
+	if status == importRunning && time.Since(updatedAt) > importStaleAfter {
+		status = importFailed
+		jobErr = sql.NullString{String: "the import stopped making progress, e.g. because the server restarted; rows reported as created were imported", Valid: true}
+		_, err := h.database.ExecContext(ctx,
+			"UPDATE store_imports SET status = $2, error = $3, finished_at = NOW() WHERE id = $1 AND status = $4",
+			id, importFailed, jobErr.String, importRunning)
+		if err != nil {
+			h.logger.Warn("failed to mark stale import failed", "import_id", id, "error", err.Error())
+		}
+	}
+
+	body := map[string]interface{}{
+		"id":             id,
+		"format":         format,
+		"dry_run":        dryRun,
+		"status":         status,
+		"total_rows":     total,
+		"processed_rows": processed,
+		"created":        created,
+		"failed":         failed,
+		"created_at":     createdAt.UTC().Format(time.RFC3339),
+	}
+	if dryRun {
+		body["valid"] = processed - failed
+	}
+	if finishedAt.Valid {
+		body["finished_at"] = finishedAt.Time.UTC().Format(time.RFC3339)
+	}
+	if jobErr.Valid {
+		body["error"] = jobErr.String
+	}
+	if report != nil {
+		body["rows"] = json.RawMessage(report)
+	}
+	return body, nil
+}
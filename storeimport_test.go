@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func TestParseCSVImport(t *testing.T) {
+	//ARRANGE: Columns in any order, a byte order mark and a short row
+	body := "\ufeffStatus, Name,revenue\n" +
+		"draft,Sticker Shop,12.50\n" +
+		"active,Short Row\n" +
+		",\"Quoted, Name\",\n"
+
+	//ACT: Parse it
+	rows, err := parseCSVImport(strings.NewReader(body))
+
+	//ASSERT: Rows by header name, with their line numbers
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := []importRow{
+		{Line: 2, Name: "Sticker Shop", Revenue: "12.50", Status: "draft"},
+		{Line: 3, Err: "expected 3 fields, got 2"},
+		{Line: 4, Name: "Quoted, Name"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("Expected %+v, got %+v", want, rows)
+	}
+}
+
+func TestParseCSVImport_BadHeader(t *testing.T) {
+	cases := map[string]string{
+		"":                   "the file is empty",
+		"name,owner\n":       `unknown column "owner"; expected name, revenue, currency and status`,
+		"revenue,currency\n": "the header must have a name column",
+		"name\n":             "the file has no rows",
+	}
+	for body, want := range cases {
+		_, err := parseCSVImport(strings.NewReader(body))
+		if err == nil || err.Error() != want {
+			t.Errorf("%q: expected %q, got %v", body, want, err)
+		}
+	}
+}
+
+func TestParseNDJSONImport(t *testing.T) {
+	//ARRANGE: Numbers or strings for revenue, a blank line and a bad line
+	body := `{"name": "Sticker Shop", "revenue": 12.5, "currency": "eur"}` + "\n" +
+		"\n" +
+		`{"name": "Draft Shop", "revenue": "0.99", "status": "draft"}` + "\n" +
+		`{"name": "Typo", "revnue": 1}` + "\n"
+
+	//ACT: Parse it
+	rows, err := parseNDJSONImport(strings.NewReader(body))
+
+	//ASSERT: One row per object, numbered by line
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+	if rows[0] != (importRow{Line: 1, Name: "Sticker Shop", Revenue: "12.5", Currency: "eur"}) {
+		t.Errorf("Unexpected first row: %+v", rows[0])
+	}
+	if rows[1] != (importRow{Line: 3, Name: "Draft Shop", Revenue: "0.99", Status: "draft"}) {
+		t.Errorf("Unexpected second row: %+v", rows[1])
+	}
+	if rows[2].Line != 4 || !strings.Contains(rows[2].Err, `unknown field "revnue"`) {
+		t.Errorf("Expected an unknown field error on line 4, got %+v", rows[2])
+	}
+}
+
+func TestValidateImportRow(t *testing.T) {
+	//ACT: A minimal row gets the createStore defaults
+	store, problems := validateImportRow(importRow{Line: 2, Name: "Sticker Shop"})
+
+	//ASSERT: Zero USD revenue and active
+	if len(problems) != 0 {
+		t.Fatalf("Expected no problems, got %v", problems)
+	}
+	if store.revenue != (Money{Amount: 0, Currency: "USD"}) || store.status != storeStatusActive {
+		t.Errorf("Unexpected store: %+v", store)
+	}
+
+	//ACT: Everything wrong at once
+	_, problems = validateImportRow(importRow{Line: 3, Revenue: "-5", Status: "suspended"})
+
+	//ASSERT: Every problem is reported, not just the first
+	want := []string{"name is required", "revenue cannot be negative", "new stores start as draft or active"}
+	if !reflect.DeepEqual(problems, want) {
+		t.Errorf("Expected %v, got %v", want, problems)
+	}
+}
+
+func TestStoresHandler_Import(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Two good rows and one without a name are copied in one batch
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT nextval\\(pg_get_serial_sequence\\('stores', 'id'\\)\\) FROM generate_series\\(1, \\$1\\)").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"nextval"}).AddRow(41).AddRow(42))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(storeSlugLockID, pq.Array([]string{"label-shop", "sticker-shop"})).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT slug FROM stores WHERE id <> \\$2").
+		WithArgs(pq.Array([]string{"label-shop", "sticker-shop"}), 0).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	copyIn := mock.ExpectPrepare("COPY \"stores\" \\(\"id\", \"name\", \"revenue_cents\", \"currency\", \"total_orders\", \"status\", \"user_id\", \"slug\"\\) FROM STDIN")
+	copyIn.ExpectExec().
+		WithArgs(41, "Sticker Shop", int64(1250), "USD", 0, "active", 1, "sticker-shop").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	copyIn.ExpectExec().
+		WithArgs(42, "Label Shop", int64(0), "EUR", 0, "draft", 1, "label-shop").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	copyIn.ExpectExec().
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO revenue_adjustments \\(store_id, kind, amount_cents, orders_delta, created_by\\) SELECT id").
+		WithArgs(pq.Array([]int{41, 42}), adjustmentOpeningBalance).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO store_status_history \\(store_id, from_status, to_status, changed_by\\) SELECT id").
+		WithArgs(pq.Array([]int{41, 42})).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	body := "name,revenue,currency,status\n" +
+		"Sticker Shop,12.50,,\n" +
+		",1.00,USD,active\n" +
+		"Label Shop,,eur,draft\n"
+	req := httptest.NewRequest(http.MethodPost, "/stores/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv; charset=utf-8")
+	authorize(t, fakeDB, req, 1)
+	w := httptest.NewRecorder()
+
+	//ACT: Import within the request
+	handler.storesHandler(w, req)
+
+	//ASSERT: A report for every row
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report importReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if report.TotalRows != 3 || report.Created != 2 || report.Failed != 1 {
+		t.Errorf("Unexpected counts: %+v", report)
+	}
+	want := []importRowResult{
+		{Line: 2, Name: "Sticker Shop", Status: importRowCreated, StoreID: 41},
+		{Line: 3, Status: importRowFailed, Errors: []string{"name is required"}},
+		{Line: 4, Name: "Label Shop", Status: importRowCreated, StoreID: 42},
+	}
+	if !reflect.DeepEqual(report.Rows, want) {
+		t.Errorf("Expected %+v, got %+v", want, report.Rows)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_ImportDryRun(t *testing.T) {
+	//ARRANGE: Create mock database; a dry run must not touch it
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	body := `{"name": "Sticker Shop", "revenue": "12.50"}` + "\n" + `{"name": "Bad", "currency": "dollars"}` + "\n"
+	req := httptest.NewRequest(http.MethodPost, "/stores/import?dryRun=true", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	authorize(t, fakeDB, req, 1)
+	w := httptest.NewRecorder()
+
+	//ACT: Validate only
+	handler.storesHandler(w, req)
+
+	//ASSERT: What would happen, and nothing written
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var report importReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode report: %v", err)
+	}
+	if !report.DryRun || report.Valid != 1 || report.Failed != 1 || report.Created != 0 {
+		t.Errorf("Unexpected counts: %+v", report)
+	}
+	if report.Rows[0].Status != importRowValid || report.Rows[1].Errors[0] != `invalid currency code "DOLLARS"` {
+		t.Errorf("Unexpected rows: %+v", report.Rows)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_ImportRejected(t *testing.T) {
+	fakeDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	cases := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		auth        bool
+		want        int
+	}{
+		{"no token", http.MethodPost, "text/csv", "name\nShop\n", false, http.StatusUnauthorized},
+		{"wrong method", http.MethodGet, "text/csv", "", true, http.StatusMethodNotAllowed},
+		{"unsupported type", http.MethodPost, "application/json", `[{"name": "Shop"}]`, true, http.StatusUnsupportedMediaType},
+		{"too large", http.MethodPost, "text/csv", "name\n" + strings.Repeat("Shop\n", 10), true, http.StatusRequestEntityTooLarge},
+	}
+	t.Setenv("MAX_IMPORT_BYTES", "32")
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/stores/import", strings.NewReader(c.body))
+		req.Header.Set("Content-Type", c.contentType)
+		if c.auth {
+			authorize(t, fakeDB, req, 1)
+		}
+		w := httptest.NewRecorder()
+
+		handler.storesHandler(w, req)
+
+		if w.Code != c.want {
+			t.Errorf("%s: expected status %d, got %d: %s", c.name, c.want, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRunImportJob(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: A dry run job records progress and then its report
+	mock.ExpectExec("UPDATE store_imports SET processed_rows = \\$2, created_rows = \\$3, failed_rows = \\$4, updated_at = NOW\\(\\) WHERE id = \\$1").
+		WithArgs(int64(7), 2, 0, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE store_imports SET status = \\$2").
+		WithArgs(int64(7), importSucceeded, 2, 0, 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	rows := []importRow{{Line: 2, Name: "Sticker Shop"}, {Line: 3}}
+
+	//ACT: Run the job
+	handler.runImportJob(context.Background(), 7, 1, rows, true)
+
+	//ASSERT: Progress and report were written
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_ImportStatusStale(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: A job that last made progress an hour ago
+	updated := time.Now().Add(-time.Hour)
+	mock.ExpectQuery("FROM store_imports WHERE id = \\$1").
+		WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "format", "dry_run", "status", "total_rows", "processed_rows",
+			"created_rows", "failed_rows", "report", "error", "created_at", "updated_at", "finished_at"}).
+			AddRow(1, importFormatCSV, false, importRunning, 5000, 1500, 1490, 10, nil, nil, updated.Add(-time.Minute), updated, nil))
+	mock.ExpectExec("UPDATE store_imports SET status = \\$2, error = \\$3, finished_at = NOW\\(\\) WHERE id = \\$1 AND status = \\$4").
+		WithArgs(int64(7), importFailed, sqlmock.AnyArg(), importRunning).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/import/7", nil)
+	authorize(t, fakeDB, req, 1)
+	w := httptest.NewRecorder()
+
+	//ACT: Poll the job
+	handler.storesHandler(w, req)
+
+	//ASSERT: Reported as failed with its progress so far
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var status map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status["status"] != importFailed || status["created"] != float64(1490) || status["error"] == nil {
+		t.Errorf("Unexpected status: %v", status)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+//requestError is a client mistake; REST handlers answer it with Status instead of a 500
+type requestError struct {
+	Status  int
+	Message string
+}
+
+func (e *requestError) Error() string {
+	return e.Message
+}
+
+//storePatchFields are the store fields a partial update may set, in SET clause order.
+//Revenue and total_orders are left out: they are recomputed from the revenue ledger.
+var storePatchFields = []struct {
+	name   string
+	column string
+}{
+	{"name", "name"},
+}
+
+//ledgerStoreFields are store fields derived from the revenue ledger, which a
+//partial update is told it cannot set instead of seeing them as unknown
+var ledgerStoreFields = map[string]bool{"revenue": true, "total_orders": true}
+
+//isStorePatchField reports whether name is a field a partial update may set
+func isStorePatchField(name string) bool {
+	for _, f := range storePatchFields {
+		if f.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+//storePatch holds the fields a caller sent for a partial update. A field that
+//was sent as null is present with a nil value; a field that was left out is absent.
+type storePatch map[string]interface{}
+
+//fields lists the fields present in the patch, for logging
+func (patch storePatch) fields() []string {
+	var names []string
+	for _, f := range storePatchFields {
+		if _, ok := patch[f.name]; ok {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}
+
+//setClause validates the patch and builds the SET clause and its arguments,
+//numbered from $1
+func (patch storePatch) setClause() (string, []interface{}, error) {
+	var assignments []string
+	var args []interface{}
+	for _, f := range storePatchFields {
+		value, ok := patch[f.name]
+		if !ok {
+			continue
+		}
+		//Every patchable column is NOT NULL
+		if value == nil {
+			return "", nil, &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("%s cannot be null", f.name)}
+		}
+
+		switch f.name {
+		case "name":
+			name, _ := value.(string)
+			name = strings.TrimSpace(name)
+			if name == "" {
+				return "", nil, &requestError{Status: http.StatusBadRequest, Message: "name cannot be empty"}
+			}
+			value = name
+		}
+
+		args = append(args, value)
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", f.column, len(args)))
+	}
+	return strings.Join(assignments, ", "), args, nil
+}
+
+//graphqlStorePatch collects the updateStore arguments the query actually passed.
+//graphql-go drops null arguments from p.Args, so explicit nulls are found by
+//looking for arguments bound to a variable whose value was sent as null.
+func graphqlStorePatch(p graphql.ResolveParams) storePatch {
+	patch := storePatch{}
+	for _, f := range storePatchFields {
+		if value, ok := p.Args[f.name]; ok {
+			patch[f.name] = value
+		}
+	}
+
+	if len(p.Info.FieldASTs) == 0 {
+		return patch
+	}
+	for _, arg := range p.Info.FieldASTs[0].Arguments {
+		if arg.Name == nil || !isStorePatchField(arg.Name.Value) {
+			continue
+		}
+		if _, ok := patch[arg.Name.Value]; ok {
+			continue
+		}
+		variable, ok := arg.Value.(*ast.Variable)
+		if !ok || variable.Name == nil {
+			continue
+		}
+		if value, sent := p.Info.VariableValues[variable.Name.Value]; sent && value == nil {
+			patch[arg.Name.Value] = nil
+		}
+	}
+	return patch
+}
+
+//jsonStorePatch reads a PATCH body. Only the keys present in the JSON object are
+//updated, and a key set to null is kept as an explicit null.
+func jsonStorePatch(body io.Reader) (storePatch, error) {
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&raw); err != nil {
+		return nil, &requestError{Status: http.StatusBadRequest, Message: "body must be a JSON object"}
+	}
+
+	patch := storePatch{}
+	for key, value := range raw {
+		if ledgerStoreFields[key] {
+			return nil, &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("%s is derived from the store's orders and cannot be set", key)}
+		}
+		if !isStorePatchField(key) {
+			return nil, &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("unknown field %q", key)}
+		}
+		if string(value) == "null" {
+			patch[key] = nil
+			continue
+		}
+
+		invalid := &requestError{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid %s", key)}
+		switch key {
+		case "name":
+			var name string
+			if err := json.Unmarshal(value, &name); err != nil {
+				return nil, invalid
+			}
+			patch[key] = name
+		}
+	}
+	return patch, nil
+}
+
+//storesHandler serves /stores/{id}: GET reads a store and PATCH partially updates
+//it (REQUIRES AUTH + OWNERSHIP). Both send the store's ETag; GET honours
+//If-None-Match and PATCH honours If-Match. /stores/{id}/revenue is the CSV
+//revenue series; /stores/import, /stores/export and /stores/by-slug/{slug}
+//have handlers of their own.
+func (h *Handler) storesHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/stores/")
+	if path == "import" || strings.HasPrefix(path, "import/") {
+		h.storeImportHandler(w, r, path)
+		return
+	}
+	if path == "export" {
+		h.storeExportHandler(w, r)
+		return
+	}
+	if slug, ok := strings.CutPrefix(path, "by-slug/"); ok && slug != "" {
+		h.storeBySlugHandler(w, r, slug)
+		return
+	}
+	if idPart, ok := strings.CutSuffix(path, "/revenue"); ok {
+		if id, err := strconv.Atoi(idPart); err == nil {
+			h.revenueSeriesCSV(w, r, id)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	id, err := strconv.Atoi(path)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "store not found"}`))
+		return
+	}
+
+	var store map[string]interface{}
+	switch r.Method {
+	case http.MethodGet:
+		store, err = loadStore(r.Context(), h.database, id)
+		if err == nil && storeHiddenFrom(store, h.viewerID(r)) {
+			err = sql.ErrNoRows
+		}
+		if err == sql.ErrNoRows {
+			err = &requestError{Status: http.StatusNotFound, Message: "store not found"}
+		}
+		if err == nil && writeNotModified(w, r, storeETag(store["version"].(int))) {
+			return
+		}
+	case http.MethodPatch:
+		store, err = h.patchStoreRequest(r, id)
+	default:
+		w.Header().Set("Allow", "GET, PATCH")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error": "method not allowed"}`))
+		return
+	}
+
+	if err == nil {
+		w.Header().Set("ETag", storeETag(store["version"].(int)))
+		json.NewEncoder(w).Encode(storeJSON(store))
+		return
+	}
+
+	var reqErr *requestError
+	var conflict *versionConflictError
+	switch {
+	case errors.As(err, &reqErr):
+		w.WriteHeader(reqErr.Status)
+		json.NewEncoder(w).Encode(map[string]string{"error": reqErr.Message})
+	case errors.As(err, &conflict):
+		w.Header().Set("ETag", storeETag(conflict.Current))
+		w.WriteHeader(http.StatusPreconditionFailed)
+		json.NewEncoder(w).Encode(map[string]string{"error": conflict.Error()})
+	default:
+		h.logger.Error("store request failed", "store_id", id, "method", r.Method, "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Database error"}`))
+	}
+}
+
+//patchStoreRequest authenticates a PATCH /stores/{id} and applies its body
+func (h *Handler) patchStoreRequest(r *http.Request, id int) (map[string]interface{}, error) {
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		h.logger.Warn("unauthorized store patch attempt", "store_id", id, "error", err.Error())
+		return nil, &requestError{Status: http.StatusUnauthorized, Message: "authentication required"}
+	}
+
+	expectedVersion, err := ifMatchVersion(r.Header.Get("If-Match"))
+	if err != nil {
+		return nil, err
+	}
+	patch, err := jsonStorePatch(io.LimitReader(r.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	return h.updateStore(r.Context(), userID, id, patch, expectedVersion)
+}
+
+//storeJSON shapes a store map for REST responses, with revenue as an exact JSON number
+func storeJSON(store map[string]interface{}) map[string]interface{} {
+	body := map[string]interface{}{}
+	for key, value := range store {
+		body[key] = value
+	}
+	if revenue, ok := store["revenue"].(Money); ok {
+		body["revenue"] = json.RawMessage(revenue.Decimal())
+	}
+	return body
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/graphql-go/graphql"
+)
+
+//storeRows returns the columns loadStore scans
+func storeRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "name", "revenue_cents", "currency", "total_orders", "status", "version", "user_id", "slug", "logo_key"})
+}
+
+func TestUpdateStoreResolver_OnlyProvidedFields(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Only the name is sent, so only the name is written
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT slug FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("my-store"))
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WithArgs(storeSlugLockID, pq.Array([]string{"renamed"})).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT slug FROM stores WHERE id <> \\$2").
+		WithArgs(pq.Array([]string{"renamed"}), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}))
+	mock.ExpectExec("UPDATE stores SET name = \\$1, slug = \\$2 WHERE id = \\$3").
+		WithArgs("Renamed", "renamed", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO store_slug_history \\(slug, store_id\\)").
+		WithArgs("my-store", 1, "renamed").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(storeRows().AddRow(1, "Renamed", int64(7500000), "USD", 500, "active", 2, 1, "renamed", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 1, "name": " Renamed "},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.updateStoreResolver(params)
+
+	//ASSERT: Revenue and orders are untouched
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	store := result.(map[string]interface{})
+	if store["total_orders"] != 500 || store["revenue"] != (Money{Amount: 7500000, Currency: "USD"}) {
+		t.Errorf("Expected other fields to be kept, got %v", store)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestUpdateStoreMutation_ExplicitNull(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	schema, err := createSchema(handler)
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	//ACT: Send the name as a null variable
+	result := graphql.Do(graphql.Params{
+		Schema:         schema,
+		RequestString:  `mutation ($name: String) { updateStore(id: 1, name: $name) { id } }`,
+		VariableValues: map[string]interface{}{"name": nil},
+		Context:        authContext(t, fakeDB, 1),
+	})
+
+	//ASSERT: The null is rejected instead of being ignored or written as empty
+	if len(result.Errors) != 1 || result.Errors[0].Message != "name cannot be null" {
+		t.Errorf("Expected null name error, got %v", result.Errors)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_Patch(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT slug FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("my-store"))
+	mock.ExpectExec("UPDATE stores SET name = \\$1 WHERE id = \\$2").
+		WithArgs("My Store", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(storeRows().AddRow(1, "My Store", int64(1250), "USD", 12, "active", 2, 1, "my-store", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/stores/1", strings.NewReader(`{"name": "My Store"}`))
+	authorize(t, fakeDB, req, 1)
+	w := httptest.NewRecorder()
+
+	//ACT: Patch the store
+	handler.storesHandler(w, req)
+
+	//ASSERT: Updated store is returned with an exact revenue
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.Contains(body, `"name":"My Store"`) || !strings.Contains(body, `"revenue":12.50`) {
+		t.Errorf("Unexpected body: %s", body)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_RejectsBadPatches(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	cases := []struct {
+		method, body string
+		want         int
+	}{
+		{http.MethodPatch, `{"status": "closed"}`, http.StatusBadRequest},
+		{http.MethodPatch, `{"revenue": 12.50}`, http.StatusBadRequest},
+		{http.MethodPatch, `{"total_orders": 12}`, http.StatusBadRequest},
+		{http.MethodPatch, `[1, 2]`, http.StatusBadRequest},
+		{http.MethodPut, `{"name": "x"}`, http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/stores/1", strings.NewReader(c.body))
+		authorize(t, fakeDB, req, 1)
+		w := httptest.NewRecorder()
+
+		handler.storesHandler(w, req)
+
+		if w.Code != c.want {
+			t.Errorf("%s %s: expected %d, got %d", c.method, c.body, c.want, w.Code)
+		}
+	}
+
+	//ASSERT: Nothing reached the database
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
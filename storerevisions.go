@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+const (
+	defaultRevisionPageSize = 50
+	maxRevisionPageSize     = 200
+)
+
+//dateTimeScalar is an RFC 3339 timestamp such as 2026-06-01T00:00:00Z
+var dateTimeScalar = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "DateTime",
+	Description: "An RFC 3339 timestamp such as 2026-06-01T00:00:00Z",
+	Serialize: func(value interface{}) interface{} {
+		switch v := value.(type) {
+		case time.Time:
+			return v.Format(time.RFC3339)
+		case string:
+			return v
+		}
+		return nil
+	},
+	ParseValue: parseDateTime,
+	ParseLiteral: func(valueAST ast.Value) interface{} {
+		if v, ok := valueAST.(*ast.StringValue); ok {
+			return parseDateTime(v.Value)
+		}
+		return nil
+	},
+})
+
+//parseDateTime reads a DateTime input, nil if it is not RFC 3339
+func parseDateTime(value interface{}) interface{} {
+	raw, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+//setRevisionActor tells the store revision trigger which user makes the
+//changes in tx. The setting is transaction-local, so it never leaks to
+//another request through the connection pool.
+func setRevisionActor(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, "SELECT set_config('app.user_id', $1, true)", strconv.Itoa(userID))
+	return err
+}
+
+//storeSnapshot is the part of a revision's row image the Store type shows
+type storeSnapshot struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	RevenueCents int64   `json:"revenue_cents"`
+	Currency     string  `json:"currency"`
+	TotalOrders  int     `json:"total_orders"`
+	Status       string  `json:"status"`
+	Version      int     `json:"version"`
+	UserID       *int    `json:"user_id"`
+	Slug         string  `json:"slug"`
+	DeletedAt    *string `json:"deleted_at"`
+}
+
+//storeMap turns the snapshot into the map the Store GraphQL type resolves from
+func (s storeSnapshot) storeMap() map[string]interface{} {
+	revenue := Money{Amount: s.RevenueCents, Currency: s.Currency}
+	store := map[string]interface{}{
+		"id":           s.ID,
+		"name":         s.Name,
+		"revenue":      revenue,
+		"currency":     revenue.Currency,
+		"total_orders": s.TotalOrders,
+		"status":       s.Status,
+		"version":      s.Version,
+		"user_id":      nil,
+		"slug":         nil,
+	}
+	if s.UserID != nil {
+		store["user_id"] = *s.UserID
+	}
+	//Revisions from before slugs existed have none
+	if s.Slug != "" {
+		store["slug"] = s.Slug
+	}
+	return store
+}
+
+//storeAsOf reads a store as it was at a point in time from its revisions
+func (h *Handler) storeAsOf(ctx context.Context, id int, asOf time.Time) (map[string]interface{}, error) {
+	var after []byte
+	err := h.database.QueryRowContext(ctx,
+		"SELECT after FROM store_revisions WHERE store_id = $1 AND changed_at <= $2 ORDER BY id DESC LIMIT 1", id, asOf).
+		Scan(&after)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d has no history at %s", id, asOf.UTC().Format(time.RFC3339))
+	}
+	if err != nil {
+		h.logger.Error("database error loading store revision", "store_id", id, "as_of", asOf, "error", err.Error())
+		return nil, err
+	}
+
+	var snapshot storeSnapshot
+	if err := json.Unmarshal(after, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to decode revision of store %d: %w", id, err)
+	}
+	if snapshot.DeletedAt != nil {
+		return nil, fmt.Errorf("store with id %d was deleted at %s", id, asOf.UTC().Format(time.RFC3339))
+	}
+	return snapshot.storeMap(), nil
+}
+
+//changedFields lists the columns that differ between two row images, version aside
+func changedFields(before, after []byte) []string {
+	var oldRow, newRow map[string]json.RawMessage
+	json.Unmarshal(before, &oldRow)
+	json.Unmarshal(after, &newRow)
+
+	var fields []string
+	for key, value := range newRow {
+		if key == "version" {
+			continue
+		}
+		if previous, ok := oldRow[key]; !ok || string(previous) != string(value) {
+			fields = append(fields, key)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+//storeHistoryResolver lists a store's revisions, newest first - REQUIRES AUTH + OWNERSHIP OR ADMIN
+func (h *Handler) storeHistoryResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized store history attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+	limit := defaultRevisionPageSize
+	if l, ok := p.Args["limit"].(int); ok {
+		if l < 1 || l > maxRevisionPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxRevisionPageSize)
+		}
+		limit = l
+	}
+
+	//Deleted stores keep their history until they are purged
+	ctx := p.Context
+	ownerID, err := storeOwnerID(ctx, h.database, id)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading store owner", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+	if ownerID != userID {
+		admin, err := isAdmin(ctx, h.database, userID)
+		if err != nil {
+			h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+			return nil, err
+		}
+		if !admin {
+			return nil, fmt.Errorf("you can only view the history of your own stores")
+		}
+	}
+
+	query := `SELECT id, version, operation, changed_by, changed_at, before, after
+		FROM store_revisions WHERE store_id = $1`
+	args := []interface{}{id}
+	if before, ok := p.Args["before"].(int); ok {
+		args = append(args, before)
+		query += " AND id < $2"
+	}
+	args = append(args, limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := h.database.QueryContext(ctx, query, args...)
+	if err != nil {
+		h.logger.Error("database error loading store history", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []map[string]interface{}{}
+	for rows.Next() {
+		var revisionID int64
+		var version int
+		var operation string
+		var changedBy sql.NullInt64
+		var changedAt time.Time
+		var before, after []byte
+		if err := rows.Scan(&revisionID, &version, &operation, &changedBy, &changedAt, &before, &after); err != nil {
+			return nil, err
+		}
+
+		revision := map[string]interface{}{
+			"id":             int(revisionID),
+			"version":        version,
+			"operation":      operation,
+			"changed_by":     nil,
+			"changed_at":     changedAt,
+			"before":         nil,
+			"after":          string(after),
+			"changed_fields": changedFields(before, after),
+		}
+		if changedBy.Valid {
+			revision["changed_by"] = int(changedBy.Int64)
+		}
+		if before != nil {
+			revision["before"] = string(before)
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+//storeRevisionType is one recorded change of a store in GraphQL
+var storeRevisionType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "StoreRevision",
+	Fields: graphql.Fields{
+		"id":         &graphql.Field{Type: graphql.Int},
+		"version":    &graphql.Field{Type: graphql.Int},
+		"operation":  &graphql.Field{Type: graphql.String, Description: "create, update, delete or restore"},
+		"changed_by": &graphql.Field{Type: graphql.Int, Description: "User who made the change, null for system changes"},
+		"changed_at": &graphql.Field{Type: dateTimeScalar},
+		"before":     &graphql.Field{Type: graphql.String, Description: "The store row as JSON before the change, null for create"},
+		"after":      &graphql.Field{Type: graphql.String, Description: "The store row as JSON after the change"},
+		"changed_fields": &graphql.Field{
+			Type:        graphql.NewList(graphql.String),
+			Description: "Columns that differ between before and after",
+		},
+	},
+})
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+func TestStoreQuery_AsOf(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Last Tuesday the store still had its old name
+	asOf := time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT after FROM store_revisions WHERE store_id = \\$1 AND changed_at <= \\$2 ORDER BY id DESC LIMIT 1").
+		WithArgs(5, asOf).
+		WillReturnRows(sqlmock.NewRows([]string{"after"}).AddRow(
+			`{"id": 5, "name": "Old Name", "revenue_cents": 123456, "currency": "USD", "total_orders": 12, "status": "active", "version": 7, "user_id": 1, "deleted_at": null}`))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	schema, err := createSchema(handler)
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+
+	//ACT: Read the store as of that time
+	result := graphql.Do(graphql.Params{
+		Schema:        schema,
+		RequestString: `{ store(id: 5, asOf: "2026-03-03T12:00:00Z") { name revenue { amount } total_orders version } }`,
+		Context:       context.Background(),
+	})
+
+	//ASSERT: The historical values are returned
+	if len(result.Errors) > 0 {
+		t.Fatalf("Unexpected errors: %v", result.Errors)
+	}
+	store := result.Data.(map[string]interface{})["store"].(map[string]interface{})
+	if store["name"] != "Old Name" || store["total_orders"] != 12 || store["version"] != 7 {
+		t.Errorf("Unexpected store: %v", store)
+	}
+	if amount := store["revenue"].(map[string]interface{})["amount"]; amount != "1234.56" {
+		t.Errorf("Expected revenue 1234.56, got %v", amount)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoreAsOf_Deleted(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT after FROM store_revisions").
+		WillReturnRows(sqlmock.NewRows([]string{"after"}).AddRow(
+			`{"id": 5, "name": "Gone", "revenue_cents": 0, "currency": "USD", "total_orders": 0, "status": "active", "version": 9, "user_id": 1, "deleted_at": "2026-03-01T10:00:00+00:00"}`))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Read the store at a time it was deleted
+	_, err = handler.storeAsOf(context.Background(), 5, time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC))
+
+	//ASSERT: Not found at that time
+	if err == nil || err.Error() != "store with id 5 was deleted at 2026-03-02T00:00:00Z" {
+		t.Errorf("Expected deleted error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoreHistoryResolver(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Owner 1 renamed store 5 after creating it; the rename is newest
+	changedAt := time.Date(2026, 3, 3, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery("SELECT id, version, operation, changed_by, changed_at, before, after FROM store_revisions WHERE store_id = \\$1 ORDER BY id DESC LIMIT \\$2").
+		WithArgs(5, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "version", "operation", "changed_by", "changed_at", "before", "after"}).
+			AddRow(12, 2, "update", 1, changedAt, []byte(`{"name": "Old", "version": 1}`), []byte(`{"name": "New", "version": 2}`)).
+			AddRow(10, 1, "create", nil, changedAt.Add(-time.Hour), nil, []byte(`{"name": "Old", "version": 1}`)))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 5},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.storeHistoryResolver(params)
+
+	//ASSERT: Newest first, with who changed what
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	revisions := result.([]map[string]interface{})
+	if len(revisions) != 2 {
+		t.Fatalf("Expected 2 revisions, got %d", len(revisions))
+	}
+	if revisions[0]["changed_by"] != 1 || !reflect.DeepEqual(revisions[0]["changed_fields"], []string{"name"}) {
+		t.Errorf("Unexpected rename revision: %v", revisions[0])
+	}
+	if revisions[1]["before"] != nil || revisions[1]["changed_by"] != nil || revisions[1]["operation"] != "create" {
+		t.Errorf("Unexpected create revision: %v", revisions[1])
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoreHistoryResolver_NotOwner(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args:    map[string]interface{}{"id": 5},
+	}
+
+	//ACT: Call the resolver as someone else
+	_, err = handler.storeHistoryResolver(params)
+
+	//ASSERT: Rejected before reading any revision
+	if err == nil || err.Error() != "you can only view the history of your own stores" {
+		t.Errorf("Expected ownership error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+//States of a scheduled store status change
+const (
+	scheduleStatePending   = "pending"
+	scheduleStateApplied   = "applied"
+	scheduleStateFailed    = "failed"
+	scheduleStateCancelled = "cancelled"
+)
+
+//storeScheduleColumns are the store_status_schedules columns scanSchedule reads, in order
+const storeScheduleColumns = "id, store_id, status, run_at, reason, state, error, created_by, created_at, processed_at"
+
+//scanSchedule reads a store_status_schedules row into the map returned by GraphQL
+func scanSchedule(row interface{ Scan(...interface{}) error }) (map[string]interface{}, error) {
+	var id, storeID int
+	var status, reason, state string
+	var runAt, createdAt time.Time
+	var scheduleErr sql.NullString
+	var createdBy sql.NullInt64
+	var processedAt sql.NullTime
+	if err := row.Scan(&id, &storeID, &status, &runAt, &reason, &state, &scheduleErr, &createdBy, &createdAt, &processedAt); err != nil {
+		return nil, err
+	}
+
+	schedule := map[string]interface{}{
+		"id":           id,
+		"store_id":     storeID,
+		"status":       status,
+		"run_at":       runAt.UTC().Format(time.RFC3339),
+		"reason":       reason,
+		"state":        state,
+		"error":        nil,
+		"created_by":   nil,
+		"created_at":   createdAt.UTC().Format(time.RFC3339),
+		"processed_at": nil,
+	}
+	if scheduleErr.Valid {
+		schedule["error"] = scheduleErr.String
+	}
+	if createdBy.Valid {
+		schedule["created_by"] = int(createdBy.Int64)
+	}
+	if processedAt.Valid {
+		schedule["processed_at"] = processedAt.Time.UTC().Format(time.RFC3339)
+	}
+	return schedule, nil
+}
+
+//scheduleStoreStatusResolver plans a status change for a later time - REQUIRES AUTH + OWNERSHIP.
+//The same rules as setStoreStatus apply; whether the transition is allowed is
+//checked when the change fires, since earlier schedules may move the store first.
+func (h *Handler) scheduleStoreStatusResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized store schedule attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+	status, _ := p.Args["status"].(string)
+	reason, _ := p.Args["reason"].(string)
+	reason = strings.TrimSpace(reason)
+	at, _, err := timeArg(p.Args, "at")
+	if err != nil {
+		return nil, err
+	}
+	if !at.Valid || !at.Time.After(time.Now()) {
+		return nil, fmt.Errorf("at must be in the future")
+	}
+
+	ctx := p.Context
+	var ownerID int
+	var current string
+	err = h.database.QueryRowContext(ctx, "SELECT user_id, status FROM stores WHERE id = $1 AND deleted_at IS NULL", id).Scan(&ownerID, &current)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading store status", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	admin, err := isAdmin(ctx, h.database, userID)
+	if err != nil {
+		h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+	if err := checkStoreStatusChange(admin, ownerID == userID, current, status, reason); err != nil {
+		h.logger.Warn("store schedule rejected", "store_id", id, "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+	if current == storeStatusClosed {
+		return nil, fmt.Errorf("store %d is closed", id)
+	}
+
+	query := `INSERT INTO store_status_schedules (store_id, status, run_at, reason, created_by)
+		VALUES ($1, $2, $3, $4, $5) RETURNING ` + storeScheduleColumns
+	schedule, err := scanSchedule(h.database.QueryRowContext(ctx, query, id, status, at.Time.UTC(), reason, userID))
+	if err != nil {
+		h.logger.Error("database error scheduling store status", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("store status change scheduled",
+		"store_id", id,
+		"status", status,
+		"run_at", schedule["run_at"],
+		"user_id", userID,
+	)
+	return schedule, nil
+}
+
+//cancelStoreStatusScheduleResolver drops a pending scheduled change - REQUIRES AUTH + OWNERSHIP
+func (h *Handler) cancelStoreStatusScheduleResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized store schedule cancel attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+
+	ctx := p.Context
+	var storeID, ownerID int
+	err = h.database.QueryRowContext(ctx,
+		"SELECT s.store_id, st.user_id FROM store_status_schedules s JOIN stores st ON st.id = s.store_id WHERE s.id = $1", id).
+		Scan(&storeID, &ownerID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("schedule with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading store schedule", "schedule_id", id, "error", err.Error())
+		return nil, err
+	}
+	if ownerID != userID {
+		admin, err := isAdmin(ctx, h.database, userID)
+		if err != nil {
+			h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+			return nil, err
+		}
+		if !admin {
+			return nil, fmt.Errorf("you can only manage your own stores")
+		}
+	}
+
+	//Only a pending change can be cancelled; the scheduler may have just claimed it
+	query := "UPDATE store_status_schedules SET state = $1, processed_at = NOW() WHERE id = $2 AND state = $3 RETURNING " + storeScheduleColumns
+	schedule, err := scanSchedule(h.database.QueryRowContext(ctx, query, scheduleStateCancelled, id, scheduleStatePending))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("schedule %d is no longer pending", id)
+	}
+	if err != nil {
+		h.logger.Error("database error cancelling store schedule", "schedule_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("store status schedule cancelled", "schedule_id", id, "store_id", storeID, "user_id", userID)
+	return schedule, nil
+}
+
+//storeScheduleResolver lists the pending scheduled changes of a store, soonest first - store owner or admin only
+func (h *Handler) storeScheduleResolver(p graphql.ResolveParams) (interface{}, error) {
+	store, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		return nil, err
+	}
+	if store["user_id"] != userID {
+		admin, err := isAdmin(p.Context, h.database, userID)
+		if err != nil {
+			h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+			return nil, err
+		}
+		if !admin {
+			return nil, fmt.Errorf("you can only manage your own stores")
+		}
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		"SELECT "+storeScheduleColumns+" FROM store_status_schedules WHERE store_id = $1 AND state = $2 ORDER BY run_at, id",
+		store["id"], scheduleStatePending)
+	if err != nil {
+		h.logger.Error("database error loading store schedule", "store_id", store["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	schedules := []map[string]interface{}{}
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, schedule)
+	}
+	return schedules, rows.Err()
+}
+
+//applyNextStoreStatusChange claims the oldest due schedule and applies it in one
+//transaction. The row is locked with SKIP LOCKED and marked as processed before
+//commit, so each change fires exactly once however many instances are polling.
+//It returns false when nothing is due.
+func (h *Handler) applyNextStoreStatusChange(ctx context.Context) (bool, error) {
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var scheduleID, storeID int
+	var status, reason string
+	var createdBy sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, store_id, status, reason, created_by FROM store_status_schedules
+		WHERE state = $1 AND run_at <= NOW()
+		ORDER BY run_at, id LIMIT 1 FOR UPDATE SKIP LOCKED`, scheduleStatePending).
+		Scan(&scheduleID, &storeID, &status, &reason, &createdBy)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to claim store schedule: %w", err)
+	}
+
+	var current string
+	var deleted bool
+	err = tx.QueryRowContext(ctx, "SELECT status, deleted_at IS NOT NULL FROM stores WHERE id = $1 FOR UPDATE", storeID).
+		Scan(&current, &deleted)
+	if err != nil {
+		return false, fmt.Errorf("failed to lock store %d: %w", storeID, err)
+	}
+
+	//A change that is no longer possible is marked failed rather than retried forever.
+	//deleteStore cancels pending changes, but one planned while the delete was
+	//in flight can still come due on a deleted store.
+	failure := ""
+	if deleted {
+		failure = fmt.Sprintf("store %d is deleted", storeID)
+	} else if !canTransitionStore(current, status) {
+		failure = fmt.Sprintf("store cannot move from %s to %s", current, status)
+	} else if current == storeStatusSuspended || status == storeStatusSuspended {
+		//An admin may have suspended the store after its owner planned the change,
+		//so who planned it is checked against the store as it is now
+		admin, err := isAdmin(ctx, tx, int(createdBy.Int64))
+		if err != nil {
+			return false, fmt.Errorf("failed to check who scheduled store schedule %d: %w", scheduleID, err)
+		}
+		if err := checkStoreStatusChange(admin, true, current, status, reason); err != nil {
+			failure = err.Error()
+		}
+	}
+	if failure != "" {
+		_, err = tx.ExecContext(ctx, "UPDATE store_status_schedules SET state = $1, error = $2, processed_at = NOW() WHERE id = $3",
+			scheduleStateFailed, failure, scheduleID)
+		if err != nil {
+			return false, fmt.Errorf("failed to mark store schedule %d failed: %w", scheduleID, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return false, err
+		}
+		h.logger.Warn("scheduled store status change failed",
+			"schedule_id", scheduleID,
+			"store_id", storeID,
+			"error", failure,
+		)
+		return true, nil
+	}
+
+	if reason == "" {
+		reason = "scheduled"
+	}
+	//The revision is attributed to whoever planned the change
+	if createdBy.Valid {
+		if err := setRevisionActor(ctx, tx, int(createdBy.Int64)); err != nil {
+			return false, err
+		}
+	}
+	change, err := transitionStore(ctx, tx, storeID, current, status, int(createdBy.Int64), reason)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE store_status_schedules SET state = $1, processed_at = NOW() WHERE id = $2",
+		scheduleStateApplied, scheduleID)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark store schedule %d applied: %w", scheduleID, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	h.logger.Info("scheduled store status change applied",
+		"schedule_id", scheduleID,
+		"store_id", storeID,
+		"from", change.From,
+		"to", change.To,
+	)
+	h.invalidateStoreCache(storeID)
+	h.refreshStoreLeaderboard(ctx, storeID)
+	h.publishEvent(ctx, eventStoreStatusChanged, change)
+	return true, nil
+}
+
+//applyDueStoreStatusChanges applies every schedule that is due and returns how many were processed
+func (h *Handler) applyDueStoreStatusChanges(ctx context.Context) (int, error) {
+	processed := 0
+	for {
+		applied, err := h.applyNextStoreStatusChange(ctx)
+		if err != nil {
+			return processed, err
+		}
+		if !applied {
+			return processed, nil
+		}
+		processed++
+	}
+}
+
+//runStoreStatusScheduler applies due scheduled status changes every interval until ctx is done
+func (h *Handler) runStoreStatusScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := h.applyDueStoreStatusChanges(ctx); err != nil {
+			h.logger.Error("store status scheduler failed", "error", err.Error())
+		}
+	}
+}
+
+//storeStatusScheduleType is a planned store status change in GraphQL
+var storeStatusScheduleType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "StoreStatusSchedule",
+	Fields: graphql.Fields{
+		"id":           &graphql.Field{Type: graphql.Int},
+		"store_id":     &graphql.Field{Type: graphql.Int},
+		"status":       &graphql.Field{Type: storeStatusEnum},
+		"run_at":       &graphql.Field{Type: graphql.String},
+		"reason":       &graphql.Field{Type: graphql.String},
+		"state":        &graphql.Field{Type: graphql.String, Description: "pending, applied, failed or cancelled"},
+		"error":        &graphql.Field{Type: graphql.String},
+		"created_by":   &graphql.Field{Type: graphql.Int},
+		"created_at":   &graphql.Field{Type: graphql.String},
+		"processed_at": &graphql.Field{Type: graphql.String},
+	},
+})
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+//scheduleClaimQuery matches the scheduler's claim of the next due schedule
+const scheduleClaimQuery = "SELECT id, store_id, status, reason, created_by FROM store_status_schedules (.+) FOR UPDATE SKIP LOCKED"
+
+func TestApplyDueStoreStatusChanges_AppliesDueChange(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Schedule 3 closes pop-up store 5; the change and its bookkeeping share a transaction
+	mock.ExpectBegin()
+	mock.ExpectQuery(scheduleClaimQuery).
+		WithArgs("pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "reason", "created_by"}).AddRow(3, 5, "closed", "Sale is over", 1))
+	mock.ExpectQuery("SELECT status, deleted_at IS NOT NULL FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "deleted"}).AddRow("active", false))
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE stores SET status = \\$1 WHERE id = \\$2").
+		WithArgs("closed", 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO store_status_history").
+		WithArgs(5, "active", "closed", "Sale is over", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE store_status_schedules SET state = \\$1, processed_at = NOW\\(\\) WHERE id = \\$2").
+		WithArgs("applied", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	//ARRANGE: Nothing else is due (or another instance holds it)
+	mock.ExpectBegin()
+	mock.ExpectQuery(scheduleClaimQuery).
+		WithArgs("pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "reason", "created_by"}))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Run the scheduler once
+	processed, err := handler.applyDueStoreStatusChanges(context.Background())
+
+	//ASSERT: One change applied
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if processed != 1 {
+		t.Errorf("Expected 1 processed schedule, got %d", processed)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestApplyDueStoreStatusChanges_MarksImpossibleChangeFailed(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 5 was closed before its scheduled reopening came due
+	mock.ExpectBegin()
+	mock.ExpectQuery(scheduleClaimQuery).
+		WithArgs("pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "reason", "created_by"}).AddRow(4, 5, "active", "", 1))
+	mock.ExpectQuery("SELECT status, deleted_at IS NOT NULL FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "deleted"}).AddRow("closed", false))
+	mock.ExpectExec("UPDATE store_status_schedules SET state = \\$1, error = \\$2").
+		WithArgs("failed", "store cannot move from closed to active", 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(scheduleClaimQuery).
+		WithArgs("pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "reason", "created_by"}))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Run the scheduler once
+	processed, err := handler.applyDueStoreStatusChanges(context.Background())
+
+	//ASSERT: The schedule is settled without touching the store
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if processed != 1 {
+		t.Errorf("Expected 1 processed schedule, got %d", processed)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestApplyDueStoreStatusChanges_OwnerCannotLiftSuspension(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The owner scheduled a pause, then an admin suspended store 5
+	mock.ExpectBegin()
+	mock.ExpectQuery(scheduleClaimQuery).
+		WithArgs("pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "reason", "created_by"}).AddRow(4, 5, "paused", "", 1))
+	mock.ExpectQuery("SELECT status, deleted_at IS NOT NULL FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "deleted"}).AddRow("suspended", false))
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectExec("UPDATE store_status_schedules SET state = \\$1, error = \\$2").
+		WithArgs("failed", "only admins can suspend a store or lift a suspension", 4).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(scheduleClaimQuery).
+		WithArgs("pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "reason", "created_by"}))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Run the scheduler once
+	processed, err := handler.applyDueStoreStatusChanges(context.Background())
+
+	//ASSERT: The suspension stays and the schedule is marked failed
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if processed != 1 {
+		t.Errorf("Expected 1 processed schedule, got %d", processed)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduleStoreStatusResolver_Success(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	runAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+
+	//ARRANGE: Owner 1 plans to open draft store 5
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "draft"))
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectQuery("INSERT INTO store_status_schedules").
+		WithArgs(5, "active", runAt, "Launch", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "run_at", "reason", "state", "error", "created_by", "created_at", "processed_at"}).
+			AddRow(3, 5, "active", runAt, "Launch", "pending", nil, 1, time.Now(), nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 5, "status": "active", "at": runAt.Format(time.RFC3339), "reason": "Launch"},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.scheduleStoreStatusResolver(params)
+
+	//ASSERT: A pending schedule is returned
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	schedule := result.(map[string]interface{})
+	if schedule["state"] != "pending" || schedule["run_at"] != runAt.Format(time.RFC3339) {
+		t.Errorf("Expected pending schedule at %s, got %v", runAt.Format(time.RFC3339), schedule)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestScheduleStoreStatusResolver_PastTime(t *testing.T) {
+	fakeDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 5, "status": "active", "at": "2020-01-01T00:00:00Z"},
+	}
+
+	if _, err := handler.scheduleStoreStatusResolver(params); err == nil || err.Error() != "at must be in the future" {
+		t.Errorf("Expected future time error, got %v", err)
+	}
+}
+
+func TestApplyDueStoreStatusChanges_MarksDeletedStoreFailed(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 5 was deleted while its scheduled pause was being planned
+	mock.ExpectBegin()
+	mock.ExpectQuery(scheduleClaimQuery).
+		WithArgs("pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "reason", "created_by"}).AddRow(6, 5, "paused", "", 1))
+	mock.ExpectQuery("SELECT status, deleted_at IS NOT NULL FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "deleted"}).AddRow("active", true))
+	mock.ExpectExec("UPDATE store_status_schedules SET state = \\$1, error = \\$2").
+		WithArgs("failed", "store 5 is deleted", 6).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(scheduleClaimQuery).
+		WithArgs("pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "reason", "created_by"}))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Run the scheduler once
+	processed, err := handler.applyDueStoreStatusChanges(context.Background())
+
+	//ASSERT: The schedule is settled without touching the store
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if processed != 1 {
+		t.Errorf("Expected 1 processed schedule, got %d", processed)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoreScheduleResolver_Owner(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	runAt := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+
+	//ARRANGE: Store 5 has one pending change
+	mock.ExpectQuery("SELECT (.+) FROM store_status_schedules WHERE store_id = \\$1 AND state = \\$2").
+		WithArgs(5, "pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "store_id", "status", "run_at", "reason", "state", "error", "created_by", "created_at", "processed_at"}).
+			AddRow(3, 5, "active", runAt, "Launch", "pending", nil, 1, time.Now(), nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Source:  map[string]interface{}{"id": 5, "user_id": 1},
+	}
+
+	//ACT: The owner reads the schedule
+	result, err := handler.storeScheduleResolver(params)
+
+	//ASSERT: The pending change is listed
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if schedules := result.([]map[string]interface{}); len(schedules) != 1 || schedules[0]["id"] != 3 {
+		t.Errorf("Expected schedule 3, got %v", result)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoreScheduleResolver_OtherUser(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: User 2 is neither the owner nor an admin
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Source:  map[string]interface{}{"id": 5, "user_id": 1},
+	}
+
+	//ACT: Another user asks for store 5's schedule
+	_, err = handler.storeScheduleResolver(params)
+
+	//ASSERT: The schedule is not loaded
+	if err == nil || err.Error() != "you can only manage your own stores" {
+		t.Errorf("Expected ownership error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
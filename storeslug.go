@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	//storeSlugLockID is the first key of the transaction advisory locks that
+	//serialise slug allocation per base; the second is the hash of the base
+	storeSlugLockID = 720049
+	//maxSlugBase leaves room for a -N suffix in the 80 character column
+	maxSlugBase = 60
+)
+
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+//slugify turns a store name into the base of its slug: lowercase ASCII letters
+//and digits separated by single dashes. The migration backfill does the same in SQL.
+func slugify(name string) string {
+	slug := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(slug) > maxSlugBase {
+		slug = strings.TrimRight(slug[:maxSlugBase], "-")
+	}
+	if slug == "" {
+		return "store"
+	}
+	return slug
+}
+
+//slugHasBase reports whether slug is base itself or base with a -N suffix
+func slugHasBase(slug, base string) bool {
+	if slug == base {
+		return true
+	}
+	suffix, ok := strings.CutPrefix(slug, base+"-")
+	if !ok || suffix == "" {
+		return false
+	}
+	_, err := strconv.Atoi(suffix)
+	return err == nil
+}
+
+//allocateStoreSlugs picks a free slug for each name, in order, for storeID (0
+//for new stores). A slug is free when no other store has it now or had it
+//before; names that share a base within the call get -2, -3, ... in turn.
+//The allocation holds until tx ends, so the slugs must be written in tx.
+func allocateStoreSlugs(ctx context.Context, tx *sql.Tx, names []string, storeID int) ([]string, error) {
+	bases := make([]string, len(names))
+	seen := map[string]bool{}
+	var distinct []string
+	for i, name := range names {
+		bases[i] = slugify(name)
+		if !seen[bases[i]] {
+			seen[bases[i]] = true
+			distinct = append(distinct, bases[i])
+		}
+	}
+	//Locks are taken in a fixed order so two batches cannot deadlock
+	sort.Strings(distinct)
+
+	_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1, hashtext(b)) FROM unnest($2::text[]) AS b ORDER BY b",
+		storeSlugLockID, pq.Array(distinct))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT slug FROM stores WHERE id <> $2 AND (slug = ANY($1) OR substring(slug from '^(.*)-[0-9]+$') = ANY($1)) "+
+			"UNION SELECT slug FROM store_slug_history WHERE store_id <> $2 AND (slug = ANY($1) OR substring(slug from '^(.*)-[0-9]+$') = ANY($1))",
+		pq.Array(distinct), storeID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	taken := map[string]bool{}
+	for rows.Next() {
+		var slug string
+		if err := rows.Scan(&slug); err != nil {
+			return nil, err
+		}
+		taken[slug] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slugs := make([]string, len(bases))
+	for i, base := range bases {
+		slug := base
+		for n := 2; taken[slug]; n++ {
+			slug = fmt.Sprintf("%s-%d", base, n)
+		}
+		taken[slug] = true
+		slugs[i] = slug
+	}
+	return slugs, nil
+}
+
+//renamedStoreSlug works out the slug a store gets when it is renamed to name.
+//A store whose current slug already comes from that name keeps it.
+func renamedStoreSlug(ctx context.Context, tx *sql.Tx, storeID int, name string) (string, string, error) {
+	var current string
+	err := tx.QueryRowContext(ctx, "SELECT slug FROM stores WHERE id = $1 FOR UPDATE", storeID).Scan(&current)
+	if err != nil {
+		return "", "", err
+	}
+	if slugHasBase(current, slugify(name)) {
+		return current, current, nil
+	}
+	slugs, err := allocateStoreSlugs(ctx, tx, []string{name}, storeID)
+	if err != nil {
+		return "", "", err
+	}
+	return slugs[0], current, nil
+}
+
+//retireStoreSlug keeps a store's previous slug so links to it still resolve.
+//The new slug may be one the store used before, which is then current again.
+func retireStoreSlug(ctx context.Context, tx *sql.Tx, storeID int, previous, current string) error {
+	_, err := tx.ExecContext(ctx,
+		"WITH reclaimed AS (DELETE FROM store_slug_history WHERE slug = $3 AND store_id = $2) "+
+			"INSERT INTO store_slug_history (slug, store_id) VALUES ($1, $2)",
+		previous, storeID, current)
+	return err
+}
+
+//storeIDBySlug finds the live store a slug points to, along with its current
+//slug, which differs from the one asked for when the store has been renamed
+func storeIDBySlug(ctx context.Context, q rowQuerier, slug string) (int, string, error) {
+	var id int
+	var current string
+	err := q.QueryRowContext(ctx,
+		"SELECT id, slug FROM stores WHERE slug = $1 AND deleted_at IS NULL "+
+			"UNION ALL SELECT s.id, s.slug FROM store_slug_history h JOIN stores s ON s.id = h.store_id WHERE h.slug = $1 AND s.deleted_at IS NULL "+
+			"LIMIT 1",
+		slug).Scan(&id, &current)
+	return id, current, err
+}
+
+//storeSlugCacheKey is where GET /stores/by-slug/{slug} caches its response
+func storeSlugCacheKey(slug string) string {
+	return "store:slug:" + slug
+}
+
+//storeSlugKeysKey lists the slug cache entries of a store, so invalidating
+//store:<id> can drop them without knowing which slugs were requested
+func storeSlugKeysKey(storeID int) string {
+	return fmt.Sprintf("store:%d:slug_keys", storeID)
+}
+
+//invalidateStoreSlugCache drops every cached by-slug response of a store
+func (h *Handler) invalidateStoreSlugCache(ctx context.Context, storeID int) {
+	keys, err := h.redis.SMembers(ctx, storeSlugKeysKey(storeID)).Result()
+	if err == nil {
+		err = h.redis.Del(ctx, append(keys, storeSlugKeysKey(storeID))...).Err()
+	}
+	if err != nil {
+		h.logger.Warn("failed to invalidate store slug cache", "store_id", storeID, "error", err.Error())
+	}
+}
+
+//storeBySlugHandler serves GET /stores/by-slug/{slug}. A slug the store has
+//been renamed away from is answered with a permanent redirect to the current one.
+func (h *Handler) storeBySlugHandler(w http.ResponseWriter, r *http.Request, slug string) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error": "method not allowed"}`))
+		return
+	}
+	ctx := r.Context()
+	slug = strings.ToLower(slug)
+	cacheKey := storeSlugCacheKey(slug)
+
+	if h.redis != nil {
+		cached, err := h.redis.Get(ctx, cacheKey).Result()
+		if err == nil {
+			cacheHits.WithLabelValues("store_slug").Inc()
+			w.Header().Set("X-Cache", "HIT")
+			etag := ""
+			if version := cachedStoreVersion(cached); version > 0 {
+				etag = storeETag(version)
+			}
+			if writeNotModified(w, r, etag) {
+				return
+			}
+			if etag != "" {
+				w.Header().Set("ETag", etag)
+			}
+			w.Write([]byte(cached))
+			return
+		} else if err != redis.Nil {
+			h.logger.Warn("cache error", "slug", slug, "error", err.Error())
+		} else {
+			cacheMisses.WithLabelValues("store_slug").Inc()
+		}
+	}
+
+	id, current, err := storeIDBySlug(ctx, h.database, slug)
+	var store map[string]interface{}
+	if err == nil && current != slug {
+		location := "/stores/by-slug/" + url.PathEscape(current)
+		w.Header().Set("Location", location)
+		w.WriteHeader(http.StatusMovedPermanently)
+		json.NewEncoder(w).Encode(map[string]string{"slug": current, "location": location})
+		return
+	}
+	if err == nil {
+		store, err = loadStore(ctx, h.database, id)
+	}
+	if err == nil && storeHiddenFrom(store, h.viewerID(r)) {
+		err = sql.ErrNoRows
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"error": "store not found"}`))
+		return
+	}
+	if err != nil {
+		h.logger.Error("store by slug request failed", "slug", slug, "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Database error"}`))
+		return
+	}
+
+	body, err := json.Marshal(storeJSON(store))
+	if err != nil {
+		h.logger.Error("failed to encode store", "store_id", id, "error", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": "Database error"}`))
+		return
+	}
+	if h.redis != nil && store["status"] != storeStatusDraft {
+		_, err := h.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, cacheKey, body, 5*time.Minute)
+			pipe.SAdd(ctx, storeSlugKeysKey(id), cacheKey)
+			pipe.Expire(ctx, storeSlugKeysKey(id), 5*time.Minute)
+			return nil
+		})
+		if err != nil {
+			h.logger.Warn("failed to cache response", "slug", slug, "error", err.Error())
+		}
+	}
+
+	version := store["version"].(int)
+	w.Header().Set("X-Cache", "MISS")
+	if writeNotModified(w, r, storeETag(version)) {
+		return
+	}
+	w.Header().Set("ETag", storeETag(version))
+	w.Write(body)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+)
+
+func TestSlugify(t *testing.T) {
+	cases := map[string]string{
+		"Sticker Shop":             "sticker-shop",
+		"  Bob's  Stickers & Co. ": "bob-s-stickers-co",
+		"Café 42":                  "caf-42",
+		"!!!":                      "store",
+		strings.Repeat("a", 70):    strings.Repeat("a", 60),
+		strings.Repeat("ab ", 30):  strings.Repeat("ab-", 19) + "ab",
+	}
+	for name, want := range cases {
+		if got := slugify(name); got != want {
+			t.Errorf("slugify(%q): expected %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestSlugHasBase(t *testing.T) {
+	cases := []struct {
+		slug, base string
+		want       bool
+	}{
+		{"shop", "shop", true},
+		{"shop-3", "shop", true},
+		{"shop-", "shop", false},
+		{"shop-front", "shop", false},
+		{"shops", "shop", false},
+	}
+	for _, c := range cases {
+		if got := slugHasBase(c.slug, c.base); got != c.want {
+			t.Errorf("slugHasBase(%q, %q): expected %v, got %v", c.slug, c.base, c.want, got)
+		}
+	}
+}
+
+func TestAllocateStoreSlugs(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: "shop" and "shop-2" are taken, one now and one by a renamed store
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock\\(\\$1, hashtext\\(b\\)\\) FROM unnest\\(\\$2::text\\[\\]\\) AS b ORDER BY b").
+		WithArgs(storeSlugLockID, pq.Array([]string{"label-shop", "shop"})).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("UNION SELECT slug FROM store_slug_history WHERE store_id <> \\$2").
+		WithArgs(pq.Array([]string{"label-shop", "shop"}), 0).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("shop").AddRow("shop-2"))
+	mock.ExpectRollback()
+
+	tx, err := fakeDB.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	//ACT: Allocate for a batch where two stores share a name
+	slugs, err := allocateStoreSlugs(context.Background(), tx, []string{"Shop", "Label Shop", "shop!"}, 0)
+
+	//ASSERT: Taken slugs are skipped and the batch does not collide with itself
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	want := []string{"shop-3", "label-shop", "shop-4"}
+	if !reflect.DeepEqual(slugs, want) {
+		t.Errorf("Expected %v, got %v", want, slugs)
+	}
+}
+
+func TestStoresHandler_BySlug(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT id, slug FROM stores WHERE slug = \\$1 AND deleted_at IS NULL UNION ALL").
+		WithArgs("sticker-shop").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(5, "sticker-shop"))
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(storeRows().AddRow(5, "Sticker Shop", int64(1250), "USD", 3, "active", 2, 1, "sticker-shop", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/by-slug/Sticker-Shop", nil)
+	w := httptest.NewRecorder()
+
+	//ACT: Look the store up by slug, in any case
+	handler.storesHandler(w, req)
+
+	//ASSERT: The store, with its ETag
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != `"2"` {
+		t.Errorf("Expected ETag \"2\", got %q", w.Header().Get("ETag"))
+	}
+	var body map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+	if body["id"] != float64(5) || body["slug"] != "sticker-shop" || body["revenue"] != 12.5 {
+		t.Errorf("Unexpected body: %s", w.Body.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_BySlugRenamed(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The store used to be called Sticker Shop
+	mock.ExpectQuery("FROM store_slug_history h JOIN stores s ON s.id = h.store_id WHERE h.slug = \\$1").
+		WithArgs("sticker-shop").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(5, "label-shop"))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/by-slug/sticker-shop", nil)
+	w := httptest.NewRecorder()
+
+	//ACT: Use the old slug
+	handler.storesHandler(w, req)
+
+	//ASSERT: Redirected to the current one
+	if w.Code != http.StatusMovedPermanently {
+		t.Fatalf("Expected status 301, got %d: %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Location") != "/stores/by-slug/label-shop" {
+		t.Errorf("Unexpected Location %q", w.Header().Get("Location"))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_BySlugNotFound(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT id, slug FROM stores WHERE slug = \\$1").
+		WithArgs("nowhere").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/by-slug/nowhere", nil)
+	w := httptest.NewRecorder()
+
+	//ACT: Ask for a slug no store has had
+	handler.storesHandler(w, req)
+
+	//ASSERT: Not found
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStoreResolver_BySlug(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT id, slug FROM stores WHERE slug = \\$1").
+		WithArgs("graphql-store").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "slug"}).AddRow(1, "graphql-store"))
+	mock.ExpectQuery("SELECT id, name, revenue_cents, currency, total_orders, status, version, slug, logo_key FROM stores s WHERE id = \\$1").
+		WithArgs(1, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "revenue_cents", "currency", "total_orders", "status", "version", "slug", "logo_key"}).
+			AddRow(1, "GraphQL Store", 7500050, "USD", 300, "active", 3, "graphql-store", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Query by slug
+	result, err := handler.storeResolver(graphql.ResolveParams{
+		Context: context.Background(),
+		Args:    map[string]interface{}{"slug": "graphql-store"},
+	})
+
+	//ASSERT: The store it names
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	store := result.(map[string]interface{})
+	if store["id"] != 1 || store["slug"] != "graphql-store" {
+		t.Errorf("Unexpected store: %v", store)
+	}
+
+	//ACT: Both at once is ambiguous
+	_, err = handler.storeResolver(graphql.ResolveParams{
+		Context: context.Background(),
+		Args:    map[string]interface{}{"id": 1, "slug": "graphql-store"},
+	})
+
+	//ASSERT: Rejected
+	if err == nil || err.Error() != "pass either id or slug, not both" {
+		t.Errorf("Expected id/slug error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/graphql-go/graphql"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	//storeStatsTTL keeps fleet statistics fresh even when no store changes
+	storeStatsTTL = time.Minute
+	//storeStatsGenerationKey is bumped by every store change; cached stats are
+	//keyed by it, so bumping it invalidates all of them at once
+	storeStatsGenerationKey = "store_stats:generation"
+	defaultTopOwners        = 10
+	maxTopOwners            = 50
+)
+
+//ordersBucketLabels name the order count ranges of the distribution, in order
+var ordersBucketLabels = []string{"0", "1-9", "10-99", "100-999", "1000+"}
+
+//storeStatsFilter narrows the stores the statistics are computed over
+type storeStatsFilter struct {
+	storeFilter
+	TopOwners int `json:"top_owners"`
+}
+
+//cacheKey names the cached statistics of this filter in a generation
+func (f storeStatsFilter) cacheKey(generation int64) string {
+	body, _ := json.Marshal(f)
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf("store_stats:%d:%x", generation, sum[:8])
+}
+
+type statusCount struct {
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+//currencyRevenueStats summarises store revenue in one currency; amounts in
+//different currencies are never added up
+type currencyRevenueStats struct {
+	Currency string `json:"currency"`
+	Stores   int    `json:"stores"`
+	Total    Money  `json:"total"`
+	Average  Money  `json:"average"`
+	Median   Money  `json:"median"`
+	P90      Money  `json:"p90"`
+}
+
+type ordersBucket struct {
+	Range string `json:"range"`
+	Count int    `json:"count"`
+}
+
+//ordersDistribution describes total_orders per store
+type ordersDistribution struct {
+	Total   int64          `json:"total"`
+	Average float64        `json:"average"`
+	Median  float64        `json:"median"`
+	P90     float64        `json:"p90"`
+	Max     int            `json:"max"`
+	Buckets []ordersBucket `json:"buckets"`
+}
+
+type ownerStats struct {
+	UserID      int    `json:"user_id"`
+	Email       string `json:"email"`
+	Stores      int    `json:"stores"`
+	TotalOrders int64  `json:"total_orders"`
+}
+
+//storeStats are fleet-wide numbers for the stores matching a filter
+type storeStats struct {
+	Stores      int                    `json:"stores"`
+	ByStatus    []statusCount          `json:"by_status"`
+	Revenue     []currencyRevenueStats `json:"revenue"`
+	Orders      ordersDistribution     `json:"orders"`
+	TopOwners   []ownerStats           `json:"top_owners"`
+	GeneratedAt time.Time              `json:"generated_at"`
+}
+
+//computeStoreStats runs the statistics queries in one read-only snapshot so the numbers agree with each other
+func (h *Handler) computeStoreStats(ctx context.Context, filter storeStatsFilter) (*storeStats, error) {
+	tx, err := h.database.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where, args := filter.where()
+	stats := &storeStats{GeneratedAt: time.Now().UTC()}
+
+	rows, err := tx.QueryContext(ctx, "SELECT s.status, COUNT(*) FROM stores s "+where+" GROUP BY s.status ORDER BY s.status", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count stores by status: %w", err)
+	}
+	for rows.Next() {
+		var c statusCount
+		if err := rows.Scan(&c.Status, &c.Count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.Stores += c.Count
+		stats.ByStatus = append(stats.ByStatus, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx,
+		`SELECT s.currency, COUNT(*), SUM(s.revenue_cents), AVG(s.revenue_cents)::float8,
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY s.revenue_cents),
+			percentile_cont(0.9) WITHIN GROUP (ORDER BY s.revenue_cents)
+		FROM stores s `+where+` GROUP BY s.currency ORDER BY s.currency`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarise revenue: %w", err)
+	}
+	for rows.Next() {
+		var r currencyRevenueStats
+		var average, median, p90 float64
+		if err := rows.Scan(&r.Currency, &r.Stores, &r.Total.Amount, &average, &median, &p90); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		r.Total.Currency = r.Currency
+		r.Average = Money{Amount: int64(math.Round(average)), Currency: r.Currency}
+		r.Median = Money{Amount: int64(math.Round(median)), Currency: r.Currency}
+		r.P90 = Money{Amount: int64(math.Round(p90)), Currency: r.Currency}
+		stats.Revenue = append(stats.Revenue, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(s.total_orders), 0), COALESCE(AVG(s.total_orders)::float8, 0),
+			COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY s.total_orders), 0),
+			COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY s.total_orders), 0),
+			COALESCE(MAX(s.total_orders), 0)
+		FROM stores s `+where, args...).
+		Scan(&stats.Orders.Total, &stats.Orders.Average, &stats.Orders.Median, &stats.Orders.P90, &stats.Orders.Max)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarise orders: %w", err)
+	}
+
+	counts := make([]int, len(ordersBucketLabels))
+	rows, err = tx.QueryContext(ctx,
+		`SELECT CASE WHEN s.total_orders < 1 THEN 0 WHEN s.total_orders < 10 THEN 1
+			WHEN s.total_orders < 100 THEN 2 WHEN s.total_orders < 1000 THEN 3 ELSE 4 END, COUNT(*)
+		FROM stores s `+where+` GROUP BY 1`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bucket orders: %w", err)
+	}
+	for rows.Next() {
+		var bucket, count int
+		if err := rows.Scan(&bucket, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if bucket >= 0 && bucket < len(counts) {
+			counts[bucket] = count
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, label := range ordersBucketLabels {
+		stats.Orders.Buckets = append(stats.Orders.Buckets, ordersBucket{Range: label, Count: counts[i]})
+	}
+
+	rows, err = tx.QueryContext(ctx,
+		`SELECT s.user_id, u.email, COUNT(*), SUM(s.total_orders)
+		FROM stores s JOIN users u ON u.id = s.user_id `+where+`
+		GROUP BY s.user_id, u.email
+		ORDER BY COUNT(*) DESC, SUM(s.total_orders) DESC, s.user_id
+		LIMIT `+fmt.Sprint(filter.TopOwners), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank owners: %w", err)
+	}
+	for rows.Next() {
+		var o ownerStats
+		if err := rows.Scan(&o.UserID, &o.Email, &o.Stores, &o.TotalOrders); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.TopOwners = append(stats.TopOwners, o)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+//storeStats returns the statistics for a filter from Redis, computing and
+//caching them on a miss. The bool reports whether they came from the cache.
+func (h *Handler) storeStats(ctx context.Context, filter storeStatsFilter) (*storeStats, bool, error) {
+	var key string
+	if h.redis != nil {
+		generation, err := h.redis.Get(ctx, storeStatsGenerationKey).Int64()
+		if err != nil && err != redis.Nil {
+			h.logger.Warn("failed to read store stats generation", "error", err.Error())
+		} else {
+			key = filter.cacheKey(generation)
+			if cached, err := h.redis.Get(ctx, key).Bytes(); err == nil {
+				var stats storeStats
+				if err := json.Unmarshal(cached, &stats); err == nil {
+					cacheHits.WithLabelValues("store_stats").Inc()
+					return &stats, true, nil
+				}
+			}
+			cacheMisses.WithLabelValues("store_stats").Inc()
+		}
+	}
+
+	stats, err := h.computeStoreStats(ctx, filter)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if key != "" {
+		body, _ := json.Marshal(stats)
+		if err := h.redis.Set(ctx, key, body, storeStatsTTL).Err(); err != nil {
+			h.logger.Warn("failed to cache store stats", "error", err.Error())
+		}
+	}
+	return stats, false, nil
+}
+
+//invalidateStoreStats makes every cached statistic stale after a store changed
+func (h *Handler) invalidateStoreStats(ctx context.Context) {
+	if h.redis == nil {
+		return
+	}
+	if err := h.redis.Incr(ctx, storeStatsGenerationKey).Err(); err != nil {
+		h.logger.Warn("failed to invalidate store stats", "error", err.Error())
+	}
+}
+
+//storeStatsResolver serves fleet-wide store statistics - REQUIRES ADMIN
+func (h *Handler) storeStatsResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireAdmin(p)
+	if err != nil {
+		h.logger.Warn("unauthorized store stats attempt", "error", err.Error())
+		return nil, err
+	}
+
+	where, err := storeFilterArg(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	filter := storeStatsFilter{storeFilter: where, TopOwners: defaultTopOwners}
+	if top, ok := p.Args["topOwners"].(int); ok {
+		if top < 1 || top > maxTopOwners {
+			return nil, fmt.Errorf("topOwners must be between 1 and %d", maxTopOwners)
+		}
+		filter.TopOwners = top
+	}
+
+	stats, cached, err := h.storeStats(p.Context, filter)
+	if err != nil {
+		h.logger.Error("failed to compute store stats", "error", err.Error())
+		return nil, err
+	}
+	h.logger.Info("store stats served", "user_id", userID, "cached", cached, "stores", stats.Stores)
+
+	byStatus := []map[string]interface{}{}
+	for _, c := range stats.ByStatus {
+		byStatus = append(byStatus, map[string]interface{}{"status": c.Status, "count": c.Count})
+	}
+	revenue := []map[string]interface{}{}
+	for _, r := range stats.Revenue {
+		revenue = append(revenue, map[string]interface{}{
+			"currency": r.Currency,
+			"stores":   r.Stores,
+			"total":    r.Total,
+			"average":  r.Average,
+			"median":   r.Median,
+			"p90":      r.P90,
+		})
+	}
+	buckets := []map[string]interface{}{}
+	for _, b := range stats.Orders.Buckets {
+		buckets = append(buckets, map[string]interface{}{"range": b.Range, "count": b.Count})
+	}
+	owners := []map[string]interface{}{}
+	for _, o := range stats.TopOwners {
+		owners = append(owners, map[string]interface{}{
+			"user_id":      o.UserID,
+			"email":        o.Email,
+			"stores":       o.Stores,
+			"total_orders": int(o.TotalOrders),
+		})
+	}
+
+	return map[string]interface{}{
+		"stores":    stats.Stores,
+		"by_status": byStatus,
+		"revenue":   revenue,
+		"orders": map[string]interface{}{
+			"total":   int(stats.Orders.Total),
+			"average": stats.Orders.Average,
+			"median":  stats.Orders.Median,
+			"p90":     stats.Orders.P90,
+			"max":     stats.Orders.Max,
+			"buckets": buckets,
+		},
+		"top_owners":   owners,
+		"generated_at": stats.GeneratedAt,
+		"cached":       cached,
+	}, nil
+}
+
+//storeStatsType is the GraphQL shape of fleet-wide store statistics
+var storeStatsType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "StoreStats",
+	Fields: graphql.Fields{
+		"stores": &graphql.Field{Type: graphql.Int},
+		"by_status": &graphql.Field{Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+			Name: "StoreStatusCount",
+			Fields: graphql.Fields{
+				"status": &graphql.Field{Type: storeStatusEnum},
+				"count":  &graphql.Field{Type: graphql.Int},
+			},
+		}))},
+		"revenue": &graphql.Field{
+			Description: "Revenue per currency; amounts in different currencies are not added up",
+			Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+				Name: "CurrencyRevenueStats",
+				Fields: graphql.Fields{
+					"currency": &graphql.Field{Type: graphql.String},
+					"stores":   &graphql.Field{Type: graphql.Int},
+					"total":    &graphql.Field{Type: moneyType},
+					"average":  &graphql.Field{Type: moneyType},
+					"median":   &graphql.Field{Type: moneyType},
+					"p90":      &graphql.Field{Type: moneyType},
+				},
+			})),
+		},
+		"orders": &graphql.Field{Type: graphql.NewObject(graphql.ObjectConfig{
+			Name: "OrdersDistribution",
+			Fields: graphql.Fields{
+				"total":   &graphql.Field{Type: graphql.Int},
+				"average": &graphql.Field{Type: graphql.Float},
+				"median":  &graphql.Field{Type: graphql.Float},
+				"p90":     &graphql.Field{Type: graphql.Float},
+				"max":     &graphql.Field{Type: graphql.Int},
+				"buckets": &graphql.Field{Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+					Name: "OrdersBucket",
+					Fields: graphql.Fields{
+						"range": &graphql.Field{Type: graphql.String, Description: "Orders per store, e.g. 10-99"},
+						"count": &graphql.Field{Type: graphql.Int},
+					},
+				}))},
+			},
+		})},
+		"top_owners": &graphql.Field{Type: graphql.NewList(graphql.NewObject(graphql.ObjectConfig{
+			Name: "StoreOwnerStats",
+			Fields: graphql.Fields{
+				"user_id":      &graphql.Field{Type: graphql.Int},
+				"email":        &graphql.Field{Type: graphql.String},
+				"stores":       &graphql.Field{Type: graphql.Int},
+				"total_orders": &graphql.Field{Type: graphql.Int},
+			},
+		}))},
+		"generated_at": &graphql.Field{Type: dateTimeScalar},
+		"cached":       &graphql.Field{Type: graphql.Boolean, Description: "Whether the numbers came from the Redis cache"},
+	},
+})
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+)
+
+func TestStoreFilter_Where(t *testing.T) {
+	filter := storeFilter{Statuses: []string{"active", "paused"}, Currency: "EUR", OwnerID: 7}
+
+	//ACT: Build the clause
+	where, args := filter.where()
+
+	//ASSERT: Deleted stores are always excluded and placeholders are numbered in order
+	want := "WHERE s.deleted_at IS NULL AND s.status = ANY($1) AND s.currency = $2 AND s.user_id = $3"
+	if where != want {
+		t.Errorf("Expected %q, got %q", want, where)
+	}
+	if len(args) != 3 || args[1] != "EUR" || args[2] != 7 {
+		t.Errorf("Unexpected args: %v", args)
+	}
+}
+
+func TestStoreStatsFilter_CacheKey(t *testing.T) {
+	a := storeStatsFilter{storeFilter: storeFilter{Currency: "EUR"}, TopOwners: 10}
+	b := storeStatsFilter{storeFilter: storeFilter{Currency: "USD"}, TopOwners: 10}
+
+	//ASSERT: Keys differ by filter and by generation
+	if a.cacheKey(1) == b.cacheKey(1) {
+		t.Error("Expected different filters to have different keys")
+	}
+	if a.cacheKey(1) == a.cacheKey(2) {
+		t.Error("Expected a new generation to change the key")
+	}
+}
+
+func TestStoreStatsResolver(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Admin 1 asks about active and paused stores
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+	statuses := pq.Array([]string{"active", "paused"})
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT s.status, COUNT\\(\\*\\) FROM stores s WHERE s.deleted_at IS NULL AND s.status = ANY\\(\\$1\\) GROUP BY s.status").
+		WithArgs(statuses).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("active", 3).AddRow("paused", 1))
+	mock.ExpectQuery("percentile_cont\\(0.9\\) WITHIN GROUP \\(ORDER BY s.revenue_cents\\)").
+		WithArgs(statuses).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "count", "sum", "avg", "median", "p90"}).
+			AddRow("EUR", 1, int64(500), 500.0, 500.0, 500.0).
+			AddRow("USD", 3, int64(10001), 3333.67, 1000.0, 7600.4))
+	mock.ExpectQuery("COALESCE\\(MAX\\(s.total_orders\\), 0\\)").
+		WithArgs(statuses).
+		WillReturnRows(sqlmock.NewRows([]string{"sum", "avg", "median", "p90", "max"}).
+			AddRow(int64(1215), 303.75, 107.5, 832.0, 1100))
+	mock.ExpectQuery("SELECT CASE WHEN s.total_orders < 1").
+		WithArgs(statuses).
+		WillReturnRows(sqlmock.NewRows([]string{"case", "count"}).AddRow(1, 1).AddRow(4, 1).AddRow(2, 2))
+	mock.ExpectQuery("FROM stores s JOIN users u ON u.id = s.user_id (.+) LIMIT 2").
+		WithArgs(statuses).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "email", "count", "sum"}).
+			AddRow(1, "a@example.com", 3, int64(1210)).
+			AddRow(2, "b@example.com", 1, int64(5)))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args: map[string]interface{}{
+			"filter":    map[string]interface{}{"status": []interface{}{"active", "paused"}},
+			"topOwners": 2,
+		},
+	}
+
+	//ACT: Call the resolver without Redis
+	result, err := handler.storeStatsResolver(params)
+
+	//ASSERT: Computed fresh, per currency, with every orders bucket present
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	stats := result.(map[string]interface{})
+	if stats["stores"] != 4 || stats["cached"] != false {
+		t.Errorf("Unexpected totals: %v", stats)
+	}
+	revenue := stats["revenue"].([]map[string]interface{})
+	if len(revenue) != 2 || revenue[1]["average"] != (Money{Amount: 3334, Currency: "USD"}) {
+		t.Errorf("Unexpected revenue: %v", revenue)
+	}
+	buckets := stats["orders"].(map[string]interface{})["buckets"].([]map[string]interface{})
+	wantCounts := []int{0, 1, 2, 0, 1}
+	for i, b := range buckets {
+		if b["range"] != ordersBucketLabels[i] || b["count"] != wantCounts[i] {
+			t.Errorf("Bucket %d: expected %s=%d, got %v", i, ordersBucketLabels[i], wantCounts[i], b)
+		}
+	}
+	if owners := stats["top_owners"].([]map[string]interface{}); len(owners) != 2 || owners[0]["email"] != "a@example.com" {
+		t.Errorf("Unexpected top owners: %v", owners)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoreStatsResolver_RequiresAdmin(t *testing.T) {
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 2),
+		Args:    map[string]interface{}{"topOwners": 10},
+	}
+
+	_, err = handler.storeStatsResolver(params)
+
+	if err == nil || err.Error() != "admin access required" {
+		t.Errorf("Expected admin error, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
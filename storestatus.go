@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/graphql-go/graphql"
+)
+
+//Store lifecycle statuses. Only active stores accept orders.
+const (
+	storeStatusDraft     = "draft"
+	storeStatusActive    = "active"
+	storeStatusPaused    = "paused"
+	storeStatusSuspended = "suspended"
+	storeStatusClosed    = "closed"
+)
+
+//storeTransitions lists the statuses a store may move to from each status.
+//Closed is final.
+var storeTransitions = map[string][]string{
+	storeStatusDraft:     {storeStatusActive, storeStatusClosed},
+	storeStatusActive:    {storeStatusPaused, storeStatusSuspended, storeStatusClosed},
+	storeStatusPaused:    {storeStatusActive, storeStatusSuspended, storeStatusClosed},
+	storeStatusSuspended: {storeStatusActive, storeStatusPaused, storeStatusClosed},
+}
+
+//canTransitionStore reports whether a store may move from one status to another
+func canTransitionStore(from, to string) bool {
+	for _, next := range storeTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+//draftVisibility is the condition that hides draft stores, aliased as s, from
+//everyone but their owner; the placeholder takes the viewer's user id
+const draftVisibility = "(s.status <> 'draft' OR s.user_id = $%d)"
+
+//viewerID returns the user a request is authenticated as, or 0 for anonymous
+//callers and invalid tokens, so that public reads keep working without login
+func (h *Handler) viewerID(r *http.Request) int {
+	if r == nil || r.Header.Get("Authorization") == "" {
+		return 0
+	}
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		return 0
+	}
+	return userID
+}
+
+//resolverViewerID is viewerID for a GraphQL resolver
+func (h *Handler) resolverViewerID(p graphql.ResolveParams) int {
+	if p.Context == nil {
+		return 0
+	}
+	r, _ := p.Context.Value(httpRequestKey).(*http.Request)
+	return h.viewerID(r)
+}
+
+//storeHiddenFrom reports whether a store map is a draft someone other than its owner is asking for
+func storeHiddenFrom(store map[string]interface{}, viewerID int) bool {
+	return store["status"] == storeStatusDraft && (viewerID == 0 || store["user_id"] != viewerID)
+}
+
+//storeStatusEnum is the StoreStatus GraphQL enum; values are the stored statuses
+var storeStatusEnum = graphql.NewEnum(graphql.EnumConfig{
+	Name: "StoreStatus",
+	Values: graphql.EnumValueConfigMap{
+		"DRAFT":     &graphql.EnumValueConfig{Value: storeStatusDraft, Description: "Being set up, not visible to buyers yet"},
+		"ACTIVE":    &graphql.EnumValueConfig{Value: storeStatusActive, Description: "Open and accepting orders"},
+		"PAUSED":    &graphql.EnumValueConfig{Value: storeStatusPaused, Description: "Temporarily closed by the owner"},
+		"SUSPENDED": &graphql.EnumValueConfig{Value: storeStatusSuspended, Description: "Closed by an admin"},
+		"CLOSED":    &graphql.EnumValueConfig{Value: storeStatusClosed, Description: "Permanently closed"},
+	},
+})
+
+//storeStatusChange is one step in a store's history, published as the storeStatusChanged event
+type storeStatusChange struct {
+	StoreID   int       `json:"store_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Reason    string    `json:"reason,omitempty"`
+	ChangedBy int       `json:"changed_by"`
+	At        time.Time `json:"at"`
+}
+
+//transitionStore validates and applies a status change and records it in the
+//history. Must run inside the caller's transaction with the store row locked.
+func transitionStore(ctx context.Context, tx *sql.Tx, storeID int, from, to string, actorID int, reason string) (*storeStatusChange, error) {
+	if !canTransitionStore(from, to) {
+		return nil, fmt.Errorf("store %d cannot move from %s to %s", storeID, from, to)
+	}
+
+	_, err := tx.ExecContext(ctx, "UPDATE stores SET status = $1 WHERE id = $2", to, storeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update store status: %w", err)
+	}
+
+	historyQuery := "INSERT INTO store_status_history (store_id, from_status, to_status, reason, changed_by) VALUES ($1, $2, $3, $4, $5)"
+	if _, err := tx.ExecContext(ctx, historyQuery, storeID, from, to, reason, actorID); err != nil {
+		return nil, fmt.Errorf("failed to record store status history: %w", err)
+	}
+
+	return &storeStatusChange{
+		StoreID:   storeID,
+		From:      from,
+		To:        to,
+		Reason:    reason,
+		ChangedBy: actorID,
+		At:        time.Now().UTC(),
+	}, nil
+}
+
+//recordInitialStoreStatus writes the first history entry of a newly created store
+func recordInitialStoreStatus(ctx context.Context, tx *sql.Tx, storeID int, status string, actorID int) error {
+	historyQuery := "INSERT INTO store_status_history (store_id, from_status, to_status, changed_by) VALUES ($1, NULL, $2, $3)"
+	if _, err := tx.ExecContext(ctx, historyQuery, storeID, status, actorID); err != nil {
+		return fmt.Errorf("failed to record store status history: %w", err)
+	}
+	return nil
+}
+
+//checkStoreStatusChange enforces who may change a store's status: owners manage
+//their own stores, and only admins can suspend a store or lift a suspension
+func checkStoreStatusChange(admin, owner bool, current, status, reason string) error {
+	if !admin && !owner {
+		return fmt.Errorf("you can only manage your own stores")
+	}
+	if !admin && (status == storeStatusSuspended || current == storeStatusSuspended) {
+		return fmt.Errorf("only admins can suspend a store or lift a suspension")
+	}
+	if status == storeStatusSuspended && reason == "" {
+		return fmt.Errorf("a reason is required to suspend a store")
+	}
+	return nil
+}
+
+//setStoreStatusResolver moves a store through its lifecycle - REQUIRES AUTH + OWNERSHIP.
+//Only admins can suspend a store or lift a suspension, and they can do so for any store.
+func (h *Handler) setStoreStatusResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized store status change attempt", "error", err.Error())
+		return nil, err
+	}
+
+	id, ok := p.Args["id"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid id")
+	}
+	status, _ := p.Args["status"].(string)
+	reason, _ := p.Args["reason"].(string)
+	reason = strings.TrimSpace(reason)
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var ownerID int
+	var current string
+	err = tx.QueryRowContext(ctx, "SELECT user_id, status FROM stores WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&ownerID, &current)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", id)
+	}
+	if err != nil {
+		h.logger.Error("database error loading store status", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	admin, err := isAdmin(ctx, tx, userID)
+	if err != nil {
+		h.logger.Error("database error checking admin flag", "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+	if err := checkStoreStatusChange(admin, ownerID == userID, current, status, reason); err != nil {
+		h.logger.Warn("store status change rejected", "store_id", id, "user_id", userID, "error", err.Error())
+		return nil, err
+	}
+
+	if err := setRevisionActor(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+	change, err := transitionStore(ctx, tx, id, current, status, userID, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := loadStore(ctx, tx, id)
+	if err != nil {
+		h.logger.Error("database error reloading store", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit store status change", "store_id", id, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("store status changed",
+		"store_id", id,
+		"from", change.From,
+		"to", change.To,
+		"user_id", userID,
+	)
+	h.invalidateStoreCache(id)
+	h.refreshStoreLeaderboard(ctx, id)
+	h.publishEvent(ctx, eventStoreStatusChanged, change)
+
+	return store, nil
+}
+
+//storeStatusHistoryResolver loads the status history of a store
+func (h *Handler) storeStatusHistoryResolver(p graphql.ResolveParams) (interface{}, error) {
+	store, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		`SELECT from_status, to_status, reason, changed_by, created_at
+		FROM store_status_history WHERE store_id = $1 ORDER BY id`, store["id"])
+	if err != nil {
+		h.logger.Error("database error loading store status history", "store_id", store["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []map[string]interface{}
+	for rows.Next() {
+		var from, reason sql.NullString
+		var to string
+		var changedBy sql.NullInt64
+		var createdAt time.Time
+		if err := rows.Scan(&from, &to, &reason, &changedBy, &createdAt); err != nil {
+			return nil, err
+		}
+
+		entry := map[string]interface{}{
+			"from_status": nil,
+			"to_status":   to,
+			"reason":      reason.String,
+			"changed_by":  nil,
+			"created_at":  createdAt.Format(time.RFC3339),
+		}
+		if from.Valid {
+			entry["from_status"] = from.String
+		}
+		if changedBy.Valid {
+			entry["changed_by"] = int(changedBy.Int64)
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+//storeStatusChangeType is one entry of a store's status history in GraphQL
+var storeStatusChangeType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "StoreStatusChange",
+	Fields: graphql.Fields{
+		"from_status": &graphql.Field{Type: storeStatusEnum},
+		"to_status":   &graphql.Field{Type: storeStatusEnum},
+		"reason":      &graphql.Field{Type: graphql.String},
+		"changed_by":  &graphql.Field{Type: graphql.Int},
+		"created_at":  &graphql.Field{Type: graphql.String},
+	},
+})
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+func TestCanTransitionStore(t *testing.T) {
+	cases := []struct {
+		from, to string
+		want     bool
+	}{
+		{storeStatusDraft, storeStatusActive, true},
+		{storeStatusDraft, storeStatusPaused, false},
+		{storeStatusActive, storeStatusPaused, true},
+		{storeStatusPaused, storeStatusActive, true},
+		{storeStatusActive, storeStatusSuspended, true},
+		{storeStatusSuspended, storeStatusActive, true},
+		{storeStatusActive, storeStatusDraft, false},
+		{storeStatusClosed, storeStatusActive, false},
+	}
+	for _, c := range cases {
+		if got := canTransitionStore(c.from, c.to); got != c.want {
+			t.Errorf("canTransitionStore(%s, %s) = %v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestSetStoreStatusResolver_OwnerPauses(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Owner 1 pauses their active store 5
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "active"))
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE stores SET status = \\$1 WHERE id = \\$2").
+		WithArgs("paused", 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO store_status_history").
+		WithArgs(5, "active", "paused", "On holiday", 1).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "revenue_cents", "currency", "total_orders", "status", "version", "user_id", "slug", "logo_key"}).
+			AddRow(5, "Sticker Shop", int64(10000), "USD", 4, "paused", 2, 1, "sticker-shop", nil))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 5, "status": "paused", "reason": " On holiday "},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.setStoreStatusResolver(params)
+
+	//ASSERT: The store is paused and the change was recorded
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if status := result.(map[string]interface{})["status"]; status != "paused" {
+		t.Errorf("Expected paused, got %v", status)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSetStoreStatusResolver_OnlyAdminsSuspend(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The owner tries to lift their store's suspension
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "suspended"))
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 5, "status": "active"},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.setStoreStatusResolver(params)
+
+	//ASSERT: Rejected without touching the store
+	if err == nil || err.Error() != "only admins can suspend a store or lift a suspension" {
+		t.Errorf("Expected admin only error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSetStoreStatusResolver_SuspendRequiresReason(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Admin 9 suspends someone else's store without saying why
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id, status FROM stores WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "status"}).AddRow(1, "active"))
+	mock.ExpectQuery("SELECT is_admin FROM users WHERE id = \\$1").
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 9),
+		Args:    map[string]interface{}{"id": 5, "status": "suspended"},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.setStoreStatusResolver(params)
+
+	//ASSERT: A reason is required
+	if err == nil || err.Error() != "a reason is required to suspend a store" {
+		t.Errorf("Expected missing reason error, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoreHiddenFrom(t *testing.T) {
+	draft := map[string]interface{}{"status": storeStatusDraft, "user_id": 1}
+
+	if storeHiddenFrom(draft, 1) {
+		t.Errorf("Expected the owner to see their draft")
+	}
+	if !storeHiddenFrom(draft, 2) || !storeHiddenFrom(draft, 0) {
+		t.Errorf("Expected the draft to be hidden from other users and anonymous callers")
+	}
+	if storeHiddenFrom(map[string]interface{}{"status": storeStatusActive, "user_id": 1}, 0) {
+		t.Errorf("Expected an active store to be visible to everyone")
+	}
+}
+
+func TestStoresHandler_DraftHiddenFromOthers(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 5 is a draft of user 1
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(storeRows().AddRow(5, "Sticker Shop", int64(0), "USD", 0, "draft", 1, 1, "sticker-shop", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/stores/5", nil)
+	w := httptest.NewRecorder()
+
+	//ACT: Ask for it without logging in
+	handler.storesHandler(w, req)
+
+	//ASSERT: As if it did not exist
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestStoresResolver_HidesOtherUsersDrafts(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("FROM stores s WHERE s.deleted_at IS NULL AND \\(s.status <> 'draft' OR s.user_id = \\$1\\) ORDER BY id").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "revenue_cents", "currency", "total_orders", "status", "version", "user_id", "slug", "logo_key"}).
+			AddRow(5, "Sticker Shop", int64(0), "USD", 0, "draft", 1, 3, "sticker-shop", nil))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: List stores as user 3
+	result, err := handler.storesResolver(graphql.ResolveParams{Context: authContext(t, fakeDB, 3)})
+
+	//ASSERT: The viewer is passed so their own drafts are included
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if stores := result.([]map[string]interface{}); len(stores) != 1 {
+		t.Errorf("Expected one store, got %v", stores)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
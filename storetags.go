@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+)
+
+const (
+	maxStoreTags     = 10
+	maxTagLength     = 40
+	defaultFacetTags = 20
+	maxFacetTags     = 100
+)
+
+//normalizeTag turns a tag as typed into the stored form: lowercase letters
+//and digits separated by single dashes, the way store slugs are made
+func normalizeTag(raw string) (string, error) {
+	tag := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(raw), "-"), "-")
+	if tag == "" {
+		return "", fmt.Errorf("tag %q has no letters or digits", raw)
+	}
+	if len(tag) > maxTagLength {
+		return "", fmt.Errorf("tag %q is longer than %d characters", raw, maxTagLength)
+	}
+	return tag, nil
+}
+
+//normalizeTags normalises a list of tags and drops duplicates, keeping the first
+func normalizeTags(raw []string) ([]string, error) {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, r := range raw {
+		tag, err := normalizeTag(r)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+//setStoreTagsResolver replaces a store's tags - REQUIRES AUTH + OWNERSHIP.
+//Tags that do not exist yet are created.
+func (h *Handler) setStoreTagsResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized set tags attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid store id")
+	}
+	var raw []string
+	if list, ok := p.Args["tags"].([]interface{}); ok {
+		for _, tag := range list {
+			if t, ok := tag.(string); ok {
+				raw = append(raw, t)
+			}
+		}
+	}
+	tags, err := normalizeTags(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) > maxStoreTags {
+		return nil, fmt.Errorf("a store can have at most %d tags", maxStoreTags)
+	}
+
+	ctx := p.Context
+	tx, err := h.database.BeginTx(ctx, nil)
+	if err != nil {
+		h.logger.Error("failed to begin transaction", "error", err.Error())
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	//Locking the store row keeps two tag updates of one store from interleaving
+	var ownerID int
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM stores WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", storeID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		h.logger.Error("database error checking store owner", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if ownerID != userID {
+		return nil, fmt.Errorf("you can only update your own stores")
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING", pq.Array(tags))
+	if err != nil {
+		h.logger.Error("database error creating tags", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM store_tags WHERE store_id = $1 AND tag_id NOT IN (SELECT id FROM tags WHERE name = ANY($2))",
+		storeID, pq.Array(tags))
+	if err != nil {
+		h.logger.Error("database error removing store tags", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO store_tags (store_id, tag_id) SELECT $1, id FROM tags WHERE name = ANY($2) ON CONFLICT DO NOTHING",
+		storeID, pq.Array(tags))
+	if err != nil {
+		h.logger.Error("database error adding store tags", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+
+	store, err := loadStore(ctx, tx, storeID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		h.logger.Error("failed to commit store tags", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("store tags updated", "store_id", storeID, "tags", tags, "user_id", userID)
+	h.invalidateStoreStats(ctx)
+	return store, nil
+}
+
+//storeTagsResolver lists a store's tags in alphabetical order
+func (h *Handler) storeTagsResolver(p graphql.ResolveParams) (interface{}, error) {
+	store, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	rows, err := h.database.QueryContext(p.Context,
+		"SELECT t.name FROM store_tags st JOIN tags t ON t.id = st.tag_id WHERE st.store_id = $1 ORDER BY t.name", store["id"])
+	if err != nil {
+		h.logger.Error("database error loading store tags", "store_id", store["id"], "error", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+//storeFacets counts the stores a filter matches, per tag and per category,
+//for faceted navigation. A category counts the stores in its subcategories.
+//Drafts only count for their owner, as in the stores list.
+func (h *Handler) storeFacets(ctx context.Context, filter storeFilter, viewerID, tagLimit int) (map[string]interface{}, error) {
+	tx, err := h.database.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	where, args := filter.where()
+	args = append(args, viewerID)
+	where += " AND " + fmt.Sprintf(draftVisibility, len(args))
+	var total int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM stores s "+where, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count stores: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		fmt.Sprintf(`SELECT t.name, COUNT(*) FROM stores s
+		JOIN store_tags st ON st.store_id = s.id JOIN tags t ON t.id = st.tag_id
+		%s GROUP BY t.name ORDER BY COUNT(*) DESC, t.name LIMIT $%d`, where, len(args)+1),
+		append(args, tagLimit)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count stores by tag: %w", err)
+	}
+	tags := []map[string]interface{}{}
+	for rows.Next() {
+		var name string
+		var count int
+		if err := rows.Scan(&name, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tags = append(tags, map[string]interface{}{"name": name, "count": count})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	//Each store is counted in its category and every category above it
+	rows, err = tx.QueryContext(ctx,
+		`WITH RECURSIVE ancestry AS (
+			SELECT id AS category_id, id AS ancestor_id FROM categories
+			UNION ALL
+			SELECT a.category_id, c.parent_id FROM ancestry a JOIN categories c ON c.id = a.ancestor_id WHERE c.parent_id IS NOT NULL
+		)
+		SELECT c.id, c.name, c.parent_id, COUNT(*) FROM stores s
+		JOIN ancestry a ON a.category_id = s.category_id JOIN categories c ON c.id = a.ancestor_id
+		`+where+` GROUP BY c.id, c.name, c.parent_id ORDER BY c.id`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count stores by category: %w", err)
+	}
+	categories := []map[string]interface{}{}
+	for rows.Next() {
+		var id, count int
+		var name string
+		var parentID sql.NullInt64
+		if err := rows.Scan(&id, &name, &parentID, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		facet := map[string]interface{}{"id": id, "name": name, "parent_id": nil, "count": count}
+		if parentID.Valid {
+			facet["parent_id"] = int(parentID.Int64)
+		}
+		categories = append(categories, facet)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"total": total, "tags": tags, "categories": categories}, tx.Commit()
+}
+
+//storeFacetsResolver serves the storeFacets query; like stores it needs no login
+func (h *Handler) storeFacetsResolver(p graphql.ResolveParams) (interface{}, error) {
+	filter, err := storeFilterArg(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	tagLimit := defaultFacetTags
+	if limit, ok := p.Args["tagLimit"].(int); ok {
+		if limit < 1 || limit > maxFacetTags {
+			return nil, fmt.Errorf("tagLimit must be between 1 and %d", maxFacetTags)
+		}
+		tagLimit = limit
+	}
+
+	facets, err := h.storeFacets(p.Context, filter, h.resolverViewerID(p), tagLimit)
+	if err != nil {
+		h.logger.Error("database error computing store facets", "error", err.Error())
+		return nil, err
+	}
+	return facets, nil
+}
+
+var tagCountType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "TagCount",
+	Fields: graphql.Fields{
+		"name":  &graphql.Field{Type: graphql.String},
+		"count": &graphql.Field{Type: graphql.Int},
+	},
+})
+
+var categoryCountType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "CategoryCount",
+	Fields: graphql.Fields{
+		"id":        &graphql.Field{Type: graphql.Int},
+		"name":      &graphql.Field{Type: graphql.String},
+		"parent_id": &graphql.Field{Type: graphql.Int},
+		"count": &graphql.Field{
+			Type:        graphql.Int,
+			Description: "Matching stores in this category and its subcategories",
+		},
+	},
+})
+
+//storeFacetsType is the result of the storeFacets query
+var storeFacetsType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "StoreFacets",
+	Fields: graphql.Fields{
+		"total": &graphql.Field{Type: graphql.Int},
+		"tags": &graphql.Field{
+			Type:        graphql.NewList(tagCountType),
+			Description: "The most used tags among matching stores, most used first",
+		},
+		"categories": &graphql.Field{
+			Type:        graphql.NewList(categoryCountType),
+			Description: "Categories with matching stores; parent_id rebuilds the tree",
+		},
+	},
+})
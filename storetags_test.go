@@ -0,0 +1,210 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	//ACT: Differently typed spellings of the same tags
+	tags, err := normalizeTags([]string{"Laptop Stickers", "anime", "laptop-stickers", " ANIME!"})
+
+	//ASSERT: One of each, in the order first seen
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if want := []string{"laptop-stickers", "anime"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("Expected %v, got %v", want, tags)
+	}
+
+	for _, bad := range []string{"", "!!!", strings.Repeat("a", 41)} {
+		if _, err := normalizeTag(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestStoreFilter_WhereTagsAndCategory(t *testing.T) {
+	filter := storeFilter{OwnerID: 7, Tags: []string{"anime", "vinyl"}, CategoryID: 3}
+
+	//ACT: Build the clause
+	where, args := filter.where()
+
+	//ASSERT: A store needs every tag, and the category takes in its subtree
+	if !strings.Contains(where, "WHERE t.name = ANY($2) GROUP BY st.store_id HAVING COUNT(*) = $3)") {
+		t.Errorf("Unexpected tag condition in %q", where)
+	}
+	if !strings.Contains(where, "s.category_id IN (WITH RECURSIVE subtree AS (SELECT id FROM categories WHERE id = $4") {
+		t.Errorf("Unexpected category condition in %q", where)
+	}
+	if len(args) != 4 || args[2] != 2 || args[3] != 3 {
+		t.Errorf("Unexpected args: %v", args)
+	}
+}
+
+func TestStoreFilterQuery_TagsAndCategory(t *testing.T) {
+	query, _ := url.ParseQuery("tag=Anime,vinyl&tag=anime&categoryId=3")
+
+	filter, err := storeFilterQuery(query)
+
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !reflect.DeepEqual(filter.Tags, []string{"anime", "vinyl"}) || filter.CategoryID != 3 {
+		t.Errorf("Unexpected filter: %+v", filter)
+	}
+
+	query, _ = url.ParseQuery("categoryId=books")
+	if _, err := storeFilterQuery(query); err == nil || err.Error() != "categoryId must be a category id" {
+		t.Errorf("Expected categoryId error, got %v", err)
+	}
+}
+
+func TestSetStoreTagsResolver(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The owner replaces the tags of store 5
+	tags := pq.Array([]string{"anime", "laptop-stickers"})
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1 AND deleted_at IS NULL FOR UPDATE").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectExec("INSERT INTO tags \\(name\\) SELECT unnest\\(\\$1::text\\[\\]\\) ON CONFLICT \\(name\\) DO NOTHING").
+		WithArgs(tags).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM store_tags WHERE store_id = \\$1 AND tag_id NOT IN").
+		WithArgs(5, tags).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO store_tags \\(store_id, tag_id\\) SELECT \\$1, id FROM tags WHERE name = ANY\\(\\$2\\)").
+		WithArgs(5, tags).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT (.+) FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(storeRows().AddRow(5, "Sticker Shop", int64(1250), "USD", 3, "active", 2, 1, "sticker-shop", nil))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"storeId": 5, "tags": []interface{}{"Anime", "Laptop Stickers", "anime"}},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.setStoreTagsResolver(params)
+
+	//ASSERT: The store comes back
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.(map[string]interface{})["id"] != 5 {
+		t.Errorf("Unexpected store: %v", result)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSetStoreTagsResolver_TooMany(t *testing.T) {
+	fakeDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	var tags []interface{}
+	for _, tag := range strings.Split("a b c d e f g h i j k", " ") {
+		tags = append(tags, tag)
+	}
+
+	_, err = handler.setStoreTagsResolver(graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"storeId": 5, "tags": tags},
+	})
+
+	if err == nil || err.Error() != "a store can have at most 10 tags" {
+		t.Errorf("Expected tag limit error, got %v", err)
+	}
+}
+
+func TestStoreFacetsResolver(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Active stores tagged anime, one of them in Stickers > Laptop
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM stores s WHERE s.deleted_at IS NULL AND s.status = ANY\\(\\$1\\) AND s.id IN (.+) AND \\(s.status <> 'draft' OR s.user_id = \\$4\\)").
+		WithArgs(pq.Array([]string{"active"}), pq.Array([]string{"anime"}), 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery("GROUP BY t.name ORDER BY COUNT\\(\\*\\) DESC, t.name LIMIT \\$5").
+		WithArgs(pq.Array([]string{"active"}), pq.Array([]string{"anime"}), 1, 1, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "count"}).AddRow("anime", 2).AddRow("vinyl", 1))
+	mock.ExpectQuery("WITH RECURSIVE ancestry AS").
+		WithArgs(pq.Array([]string{"active"}), pq.Array([]string{"anime"}), 1, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "parent_id", "count"}).
+			AddRow(1, "Stickers", nil, 1).
+			AddRow(4, "Laptop", 1, 1))
+	mock.ExpectCommit()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args: map[string]interface{}{
+			"filter":   map[string]interface{}{"status": []interface{}{"active"}, "tags": []interface{}{"Anime"}},
+			"tagLimit": 5,
+		},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.storeFacetsResolver(params)
+
+	//ASSERT: The total with counts per tag and per category
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	facets := result.(map[string]interface{})
+	if facets["total"] != 2 {
+		t.Errorf("Expected 2 stores, got %v", facets["total"])
+	}
+	tags := facets["tags"].([]map[string]interface{})
+	if len(tags) != 2 || tags[1]["name"] != "vinyl" || tags[1]["count"] != 1 {
+		t.Errorf("Unexpected tag counts: %v", tags)
+	}
+	categories := facets["categories"].([]map[string]interface{})
+	if len(categories) != 2 || categories[0]["parent_id"] != nil || categories[1]["parent_id"] != 1 {
+		t.Errorf("Unexpected category counts: %v", categories)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
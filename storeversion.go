@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//versionConflictError reports a write based on a stale copy of a store
+type versionConflictError struct {
+	StoreID  int
+	Expected int
+	Current  int
+}
+
+func (e *versionConflictError) Error() string {
+	return fmt.Sprintf("store %d has been modified: expected version %d, current version %d", e.StoreID, e.Expected, e.Current)
+}
+
+//storeVersionConflict builds the error for a conditional write on storeID that
+//matched no row: a conflict when the store still exists, not found otherwise
+func storeVersionConflict(ctx context.Context, q rowQuerier, storeID int, expected int) error {
+	var current int
+	err := q.QueryRowContext(ctx, "SELECT version FROM stores WHERE id = $1 AND deleted_at IS NULL", storeID).Scan(&current)
+	if err == sql.ErrNoRows {
+		return &requestError{Status: http.StatusNotFound, Message: fmt.Sprintf("store with id %d not found", storeID)}
+	}
+	if err != nil {
+		return err
+	}
+	return &versionConflictError{StoreID: storeID, Expected: expected, Current: current}
+}
+
+//storeETag is the strong ETag of a store at a version
+func storeETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+//etagMatches reports whether an If-None-Match header lists the given ETag.
+//Weak tags compare equal to strong ones, as RFC 9110 asks for If-None-Match.
+func etagMatches(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+//ifMatchVersion reads the store version an If-Match header asks for; 0 means
+//no precondition (no header, or "*" which any existing store matches)
+func ifMatchVersion(header string) (int, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return 0, nil
+	}
+	invalid := &requestError{Status: http.StatusBadRequest, Message: "If-Match must be a single store ETag or *"}
+	if !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) || len(header) < 3 {
+		return 0, invalid
+	}
+	version, err := strconv.Atoi(header[1 : len(header)-1])
+	if err != nil || version < 1 {
+		return 0, invalid
+	}
+	return version, nil
+}
+
+//cachedStoreVersion reads the version out of a cached /store response, 0 if it has none
+func cachedStoreVersion(cached string) int {
+	var body struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(cached), &body); err != nil {
+		return 0
+	}
+	return body.Version
+}
+
+//writeNotModified answers a conditional GET whose ETag still matches
+func writeNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if etag == "" || !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+func TestUpdateStoreResolver_VersionConflict(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: The caller read version 3 but someone else already saved version 4
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT slug FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("renamed"))
+	mock.ExpectExec("UPDATE stores SET name = \\$1 WHERE id = \\$2 AND version = \\$3").
+		WithArgs("Renamed", 1, 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version FROM stores WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(4))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"id": 1, "name": "Renamed", "expectedVersion": 3},
+	}
+
+	//ACT: Call the resolver
+	_, err = handler.updateStoreResolver(params)
+
+	//ASSERT: A conflict naming both versions
+	var conflict *versionConflictError
+	if !errors.As(err, &conflict) || conflict.Expected != 3 || conflict.Current != 4 {
+		t.Errorf("Expected version conflict, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestStoresHandler_StaleIfMatch(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(1))
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT set_config\\('app.user_id'").
+		WithArgs("1").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT slug FROM stores WHERE id = \\$1 FOR UPDATE").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"slug"}).AddRow("my-store"))
+	mock.ExpectExec("UPDATE stores SET name = \\$1 WHERE id = \\$2 AND version = \\$3").
+		WithArgs("My Store", 1, 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version FROM stores WHERE id = \\$1").
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+	mock.ExpectRollback()
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/stores/1", strings.NewReader(`{"name": "My Store"}`))
+	req.Header.Set("If-Match", `"3"`)
+	authorize(t, fakeDB, req, 1)
+	w := httptest.NewRecorder()
+
+	//ACT: Patch with a stale ETag
+	handler.storesHandler(w, req)
+
+	//ASSERT: 412 with the current ETag
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status 412, got %d", w.Code)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"5"` {
+		t.Errorf("Expected current ETag \"5\", got %s", etag)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestGetStoreInfo_NotModified(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT name, revenue_cents, currency, total_orders, status, version FROM stores s WHERE id = \\$1").
+		WithArgs("1", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "revenue_cents", "currency", "total_orders", "status", "version"}).
+			AddRow("Test Store", 9999999, "USD", 500, "active", 3))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/store?id=1", nil)
+	req.Header.Set("If-None-Match", `W/"3"`)
+	w := httptest.NewRecorder()
+
+	//ACT: Revalidate a copy of version 3
+	handler.getStoreInfo(w, req)
+
+	//ASSERT: Not modified, without a body
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304, got %d", w.Code)
+	}
+	if w.Body.Len() != 0 || w.Header().Get("ETag") != `"3"` {
+		t.Errorf("Expected empty body and ETag \"3\", got %q, %s", w.Body.String(), w.Header().Get("ETag"))
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestIfMatchVersion(t *testing.T) {
+	cases := []struct {
+		header  string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"*", 0, false},
+		{`"12"`, 12, false},
+		{`W/"12"`, 0, true},
+		{`"1", "2"`, 0, true},
+		{"12", 0, true},
+	}
+	for _, c := range cases {
+		got, err := ifMatchVersion(c.header)
+		if got != c.want || (err != nil) != c.wantErr {
+			t.Errorf("ifMatchVersion(%q) = %d, %v", c.header, got, err)
+		}
+	}
+
+	if !etagMatches(`"1", "3"`, storeETag(3)) || etagMatches(`"4"`, storeETag(3)) {
+		t.Error("Expected If-None-Match lists to be matched tag by tag")
+	}
+}
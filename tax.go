@@ -0,0 +1,515 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/lib/pq"
+)
+
+//defaultItemCategory is used for order items that do not name a category
+const defaultItemCategory = "general"
+
+//jurisdictionPattern matches codes like "US", "US-CA" or "DE"
+var jurisdictionPattern = regexp.MustCompile(`^[A-Z0-9]+(-[A-Z0-9]+)*$`)
+
+//taxRule is one configured tax of a jurisdiction
+type taxRule struct {
+	ID               int
+	Jurisdiction     string
+	Name             string
+	Rate             *big.Rat //Fraction, e.g. 0.0725
+	Inclusive        bool     //Prices already include the tax
+	ExemptCategories []string
+}
+
+//appliesTo reports whether the rule covers a jurisdiction: its own or any below it
+func (r taxRule) appliesTo(jurisdiction string) bool {
+	return jurisdiction == r.Jurisdiction || strings.HasPrefix(jurisdiction, r.Jurisdiction+"-")
+}
+
+//exempts reports whether items of a category are not taxed by the rule
+func (r taxRule) exempts(category string) bool {
+	for _, exempt := range r.ExemptCategories {
+		if exempt == category {
+			return true
+		}
+	}
+	return false
+}
+
+//taxableLine is the amount of one order line together with its product category
+type taxableLine struct {
+	Category string
+	Amount   Money
+}
+
+//taxLine is one itemized tax of an order
+type taxLine struct {
+	RuleID       int
+	Jurisdiction string
+	Name         string
+	Rate         *big.Rat
+	Inclusive    bool
+	Taxable      Money //Sum of the lines the tax applies to
+	Amount       Money
+}
+
+//taxResult is the outcome of a tax calculation
+type taxResult struct {
+	Subtotal Money //Sum of the line amounts as priced
+	Discount Money //Promotion discount, spread over the lines before taxing
+	TaxLines []taxLine
+	Total    Money //Subtotal less discount plus exclusive taxes
+}
+
+//calculateTax applies the rules covering a jurisdiction to the lines of an order. Exclusive taxes are
+//added on top of the line amounts; inclusive taxes are extracted from them.
+//Each tax is rounded once, on the sum of the lines it applies to.
+func calculateTax(lines []taxableLine, allRules []taxRule, jurisdiction string, currency string) taxResult {
+	var rules []taxRule
+	for _, rule := range allRules {
+		if jurisdiction != "" && rule.appliesTo(jurisdiction) {
+			rules = append(rules, rule)
+		}
+	}
+
+	result := taxResult{
+		Subtotal: Money{Currency: currency},
+		Discount: Money{Currency: currency},
+		Total:    Money{Currency: currency},
+	}
+	for _, line := range lines {
+		result.Subtotal.Amount += line.Amount.Amount
+	}
+	result.Total.Amount = result.Subtotal.Amount
+
+	//A line's price includes every inclusive tax that applies to it
+	inclusiveRates := make([]*big.Rat, len(lines))
+	for i, line := range lines {
+		inclusiveRates[i] = new(big.Rat)
+		for _, rule := range rules {
+			if rule.Inclusive && !rule.exempts(line.Category) {
+				inclusiveRates[i].Add(inclusiveRates[i], rule.Rate)
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		taxable := Money{Currency: currency}
+		exact := new(big.Rat)
+		for i, line := range lines {
+			if rule.exempts(line.Category) {
+				continue
+			}
+			taxable.Amount += line.Amount.Amount
+
+			share := new(big.Rat).Mul(new(big.Rat).SetInt64(line.Amount.Amount), rule.Rate)
+			if rule.Inclusive {
+				share.Quo(share, new(big.Rat).Add(big.NewRat(1, 1), inclusiveRates[i]))
+			}
+			exact.Add(exact, share)
+		}
+		if taxable.Amount == 0 {
+			continue
+		}
+
+		amount := Money{Amount: roundRat(exact), Currency: currency}
+		result.TaxLines = append(result.TaxLines, taxLine{
+			RuleID:       rule.ID,
+			Jurisdiction: rule.Jurisdiction,
+			Name:         rule.Name,
+			Rate:         rule.Rate,
+			Inclusive:    rule.Inclusive,
+			Taxable:      taxable,
+			Amount:       amount,
+		})
+		if !rule.Inclusive {
+			result.Total.Amount += amount.Amount
+		}
+	}
+	return result
+}
+
+//formatPercent renders a rate fraction as a percentage, e.g. 0.0725 -> "7.25%"
+func formatPercent(rate *big.Rat) string {
+	percent := new(big.Rat).Mul(rate, big.NewRat(100, 1)).FloatString(4)
+	percent = strings.TrimRight(strings.TrimRight(percent, "0"), ".")
+	return percent + "%"
+}
+
+//label is how the tax is printed on invoices
+func (t taxLine) label() string {
+	label := fmt.Sprintf("%s %s", t.Name, formatPercent(t.Rate))
+	if t.Inclusive {
+		label += " (included)"
+	}
+	return label
+}
+
+//normalizeJurisdiction validates a jurisdiction code; empty means untaxed
+func normalizeJurisdiction(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return "", nil
+	}
+	if len(code) > 20 || !jurisdictionPattern.MatchString(code) {
+		return "", fmt.Errorf("invalid jurisdiction %q", code)
+	}
+	return code, nil
+}
+
+//parseTaxRate validates a rate given as a fraction between 0 and 1
+func parseTaxRate(value string) (*big.Rat, error) {
+	value = strings.TrimSpace(value)
+	if !decimalPattern.MatchString(value) {
+		return nil, fmt.Errorf("invalid tax rate %q", value)
+	}
+	rate, ok := new(big.Rat).SetString(value)
+	if !ok || rate.Sign() < 0 || rate.Cmp(big.NewRat(1, 1)) >= 0 {
+		return nil, fmt.Errorf("tax rate must be a fraction between 0 and 1, got %q", value)
+	}
+	return rate, nil
+}
+
+//rowsQuerier is satisfied by both *sql.DB and *sql.Tx
+type rowsQuerier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+//loadTaxRules returns the active rules covering a jurisdiction, broadest first
+func loadTaxRules(ctx context.Context, q rowsQuerier, jurisdiction string) ([]taxRule, error) {
+	if jurisdiction == "" {
+		return nil, nil
+	}
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT id, jurisdiction, name, rate, inclusive, exempt_categories FROM tax_rules
+		WHERE active AND ($1 = jurisdiction OR $1 LIKE jurisdiction || '-%')
+		ORDER BY length(jurisdiction), name`, jurisdiction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tax rules: %w", err)
+	}
+	return scanTaxRules(rows)
+}
+
+//scanTaxRules reads the rows of a tax_rules query
+func scanTaxRules(rows *sql.Rows) ([]taxRule, error) {
+	defer rows.Close()
+
+	var rules []taxRule
+	for rows.Next() {
+		var rule taxRule
+		var rate string
+		if err := rows.Scan(&rule.ID, &rule.Jurisdiction, &rule.Name, &rate, &rule.Inclusive, pq.Array(&rule.ExemptCategories)); err != nil {
+			return nil, err
+		}
+		var err error
+		if rule.Rate, err = parseTaxRate(rate); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+//taxOrderItems calculates the taxes of order items in a jurisdiction, after taking off a discount
+func taxOrderItems(ctx context.Context, q rowsQuerier, items []OrderItem, discount Money, jurisdiction string, currency string) (taxResult, error) {
+	rules, err := loadTaxRules(ctx, q, jurisdiction)
+	if err != nil {
+		return taxResult{}, err
+	}
+
+	lines := make([]taxableLine, 0, len(items))
+	for _, item := range items {
+		lines = append(lines, taxableLine{Category: item.Category, Amount: item.UnitPrice.Mul(int64(item.Quantity))})
+	}
+	result := calculateTax(allocateDiscount(lines, discount.Amount), rules, jurisdiction, currency)
+	result.Subtotal.Amount += discount.Amount
+	result.Discount.Amount = discount.Amount
+	return result, nil
+}
+
+//recordOrderTaxLines snapshots the taxes of a new order. Must run inside the caller's transaction.
+func recordOrderTaxLines(ctx context.Context, tx *sql.Tx, orderID int, lines []taxLine) error {
+	insertQuery := `INSERT INTO order_tax_lines (order_id, tax_rule_id, jurisdiction, name, rate, inclusive, taxable_cents, amount_cents)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	for _, line := range lines {
+		_, err := tx.ExecContext(ctx, insertQuery, orderID, line.RuleID, line.Jurisdiction, line.Name,
+			line.Rate.FloatString(6), line.Inclusive, line.Taxable.Amount, line.Amount.Amount)
+		if err != nil {
+			return fmt.Errorf("failed to record tax line: %w", err)
+		}
+	}
+	return nil
+}
+
+//loadOrderTaxLines returns the taxes charged on an order
+func loadOrderTaxLines(ctx context.Context, q rowsQuerier, orderID int, currency string) ([]taxLine, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT COALESCE(tax_rule_id, 0), jurisdiction, name, rate, inclusive, taxable_cents, amount_cents
+		FROM order_tax_lines WHERE order_id = $1 ORDER BY id`, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tax lines: %w", err)
+	}
+	defer rows.Close()
+
+	var lines []taxLine
+	for rows.Next() {
+		line := taxLine{Taxable: Money{Currency: currency}, Amount: Money{Currency: currency}}
+		var rate string
+		if err := rows.Scan(&line.RuleID, &line.Jurisdiction, &line.Name, &rate, &line.Inclusive, &line.Taxable.Amount, &line.Amount.Amount); err != nil {
+			return nil, err
+		}
+		if line.Rate, err = parseTaxRate(rate); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+//taxLineToMap converts a tax line to the shape returned by GraphQL
+func taxLineToMap(line taxLine) map[string]interface{} {
+	return map[string]interface{}{
+		"jurisdiction": line.Jurisdiction,
+		"name":         line.Name,
+		"rate":         line.Rate.FloatString(6),
+		"inclusive":    line.Inclusive,
+		"taxable":      line.Taxable,
+		"amount":       line.Amount,
+	}
+}
+
+func taxLinesToMaps(lines []taxLine) []map[string]interface{} {
+	result := make([]map[string]interface{}, 0, len(lines))
+	for _, line := range lines {
+		result = append(result, taxLineToMap(line))
+	}
+	return result
+}
+
+//quoteOrderResolver prices a prospective order, discount and taxes included, without placing it
+func (h *Handler) quoteOrderResolver(p graphql.ResolveParams) (interface{}, error) {
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid storeId")
+	}
+	jurisdictionArg, _ := p.Args["jurisdiction"].(string)
+	jurisdiction, err := normalizeJurisdiction(jurisdictionArg)
+	if err != nil {
+		return nil, err
+	}
+
+	var status string
+	var currency string
+	err = h.database.QueryRowContext(p.Context, "SELECT status, currency FROM stores WHERE id = $1 AND deleted_at IS NULL", storeID).Scan(&status, &currency)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		h.logger.Error("database error loading store for quote", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if status != storeStatusActive {
+		return nil, fmt.Errorf("store with id %d is not accepting orders", storeID)
+	}
+
+	items, err := parseOrderItems(p.Args["items"], currency)
+	if err != nil {
+		return nil, err
+	}
+
+	discount := Money{Currency: currency}
+	if code, _ := p.Args["promoCode"].(string); code != "" {
+		discount, err = h.previewDiscount(p, storeID, code, itemsSubtotal(items, currency))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tax, err := taxOrderItems(p.Context, h.database, items, discount, jurisdiction, currency)
+	if err != nil {
+		h.logger.Error("failed to calculate tax", "store_id", storeID, "jurisdiction", jurisdiction, "error", err.Error())
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"subtotal":  tax.Subtotal,
+		"discount":  tax.Discount,
+		"tax_lines": taxLinesToMaps(tax.TaxLines),
+		"total":     tax.Total,
+	}, nil
+}
+
+//orderTaxLinesResolver loads the taxes charged on an order
+func (h *Handler) orderTaxLinesResolver(p graphql.ResolveParams) (interface{}, error) {
+	order, ok := p.Source.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+	if lines, ok := order["tax_lines"]; ok {
+		return lines, nil
+	}
+
+	total, _ := order["total"].(Money)
+	lines, err := loadOrderTaxLines(p.Context, h.database, order["id"].(int), total.Currency)
+	if err != nil {
+		h.logger.Error("database error loading tax lines", "order_id", order["id"], "error", err.Error())
+		return nil, err
+	}
+	return taxLinesToMaps(lines), nil
+}
+
+//taxRulesResolver lists the active tax rules, optionally those covering one jurisdiction
+func (h *Handler) taxRulesResolver(p graphql.ResolveParams) (interface{}, error) {
+	jurisdictionArg, _ := p.Args["jurisdiction"].(string)
+	jurisdiction, err := normalizeJurisdiction(jurisdictionArg)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []taxRule
+	if jurisdiction != "" {
+		rules, err = loadTaxRules(p.Context, h.database, jurisdiction)
+	} else {
+		rules, err = loadAllTaxRules(p.Context, h.database)
+	}
+	if err != nil {
+		h.logger.Error("database error loading tax rules", "error", err.Error())
+		return nil, err
+	}
+
+	result := make([]map[string]interface{}, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, taxRuleToMap(rule))
+	}
+	return result, nil
+}
+
+//loadAllTaxRules returns every active rule
+func loadAllTaxRules(ctx context.Context, q rowsQuerier) ([]taxRule, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT id, jurisdiction, name, rate, inclusive, exempt_categories FROM tax_rules WHERE active ORDER BY jurisdiction, name")
+	if err != nil {
+		return nil, err
+	}
+	return scanTaxRules(rows)
+}
+
+func taxRuleToMap(rule taxRule) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                rule.ID,
+		"jurisdiction":      rule.Jurisdiction,
+		"name":              rule.Name,
+		"rate":              rule.Rate.FloatString(6),
+		"inclusive":         rule.Inclusive,
+		"exempt_categories": rule.ExemptCategories,
+	}
+}
+
+//setTaxRuleResolver creates or replaces the rule with the same jurisdiction and name - REQUIRES ADMIN
+func (h *Handler) setTaxRuleResolver(p graphql.ResolveParams) (interface{}, error) {
+	adminID, err := h.requireAdmin(p)
+	if err != nil {
+		return nil, err
+	}
+
+	jurisdictionArg, _ := p.Args["jurisdiction"].(string)
+	jurisdiction, err := normalizeJurisdiction(jurisdictionArg)
+	if err != nil {
+		return nil, err
+	}
+	if jurisdiction == "" {
+		return nil, fmt.Errorf("jurisdiction is required")
+	}
+	name, _ := p.Args["name"].(string)
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	rateArg, _ := p.Args["rate"].(string)
+	rate, err := parseTaxRate(rateArg)
+	if err != nil {
+		return nil, err
+	}
+	inclusive, _ := p.Args["inclusive"].(bool)
+	active := true
+	if v, ok := p.Args["active"].(bool); ok {
+		active = v
+	}
+	exempt := []string{}
+	if list, ok := p.Args["exemptCategories"].([]interface{}); ok {
+		for _, c := range list {
+			if category, ok := c.(string); ok && strings.TrimSpace(category) != "" {
+				exempt = append(exempt, strings.ToLower(strings.TrimSpace(category)))
+			}
+		}
+	}
+
+	rule := taxRule{Jurisdiction: jurisdiction, Name: name, Rate: rate, Inclusive: inclusive, ExemptCategories: exempt}
+	upsert := `INSERT INTO tax_rules (jurisdiction, name, rate, inclusive, exempt_categories, active)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (jurisdiction, name) DO UPDATE SET rate = EXCLUDED.rate, inclusive = EXCLUDED.inclusive,
+			exempt_categories = EXCLUDED.exempt_categories, active = EXCLUDED.active, updated_at = NOW()
+		RETURNING id`
+	err = h.database.QueryRowContext(p.Context, upsert,
+		jurisdiction, name, rate.FloatString(6), inclusive, pq.Array(exempt), active).Scan(&rule.ID)
+	if err != nil {
+		h.logger.Error("database error saving tax rule", "jurisdiction", jurisdiction, "name", name, "error", err.Error())
+		return nil, err
+	}
+
+	h.logger.Info("tax rule saved",
+		"rule_id", rule.ID,
+		"jurisdiction", jurisdiction,
+		"name", name,
+		"rate", rate.FloatString(6),
+		"inclusive", inclusive,
+		"active", active,
+		"admin_id", adminID,
+	)
+	return taxRuleToMap(rule), nil
+}
+
+//taxLineType is one itemized tax of an order or quote in GraphQL
+var taxLineType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "TaxLine",
+	Fields: graphql.Fields{
+		"jurisdiction": &graphql.Field{Type: graphql.String},
+		"name":         &graphql.Field{Type: graphql.String},
+		"rate":         &graphql.Field{Type: graphql.String},
+		"inclusive":    &graphql.Field{Type: graphql.Boolean},
+		"taxable":      &graphql.Field{Type: moneyType},
+		"amount":       &graphql.Field{Type: moneyType},
+	},
+})
+
+//quoteType is the priced preview of an order in GraphQL
+var quoteType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "Quote",
+	Fields: graphql.Fields{
+		"subtotal":  &graphql.Field{Type: moneyType},
+		"discount":  &graphql.Field{Type: moneyType},
+		"tax_lines": &graphql.Field{Type: graphql.NewList(taxLineType)},
+		"total":     &graphql.Field{Type: moneyType},
+	},
+})
+
+//taxRuleType is a configured tax rule in GraphQL
+var taxRuleType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "TaxRule",
+	Fields: graphql.Fields{
+		"id":                &graphql.Field{Type: graphql.Int},
+		"jurisdiction":      &graphql.Field{Type: graphql.String},
+		"name":              &graphql.Field{Type: graphql.String},
+		"rate":              &graphql.Field{Type: graphql.String},
+		"inclusive":         &graphql.Field{Type: graphql.Boolean},
+		"exempt_categories": &graphql.Field{Type: graphql.NewList(graphql.String)},
+	},
+})
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math/big"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+//taxFixtures are the rules the calculation tests run against
+var taxFixtures = []taxRule{
+	{ID: 1, Jurisdiction: "US-CA", Name: "CA sales tax", Rate: big.NewRat(6, 100), ExemptCategories: []string{"shipping"}},
+	{ID: 2, Jurisdiction: "US-CA-SF", Name: "SF district tax", Rate: big.NewRat(125, 10000), ExemptCategories: []string{"shipping"}},
+	{ID: 3, Jurisdiction: "DE", Name: "VAT", Rate: big.NewRat(19, 100), Inclusive: true},
+	{ID: 4, Jurisdiction: "DE", Name: "Reduced VAT", Rate: big.NewRat(7, 100), Inclusive: true, ExemptCategories: []string{"general", "shipping"}},
+}
+
+func usd(cents int64) Money { return Money{Amount: cents, Currency: "USD"} }
+
+func eur(cents int64) Money { return Money{Amount: cents, Currency: "EUR"} }
+
+func TestCalculateTax_ExclusiveWithExemption(t *testing.T) {
+	lines := []taxableLine{
+		{Category: "general", Amount: usd(2500)},
+		{Category: "shipping", Amount: usd(250)},
+	}
+
+	result := calculateTax(lines, taxFixtures, "US-CA", "USD")
+
+	if result.Subtotal.Amount != 2750 || result.Total.Amount != 2900 {
+		t.Errorf("Expected subtotal 2750 and total 2900, got %d and %d", result.Subtotal.Amount, result.Total.Amount)
+	}
+	if len(result.TaxLines) != 1 {
+		t.Fatalf("Expected 1 tax line, got %d", len(result.TaxLines))
+	}
+	if line := result.TaxLines[0]; line.RuleID != 1 || line.Taxable.Amount != 2500 || line.Amount.Amount != 150 {
+		t.Errorf("Expected 150 tax on 2500, got %+v", line)
+	}
+}
+
+func TestCalculateTax_NestedJurisdictions(t *testing.T) {
+	lines := []taxableLine{
+		{Category: "general", Amount: usd(2500)},
+		{Category: "shipping", Amount: usd(250)},
+	}
+
+	result := calculateTax(lines, taxFixtures, "US-CA-SF", "USD")
+
+	//State tax 150 plus district tax 31.25, rounded to 31
+	if len(result.TaxLines) != 2 {
+		t.Fatalf("Expected 2 tax lines, got %d", len(result.TaxLines))
+	}
+	if result.TaxLines[0].Amount.Amount != 150 || result.TaxLines[1].Amount.Amount != 31 {
+		t.Errorf("Expected taxes 150 and 31, got %d and %d", result.TaxLines[0].Amount.Amount, result.TaxLines[1].Amount.Amount)
+	}
+	if result.Total.Amount != 2931 {
+		t.Errorf("Expected total 2931, got %d", result.Total.Amount)
+	}
+}
+
+func TestCalculateTax_InclusiveVAT(t *testing.T) {
+	lines := []taxableLine{
+		{Category: "general", Amount: eur(1190)},
+		{Category: "books", Amount: eur(1070)},
+	}
+
+	result := calculateTax(lines, taxFixtures, "DE", "EUR")
+
+	//Prices already contain the tax, so the total is unchanged
+	if result.Total.Amount != 2260 {
+		t.Errorf("Expected total 2260, got %d", result.Total.Amount)
+	}
+	if len(result.TaxLines) != 2 {
+		t.Fatalf("Expected 2 tax lines, got %d", len(result.TaxLines))
+	}
+	//19% of the books line is extracted together with the 7%: 1070 * 0.19 / 1.26 = 161.35
+	if result.TaxLines[0].Amount.Amount != 190+161 {
+		t.Errorf("Expected VAT 351, got %d", result.TaxLines[0].Amount.Amount)
+	}
+	if result.TaxLines[1].Amount.Amount != 59 {
+		t.Errorf("Expected reduced VAT 59, got %d", result.TaxLines[1].Amount.Amount)
+	}
+}
+
+func TestCalculateTax_UnknownJurisdiction(t *testing.T) {
+	lines := []taxableLine{{Category: "general", Amount: usd(1000)}}
+
+	for _, jurisdiction := range []string{"", "FR", "US", "US-CAL"} {
+		result := calculateTax(lines, taxFixtures, jurisdiction, "USD")
+		if len(result.TaxLines) != 0 || result.Total.Amount != 1000 {
+			t.Errorf("Expected no tax for %q, got %+v", jurisdiction, result)
+		}
+	}
+}
+
+func TestTaxLineLabel(t *testing.T) {
+	cases := []struct {
+		line taxLine
+		want string
+	}{
+		{taxLine{Name: "CA sales tax", Rate: big.NewRat(725, 10000)}, "CA sales tax 7.25%"},
+		{taxLine{Name: "VAT", Rate: big.NewRat(19, 100), Inclusive: true}, "VAT 19% (included)"},
+	}
+
+	for _, c := range cases {
+		if got := c.line.label(); got != c.want {
+			t.Errorf("label() = %q, want %q", got, c.want)
+		}
+	}
+}
+
+func TestParseTaxRate(t *testing.T) {
+	rate, err := parseTaxRate("0.0725")
+	if err != nil || rate.Cmp(big.NewRat(725, 10000)) != 0 {
+		t.Errorf("parseTaxRate(0.0725) = %v, %v", rate, err)
+	}
+
+	for _, input := range []string{"1", "1.5", "-0.1", "7%", ""} {
+		if _, err := parseTaxRate(input); err == nil {
+			t.Errorf("Expected parseTaxRate(%q) to fail", input)
+		}
+	}
+}
+
+func TestNormalizeJurisdiction(t *testing.T) {
+	if got, err := normalizeJurisdiction(" us-ca "); err != nil || got != "US-CA" {
+		t.Errorf("normalizeJurisdiction(us-ca) = %q, %v", got, err)
+	}
+	for _, input := range []string{"US CA", "US--CA", "-US"} {
+		if _, err := normalizeJurisdiction(input); err == nil {
+			t.Errorf("Expected normalizeJurisdiction(%q) to fail", input)
+		}
+	}
+}
+
+func TestQuoteOrderResolver(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: California taxes the sticker (25.00 * 7.25% = 1.81) but not shipping
+	mock.ExpectQuery("SELECT status, currency FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "currency"}).AddRow("active", "USD"))
+	mock.ExpectQuery("SELECT (.+) FROM tax_rules").
+		WithArgs("US-CA").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "jurisdiction", "name", "rate", "inclusive", "exempt_categories"}).
+			AddRow(1, "US-CA", "CA sales tax", "0.072500", false, "{shipping}"))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	params := graphql.ResolveParams{
+		Context: context.Background(),
+		Args: map[string]interface{}{
+			"storeId":      5,
+			"jurisdiction": "us-ca",
+			"items": []interface{}{
+				map[string]interface{}{"description": "Laptop sticker", "quantity": 10, "unitPrice": "2.50"},
+				map[string]interface{}{"description": "Shipping", "category": "Shipping", "quantity": 1, "unitPrice": "2.5"},
+			},
+		},
+	}
+
+	//ACT: Call the resolver
+	result, err := handler.quoteOrderResolver(params)
+
+	//ASSERT: The priced order, nothing written
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	quote := result.(map[string]interface{})
+	if quote["subtotal"] != usd(2750) || quote["total"] != usd(2931) {
+		t.Errorf("Expected 27.50 + tax = 29.31, got %v", quote)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestQuoteOrderResolver_StoreNotActive(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	mock.ExpectQuery("SELECT status, currency FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "currency"}).AddRow("paused", "USD"))
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+
+	//ACT: Quote from a paused store
+	_, err = handler.quoteOrderResolver(graphql.ResolveParams{
+		Context: context.Background(),
+		Args: map[string]interface{}{
+			"storeId": 5,
+			"items":   []interface{}{map[string]interface{}{"description": "Sticker", "quantity": 1, "unitPrice": "1.00"}},
+		},
+	})
+
+	//ASSERT: Refused like placeOrder
+	if err == nil || err.Error() != "store with id 5 is not accepting orders" {
+		t.Errorf("Expected not accepting orders error, got %v", err)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	defaultMaxUploadBytes = 5 << 20 //5 MB
+	thumbnailSize         = 256     //Longest side of generated thumbnails, in pixels
+	maxImagePixels        = 40_000_000
+)
+
+//allowedUploadTypes are the sniffed content types accepted by the upload endpoint
+var allowedUploadTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"application/pdf": true,
+}
+
+//maxUploadBytes reads MAX_UPLOAD_BYTES, falling back to 5 MB
+func maxUploadBytes() int64 {
+	if v := os.Getenv("MAX_UPLOAD_BYTES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return defaultMaxUploadBytes
+}
+
+//assetURL is the public URL of a blob, served by the /assets/ endpoint
+func assetURL(key string) string {
+	return strings.TrimRight(os.Getenv("ASSET_BASE_URL"), "/") + "/assets/" + key
+}
+
+//thumbnailKey is where the thumbnail of an image blob is stored
+func thumbnailKey(key string) string {
+	return "thumbnails/" + key
+}
+
+//makeThumbnail scales an image down so its longest side is at most size
+//pixels, averaging the source pixels that fall into each target pixel
+func makeThumbnail(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+
+	tw, th := size, size
+	if w > h {
+		th = max(1, h*size/w)
+	} else {
+		tw = max(1, w*size/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0 := bounds.Min.Y + y*h/th
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*h/th)
+		for x := 0; x < tw; x++ {
+			x0 := bounds.Min.X + x*w/tw
+			x1 := max(x0+1, bounds.Min.X+(x+1)*w/tw)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					b += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(b / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+//encodeThumbnail decodes a PNG/JPEG image and re-encodes its thumbnail in the same format
+func encodeThumbnail(data []byte, contentType string) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("image is too large: %dx%d", cfg.Width, cfg.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	thumb := makeThumbnail(src, thumbnailSize)
+
+	var buf bytes.Buffer
+	if contentType == "image/png" {
+		err = png.Encode(&buf, thumb)
+	} else {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+//storeUpload validates an upload and stores it (plus its thumbnail) under its content address
+func (h *Handler) storeUpload(ctx context.Context, data []byte) (map[string]interface{}, error) {
+	contentType := http.DetectContentType(data)
+	if !allowedUploadTypes[contentType] {
+		return nil, fmt.Errorf("unsupported file type %s", contentType)
+	}
+
+	//Validate images before storing anything
+	var thumbnail []byte
+	if contentType == "image/png" || contentType == "image/jpeg" {
+		var err error
+		if thumbnail, err = encodeThumbnail(data, contentType); err != nil {
+			return nil, err
+		}
+	}
+
+	key := contentKey(data)
+	result := map[string]interface{}{
+		"key":          key,
+		"url":          assetURL(key),
+		"content_type": contentType,
+		"size":         len(data),
+	}
+
+	//Same content, same key: nothing to do if it is already stored
+	exists, err := h.blobs.Exists(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		if err := h.blobs.Put(ctx, key, data, contentType); err != nil {
+			return nil, err
+		}
+	}
+
+	if thumbnail != nil {
+		if err := h.blobs.Put(ctx, thumbnailKey(key), thumbnail, contentType); err != nil {
+			return nil, err
+		}
+		result["thumbnail_url"] = assetURL(thumbnailKey(key))
+	}
+	return result, nil
+}
+
+//uploadHandler accepts a multipart "file" upload - REQUIRES AUTH
+func (h *Handler) uploadHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write([]byte(`{"error": "method not allowed"}`))
+		return
+	}
+	if h.blobs == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error": "uploads are not configured"}`))
+		return
+	}
+
+	userID, err := h.getUserIDFromContext(r)
+	if err != nil {
+		h.logger.Warn("unauthorized upload attempt", "error", err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"error": "authentication required"}`))
+		return
+	}
+
+	limit := maxUploadBytes()
+	//Leave room for the multipart envelope; the file itself is checked below
+	r.Body = http.MaxBytesReader(w, r.Body, limit+64<<10)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "expected a multipart/form-data upload"}`))
+		return
+	}
+
+	var data []byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "malformed upload"}`))
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		data, err = io.ReadAll(io.LimitReader(part, limit+1))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) || int64(len(data)) > limit {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			fmt.Fprintf(w, `{"error": "file exceeds %d bytes"}`, limit)
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": "malformed upload"}`))
+			return
+		}
+		break
+	}
+	if len(data) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "file is required"}`))
+		return
+	}
+
+	result, err := h.storeUpload(r.Context(), data)
+	if err != nil {
+		h.logger.Warn("upload rejected", "user_id", userID, "size", len(data), "error", err.Error())
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	h.logger.Info("file uploaded",
+		"user_id", userID,
+		"key", result["key"],
+		"content_type", result["content_type"],
+		"size", len(data),
+	)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(result)
+}
+
+//assetHandler serves stored blobs. Keys are content addresses, so responses never change.
+func (h *Handler) assetHandler(w http.ResponseWriter, r *http.Request) {
+	if h.blobs == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/assets/")
+	if validateBlobKey(key) != nil || isPrivateBlobKey(key) {
+		http.NotFound(w, r)
+		return
+	}
+
+	blob, err := h.blobs.Get(r.Context(), key)
+	if err == errBlobNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		h.logger.Error("failed to read blob", "key", key, "error", err.Error())
+		http.Error(w, "failed to read asset", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		h.logger.Error("failed to read blob", "key", key, "error", err.Error())
+		http.Error(w, "failed to read asset", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Write(data)
+}
+
+//setStoreLogoResolver points a store's logo at an uploaded image - REQUIRES AUTH + OWNERSHIP
+func (h *Handler) setStoreLogoResolver(p graphql.ResolveParams) (interface{}, error) {
+	userID, err := h.requireUserID(p)
+	if err != nil {
+		h.logger.Warn("unauthorized set logo attempt", "error", err.Error())
+		return nil, err
+	}
+
+	storeID, ok := p.Args["storeId"].(int)
+	if !ok {
+		return nil, fmt.Errorf("invalid store id")
+	}
+	key, _ := p.Args["assetKey"].(string)
+	if err := validateBlobKey(key); err != nil {
+		return nil, err
+	}
+	if isPrivateBlobKey(key) {
+		return nil, fmt.Errorf("asset %s is not an uploaded image", key)
+	}
+	if h.blobs == nil {
+		return nil, fmt.Errorf("uploads are not configured")
+	}
+
+	var ownerID int
+	err = h.database.QueryRowContext(p.Context, "SELECT user_id FROM stores WHERE id = $1 AND deleted_at IS NULL", storeID).Scan(&ownerID)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+	if err != nil {
+		h.logger.Error("database error checking store owner", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	if ownerID != userID {
+		return nil, fmt.Errorf("you can only update your own stores")
+	}
+
+	//Only images get a thumbnail, so this also rejects PDFs
+	exists, err := h.blobs.Exists(p.Context, thumbnailKey(key))
+	if err != nil {
+		h.logger.Error("failed to check logo blob", "key", key, "error", err.Error())
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("asset %s is not an uploaded image", key)
+	}
+
+	result, err := h.database.ExecContext(p.Context, "UPDATE stores SET logo_key = $1 WHERE id = $2 AND deleted_at IS NULL", key, storeID)
+	if err != nil {
+		h.logger.Error("database error setting store logo", "store_id", storeID, "error", err.Error())
+		return nil, err
+	}
+	//Deleted since the ownership check
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return nil, fmt.Errorf("store with id %d not found", storeID)
+	}
+
+	h.logger.Info("store logo updated", "store_id", storeID, "key", key, "user_id", userID)
+	h.invalidateStoreCache(storeID)
+	h.refreshStoreLeaderboard(p.Context, storeID)
+
+	return map[string]interface{}{
+		"logoUrl":          assetURL(key),
+		"logoThumbnailUrl": assetURL(thumbnailKey(key)),
+	}, nil
+}
+
+//storeLogoURLResolver resolves Store.logoUrl, or Store.logoThumbnailUrl when thumbnail is set.
+//The store queries load logo_key along with the store so lists need no query per store;
+//only maps without it, such as past revisions, look the logo up.
+func (h *Handler) storeLogoURLResolver(thumbnail bool) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		store, ok := p.Source.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+
+		var key sql.NullString
+		if loaded, ok := store["logo_key"]; ok {
+			key.String, key.Valid = loaded.(string)
+		} else {
+			err := h.database.QueryRowContext(p.Context, "SELECT logo_key FROM stores WHERE id = $1", store["id"]).Scan(&key)
+			if err != nil && err != sql.ErrNoRows {
+				h.logger.Error("database error loading store logo", "store_id", store["id"], "error", err.Error())
+				return nil, err
+			}
+		}
+		if !key.Valid {
+			return nil, nil
+		}
+
+		if thumbnail {
+			return assetURL(thumbnailKey(key.String)), nil
+		}
+		return assetURL(key.String), nil
+	}
+}
+
+//storeLogoType is the result of setStoreLogo
+var storeLogoType = graphql.NewObject(graphql.ObjectConfig{
+	Name: "StoreLogo",
+	Fields: graphql.Fields{
+		"logoUrl":          &graphql.Field{Type: graphql.String},
+		"logoThumbnailUrl": &graphql.Field{Type: graphql.String},
+	},
+})
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/graphql-go/graphql"
+)
+
+//testPNG encodes a solid image of the given size
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 30, B: 90, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+//uploadRequest builds an authenticated multipart upload of data
+func uploadRequest(t *testing.T, fakeDB *sql.DB, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("file", "logo.png")
+	part.Write(data)
+	form.Close()
+
+	req := httptest.NewRequest("POST", "/uploads", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	authorize(t, fakeDB, req, 1)
+	return req
+}
+
+func TestMakeThumbnail_KeepsAspectRatio(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+
+	thumb := makeThumbnail(src, 256)
+
+	if got := thumb.Bounds(); got.Dx() != 256 || got.Dy() != 128 {
+		t.Errorf("Expected 256x128 thumbnail, got %dx%d", got.Dx(), got.Dy())
+	}
+}
+
+func TestUploadHandler_StoresImageAndThumbnail(t *testing.T) {
+	//ARRANGE: Handler backed by a local blob store
+	fakeDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	blobs, _ := NewLocalBlobStore(t.TempDir())
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		blobs:    blobs,
+	}
+	data := testPNG(t, 600, 300)
+	w := httptest.NewRecorder()
+
+	//ACT: Upload a PNG
+	handler.uploadHandler(w, uploadRequest(t, fakeDB, data))
+
+	//ASSERT: The file is stored under its SHA-256 with a thumbnail next to it
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", w.Code, w.Body.String())
+	}
+	var result map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result["key"] != contentKey(data) {
+		t.Errorf("Expected content-addressed key, got %v", result["key"])
+	}
+	if result["thumbnail_url"] != "/assets/thumbnails/"+contentKey(data) {
+		t.Errorf("Expected thumbnail url, got %v", result["thumbnail_url"])
+	}
+
+	thumb, err := blobs.Get(t.Context(), thumbnailKey(contentKey(data)))
+	if err != nil {
+		t.Fatalf("Expected thumbnail blob, got %v", err)
+	}
+	defer thumb.Close()
+	cfg, err := png.DecodeConfig(thumb)
+	if err != nil || cfg.Width != 256 || cfg.Height != 128 {
+		t.Errorf("Expected 256x128 png thumbnail, got %+v (err %v)", cfg, err)
+	}
+}
+
+func TestUploadHandler_RejectsInvalidUploads(t *testing.T) {
+	fakeDB, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	blobs, _ := NewLocalBlobStore(t.TempDir())
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		blobs:    blobs,
+	}
+
+	//Unsupported type
+	w := httptest.NewRecorder()
+	handler.uploadHandler(w, uploadRequest(t, fakeDB, []byte("#!/bin/sh\nrm -rf /\n")))
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a shell script, got %d", w.Code)
+	}
+
+	//Too large
+	t.Setenv("MAX_UPLOAD_BYTES", "64")
+	w = httptest.NewRecorder()
+	handler.uploadHandler(w, uploadRequest(t, fakeDB, testPNG(t, 200, 200)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for an oversized file, got %d", w.Code)
+	}
+}
+
+func TestStoreLogoURLResolver_UsesLoadedKey(t *testing.T) {
+	//ARRANGE: Create mock database with no queries expected
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+	t.Setenv("ASSET_BASE_URL", "https://cdn.example.com")
+
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+	}
+	stores := []map[string]interface{}{
+		{"id": 1, "logo_key": "logos/1.png"},
+		{"id": 2, "logo_key": nil},
+	}
+
+	//ACT: Resolve the thumbnail of each store in a list
+	var urls []interface{}
+	for _, store := range stores {
+		url, err := handler.storeLogoURLResolver(true)(graphql.ResolveParams{Source: store})
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		urls = append(urls, url)
+	}
+
+	//ASSERT: Resolved from the store maps without a query per store
+	if urls[0] != "https://cdn.example.com/assets/thumbnails/logos/1.png" || urls[1] != nil {
+		t.Errorf("Unexpected logo URLs: %v", urls)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}
+
+func TestSetStoreLogoResolver_DeletedStore(t *testing.T) {
+	//ARRANGE: Create mock database
+	fakeDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock: %v", err)
+	}
+	defer fakeDB.Close()
+
+	//ARRANGE: Store 5 has been soft deleted
+	mock.ExpectQuery("SELECT user_id FROM stores WHERE id = \\$1 AND deleted_at IS NULL").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+	blobs, _ := NewLocalBlobStore(t.TempDir())
+	handler := &Handler{
+		database: fakeDB,
+		logger:   slog.New(slog.NewJSONHandler(io.Discard, nil)),
+		blobs:    blobs,
+	}
+
+	//ACT: Give the deleted store a logo
+	_, err = handler.setStoreLogoResolver(graphql.ResolveParams{
+		Context: authContext(t, fakeDB, 1),
+		Args:    map[string]interface{}{"storeId": 5, "assetKey": contentKey(testPNG(t, 10, 10))},
+	})
+
+	//ASSERT: Treated like a store that does not exist
+	if err == nil || err.Error() != "store with id 5 not found" {
+		t.Errorf("Expected store not found, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+}